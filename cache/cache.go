@@ -48,6 +48,10 @@ import (
 const (
 	defaultMaxLRUCacheEntry = 10
 	defaultMaxCacheFds      = 10
+
+	// wipDirName is the directory of a directory cache in which entries are written
+	// before they are committed.
+	wipDirName = "wip"
 )
 
 type DirectoryCacheConfig struct {
@@ -77,6 +81,15 @@ type DirectoryCacheConfig struct {
 	// Direct forcefully enables direct mode for all operation in cache.
 	// Thus operation won't use on-memory caches.
 	Direct bool
+
+	// Persistent keeps the committed entries in the directory when the cache is
+	// closed, so that a cache created later on the same directory, e.g. after a
+	// restart, serves them again.
+	Persistent bool
+
+	// Quota limits the total size of the entries committed to the directory. It can
+	// be shared by several directory caches.
+	Quota *Quota
 }
 
 // TODO: contents validation.
@@ -164,7 +177,7 @@ func NewDirectoryCache(directory string, config DirectoryCacheConfig) (BlobCache
 	if err := os.MkdirAll(directory, 0700); err != nil {
 		return nil, err
 	}
-	wipdir := filepath.Join(directory, "wip")
+	wipdir := filepath.Join(directory, wipDirName)
 	if err := os.MkdirAll(wipdir, 0700); err != nil {
 		return nil, err
 	}
@@ -176,6 +189,8 @@ func NewDirectoryCache(directory string, config DirectoryCacheConfig) (BlobCache
 		wipDirectory: wipdir,
 		bufPool:      bufPool,
 		direct:       config.Direct,
+		persistent:   config.Persistent,
+		quota:        config.Quota,
 	}
 	dc.syncAdd = config.SyncAdd
 	return dc, nil
@@ -191,8 +206,10 @@ type directoryCache struct {
 
 	bufPool *sync.Pool
 
-	syncAdd bool
-	direct  bool
+	syncAdd    bool
+	direct     bool
+	persistent bool
+	quota      *Quota
 
 	closed   bool
 	closedMu sync.Mutex
@@ -235,8 +252,15 @@ func (dc *directoryCache) Get(key string, opts ...Option) (Reader, error) {
 	// Open the cache file and read the target region
 	// TODO: If the target cache is write-in-progress, should we wait for the completion
 	//       or simply report the cache miss?
-	file, err := os.Open(dc.cachePath(key))
+	// The entry isn't evicted from the quota until the reader is closed.
+	c := dc.cachePath(key)
+	release := func() {}
+	if dc.quota != nil && dc.quota.Acquire(c) {
+		release = func() { dc.quota.Release(c) }
+	}
+	file, err := os.Open(c)
 	if err != nil {
+		release()
 		return nil, fmt.Errorf("failed to open blob file for %q: %w", key, err)
 	}
 
@@ -245,8 +269,11 @@ func (dc *directoryCache) Get(key string, opts ...Option) (Reader, error) {
 	// that won't be accessed immediately.
 	if dc.direct || opt.direct {
 		return &reader{
-			ReaderAt:  file,
-			closeFunc: func() error { return file.Close() },
+			ReaderAt: file,
+			closeFunc: func() error {
+				defer release()
+				return file.Close()
+			},
 		}, nil
 	}
 
@@ -256,6 +283,7 @@ func (dc *directoryCache) Get(key string, opts ...Option) (Reader, error) {
 	return &reader{
 		ReaderAt: file,
 		closeFunc: func() error {
+			defer release()
 			_, done, added := dc.fileCache.Add(key, file)
 			defer done() // Release it immediately. Cleaned up on eviction.
 			if !added {
@@ -284,6 +312,7 @@ func (dc *directoryCache) Add(key string, opts ...Option) (Writer, error) {
 		WriteCloser: wip,
 		commitFunc: func() error {
 			if dc.isClosed() {
+				os.Remove(wip.Name())
 				return fmt.Errorf("cache is already closed")
 			}
 			// Commit the cache contents
@@ -296,7 +325,17 @@ func (dc *directoryCache) Add(key string, opts ...Option) (Writer, error) {
 				return errors.Join(allErr,
 					fmt.Errorf("failed to create cache directory %q: %w", c, err))
 			}
-			return os.Rename(wip.Name(), c)
+			if err := os.Rename(wip.Name(), c); err != nil {
+				return err
+			}
+			if dc.quota != nil {
+				info, err := os.Stat(c)
+				if err != nil {
+					return err
+				}
+				dc.quota.Add(c, info.Size())
+			}
+			return nil
 		},
 		abortFunc: func() error {
 			return os.Remove(wip.Name())
@@ -365,6 +404,13 @@ func (dc *directoryCache) Close() error {
 		return nil
 	}
 	dc.closed = true
+	if dc.persistent {
+		// Entries being written are discarded by their commit.
+		return nil
+	}
+	if dc.quota != nil {
+		defer dc.quota.RemoveAll(dc.directory)
+	}
 	return os.RemoveAll(dc.directory)
 }
 
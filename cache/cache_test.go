@@ -39,11 +39,18 @@
 package cache
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -85,6 +92,19 @@ func TestMemoryCache(t *testing.T) {
 	testCache(t, "memory", func(*testing.T) BlobCache { return NewMemoryCache() })
 }
 
+func TestCompressedCache(t *testing.T) {
+	testCache(t, "compressed-dir", func(t *testing.T) BlobCache {
+		c, err := NewDirectoryCache(t.TempDir(), DirectoryCacheConfig{SyncAdd: true, Direct: true})
+		if err != nil {
+			t.Fatalf("failed to make cache: %v", err)
+		}
+		return NewCompressedCache(c, CompressedCacheConfig{BlockSize: 4})
+	})
+	testCache(t, "compressed-memory", func(*testing.T) BlobCache {
+		return NewCompressedCache(NewMemoryCache(), CompressedCacheConfig{BlockSize: 4})
+	})
+}
+
 func testCache(t *testing.T, name string, newCache func(t *testing.T) BlobCache) {
 	tests := []struct {
 		name   string
@@ -207,3 +227,290 @@ func miss(sample string) check {
 		}
 	}
 }
+
+func addBlob(t *testing.T, c BlobCache, key, blob string) {
+	t.Helper()
+	w, err := c.Add(key)
+	if err != nil {
+		t.Fatalf("failed to add %v: %v", key, err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte(blob)); err != nil {
+		t.Fatalf("failed to write %v: %v", key, err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("failed to commit %v: %v", key, err)
+	}
+}
+
+func TestDirectoryCachePersistent(t *testing.T) {
+	tmp := t.TempDir()
+	cfg := DirectoryCacheConfig{SyncAdd: true, Direct: true, Persistent: true}
+	c, err := NewDirectoryCache(tmp, cfg)
+	if err != nil {
+		t.Fatalf("failed to make cache: %v", err)
+	}
+	addBlob(t, c, digestFor(sampleData), sampleData)
+	if err := c.Close(); err != nil {
+		t.Fatalf("failed to close cache: %v", err)
+	}
+
+	// A cache on the same directory, e.g. after a restart, serves the entries again.
+	c, err = NewDirectoryCache(tmp, cfg)
+	if err != nil {
+		t.Fatalf("failed to make cache: %v", err)
+	}
+	defer c.Close()
+	hit(sampleData)(t, c)
+}
+
+func TestQuota(t *testing.T) {
+	root := t.TempDir()
+	// Entries cached before the quota was created are counted, and unfinished writes are removed.
+	prev, err := NewDirectoryCache(filepath.Join(root, "prev"), DirectoryCacheConfig{SyncAdd: true, Direct: true, Persistent: true})
+	if err != nil {
+		t.Fatalf("failed to make cache: %v", err)
+	}
+	addBlob(t, prev, "old", "0123")
+	if err := os.WriteFile(filepath.Join(root, "prev", wipDirName, "unfinished"), []byte("0123"), 0600); err != nil {
+		t.Fatalf("failed to write unfinished entry: %v", err)
+	}
+	prev.Close()
+
+	q, err := NewQuota(root, 10)
+	if err != nil {
+		t.Fatalf("failed to make quota: %v", err)
+	}
+	if size := q.Size(); size != 4 {
+		t.Fatalf("unexpected quota size after restart: got %d, want 4", size)
+	}
+	if _, err := os.Stat(filepath.Join(root, "prev", wipDirName)); !os.IsNotExist(err) {
+		t.Fatalf("expected unfinished writes to be removed, got %v", err)
+	}
+
+	newCache := func(name string) BlobCache {
+		c, err := NewDirectoryCache(filepath.Join(root, name), DirectoryCacheConfig{SyncAdd: true, Direct: true, Persistent: true, Quota: q})
+		if err != nil {
+			t.Fatalf("failed to make cache: %v", err)
+		}
+		return c
+	}
+	c1, c2 := newCache("prev"), newCache("c2")
+	defer c1.Close()
+	defer c2.Close()
+
+	// Reading "old" keeps it from being evicted, so "a" is evicted instead.
+	r, err := c1.Get("old")
+	if err != nil {
+		t.Fatalf("failed to get entry: %v", err)
+	}
+	addBlob(t, c2, "a", "0123")
+	addBlob(t, c2, "b", "0123")
+	if size := q.Size(); size != 8 {
+		t.Fatalf("unexpected quota size: got %d, want 8", size)
+	}
+	if _, err := c2.Get("a"); err == nil {
+		t.Fatalf("expected least recently used entry to be evicted")
+	}
+	r.Close()
+
+	// Once released, "old" is the least recently used entry.
+	addBlob(t, c2, "c", "0123")
+	if _, err := c1.Get("old"); err == nil {
+		t.Fatalf("expected released entry to be evicted")
+	}
+	for _, key := range []string{"b", "c"} {
+		r, err := c2.Get(key)
+		if err != nil {
+			t.Fatalf("expected %q to be cached: %v", key, err)
+		}
+		r.Close()
+	}
+
+	// Entries removed with a non-persistent cache leave the quota.
+	c3, err := NewDirectoryCache(filepath.Join(root, "c3"), DirectoryCacheConfig{SyncAdd: true, Direct: true, Quota: q})
+	if err != nil {
+		t.Fatalf("failed to make cache: %v", err)
+	}
+	addBlob(t, c3, "d", "01")
+	c3.Close()
+	if size := q.Size(); size != 8 {
+		t.Fatalf("unexpected quota size after close: got %d, want 8", size)
+	}
+}
+
+func TestCompressedCacheEntries(t *testing.T) {
+	compressible := strings.Repeat("0123456789", 1000)
+	incompressible := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(incompressible)
+
+	tests := []struct {
+		name       string
+		blob       string
+		compressed bool
+	}{
+		{name: "compressible", blob: compressible, compressed: true},
+		{name: "incompressible", blob: string(incompressible)},
+		{name: "magic", blob: compressedMagic + string(incompressible), compressed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMemoryCache().(*MemoryCache)
+			var decodes int
+			var size, storedSize int64
+			c := NewCompressedCache(mc, CompressedCacheConfig{
+				BlockSize: 1000,
+				OnAdd: func(s, stored int64) {
+					size, storedSize = s, stored
+				},
+				OnDecode: func(time.Time) { decodes++ },
+			})
+			addBlob(t, c, "key", tt.blob)
+			if size != int64(len(tt.blob)) || int64(mc.Membuf["key"].Len()) != storedSize {
+				t.Fatalf("unexpected sizes reported: got %d (stored %d), want %d (stored %d)", size, storedSize, len(tt.blob), mc.Membuf["key"].Len())
+			}
+			if compressed := bytes.HasPrefix(mc.Membuf["key"].Bytes(), []byte(compressedMagic)); compressed != tt.compressed {
+				t.Fatalf("expected entry to be stored compressed: %v", tt.compressed)
+			}
+
+			// Random reads within the entry, including reads across blocks and past the end.
+			for _, off := range []int64{0, 10, 999, 1500, 9990} {
+				testBlob(t, c, "key", off, tt.blob[off:min(off+1200, int64(len(tt.blob)))])
+			}
+			if tt.compressed && decodes == 0 {
+				t.Fatalf("expected blocks to be decompressed")
+			}
+		})
+	}
+
+	// Reads of a block already decompressed don't decompress it again.
+	var decodes int
+	c := NewCompressedCache(NewMemoryCache(), CompressedCacheConfig{BlockSize: 1000, OnDecode: func(time.Time) { decodes++ }})
+	addBlob(t, c, "key", compressible)
+	r, err := c.Get("key")
+	if err != nil {
+		t.Fatalf("failed to get entry: %v", err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(io.NewSectionReader(r, 0, 1000)); err != nil {
+		t.Fatalf("failed to read entry: %v", err)
+	}
+	if decodes != 1 {
+		t.Fatalf("expected a single decompression for sequential reads of a block, got %d", decodes)
+	}
+}
+
+func TestCompressedCacheVerbatimEntries(t *testing.T) {
+	// Entries added before compression was enabled are read verbatim.
+	dir := t.TempDir()
+	c, err := NewDirectoryCache(dir, DirectoryCacheConfig{SyncAdd: true, Direct: true, Persistent: true})
+	if err != nil {
+		t.Fatalf("failed to make cache: %v", err)
+	}
+	addBlob(t, c, digestFor(sampleData), sampleData)
+	c.Close()
+
+	c, err = NewDirectoryCache(dir, DirectoryCacheConfig{SyncAdd: true, Direct: true, Persistent: true})
+	if err != nil {
+		t.Fatalf("failed to make cache: %v", err)
+	}
+	defer c.Close()
+	hit(sampleData)(t, NewCompressedCache(c, CompressedCacheConfig{}))
+}
+
+func TestCompressedCacheCorruptedEntries(t *testing.T) {
+	blob := strings.Repeat("0123456789", 1000)
+	const (
+		blockSizeOff = len(compressedMagic)
+		sizeOff      = blockSizeOff + 4
+		numBlocksOff = sizeOff + 8
+		// The blob is stored in 10 blocks of 1000 bytes.
+		numBlocks = 10
+		dataStart = compressedHeaderSize + 8*(numBlocks+1)
+	)
+	offset := func(i int) int { return compressedHeaderSize + 8*i }
+
+	tests := []struct {
+		name    string
+		corrupt func(b []byte) []byte
+		// readErr is whether the entry is only found to be corrupted when it is read.
+		readErr bool
+	}{
+		{
+			name: "number of blocks larger than the entry",
+			corrupt: func(b []byte) []byte {
+				binary.LittleEndian.PutUint32(b[blockSizeOff:], 1)
+				binary.LittleEndian.PutUint64(b[sizeOff:], 1<<32-1)
+				binary.LittleEndian.PutUint32(b[numBlocksOff:], 1<<32-1)
+				return b
+			},
+		},
+		{
+			name:    "truncated offsets",
+			corrupt: func(b []byte) []byte { return b[:offset(5)] },
+		},
+		{
+			name: "first offset isn't 0",
+			corrupt: func(b []byte) []byte {
+				binary.LittleEndian.PutUint64(b[offset(0):], 1)
+				return b
+			},
+		},
+		{
+			name: "decreasing offsets",
+			corrupt: func(b []byte) []byte {
+				binary.LittleEndian.PutUint64(b[offset(5):], binary.LittleEndian.Uint64(b[offset(5):])+1000)
+				return b
+			},
+		},
+		{
+			name: "last offset past the data",
+			corrupt: func(b []byte) []byte {
+				binary.LittleEndian.PutUint64(b[offset(numBlocks):], 1<<40)
+				return b
+			},
+		},
+		{
+			name:    "truncated data",
+			corrupt: func(b []byte) []byte { return b[:len(b)-1] },
+		},
+		{
+			name: "corrupted block",
+			corrupt: func(b []byte) []byte {
+				for i := dataStart; i < len(b); i++ {
+					b[i] ^= 0xff
+				}
+				return b
+			},
+			readErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMemoryCache().(*MemoryCache)
+			c := NewCompressedCache(mc, CompressedCacheConfig{BlockSize: 1000})
+			addBlob(t, c, "key", blob)
+			stored := mc.Membuf["key"].Bytes()
+			if !bytes.HasPrefix(stored, []byte(compressedMagic)) {
+				t.Fatalf("expected entry to be stored compressed")
+			}
+			mc.Membuf["key"] = bytes.NewBuffer(tt.corrupt(bytes.Clone(stored)))
+
+			r, err := c.Get("key")
+			if !tt.readErr {
+				if err == nil {
+					r.Close()
+					t.Fatalf("expected an error getting the corrupted entry")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to get entry: %v", err)
+			}
+			defer r.Close()
+			if _, err := r.ReadAt(make([]byte, len(blob)), 0); err == nil {
+				t.Fatalf("expected an error reading the corrupted entry")
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// defaultCompressedBlockSize is the size of the blocks compressed separately, so
+	// that a read within an entry only decompresses the blocks it overlaps.
+	defaultCompressedBlockSize = 64 * 1024
+
+	// compressedHeaderSize is the size of the header of a compressed entry: the magic,
+	// the block size, the uncompressed size and the number of blocks.
+	compressedHeaderSize = len(compressedMagic) + 4 + 8 + 4
+
+	// compressedMagic starts the entries stored compressed, which are told apart from
+	// the entries stored verbatim, e.g. before compression was enabled.
+	compressedMagic = "SOCIZST1"
+)
+
+var (
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdOnce    sync.Once
+)
+
+// zstdCodec returns the zstd encoder and decoder shared by the compressed caches.
+// EncodeAll and DecodeAll are safe for concurrent use.
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// CompressedCacheConfig is config for a compressed cache.
+type CompressedCacheConfig struct {
+	// BlockSize is the size of the blocks of an entry compressed separately
+	// (default: 64 KiB). Smaller blocks make random reads cheaper, at the cost
+	// of the compression ratio.
+	BlockSize int
+
+	// OnAdd is called with the size of every entry added to the cache and the
+	// size it is stored with.
+	OnAdd func(size, storedSize int64)
+
+	// OnDecode is called once a block read from the cache is decompressed, with
+	// the time at which the decompression started.
+	OnDecode func(start time.Time)
+}
+
+// NewCompressedCache returns a cache which stores the entries of `c` compressed with
+// zstd. Entries are compressed in blocks, so that reads within an entry only
+// decompress the blocks they overlap. Entries which don't compress, like the
+// compressed spans of a layer, are stored verbatim.
+func NewCompressedCache(c BlobCache, config CompressedCacheConfig) BlobCache {
+	if config.BlockSize <= 0 {
+		config.BlockSize = defaultCompressedBlockSize
+	}
+	return &compressedCache{BlobCache: c, config: config}
+}
+
+// compressedCache is a cache implementation which compresses the entries of
+// another cache.
+type compressedCache struct {
+	BlobCache
+	config CompressedCacheConfig
+}
+
+func (cc *compressedCache) Add(key string, opts ...Option) (Writer, error) {
+	w, err := cc.BlobCache.Add(key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	b := new(bytes.Buffer)
+	return &writer{
+		WriteCloser: &writeCloser{Writer: b, closeFunc: w.Close},
+		commitFunc: func() error {
+			stored := cc.compress(b.Bytes())
+			if _, err := w.Write(stored); err != nil {
+				w.Abort()
+				return err
+			}
+			if err := w.Commit(); err != nil {
+				return err
+			}
+			if cc.config.OnAdd != nil {
+				cc.config.OnAdd(int64(b.Len()), int64(len(stored)))
+			}
+			return nil
+		},
+		abortFunc: w.Abort,
+	}, nil
+}
+
+// compress returns the contents stored for `data`: either the compressed blocks
+// after their header and offsets, or `data` itself if it doesn't compress.
+func (cc *compressedCache) compress(data []byte) []byte {
+	enc, _ := zstdCodec()
+	numBlocks := (len(data) + cc.config.BlockSize - 1) / cc.config.BlockSize
+	offsetsSize := 8 * (numBlocks + 1)
+	out := make([]byte, compressedHeaderSize+offsetsSize, compressedHeaderSize+offsetsSize+len(data)/2)
+	copy(out, compressedMagic)
+	binary.LittleEndian.PutUint32(out[len(compressedMagic):], uint32(cc.config.BlockSize))
+	binary.LittleEndian.PutUint64(out[len(compressedMagic)+4:], uint64(len(data)))
+	binary.LittleEndian.PutUint32(out[len(compressedMagic)+12:], uint32(numBlocks))
+	offsets := out[compressedHeaderSize:]
+	dataStart := len(out)
+	for i := 0; i < numBlocks; i++ {
+		binary.LittleEndian.PutUint64(offsets[8*i:], uint64(len(out)-dataStart))
+		out = enc.EncodeAll(data[i*cc.config.BlockSize:min((i+1)*cc.config.BlockSize, len(data))], out)
+		offsets = out[compressedHeaderSize:]
+	}
+	binary.LittleEndian.PutUint64(offsets[8*numBlocks:], uint64(len(out)-dataStart))
+
+	// Entries starting with the magic are always stored compressed, so that they
+	// aren't mistaken for compressed entries.
+	if len(out) >= len(data) && !bytes.HasPrefix(data, []byte(compressedMagic)) {
+		return data
+	}
+	return out
+}
+
+func (cc *compressedCache) Get(key string, opts ...Option) (Reader, error) {
+	r, err := cc.BlobCache.Get(key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, compressedHeaderSize)
+	if n, err := r.ReadAt(header, 0); n < len(header) || !bytes.HasPrefix(header, []byte(compressedMagic)) {
+		if err != nil && !errors.Is(err, io.EOF) {
+			r.Close()
+			return nil, err
+		}
+		// The entry is stored verbatim.
+		return r, nil
+	}
+	blockSize := int64(binary.LittleEndian.Uint32(header[len(compressedMagic):]))
+	size := int64(binary.LittleEndian.Uint64(header[len(compressedMagic)+4:]))
+	numBlocks := int64(binary.LittleEndian.Uint32(header[len(compressedMagic)+12:]))
+	offsetsSize := 8 * (numBlocks + 1)
+	// The offsets are only allocated once the entry is known to contain them, so
+	// that a corrupted header can't make us allocate an arbitrary amount of memory.
+	if blockSize <= 0 || size < 0 || numBlocks != (size+blockSize-1)/blockSize ||
+		!readableAt(r, int64(compressedHeaderSize)+offsetsSize-1) {
+		r.Close()
+		return nil, fmt.Errorf("invalid header of compressed entry %q", key)
+	}
+	offsets := make([]byte, offsetsSize)
+	if n, err := r.ReadAt(offsets, int64(compressedHeaderSize)); n < len(offsets) {
+		r.Close()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("failed to read offsets of compressed entry %q: %w", key, err)
+	}
+	cr := &compressedReader{
+		r:         r,
+		blockSize: blockSize,
+		size:      size,
+		dataStart: int64(compressedHeaderSize) + offsetsSize,
+		offsets:   make([]int64, numBlocks+1),
+		onDecode:  cc.config.OnDecode,
+		block:     -1,
+	}
+	for i := range cr.offsets {
+		cr.offsets[i] = int64(binary.LittleEndian.Uint64(offsets[8*i:]))
+		// The blocks are stored in order from the start of the data, so the offsets
+		// must increase from 0, and the last one is the end of the data.
+		if (i == 0 && cr.offsets[i] != 0) || (i > 0 && cr.offsets[i] < cr.offsets[i-1]) {
+			r.Close()
+			return nil, fmt.Errorf("invalid offsets of compressed entry %q", key)
+		}
+	}
+	if end := cr.offsets[numBlocks]; end > 0 && !readableAt(r, cr.dataStart+end-1) {
+		r.Close()
+		return nil, fmt.Errorf("invalid offsets of compressed entry %q: data is truncated", key)
+	}
+	return cr, nil
+}
+
+// readableAt returns whether `r` has a byte at offset `off`.
+func readableAt(r Reader, off int64) bool {
+	var b [1]byte
+	n, _ := r.ReadAt(b[:], off)
+	return n == 1
+}
+
+// compressedReader reads a compressed entry. The last decompressed block is kept,
+// so that sequential reads within a block only decompress it once.
+type compressedReader struct {
+	r         Reader
+	blockSize int64
+	size      int64
+	dataStart int64
+	offsets   []int64
+	onDecode  func(start time.Time)
+
+	mu    sync.Mutex
+	block int64
+	buf   []byte
+}
+
+func (cr *compressedReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= cr.size {
+		return 0, io.EOF
+	}
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	var n int
+	for n < len(p) && off < cr.size {
+		block := off / cr.blockSize
+		if err := cr.decode(block); err != nil {
+			return n, err
+		}
+		c := copy(p[n:], cr.buf[off-block*cr.blockSize:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// decode decompresses the block `block` into cr.buf. cr.mu must be held.
+func (cr *compressedReader) decode(block int64) error {
+	if cr.block == block {
+		return nil
+	}
+	compressed := make([]byte, cr.offsets[block+1]-cr.offsets[block])
+	if n, err := cr.r.ReadAt(compressed, cr.dataStart+cr.offsets[block]); n < len(compressed) {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read block %d: %w", block, err)
+	}
+	_, dec := zstdCodec()
+	start := time.Now()
+	buf, err := dec.DecodeAll(compressed, cr.buf[:0])
+	if err != nil {
+		cr.block = -1
+		return fmt.Errorf("failed to decompress block %d: %w", block, err)
+	}
+	if cr.onDecode != nil {
+		cr.onDecode(start)
+	}
+	if want := min(cr.blockSize, cr.size-block*cr.blockSize); int64(len(buf)) != want {
+		cr.block = -1
+		return fmt.Errorf("unexpected size of decompressed block %d: got %d, want %d", block, len(buf), want)
+	}
+	cr.block, cr.buf = block, buf
+	return nil
+}
+
+func (cr *compressedReader) Close() error {
+	return cr.r.Close()
+}
@@ -0,0 +1,193 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cache
+
+import (
+	"container/list"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+// Quota limits the total size of the entries of the directory caches sharing it.
+// When the entries exceed the quota, the least recently used entries are removed,
+// except the entries being read.
+type Quota struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type quotaEntry struct {
+	path string
+	size int64
+	refs int
+}
+
+// NewQuota creates a Quota of `maxBytes` for the directory caches under `root`.
+// The entries already cached under `root`, e.g. before a restart, are counted
+// in the quota from the least to the most recently modified, and the unfinished
+// writes they left behind are removed. A `maxBytes` of 0 or less means no limit.
+func NewQuota(root string, maxBytes int64) (*Quota, error) {
+	q := &Quota{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+
+	type cachedFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cachedFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == wipDirName {
+				if err := os.RemoveAll(path); err != nil {
+					return err
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, cachedFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, f := range files {
+		q.entries[f.path] = q.ll.PushFront(&quotaEntry{path: f.path, size: f.size})
+		q.size += f.size
+	}
+	q.evict()
+	return q, nil
+}
+
+// Size returns the total size of the entries in the quota.
+func (q *Quota) Size() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// Add counts the entry committed to `path` in the quota, and removes the least
+// recently used entries until the entries fit.
+func (q *Quota) Add(path string, size int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if e, ok := q.entries[path]; ok {
+		// The entry was overwritten.
+		ent := e.Value.(*quotaEntry)
+		q.size += size - ent.size
+		ent.size = size
+		q.ll.MoveToFront(e)
+	} else {
+		q.entries[path] = q.ll.PushFront(&quotaEntry{path: path, size: size})
+		q.size += size
+	}
+	q.evict()
+}
+
+// Acquire marks the entry at `path` as read so that it isn't removed until
+// Release is called. It returns false if the entry isn't in the quota.
+func (q *Quota) Acquire(path string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.entries[path]
+	if !ok {
+		return false
+	}
+	e.Value.(*quotaEntry).refs++
+	q.ll.MoveToFront(e)
+	return true
+}
+
+// Release marks the end of a read of the entry at `path`.
+func (q *Quota) Release(path string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.entries[path]
+	if !ok {
+		return
+	}
+	e.Value.(*quotaEntry).refs--
+	q.evict()
+}
+
+// RemoveAll stops counting the entries under `dir`, which were removed with
+// their directory.
+func (q *Quota) RemoveAll(dir string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	prefix := dir + string(filepath.Separator)
+	for path, e := range q.entries {
+		if strings.HasPrefix(path, prefix) {
+			q.size -= e.Value.(*quotaEntry).size
+			q.ll.Remove(e)
+			delete(q.entries, path)
+		}
+	}
+}
+
+// evict removes the least recently used entries which aren't being read until
+// the entries fit in the quota. q.mu must be held.
+func (q *Quota) evict() {
+	if q.maxBytes <= 0 {
+		return
+	}
+	for e := q.ll.Back(); e != nil && q.size > q.maxBytes; {
+		prev := e.Prev()
+		ent := e.Value.(*quotaEntry)
+		if ent.refs == 0 {
+			if err := os.Remove(ent.path); err != nil && !os.IsNotExist(err) {
+				log.L.WithError(err).Warnf("failed to evict cache entry %q", ent.path)
+			} else {
+				q.size -= ent.size
+				q.ll.Remove(e)
+				delete(q.entries, ent.path)
+			}
+		}
+		e = prev
+	}
+}
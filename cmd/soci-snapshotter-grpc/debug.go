@@ -0,0 +1,178 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/awslabs/soci-snapshotter/config"
+	"github.com/awslabs/soci-snapshotter/fs"
+	"github.com/awslabs/soci-snapshotter/fs/layer"
+	"github.com/opencontainers/go-digest"
+	"github.com/urfave/cli/v3"
+)
+
+// spanStates are the states of the spans of a layer, in the order they're shown.
+var spanStates = []string{"unrequested", "requested", "fetched", "uncompressed"}
+
+var DebugCommand = &cli.Command{
+	Name:  "debug",
+	Usage: "Show the state of the mounted layers of a running snapshotter",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "debug-address",
+			Usage: "debug address of the snapshotter. Defaults to debug_address in the configuration",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the raw JSON output",
+		},
+	},
+	Commands: []*cli.Command{
+		{
+			Name:  "layers",
+			Usage: "List the mounted layers",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				var layers []fs.DebugLayer
+				if err := getDebug(ctx, cmd, fs.DebugLayersPath, &layers); err != nil || cmd.Bool("json") {
+					return err
+				}
+				writer := tabwriter.NewWriter(os.Stdout, 8, 8, 4, ' ', 0)
+				writeLayersHeader(writer, "MOUNTPOINT\tIMAGE DIGEST\t")
+				for _, l := range layers {
+					fmt.Fprintf(writer, "%s\t%s\t", l.Mountpoint, l.ImageDigest)
+					writeLayer(writer, l.Status)
+				}
+				return writer.Flush()
+			},
+		},
+		{
+			Name:      "spans",
+			Usage:     "List the spans of a mounted layer",
+			ArgsUsage: "<layer digest>",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				dgst, err := digest.Parse(cmd.Args().First())
+				if err != nil {
+					return fmt.Errorf("invalid layer digest: %w", err)
+				}
+				var spans fs.DebugSpans
+				if err := getDebug(ctx, cmd, fs.DebugSpansPath(dgst), &spans); err != nil || cmd.Bool("json") {
+					return err
+				}
+				writer := tabwriter.NewWriter(os.Stdout, 8, 8, 4, ' ', 0)
+				fmt.Fprintln(writer, "ID\tSTATE\tCOMPRESSED OFFSET\tCOMPRESSED SIZE\tUNCOMPRESSED OFFSET\tUNCOMPRESSED SIZE")
+				for _, s := range spans.Spans {
+					fmt.Fprintf(writer, "%d\t%s\t%d\t%d\t%d\t%d\n", s.ID, s.State,
+						s.CompressedOffset, s.CompressedSize, s.UncompressedOffset, s.UncompressedSize)
+				}
+				return writer.Flush()
+			},
+		},
+		{
+			Name:  "images",
+			Usage: "List the images of the mounted layers",
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				var images []fs.DebugImage
+				if err := getDebug(ctx, cmd, fs.DebugImagesPath, &images); err != nil || cmd.Bool("json") {
+					return err
+				}
+				for i, img := range images {
+					if i > 0 {
+						fmt.Println()
+					}
+					fmt.Printf("Image: %s\nImage digest: %s\nIndex digest: %s\n", img.ImageRef, img.ImageDigest, img.IndexDigest)
+					writer := tabwriter.NewWriter(os.Stdout, 8, 8, 4, ' ', 0)
+					writeLayersHeader(writer, "")
+					for _, l := range img.Layers {
+						writeLayer(writer, l.Status)
+					}
+					if err := writer.Flush(); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	},
+}
+
+// getDebug gets the debug endpoint `path` of the snapshotter and decodes its output
+// into `v`, or prints it as is with --json.
+func getDebug(ctx context.Context, cmd *cli.Command, path string, v any) error {
+	addr := cmd.String("debug-address")
+	if addr == "" {
+		cfg, err := config.NewConfigFromToml(cmd.String("config"))
+		if err != nil {
+			return err
+		}
+		addr = cfg.DebugAddress
+	}
+	if addr == "" {
+		return errors.New("debug_address is not configured, use --debug-address")
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the snapshotter: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if cmd.Bool("json") {
+		_, err := io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func writeLayersHeader(w io.Writer, prefix string) {
+	fmt.Fprintf(w, "%sLAYER DIGEST\tFETCHED\tSIZE\t%s\tBG QUEUE\tPREFETCH\tSPAN CACHE\tFILE CACHE\n",
+		prefix, strings.ToUpper(strings.Join(spanStates, "\t")))
+}
+
+func writeLayer(w io.Writer, st layer.Status) {
+	fmt.Fprintf(w, "%s\t%d\t%d\t", st.Digest, st.FetchedSize, st.Size)
+	for _, state := range spanStates {
+		fmt.Fprintf(w, "%d\t", st.SpanStates[state])
+	}
+	queue := "-"
+	if st.BackgroundFetchQueuePosition >= 0 {
+		queue = fmt.Sprint(st.BackgroundFetchQueuePosition)
+	}
+	prefetch := "-"
+	if st.Prefetch != nil {
+		prefetch = fmt.Sprintf("%d/%d", st.Prefetch.ResolvedSpans, st.Prefetch.Spans)
+	}
+	fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", queue, prefetch, st.SpanCacheBytes, st.FileCacheBytes)
+}
@@ -121,6 +121,7 @@ func buildApp() *cli.Command {
 		Version: fmt.Sprintf("%s %s", version.Version, version.Revision),
 		Commands: []*cli.Command{
 			ConfigCommand,
+			DebugCommand,
 		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			lvl, err := logrus.ParseLevel(cmd.String("log-level"))
@@ -201,6 +202,10 @@ func buildApp() *cli.Command {
 			log.G(ctx).Debug("metadata store initialized")
 
 			fsOpts = append(fsOpts, fs.WithMetadataStore(mt))
+			if cfg.DebugAddress != "" {
+				// Served along with pprof on the debug address.
+				fsOpts = append(fsOpts, fs.WithDebugServeMux(http.DefaultServeMux))
+			}
 			rs, err := service.NewSociSnapshotterService(ctx, rootDir, &cfg.ServiceConfig,
 				service.WithCredsFuncs(credsFuncs...), service.WithFilesystemOptions(fsOpts...))
 			if err != nil {
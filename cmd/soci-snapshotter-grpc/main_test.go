@@ -18,12 +18,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/awslabs/soci-snapshotter/config"
+	"github.com/awslabs/soci-snapshotter/fs"
+	"github.com/awslabs/soci-snapshotter/fs/layer"
+	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 )
 
@@ -112,3 +120,64 @@ func writeTestConfig(t *testing.T, dir, content string) string {
 	}
 	return path
 }
+
+func TestDebugCommand(t *testing.T) {
+	dgst := digest.FromString("layer")
+	layers := []fs.DebugLayer{{
+		Mountpoint: "/mnt/1",
+		Status: layer.Status{
+			Digest:                       dgst,
+			SpanStates:                   map[string]int{"fetched": 3},
+			BackgroundFetchQueuePosition: -1,
+			Prefetch:                     &layer.PrefetchStatus{Spans: 4, ResolvedSpans: 2},
+		},
+	}}
+	mux := http.NewServeMux()
+	mux.HandleFunc(fs.DebugLayersPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(layers)
+	})
+	mux.HandleFunc(fs.DebugSpansPath(dgst), func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(fs.DebugSpans{Digest: dgst})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	run := func(t *testing.T, args ...string) string {
+		t.Helper()
+		outputPath := filepath.Join(t.TempDir(), "output")
+		outputFile, err := os.Create(outputPath)
+		if err != nil {
+			t.Fatalf("failed to create output file: %v", err)
+		}
+		defer outputFile.Close()
+
+		oldStdout := os.Stdout
+		os.Stdout = outputFile
+		defer func() { os.Stdout = oldStdout }()
+
+		args = append([]string{"soci-snapshotter-grpc", "debug"}, args...)
+		if err := buildApp().Run(context.Background(), args); err != nil {
+			t.Fatalf("failed to run %v: %v", args, err)
+		}
+		out, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+
+	out := run(t, "--debug-address", srv.Listener.Addr().String(), "layers")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "MOUNTPOINT") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	if fields := strings.Fields(lines[1]); !slices.Contains(fields, dgst.String()) || !slices.Contains(fields, "2/4") {
+		t.Fatalf("unexpected layer line %q", lines[1])
+	}
+
+	out = run(t, "--debug-address", srv.URL, "--json", "spans", dgst.String())
+	var spans fs.DebugSpans
+	if err := json.Unmarshal([]byte(out), &spans); err != nil || spans.Digest != dgst {
+		t.Fatalf("unexpected JSON output %q: %v", out, err)
+	}
+}
@@ -33,6 +33,7 @@ import (
 	"github.com/containerd/containerd/v2/pkg/reference"
 	"github.com/containerd/errdefs"
 	"github.com/urfave/cli/v3"
+	"oras.land/oras-go/v2/registry/remote"
 )
 
 const (
@@ -41,6 +42,7 @@ const (
 
 	outputFormatOCIArchive = "oci-archive"
 	outputFormatOCIDir     = "oci-dir"
+	outputFormatRegistry   = "registry"
 )
 
 var ErrInvalidDestRef = errors.New(`the destination image must be a tagged ref of the form "registry/repository:tag"`)
@@ -70,8 +72,9 @@ func verifyRef(r string) error {
 // The new image is added to the containerd content store and can
 // be pushed and deployed like a normal image.
 //
-// In standalone mode, the command reads an OCI image layout (tar or directory)
-// and writes a converted OCI image layout (tar or directory) without requiring containerd.
+// In standalone mode, the command reads an OCI image layout or a docker-archive (tar or directory),
+// or an image in a registry, and writes a converted OCI image layout (tar or directory) or pushes
+// the converted image to a registry without requiring containerd.
 var ConvertCommand = &cli.Command{
 	Name:      "convert",
 	Usage:     "convert an OCI image to a SOCI enabled image",
@@ -80,22 +83,34 @@ var ConvertCommand = &cli.Command{
 		internal.PlatformFlags,
 		createZtocFlags,
 		internal.PrefetchFlags,
+		internal.RemoteZtocFlags,
 		[]cli.Flag{
 			&cli.BoolFlag{
 				Name:  StandaloneFlag,
-				Usage: "Run in standalone mode without containerd runtime. In this mode, the command reads an OCI image layout (tar or directory) and writes a converted OCI image layout without requiring a running containerd instance.",
+				Usage: "Run in standalone mode without containerd runtime. In this mode, the command reads an OCI image layout or a docker-archive (tar or directory) and writes a converted OCI image layout without requiring a running containerd instance. With --format registry, the image is read from and pushed to registries.",
 			},
 			&cli.StringFlag{
-				Name:  outputFormatFlag,
-				Usage: "Output format for standalone mode: oci-archive (tar) or oci-dir (directory).",
+				Name: outputFormatFlag,
+				Usage: "Output format for standalone mode: oci-archive (tar), oci-dir (directory) or registry. With registry, <dest_ref> is a registry reference " +
+					"and <image_ref> is either a local OCI image layout or docker-archive, or a registry reference if no such file exists.",
 				Value: outputFormatOCIArchive,
 				Validator: func(s string) error {
-					if s != outputFormatOCIArchive && s != outputFormatOCIDir {
-						return fmt.Errorf("unsupported output format %q: must be %q or %q", s, outputFormatOCIArchive, outputFormatOCIDir)
+					if s != outputFormatOCIArchive && s != outputFormatOCIDir && s != outputFormatRegistry {
+						return fmt.Errorf("unsupported output format %q: must be %q, %q or %q", s, outputFormatOCIArchive, outputFormatOCIDir, outputFormatRegistry)
 					}
 					return nil
 				},
 			},
+			// Registry flags used by the registry format of standalone mode.
+			&cli.StringFlag{
+				Name:    internal.UserFlag,
+				Aliases: []string{"u"},
+				Usage:   "User[:password] Registry user and password, for the registry format of standalone mode",
+			},
+			&cli.BoolFlag{
+				Name:  internal.PlainHTTPFlag,
+				Usage: "Allow connections using plain HTTP, for the registry format of standalone mode",
+			},
 		}),
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		src := cmd.Args().Get(0)
@@ -193,10 +208,16 @@ var ConvertCommand = &cli.Command{
 }
 
 // runStandaloneConvert runs the convert command in standalone mode (without containerd).
-// It reads an OCI image layout (tar or directory), performs the SOCI conversion, and
-// writes the result as an OCI image layout tar or directory based on the --format flag.
+// It reads an OCI image layout or a docker-archive (tar or directory), or an image in a registry,
+// performs the SOCI conversion, and writes the result as an OCI image layout tar or directory
+// or pushes it to a registry based on the --format flag.
 func runStandaloneConvert(ctx context.Context, cmd *cli.Command, inputPath string, outputPath string) error {
 	format := cmd.String(outputFormatFlag)
+	if format == outputFormatRegistry {
+		if err := verifyRef(outputPath); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidDestRef, err)
+		}
+	}
 
 	// Prefer the OS temp dir (/tmp) since it's often memory-backed and faster.
 	// Fall back to the output path's parent for minimal environments (e.g., scratch images) where /tmp may not exist.
@@ -211,9 +232,21 @@ func runStandaloneConvert(ctx context.Context, cmd *cli.Command, inputPath strin
 	}
 	defer os.RemoveAll(ociLayoutDir)
 
-	imageInfo, err := internal.LoadImage(ctx, inputPath, ociLayoutDir)
-	if err != nil {
-		return err
+	var (
+		imageInfo *internal.StandaloneImageInfo
+		srcRepo   *remote.Repository
+	)
+	if _, statErr := os.Stat(inputPath); statErr != nil && format == outputFormatRegistry {
+		// The input is not on disk, so stream the image from its registry.
+		imageInfo, srcRepo, err = internal.LoadRemoteImage(ctx, cmd, inputPath, ociLayoutDir)
+		if err != nil {
+			return fmt.Errorf("failed to access input %s: %w", inputPath, errors.Join(statErr, err))
+		}
+	} else {
+		imageInfo, err = internal.LoadImage(ctx, inputPath, ociLayoutDir)
+		if err != nil {
+			return err
+		}
 	}
 
 	artifactsDir, err := os.MkdirTemp(tmpBase, "soci-artifacts-*")
@@ -255,7 +288,10 @@ func runStandaloneConvert(ctx context.Context, cmd *cli.Command, inputPath strin
 		return err
 	}
 
-	if format == outputFormatOCIDir {
+	switch format {
+	case outputFormatRegistry:
+		return internal.PushImageToRegistry(ctx, cmd, imageInfo, srcRepo, *convertedDesc, outputPath)
+	case outputFormatOCIDir:
 		return internal.SaveImageToDir(ociLayoutDir, *convertedDesc, outputPath)
 	}
 	return internal.SaveImageToTar(ctx, imageInfo.ContentStore, *convertedDesc, outputPath)
@@ -271,20 +307,34 @@ func parseBuilderOptions(cmd *cli.Command) ([]soci.BuilderOption, error) {
 		optimizations = append(optimizations, optimization)
 	}
 
+	created, err := parseCreated(cmd)
+	if err != nil {
+		return nil, err
+	}
+
 	builderOpts := []soci.BuilderOption{
 		soci.WithMinLayerSize(cmd.Int64(minLayerSizeFlag)),
 		soci.WithSpanSize(cmd.Int64(spanSizeFlag)),
 		soci.WithBuildToolIdentifier(buildToolIdentifier),
 		soci.WithOptimizations(optimizations),
 		soci.WithForceRecreateZtocs(cmd.Bool(forceRecreateZtocsFlag)),
+		soci.WithCreated(created),
 	}
 
-	allPrefetchFiles, err := internal.ParsePrefetchFiles(cmd)
+	allPrefetchFiles, prefetchPriorities, err := internal.ParsePrefetchFiles(cmd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse prefetch files: %w", err)
 	}
 	if len(allPrefetchFiles) > 0 {
-		builderOpts = append(builderOpts, soci.WithPrefetchPaths(allPrefetchFiles))
+		builderOpts = append(builderOpts, soci.WithPrefetchPaths(allPrefetchFiles), soci.WithPrefetchPathPriorities(prefetchPriorities))
+	}
+
+	remoteZtocSource, err := internal.NewRemoteZtocSource(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if remoteZtocSource != nil {
+		builderOpts = append(builderOpts, soci.WithRemoteZtocSource(remoteZtocSource))
 	}
 
 	return builderOpts, nil
@@ -20,7 +20,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"slices"
+	"strconv"
+	"time"
 
 	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/global"
 	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/internal"
@@ -40,6 +43,11 @@ const (
 	minLayerSizeFlag       = "min-layer-size"
 	optimizationFlag       = "optimizations"
 	forceRecreateZtocsFlag = "force"
+	createdFlag            = "created"
+
+	// sourceDateEpochEnv is the standard environment variable of reproducible builds
+	// with the build time as a Unix timestamp, used when --created isn't set.
+	sourceDateEpochEnv = "SOURCE_DATE_EPOCH"
 )
 
 var createZtocFlags = []cli.Flag{
@@ -63,6 +71,36 @@ var createZtocFlags = []cli.Flag{
 		Value:   false,
 		Aliases: []string{"f"},
 	},
+	&cli.StringFlag{
+		Name: createdFlag,
+		Usage: fmt.Sprintf("Set the org.opencontainers.image.created annotation of the SOCI index to an RFC 3339 timestamp, or to the current time with 'now'. "+
+			"Defaults to %s if it is set. Without the annotation, building an index is deterministic.", sourceDateEpochEnv),
+	},
+}
+
+// parseCreated returns the time set as the created annotation of the SOCI indexes, or
+// the zero time if the annotation shouldn't be set.
+func parseCreated(cmd *cli.Command) (time.Time, error) {
+	created := cmd.String(createdFlag)
+	switch {
+	case created == "now":
+		return time.Now(), nil
+	case created != "":
+		t, err := time.Parse(time.RFC3339, created)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid --%s: %w", createdFlag, err)
+		}
+		return t, nil
+	}
+	epoch, ok := os.LookupEnv(sourceDateEpochEnv)
+	if !ok || epoch == "" {
+		return time.Time{}, nil
+	}
+	sec, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %w", sourceDateEpochEnv, err)
+	}
+	return time.Unix(sec, 0), nil
 }
 
 // CreateCommand creates SOCI index for an image
@@ -73,7 +111,7 @@ var CreateCommand = &cli.Command{
 	Name:      "create",
 	Usage:     "create SOCI index",
 	ArgsUsage: "[flags] <image_ref>",
-	Flags:     slices.Concat(internal.PlatformFlags, createZtocFlags, internal.PrefetchFlags),
+	Flags:     slices.Concat(internal.PlatformFlags, createZtocFlags, internal.PrefetchFlags, internal.RemoteZtocFlags, internal.SigningFlags),
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		srcRef := cmd.Args().Get(0)
 		if srcRef == "" {
@@ -105,6 +143,10 @@ var CreateCommand = &cli.Command{
 		spanSize := cmd.Int64(spanSizeFlag)
 		minLayerSize := cmd.Int64(minLayerSizeFlag)
 		forceRecreateZtocs := cmd.Bool(forceRecreateZtocsFlag)
+		created, err := parseCreated(cmd)
+		if err != nil {
+			return err
+		}
 
 		blobStore, err := store.NewContentStore(internal.ContentStoreOptions(ctx, cmd)...)
 		if err != nil {
@@ -131,14 +173,28 @@ var CreateCommand = &cli.Command{
 			soci.WithOptimizations(optimizations),
 			soci.WithArtifactsDb(artifactsDb),
 			soci.WithForceRecreateZtocs(forceRecreateZtocs),
+			soci.WithCreated(created),
 		}
 
-		allPrefetchFiles, err := internal.ParsePrefetchFiles(cmd)
+		allPrefetchFiles, prefetchPriorities, err := internal.ParsePrefetchFiles(cmd)
 		if err != nil {
 			return fmt.Errorf("failed to parse prefetch files: %w", err)
 		}
 		if len(allPrefetchFiles) > 0 {
-			builderOpts = append(builderOpts, soci.WithPrefetchPaths(allPrefetchFiles))
+			builderOpts = append(builderOpts, soci.WithPrefetchPaths(allPrefetchFiles), soci.WithPrefetchPathPriorities(prefetchPriorities))
+		}
+
+		remoteZtocSource, err := internal.NewRemoteZtocSource(cmd)
+		if err != nil {
+			return err
+		}
+		if remoteZtocSource != nil {
+			builderOpts = append(builderOpts, soci.WithRemoteZtocSource(remoteZtocSource))
+		}
+
+		signingKey, err := internal.LoadSigningKey(cmd)
+		if err != nil {
+			return err
 		}
 
 		builder, err := soci.NewIndexBuilder(cs, blobStore, builderOpts...)
@@ -158,6 +214,14 @@ var CreateCommand = &cli.Command{
 				return err
 			}
 
+			if signingKey != nil {
+				sigDesc, err := soci.WriteIndexSignature(batchCtx, blobStore, artifactsDb, signingKey, indexWithMetadata.Desc)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Signed SOCI index %s: %s\n", indexWithMetadata.Desc.Digest, sigDesc.Digest)
+			}
+
 			if srcImg.Labels == nil {
 				srcImg.Labels = make(map[string]string)
 			}
@@ -0,0 +1,126 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/global"
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/internal"
+	"github.com/awslabs/soci-snapshotter/config"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	gcDryRunFlag       = "dry-run"
+	gcMaxStoreSizeFlag = "max-store-size"
+	gcGracePeriodFlag  = "grace-period"
+)
+
+var GCCommand = &cli.Command{
+	Name:  "gc",
+	Usage: "remove SOCI artifacts that are no longer needed",
+	UsageText: `
+	soci [global options] gc [--dry-run] [--max-store-size <bytes>] [--grace-period <duration>]
+
+	Remove the SOCI indexes whose image doesn't exist in any containerd namespace,
+	and the ztocs and prefetch artifacts not referenced by any remaining index, from
+	the content store and the artifacts database.
+	`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  gcDryRunFlag,
+			Usage: "print the SOCI artifacts that would be removed without removing them",
+		},
+		&cli.Int64Flag{
+			Name:  gcMaxStoreSizeFlag,
+			Usage: "maximum total size of the SOCI artifacts in bytes. The least recently used SOCI indexes, and their ztocs and prefetch artifacts, are removed until the SOCI artifacts fit. 0 means no limit",
+			Value: 0,
+		},
+		&cli.DurationFlag{
+			Name:  gcGracePeriodFlag,
+			Usage: "keep the SOCI indexes used within this duration, even if their image doesn't exist, and the artifacts created within it",
+			Value: config.DefaultGCGracePeriodSec * time.Second,
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		if cmd.Int64(gcMaxStoreSizeFlag) < 0 {
+			return errors.New("max store size must not be negative")
+		}
+		client, ctx, cancel, err := internal.NewClient(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		imageDigests, err := soci.ImageDigests(ctx, client.NamespaceService(), client.ImageService(), client.ContentStore())
+		if err != nil {
+			return err
+		}
+
+		opts := []soci.GCOption{
+			soci.GCWithMaxStoreSize(cmd.Int64(gcMaxStoreSizeFlag)),
+			soci.GCWithGracePeriod(cmd.Duration(gcGracePeriodFlag)),
+		}
+		dryRun := cmd.Bool(gcDryRunFlag)
+		if dryRun {
+			opts = append(opts, soci.GCWithDryRun())
+		}
+		contentStoreType, err := store.CanonicalizeContentStoreType(store.ContentStoreType(cmd.String(global.ContentStoreFlag)))
+		if err != nil {
+			return err
+		}
+		if contentStoreType == store.ContainerdContentStoreType {
+			// The artifacts db is shared by all namespaces of the containerd content store.
+			nss, err := client.NamespaceService().List(ctx)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, soci.GCWithNamespaces(nss...))
+		}
+
+		blobStore, err := store.NewContentStore(internal.ContentStoreOptions(ctx, cmd)...)
+		if err != nil {
+			return err
+		}
+		artifactsDb, err := soci.NewDB(soci.ArtifactsDbPath(cmd.String(global.RootFlag)))
+		if err != nil {
+			return err
+		}
+
+		report, err := soci.GarbageCollect(ctx, blobStore, artifactsDb, imageDigests, opts...)
+		if err != nil {
+			return err
+		}
+
+		action := "removed"
+		if dryRun {
+			action = "would remove"
+		}
+		for _, r := range report.Removed {
+			fmt.Printf("%s %s %s (%s)\n", action, r.Type, r.Digest, r.Reason)
+		}
+		fmt.Printf("%s %d SOCI artifacts (%d bytes), %d bytes of SOCI artifacts remaining\n",
+			action, len(report.Removed), report.RemovedSize, report.StoreSize)
+		return nil
+	},
+}
@@ -0,0 +1,138 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/global"
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/internal"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli/v3"
+)
+
+const outputFlag = "output"
+
+var exportCommand = &cli.Command{
+	Name:      "export",
+	Usage:     "export SOCI indices to an OCI image layout archive",
+	ArgsUsage: "[flags] <digest|image_ref>",
+	Description: `Export a SOCI index, or the most recent SOCI index of each platform of an image, with its ztocs,
+prefetch artifacts and signatures to a tar archive of an OCI image layout. The artifacts db entries of
+the artifacts are stored in the archive, so that "soci index import" can add the SOCI index to the
+SOCI store of another host, e.g. on a site without access to the registry.
+`,
+	Flags: slices.Concat(internal.PlatformFlags, []cli.Flag{
+		&cli.StringFlag{
+			Name:     outputFlag,
+			Aliases:  []string{"o"},
+			Usage:    "the path of the archive",
+			Required: true,
+		},
+	}),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		arg := cmd.Args().First()
+		if arg == "" {
+			return errors.New("please provide a SOCI index digest or an image reference")
+		}
+
+		artifactsDb, err := soci.NewDB(soci.ArtifactsDbPath(cmd.String(global.RootFlag)))
+		if err != nil {
+			return err
+		}
+
+		var indexDigests []digest.Digest
+		if dgst, err := digest.Parse(arg); err == nil {
+			indexDigests = append(indexDigests, dgst)
+		} else {
+			indexDigests, err = latestImageIndexes(ctx, cmd, artifactsDb, arg)
+			if err != nil {
+				return err
+			}
+		}
+
+		blobStore, err := store.NewContentStore(internal.ContentStoreOptions(ctx, cmd)...)
+		if err != nil {
+			return err
+		}
+
+		output := cmd.String(outputFlag)
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		err = soci.ExportIndexes(ctx, f, blobStore, artifactsDb, indexDigests...)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(output)
+			return err
+		}
+		for _, dgst := range indexDigests {
+			fmt.Printf("Exported SOCI index %s to %s\n", dgst, output)
+		}
+		return nil
+	},
+}
+
+// latestImageIndexes returns the most recent SOCI index of each platform of an image.
+func latestImageIndexes(ctx context.Context, cmd *cli.Command, artifactsDb *soci.ArtifactsDb, ref string) ([]digest.Digest, error) {
+	client, ctx, cancel, err := internal.NewClient(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	cs := client.ContentStore()
+	img, err := client.ImageService().Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := internal.GetPlatforms(ctx, cmd, img, cs)
+	if err != nil {
+		return nil, err
+	}
+	if len(ps) == 0 {
+		ps = append(ps, platforms.DefaultSpec())
+	}
+
+	var indexDigests []digest.Digest
+	for _, platform := range ps {
+		descs, _, err := soci.GetIndexDescriptorCollection(ctx, cs, artifactsDb, img, []ocispec.Platform{platform})
+		if err != nil {
+			return nil, err
+		}
+		if len(descs) == 0 {
+			return nil, fmt.Errorf("no SOCI index found for image %s and platform %s", ref, platforms.Format(platform))
+		}
+		sort.Slice(descs, func(i, j int) bool {
+			return descs[i].CreatedAt.Before(descs[j].CreatedAt)
+		})
+		indexDigests = append(indexDigests, descs[len(descs)-1].Digest)
+	}
+	return indexDigests, nil
+}
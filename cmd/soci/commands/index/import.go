@@ -0,0 +1,70 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/global"
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/internal"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/urfave/cli/v3"
+)
+
+var importCommand = &cli.Command{
+	Name:      "import",
+	Usage:     "import SOCI indices from an OCI image layout archive",
+	ArgsUsage: "<file>",
+	Description: `Import the SOCI indices of an archive created by "soci index export", with their ztocs, prefetch
+artifacts, signatures and artifacts db entries, into the SOCI store. SOCI index manifests v1 can then be
+pushed with "soci push". SOCI index manifests v2 are part of SOCI enabled images, so the image must be
+imported as well, e.g. with "ctr image import".
+`,
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		archive := cmd.Args().First()
+		if archive == "" {
+			return errors.New("please provide the path of an archive")
+		}
+
+		artifactsDb, err := soci.NewDB(soci.ArtifactsDbPath(cmd.String(global.RootFlag)))
+		if err != nil {
+			return err
+		}
+		blobStore, err := store.NewContentStore(internal.ContentStoreOptions(ctx, cmd)...)
+		if err != nil {
+			return err
+		}
+
+		ctx, done, err := blobStore.BatchOpen(ctx)
+		if err != nil {
+			return err
+		}
+		defer done(ctx)
+
+		descs, err := soci.ImportIndexes(ctx, archive, blobStore, artifactsDb)
+		if err != nil {
+			return err
+		}
+		for _, desc := range descs {
+			fmt.Printf("Imported SOCI index %s\n", desc.Digest)
+		}
+		return nil
+	},
+}
@@ -27,5 +27,9 @@ var Command = &cli.Command{
 		listCommand,
 		infoCommand,
 		rmCommand,
+		verifyCommand,
+		upgradeCommand,
+		exportCommand,
+		importCommand,
 	},
 }
@@ -105,7 +105,7 @@ func removeArtifactsAndContent(ctx context.Context, db *soci.ArtifactsDb, conten
 			return err
 		}
 		err = contentStore.Delete(ctx, dgst)
-		if err != nil {
+		if err != nil && !store.IsErrNotFound(err) {
 			return err
 		}
 	}
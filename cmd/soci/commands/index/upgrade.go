@@ -0,0 +1,120 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/global"
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/internal"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/errdefs"
+	"github.com/urfave/cli/v3"
+)
+
+var upgradeCommand = &cli.Command{
+	Name:      "upgrade",
+	Usage:     "upgrade SOCI index manifests v1 of an image to a SOCI enabled image",
+	ArgsUsage: "[flags] <image_ref> [<dest_ref>]",
+	Description: `Create a SOCI enabled image, like "soci convert", from the most recent SOCI index manifest v1
+of each platform of an image. The SOCI index manifests v2 reuse the ztocs and prefetch artifacts
+of the SOCI index manifests v1, so no layer is read.
+
+If <dest_ref> is not provided, <image_ref> is updated in place.
+`,
+	Flags: internal.PlatformFlags,
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		src := cmd.Args().Get(0)
+		if src == "" {
+			return errors.New("source image needs to be specified")
+		}
+		dst := cmd.Args().Get(1)
+		if dst == "" {
+			dst = src
+		}
+
+		client, ctx, cancel, err := internal.NewClient(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		cs := client.ContentStore()
+		is := client.ImageService()
+		srcImg, err := is.Get(ctx, src)
+		if err != nil {
+			return err
+		}
+
+		blobStore, err := store.NewContentStore(internal.ContentStoreOptions(ctx, cmd)...)
+		if err != nil {
+			return err
+		}
+
+		artifactsDb, err := soci.NewDB(soci.ArtifactsDbPath(cmd.String(global.RootFlag)))
+		if err != nil {
+			return err
+		}
+
+		builder, err := soci.NewIndexBuilder(cs, blobStore, soci.WithArtifactsDb(artifactsDb))
+		if err != nil {
+			return err
+		}
+
+		batchCtx, done, err := blobStore.BatchOpen(ctx)
+		if err != nil {
+			return err
+		}
+		defer done(ctx)
+
+		platforms, err := internal.GetPlatforms(ctx, cmd, srcImg, cs)
+		if err != nil {
+			return err
+		}
+
+		desc, err := builder.Upgrade(batchCtx, srcImg,
+			soci.ConvertWithPlatforms(platforms...),
+			// Like "soci convert", the image in containerd is the GC root of the upgraded image.
+			soci.ConvertWithNoGarbageCollectionLabels(),
+		)
+		if err != nil {
+			return err
+		}
+
+		img, err := is.Get(ctx, dst)
+		if err != nil {
+			if !errors.Is(err, errdefs.ErrNotFound) {
+				return err
+			}
+			img = images.Image{Name: dst}
+			img.Target = *desc
+			_, err = is.Create(ctx, img)
+		} else {
+			img.Target = *desc
+			_, err = is.Update(ctx, img)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Upgraded image %s to %s: %s\n", src, dst, desc.Digest)
+		return nil
+	},
+}
@@ -0,0 +1,105 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/global"
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/internal"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli/v3"
+)
+
+var verifyCommand = &cli.Command{
+	Name:  "verify",
+	Usage: "verify an index and its ztocs",
+	Description: "Verify that every ztoc and prefetch artifact of an index exists, and verify every ztoc " +
+		"against its layer blob in the content store. Exits with a non-zero status if any problem is found.",
+	ArgsUsage: "<digest> [<digest>...]",
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		args := cmd.Args().Slice()
+		if len(args) == 0 {
+			return errors.New("please provide at least one index digest")
+		}
+
+		client, ctx, cancel, err := internal.NewClient(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		db, err := soci.NewDB(soci.ArtifactsDbPath(cmd.String(global.RootFlag)))
+		if err != nil {
+			return err
+		}
+
+		blobStore, err := store.NewContentStore(internal.ContentStoreOptions(ctx, cmd)...)
+		if err != nil {
+			return err
+		}
+
+		var failed []string
+		for _, arg := range args {
+			indexDigest, err := digest.Parse(arg)
+			if err != nil {
+				return err
+			}
+			artifactType, err := db.GetArtifactType(indexDigest.String())
+			if err != nil {
+				return err
+			}
+			if artifactType == soci.ArtifactEntryTypeLayer {
+				return fmt.Errorf("the provided digest %s is of ztoc not SOCI index. Use \"soci ztoc verify\" command to verify a ztoc", indexDigest)
+			}
+
+			reader, err := blobStore.Fetch(ctx, v1.Descriptor{Digest: indexDigest})
+			if err != nil {
+				return err
+			}
+			var index soci.Index
+			err = soci.DecodeIndex(reader, &index)
+			reader.Close()
+			if err != nil {
+				return err
+			}
+
+			report, err := soci.VerifyIndex(ctx, &index, blobStore, client.ContentStore())
+			if err != nil {
+				return err
+			}
+			problems := report.Problems()
+			for _, p := range problems {
+				fmt.Printf("%s: %s\n", indexDigest, p)
+			}
+			if len(problems) > 0 {
+				failed = append(failed, indexDigest.String())
+				continue
+			}
+			fmt.Printf("%s: verified %d ztoc(s)\n", indexDigest, len(report.Ztocs))
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("%d index(es) failed verification: %v", len(failed), failed)
+		}
+		return nil
+	},
+}
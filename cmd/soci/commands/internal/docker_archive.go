@@ -0,0 +1,193 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// dockerArchiveManifest is an entry of the manifest.json file of a docker-archive
+// (the output of `docker save`).
+type dockerArchiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// isDockerArchive returns true if `dir` contains an extracted docker-archive
+// without an OCI image layout.
+func isDockerArchive(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, "manifest.json"))
+	return err == nil
+}
+
+// dockerArchiveToOCILayout turns an extracted docker-archive in `dir` into an OCI image layout
+// in place. The config and the layers are added to the blobs directory and an OCI image manifest
+// is created for the image. Layers keep their compression; the legacy layout stores uncompressed layers.
+func dockerArchiveToOCILayout(dir string) error {
+	b, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return fmt.Errorf("failed to read manifest.json: %w", err)
+	}
+	var archiveManifests []dockerArchiveManifest
+	if err := json.Unmarshal(b, &archiveManifests); err != nil {
+		return fmt.Errorf("failed to unmarshal manifest.json: %w", err)
+	}
+	if len(archiveManifests) != 1 {
+		return fmt.Errorf("docker-archive contains %d images, expected exactly 1", len(archiveManifests))
+	}
+	archiveManifest := archiveManifests[0]
+
+	configDesc, err := linkToBlobs(dir, archiveManifest.Config)
+	if err != nil {
+		return fmt.Errorf("failed to add config %s: %w", archiveManifest.Config, err)
+	}
+	configDesc.MediaType = ocispec.MediaTypeImageConfig
+	configBytes, err := os.ReadFile(blobPath(dir, configDesc.Digest))
+	if err != nil {
+		return err
+	}
+	var config ocispec.Image
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		return fmt.Errorf("failed to unmarshal image config: %w", err)
+	}
+
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+	}
+	for _, layer := range archiveManifest.Layers {
+		layerDesc, err := linkToBlobs(dir, layer)
+		if err != nil {
+			return fmt.Errorf("failed to add layer %s: %w", layer, err)
+		}
+		layerDesc.MediaType, err = dockerArchiveLayerMediaType(blobPath(dir, layerDesc.Digest))
+		if err != nil {
+			return fmt.Errorf("failed to read layer %s: %w", layer, err)
+		}
+		manifest.Layers = append(manifest.Layers, layerDesc)
+	}
+
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifestBytes),
+		Size:      int64(len(manifestBytes)),
+		Platform: &ocispec.Platform{
+			OS:           config.OS,
+			Architecture: config.Architecture,
+			Variant:      config.Variant,
+		},
+	}
+	if len(archiveManifest.RepoTags) > 0 {
+		manifestDesc.Annotations = map[string]string{
+			images.AnnotationImageName: archiveManifest.RepoTags[0],
+		}
+	}
+	if err := writeBlob(dir, manifestDesc.Digest, manifestBytes); err != nil {
+		return err
+	}
+
+	indexBytes, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifestDesc},
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "index.json"), indexBytes, 0644)
+}
+
+// dockerArchiveLayerMediaType returns the media type of a docker-archive layer,
+// which is an uncompressed tar unless the file starts with the gzip magic number.
+func dockerArchiveLayerMediaType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	magic, err := bufio.NewReader(f).Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return ocispec.MediaTypeImageLayerGzip, nil
+	}
+	return ocispec.MediaTypeImageLayer, nil
+}
+
+// linkToBlobs adds the file at `name` in the docker-archive `dir` to the blobs
+// directory of the OCI image layout and returns its digest and size. Files in a
+// docker-archive may be symlinks to other files, e.g. to deduplicate layers, so
+// blobs are hard links to the files, which are left in place.
+func linkToBlobs(dir string, name string) (ocispec.Descriptor, error) {
+	realPath, err := filepath.EvalSymlinks(filepath.Join(dir, name))
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	f, err := os.Open(realPath)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(digester.Hash(), f)
+	f.Close()
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc := ocispec.Descriptor{
+		Digest: digester.Digest(),
+		Size:   size,
+	}
+
+	dst := blobPath(dir, desc.Digest)
+	if _, err := os.Stat(dst); err == nil {
+		return desc, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return desc, os.Link(realPath, dst)
+}
+
+func writeBlob(dir string, dgst digest.Digest, data []byte) error {
+	path := blobPath(dir, dgst)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
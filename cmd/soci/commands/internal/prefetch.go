@@ -37,40 +37,73 @@ var PrefetchFlags = []cli.Flag{
 	},
 	&cli.StringFlag{
 		Name:  PrefetchFilesJSONFlag,
-		Usage: "Path to a JSON file containing a list of file paths to prefetch. The JSON file should contain an array of strings. Example: --prefetch-files-json '/path/to/prefetch.json'",
+		Usage: "Path to a JSON file containing a list of file paths to prefetch. The JSON file should contain an array of paths, or of objects with a \"path\" and a \"priority\"; files with lower priority values are prefetched first. Example: --prefetch-files-json '/path/to/prefetch.json'",
 	},
 }
 
-func ParsePrefetchFiles(cmd *cli.Command) ([]string, error) {
+// ParsePrefetchFiles returns the paths of the files to prefetch and the priorities of the
+// files which have one in the prefetch files JSON.
+func ParsePrefetchFiles(cmd *cli.Command) ([]string, map[string]int, error) {
 	var allPrefetchFiles []string
 
 	prefetchFiles := cmd.StringSlice(PrefetchFilesFlag)
 	allPrefetchFiles = append(allPrefetchFiles, trimAndFilterFiles(prefetchFiles)...)
 
+	var priorities map[string]int
 	jsonFilePath := cmd.String(PrefetchFilesJSONFlag)
 	if jsonFilePath != "" {
-		jsonFiles, err := loadPrefetchFilesFromJSON(jsonFilePath)
+		jsonFiles, jsonPriorities, err := loadPrefetchFilesFromJSON(jsonFilePath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load prefetch files from JSON: %w", err)
+			return nil, nil, fmt.Errorf("failed to load prefetch files from JSON: %w", err)
 		}
 		allPrefetchFiles = append(allPrefetchFiles, jsonFiles...)
+		priorities = jsonPriorities
 	}
 
-	return allPrefetchFiles, nil
+	return allPrefetchFiles, priorities, nil
 }
 
-func loadPrefetchFilesFromJSON(filePath string) ([]string, error) {
+// prefetchFileEntry is an entry of the prefetch files JSON. An entry is either
+// a path or an object with a path and a priority.
+type prefetchFileEntry struct {
+	Path     string `json:"path"`
+	Priority *int   `json:"priority,omitempty"`
+}
+
+func (e *prefetchFileEntry) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		e.Path = path
+		return nil
+	}
+	type entry prefetchFileEntry
+	return json.Unmarshal(data, (*entry)(e))
+}
+
+func loadPrefetchFilesFromJSON(filePath string) ([]string, map[string]int, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read JSON file: %w", err)
+		return nil, nil, fmt.Errorf("failed to read JSON file: %w", err)
 	}
 
-	var files []string
-	if err := json.Unmarshal(data, &files); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	var entries []prefetchFileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse JSON: %w", err)
 	}
 
-	return trimAndFilterFiles(files), nil
+	var files []string
+	priorities := make(map[string]int)
+	for _, e := range entries {
+		path := strings.TrimSpace(e.Path)
+		if path == "" {
+			continue
+		}
+		files = append(files, path)
+		if e.Priority != nil {
+			priorities[path] = *e.Priority
+		}
+	}
+	return files, priorities, nil
 }
 
 func trimAndFilterFiles(files []string) []string {
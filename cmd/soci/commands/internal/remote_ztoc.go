@@ -0,0 +1,55 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package internal
+
+import (
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	RemoteZtocRepositoryFlag = "remote-ztoc-repository"
+	RemoteZtocPlainHTTPFlag  = "remote-ztoc-plain-http"
+)
+
+var RemoteZtocFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name: RemoteZtocRepositoryFlag,
+		Usage: "Registry repository (e.g. registry.example.com/base-images) whose SOCI indexes are searched for " +
+			"existing zTOCs of layers. Matching zTOCs built with the same settings are reused instead of being rebuilt",
+	},
+	&cli.BoolFlag{
+		Name:  RemoteZtocPlainHTTPFlag,
+		Usage: "Allow connections to the remote zTOC repository using plain HTTP",
+	},
+}
+
+// NewRemoteZtocSource creates a `soci.RemoteZtocSource` for the repository in the
+// `--remote-ztoc-repository` flag. It returns nil if the flag is not set.
+func NewRemoteZtocSource(cmd *cli.Command) (*soci.RemoteZtocSource, error) {
+	repoRef := cmd.String(RemoteZtocRepositoryFlag)
+	if repoRef == "" {
+		return nil, nil
+	}
+	repo, err := NewRepository(cmd, repoRef, cmd.Bool(RemoteZtocPlainHTTPFlag))
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote ztoc repository %s: %w", repoRef, err)
+	}
+	return soci.NewRemoteZtocSource(repo), nil
+}
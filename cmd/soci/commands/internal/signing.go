@@ -0,0 +1,45 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package internal
+
+import (
+	"crypto/ecdsa"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	SignKeyFlag = "sign-key"
+)
+
+var SigningFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name: SignKeyFlag,
+		Usage: "Path to a PEM encoded ECDSA private key used to sign the SOCI index. " +
+			"The signature is stored as an artifact referring to the SOCI index and is pushed with it",
+	},
+}
+
+// LoadSigningKey loads the key in the `--sign-key` flag. It returns nil if the flag is not set.
+func LoadSigningKey(cmd *cli.Command) (*ecdsa.PrivateKey, error) {
+	path := cmd.String(SignKeyFlag)
+	if path == "" {
+		return nil, nil
+	}
+	return soci.LoadSigningKey(path)
+}
@@ -41,10 +41,11 @@ type StandaloneImageInfo struct {
 	OrasStore    *oci.Store
 }
 
-// LoadImage loads an OCI image layout (tar or directory) into a writable OCI store
-// and returns image metadata that can be used by IndexBuilder.
+// LoadImage loads an OCI image layout or a docker-archive (tar or directory) into a
+// writable OCI store and returns image metadata that can be used by IndexBuilder.
 // If inputPath is a directory, it is copied into tmpDir.
-// If inputPath is a tar file, it is extracted as an OCI image layout into tmpDir.
+// If inputPath is a tar file, it is extracted into tmpDir.
+// A docker-archive (the output of `docker save`) is turned into an OCI image layout.
 func LoadImage(ctx context.Context, inputPath string, tmpDir string) (*StandaloneImageInfo, error) {
 	fi, err := os.Stat(inputPath)
 	if err != nil {
@@ -69,6 +70,12 @@ func LoadImage(ctx context.Context, inputPath string, tmpDir string) (*Standalon
 		}
 	}
 
+	if isDockerArchive(tmpDir) {
+		if err := dockerArchiveToOCILayout(tmpDir); err != nil {
+			return nil, fmt.Errorf("failed to load docker-archive %s: %w", inputPath, err)
+		}
+	}
+
 	indexData, err := os.ReadFile(filepath.Join(tmpDir, "index.json"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read index.json from %s: %w", inputPath, err)
@@ -0,0 +1,271 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/pkg/reference"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli/v3"
+	oraslib "oras.land/oras-go/v2"
+	orascontent "oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// NewRepository creates a client for the registry repository of `ref`, with the
+// credentials from the `--user` flag or the docker config file.
+func NewRepository(cmd *cli.Command, ref string, plainHTTP bool) (*remote.Repository, error) {
+	refspec, err := reference.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference %s: %w", ref, err)
+	}
+	repo, err := remote.NewRepository(refspec.String())
+	if err != nil {
+		return nil, err
+	}
+
+	username, password := ResolveCredentials(cmd, refspec.Hostname())
+	repo.Client = &auth.Client{
+		Client: auth.DefaultClient.Client,
+		Cache:  auth.NewCache(),
+		Credential: func(_ context.Context, _ string) (auth.Credential, error) {
+			return auth.Credential{
+				Username: username,
+				Password: password,
+			}, nil
+		},
+	}
+	repo.PlainHTTP = plainHTTP
+	return repo, nil
+}
+
+// LoadRemoteImage resolves the image `ref` in its registry and returns image metadata that can be
+// used by IndexBuilder without a local copy of the image: manifests and layers are read from the
+// registry when they are needed, and layers are streamed while their ztocs are built.
+// The SOCI artifacts are written to a writable OCI store in tmpDir.
+func LoadRemoteImage(ctx context.Context, cmd *cli.Command, ref string, tmpDir string) (*StandaloneImageInfo, *remote.Repository, error) {
+	repo, err := NewRepository(cmd, ref, cmd.Bool(PlainHTTPFlag))
+	if err != nil {
+		return nil, nil, err
+	}
+	target, err := repo.Resolve(ctx, repo.Reference.Reference)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+
+	orasStore, err := oci.New(tmpDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create writable OCI store: %w", err)
+	}
+
+	return &StandaloneImageInfo{
+		ContentStore: &registryContentStore{repo: repo},
+		Image:        images.Image{Name: ref, Target: target},
+		OrasStore:    orasStore,
+	}, repo, nil
+}
+
+// PushImageToRegistry pushes the image `desc` to the registry reference `ref`. Blobs are read from
+// the OCI store of `image` first, which holds the SOCI artifacts and the converted manifests, then
+// from `srcRepo` for the content of the original image, if the image was loaded from a registry.
+func PushImageToRegistry(ctx context.Context, cmd *cli.Command, image *StandaloneImageInfo, srcRepo *remote.Repository, desc ocispec.Descriptor, ref string) error {
+	dst, err := NewRepository(cmd, ref, cmd.Bool(PlainHTTPFlag))
+	if err != nil {
+		return err
+	}
+	src := fallbackStorage{image.OrasStore}
+	opts := oraslib.DefaultCopyGraphOptions
+	if srcRepo != nil {
+		src = append(src, srcRepo)
+		if srcRepo.Reference.Registry == dst.Reference.Registry {
+			// Layers of the original image can be mounted from the source repository instead of
+			// being copied through this host.
+			opts.MountFrom = func(ctx context.Context, desc ocispec.Descriptor) ([]string, error) {
+				if exists, err := image.OrasStore.Exists(ctx, desc); err != nil || exists {
+					return nil, err
+				}
+				return []string{srcRepo.Reference.Repository}, nil
+			}
+		}
+	}
+	if err := oraslib.CopyGraph(ctx, src, dst, desc, opts); err != nil {
+		return fmt.Errorf("failed to push %s: %w", ref, err)
+	}
+	if err := dst.Tag(ctx, desc, dst.Reference.Reference); err != nil {
+		return fmt.Errorf("failed to tag %s: %w", ref, err)
+	}
+	return nil
+}
+
+// fallbackStorage reads blobs from the first storage that has them.
+type fallbackStorage []orascontent.ReadOnlyStorage
+
+func (s fallbackStorage) Fetch(ctx context.Context, target ocispec.Descriptor) (io.ReadCloser, error) {
+	for _, storage := range s {
+		exists, err := storage.Exists(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return storage.Fetch(ctx, target)
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", target.Digest, errdefs.ErrNotFound)
+}
+
+func (s fallbackStorage) Exists(ctx context.Context, target ocispec.Descriptor) (bool, error) {
+	for _, storage := range s {
+		exists, err := storage.Exists(ctx, target)
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// registryContentStore is a read-only content store for the blobs of a registry repository.
+// IndexBuilder only reads the image from its content store with `ReaderAt`.
+type registryContentStore struct {
+	repo *remote.Repository
+}
+
+var _ content.Store = &registryContentStore{}
+
+func (s *registryContentStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	return &registryReaderAt{ctx: ctx, repo: s.repo, desc: desc}, nil
+}
+
+func (s *registryContentStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	desc, err := s.repo.Blobs().Resolve(ctx, dgst.String())
+	if err != nil {
+		return content.Info{}, err
+	}
+	return content.Info{Digest: desc.Digest, Size: desc.Size}, nil
+}
+
+func (s *registryContentStore) Update(context.Context, content.Info, ...string) (content.Info, error) {
+	return content.Info{}, fmt.Errorf("registry content store is read-only: %w", errdefs.ErrNotImplemented)
+}
+
+func (s *registryContentStore) Walk(context.Context, content.WalkFunc, ...string) error {
+	return fmt.Errorf("cannot walk registry content store: %w", errdefs.ErrNotImplemented)
+}
+
+func (s *registryContentStore) Delete(context.Context, digest.Digest) error {
+	return fmt.Errorf("registry content store is read-only: %w", errdefs.ErrNotImplemented)
+}
+
+func (s *registryContentStore) Status(context.Context, string) (content.Status, error) {
+	return content.Status{}, fmt.Errorf("registry content store is read-only: %w", errdefs.ErrNotImplemented)
+}
+
+func (s *registryContentStore) ListStatuses(context.Context, ...string) ([]content.Status, error) {
+	return nil, fmt.Errorf("registry content store is read-only: %w", errdefs.ErrNotImplemented)
+}
+
+func (s *registryContentStore) Abort(context.Context, string) error {
+	return fmt.Errorf("registry content store is read-only: %w", errdefs.ErrNotImplemented)
+}
+
+func (s *registryContentStore) Writer(context.Context, ...content.WriterOpt) (content.Writer, error) {
+	return nil, fmt.Errorf("registry content store is read-only: %w", errdefs.ErrNotImplemented)
+}
+
+// registryReaderAt reads a blob from a registry. Sequential reads continue the same
+// response, so a layer read from start to end is streamed with a single request.
+type registryReaderAt struct {
+	ctx  context.Context
+	repo *remote.Repository
+	desc ocispec.Descriptor
+
+	mu     sync.Mutex
+	rc     io.ReadCloser
+	offset int64
+}
+
+func (r *registryReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if off >= r.desc.Size {
+		return 0, io.EOF
+	}
+	if err := r.seek(off); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.rc, p)
+	r.offset += int64(n)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// seek positions the response body at `off`, fetching the blob again if needed.
+func (r *registryReaderAt) seek(off int64) error {
+	if r.rc != nil && r.offset == off {
+		return nil
+	}
+	if r.rc == nil || off < r.offset {
+		if r.rc != nil {
+			r.rc.Close()
+		}
+		rc, err := r.repo.Fetch(r.ctx, r.desc)
+		if err != nil {
+			r.rc = nil
+			return err
+		}
+		r.rc = rc
+		r.offset = 0
+	}
+	// Blob responses from registries supporting range requests are seekable.
+	if seeker, ok := r.rc.(io.Seeker); ok {
+		if _, err := seeker.Seek(off, io.SeekStart); err != nil {
+			return err
+		}
+		r.offset = off
+		return nil
+	}
+	n, err := io.CopyN(io.Discard, r.rc, off-r.offset)
+	r.offset += n
+	return err
+}
+
+func (r *registryReaderAt) Size() int64 {
+	return r.desc.Size
+}
+
+func (r *registryReaderAt) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
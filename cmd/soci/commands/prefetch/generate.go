@@ -0,0 +1,113 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package prefetch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/global"
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/internal"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/ztoc"
+	"github.com/opencontainers/go-digest"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	traceFlag       = "trace"
+	filesOutputFlag = "files-output"
+	mergeFlag       = "merge"
+)
+
+var generateCommand = &cli.Command{
+	Name:      "generate",
+	Usage:     "generate prefetch artifacts from a recorded access trace",
+	ArgsUsage: "[flags] <image_ref>",
+	Description: `Generate prefetch artifacts from the files an image read after it was mounted.
+
+Access traces are recorded by the snapshotter when access recording is enabled in its config
+or with the "containerd.io/snapshot/remote/soci.record-access" snapshot label, and are saved
+under the snapshotter root directory.
+
+A new SOCI index is created from the most recent SOCI index of the image, with prefetch
+artifacts for the spans containing the recorded reads.
+` + updateDescription,
+	Flags: slices.Concat(
+		internal.PlatformFlags,
+		[]cli.Flag{
+			&cli.StringFlag{
+				Name:  traceFlag,
+				Usage: "Path of the access trace. Defaults to the trace the snapshotter saved for the image manifest",
+			},
+			&cli.StringFlag{
+				Name:  filesOutputFlag,
+				Usage: "Also write the paths of the files read to a JSON file which can be passed to --prefetch-files-json",
+			},
+			&cli.BoolFlag{
+				Name:  mergeFlag,
+				Usage: "Add the recorded spans to the existing prefetch artifacts of the index instead of replacing them",
+			},
+		},
+	),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		tracePath := cmd.String(traceFlag)
+		if tracePath != "" && (cmd.Bool(internal.AllPlatformsFlag) || len(cmd.StringSlice(internal.PlatformFlag)) > 1) {
+			return fmt.Errorf("--%s can only be used with a single platform", traceFlag)
+		}
+
+		root := cmd.String(global.RootFlag)
+		var files []string
+		err := updateIndexes(ctx, cmd, func(ctx context.Context, builder *soci.IndexBuilder, indexEntry *soci.ArtifactEntry, manifestDigest digest.Digest) (*soci.IndexWithMetadata, error) {
+			path := tracePath
+			if path == "" {
+				path = soci.AccessTracePath(root, manifestDigest)
+			}
+			trace, err := soci.ReadAccessTrace(path)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read access trace of image manifest %s: %w", manifestDigest, err)
+			}
+			files = append(files, trace.Files()...)
+
+			layerFiles := make(map[digest.Digest][]soci.FileAccessTrace, len(trace.Layers))
+			for _, l := range trace.Layers {
+				layerFiles[l.LayerDigest] = append(layerFiles[l.LayerDigest], l.Files...)
+			}
+			spansFn := func(layerDigest digest.Digest, toc *ztoc.Ztoc) ([]soci.PrefetchSpan, error) {
+				return soci.PrefetchSpansFromAccessTrace(toc, layerFiles[layerDigest])
+			}
+			return builder.UpdatePrefetchArtifacts(ctx, indexEntry, spansFn, cmd.Bool(mergeFlag))
+		})
+		if err != nil {
+			return err
+		}
+
+		if output := cmd.String(filesOutputFlag); output != "" {
+			b, err := json.MarshalIndent(files, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(output, b, 0644); err != nil {
+				return fmt.Errorf("cannot write prefetch files: %w", err)
+			}
+		}
+		return nil
+	},
+}
@@ -28,5 +28,8 @@ var Command = &cli.Command{
 	Commands: []*cli.Command{
 		listCommand,
 		infoCommand,
+		generateCommand,
+		addCommand,
+		setCommand,
 	},
 }
@@ -0,0 +1,189 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package prefetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/global"
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/internal"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli/v3"
+)
+
+var addCommand = &cli.Command{
+	Name:      "add",
+	Usage:     "add files to the prefetch artifacts of an existing SOCI index",
+	ArgsUsage: "[flags] <image_ref>",
+	Description: `Add files to the prefetch artifacts of the most recent SOCI index of an image.
+
+The files are resolved against the ztocs of the index, which are reused, and a new SOCI index
+is created with the existing prefetch artifacts and the spans of the files.
+` + updateDescription,
+	Flags: slices.Concat(internal.PlatformFlags, internal.PrefetchFlags),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		return updatePrefetchPaths(ctx, cmd, true)
+	},
+}
+
+var setCommand = &cli.Command{
+	Name:      "set",
+	Usage:     "replace the prefetch artifacts of an existing SOCI index",
+	ArgsUsage: "[flags] <image_ref>",
+	Description: `Replace the prefetch artifacts of the most recent SOCI index of an image.
+
+The files are resolved against the ztocs of the index, which are reused, and a new SOCI index
+is created with prefetch artifacts for the spans of the files only.
+` + updateDescription,
+	Flags: slices.Concat(internal.PlatformFlags, internal.PrefetchFlags),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		return updatePrefetchPaths(ctx, cmd, false)
+	},
+}
+
+const updateDescription = `
+If the index is a SOCI index manifest v1, push the new index with "soci push".
+If the index is a SOCI index manifest v2, the image manifest references the index, so the image
+is updated in place with new image manifests referencing the new index, like "soci convert" does.
+`
+
+func updatePrefetchPaths(ctx context.Context, cmd *cli.Command, merge bool) error {
+	paths, priorities, err := internal.ParsePrefetchFiles(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to parse prefetch files: %w", err)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("please provide files to prefetch with --%s or --%s", internal.PrefetchFilesFlag, internal.PrefetchFilesJSONFlag)
+	}
+	return updateIndexes(ctx, cmd, func(ctx context.Context, builder *soci.IndexBuilder, indexEntry *soci.ArtifactEntry, _ digest.Digest) (*soci.IndexWithMetadata, error) {
+		return builder.UpdatePrefetchPaths(ctx, indexEntry, paths, priorities, merge)
+	})
+}
+
+// updateIndexFunc creates a new SOCI index from the index `indexEntry` of the image manifest `manifestDigest`.
+type updateIndexFunc func(ctx context.Context, builder *soci.IndexBuilder, indexEntry *soci.ArtifactEntry, manifestDigest digest.Digest) (*soci.IndexWithMetadata, error)
+
+// updateIndexes replaces the most recent SOCI index of each platform of the image given
+// as argument with the index created by `update`. If the indexes are SOCI index manifests v2,
+// the image is updated to reference the new indexes.
+func updateIndexes(ctx context.Context, cmd *cli.Command, update updateIndexFunc) error {
+	ref := cmd.Args().First()
+	if ref == "" {
+		return errors.New("please provide an image reference")
+	}
+
+	client, ctx, cancel, err := internal.NewClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	cs := client.ContentStore()
+	is := client.ImageService()
+	img, err := is.Get(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	ps, err := internal.GetPlatforms(ctx, cmd, img, cs)
+	if err != nil {
+		return err
+	}
+	if len(ps) == 0 {
+		ps = append(ps, platforms.DefaultSpec())
+	}
+
+	artifactsDb, err := soci.NewDB(soci.ArtifactsDbPath(cmd.String(global.RootFlag)))
+	if err != nil {
+		return err
+	}
+
+	blobStore, err := store.NewContentStore(internal.ContentStoreOptions(ctx, cmd)...)
+	if err != nil {
+		return err
+	}
+
+	builder, err := soci.NewIndexBuilder(cs, blobStore, soci.WithArtifactsDb(artifactsDb))
+	if err != nil {
+		return err
+	}
+
+	var v2Indexes []*soci.IndexWithMetadata
+	for _, platform := range ps {
+		manifestDesc, err := soci.GetImageManifestDescriptor(ctx, cs, img.Target, platforms.OnlyStrict(platform))
+		if err != nil {
+			return err
+		}
+		indexEntry, err := latestIndex(ctx, cs, artifactsDb, img, platform)
+		if err != nil {
+			return err
+		}
+		index, err := update(ctx, builder, indexEntry, manifestDesc.Digest)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created SOCI index %s for platform %s from SOCI index %s\n", index.Desc.Digest, platforms.Format(platform), indexEntry.Digest)
+		if index.Index.ArtifactType == soci.SociIndexArtifactTypeV2 {
+			v2Indexes = append(v2Indexes, index)
+		}
+	}
+	if len(v2Indexes) == 0 {
+		return nil
+	}
+
+	batchCtx, done, err := blobStore.BatchOpen(ctx)
+	if err != nil {
+		return err
+	}
+	defer done(ctx)
+	// Like `soci convert`, the image in containerd is the GC root of the updated image.
+	desc, err := builder.UpdateConvertedImage(batchCtx, img, v2Indexes, soci.ConvertWithNoGarbageCollectionLabels())
+	if err != nil {
+		return err
+	}
+	img.Target = *desc
+	if _, err := is.Update(ctx, img, "target"); err != nil {
+		return err
+	}
+	fmt.Printf("Updated image %s: %s\n", img.Name, desc.Digest)
+	return nil
+}
+
+// latestIndex returns the artifact entry of the most recent SOCI index of an image for a platform.
+func latestIndex(ctx context.Context, cs content.Store, artifactsDb *soci.ArtifactsDb, img images.Image, platform ocispec.Platform) (*soci.ArtifactEntry, error) {
+	indexDescriptors, _, err := soci.GetIndexDescriptorCollection(ctx, cs, artifactsDb, img, []ocispec.Platform{platform})
+	if err != nil {
+		return nil, err
+	}
+	if len(indexDescriptors) == 0 {
+		return nil, fmt.Errorf("could not find any soci index for platform %v", platforms.Format(platform))
+	}
+	sort.Slice(indexDescriptors, func(i, j int) bool {
+		return indexDescriptors[i].CreatedAt.Before(indexDescriptors[j].CreatedAt)
+	})
+	return artifactsDb.GetArtifactEntry(indexDescriptors[len(indexDescriptors)-1].Digest.String())
+}
@@ -70,6 +70,7 @@ if they are available in the snapshotter's local content store.
 		internal.SnapshotterFlags,
 		internal.PlatformFlags,
 		internal.ExistingIndexFlags,
+		internal.SigningFlags,
 		[]cli.Flag{
 			&cli.Uint64Flag{
 				Name:  maxConcurrentUploadsFlag,
@@ -140,6 +141,11 @@ if they are available in the snapshotter's local content store.
 			return fmt.Errorf("cannot create local content store: %w", err)
 		}
 
+		signingKey, err := internal.LoadSigningKey(cmd)
+		if err != nil {
+			return err
+		}
+
 		dst.Client = authClient
 
 		dst.PlainHTTP = cmd.Bool(internal.PlainHTTPFlag)
@@ -262,6 +268,26 @@ if they are available in the snapshotter's local content store.
 				return fmt.Errorf("error pushing graph to remote: %w", err)
 			}
 
+			if signingKey != nil {
+				if _, err := soci.WriteIndexSignature(ctx, src, artifactsDb, signingKey, indexDesc.Descriptor); err != nil {
+					return err
+				}
+			}
+			// Signatures refer to the index, so they aren't part of its graph.
+			signatures, err := artifactsDb.GetIndexSignatures(indexDesc.Digest.String())
+			if err != nil {
+				return fmt.Errorf("cannot get signatures of soci index %s: %w", indexDesc.Digest, err)
+			}
+			for _, sigDesc := range signatures {
+				if !quiet {
+					fmt.Printf("pushing soci index signature with digest: %v\n", sigDesc.Digest)
+				}
+				err = oraslib.CopyGraph(ctx, src, dst, sigDesc, options)
+				if err != nil {
+					return fmt.Errorf("error pushing signature to remote: %w", err)
+				}
+			}
+
 		}
 		return nil
 	},
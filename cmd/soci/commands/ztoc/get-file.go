@@ -37,8 +37,10 @@ import (
 const outputFlag = "output"
 
 var getFileCommand = &cli.Command{
-	Name:      "get-file",
-	Usage:     "retrieve a file from a local image layer using a specified ztoc",
+	Name:  "get-file",
+	Usage: "retrieve a file from a local image layer using a specified ztoc",
+	Description: "Retrieve a file from a local image layer using a specified ztoc. " +
+		"If the ztoc contains the digest of the file, the retrieved content is verified against it.",
 	ArgsUsage: "<digest> <file>",
 	Flags: []cli.Flag{
 		&cli.StringFlag{
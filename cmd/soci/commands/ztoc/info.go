@@ -50,6 +50,7 @@ type FileInfo struct {
 	Type      string             `json:"type"`
 	StartSpan compression.SpanID `json:"start_span"`
 	EndSpan   compression.SpanID `json:"end_span"`
+	Digest    string             `json:"digest,omitempty"`
 }
 
 var infoCommand = &cli.Command{
@@ -116,6 +117,7 @@ var infoCommand = &cli.Command{
 				Type:      v.Type,
 				StartSpan: startSpan,
 				EndSpan:   endSpan,
+				Digest:    v.Digest.String(),
 			})
 		}
 		zinfo.NumMultiSpanFiles = multiSpanFiles
@@ -0,0 +1,79 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package ztoc
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/global"
+	"github.com/awslabs/soci-snapshotter/cmd/soci/commands/internal"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/opencontainers/go-digest"
+	"github.com/urfave/cli/v3"
+)
+
+var verifyCommand = &cli.Command{
+	Name:  "verify",
+	Usage: "verify a ztoc against its layer",
+	Description: "Verify a ztoc against the layer blob in the content store. Every span digest " +
+		"and every TOC entry is checked. Exits with a non-zero status if any problem is found.",
+	ArgsUsage: "<digest>",
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		ztocDigest, err := digest.Parse(cmd.Args().First())
+		if err != nil {
+			return err
+		}
+
+		client, ctx, cancel, err := internal.NewClient(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		toc, err := getZtoc(ctx, cmd, ztocDigest)
+		if err != nil {
+			return err
+		}
+
+		artifactsDB, err := soci.NewDB(soci.ArtifactsDbPath(cmd.String(global.RootFlag)))
+		if err != nil {
+			return err
+		}
+
+		layerReader, err := getLayer(ctx, artifactsDB, ztocDigest, client.ContentStore())
+		if err != nil {
+			return err
+		}
+		defer layerReader.Close()
+
+		report, err := toc.Verify(io.NewSectionReader(layerReader, 0, layerReader.Size()))
+		if err != nil {
+			return err
+		}
+		problems := report.Problems()
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("ztoc %s failed verification: %d problem(s) found", ztocDigest, len(problems))
+		}
+		fmt.Printf("ztoc %s verified\n", ztocDigest)
+		return nil
+	},
+}
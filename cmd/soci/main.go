@@ -60,6 +60,7 @@ func main() {
 			commands.ConvertCommand,
 			commands.PushCommand,
 			commands.RebuildDBCommand,
+			commands.GCCommand,
 		},
 		Before: func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
 			// Standalone convert doesn't need the snapshotter root path.
@@ -92,14 +92,14 @@ type Config struct {
 }
 type configParser func(*Config) error
 
-var parsers = []configParser{parseRootConfig, parseServiceConfig, parseFSConfig, parseParallelConfig}
+var parsers = []configParser{parseRootConfig, parseServiceConfig, parseFSConfig, parseParallelConfig, parsePullModesConfig}
 
 // NewConfig returns an initialized Config with default values set.
 func NewConfig() *Config {
 	cfg := &Config{}
 
 	// Set any defaults which do not align with Go zero values.
-	var initParsers = []configParser{defaultPullModes, defaultDirectoryCacheConfig, defaultBackgroundFetchConfig}
+	var initParsers = []configParser{defaultPullModes, defaultDirectoryCacheConfig, defaultBackgroundFetchConfig, defaultFetchSchedulerConfig}
 	if err := parseConfig(cfg, append(initParsers, parsers...)); err != nil {
 		return nil
 	}
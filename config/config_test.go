@@ -106,11 +106,31 @@ func TestConfigDefaults(t *testing.T) {
 			expected: int64(defaultFuseTimeoutSec),
 			actual:   cfg.FuseConfig.NegativeTimeout,
 		},
+		{
+			name:     "fuse passthrough",
+			expected: false,
+			actual:   cfg.FuseConfig.Passthrough,
+		},
+		{
+			name:     "fuse passthrough max file size",
+			expected: int64(defaultPassthroughMaxFileSize),
+			actual:   cfg.FuseConfig.PassthroughMaxFileSize,
+		},
 		{
 			name:     "fuse directory cache direct",
 			expected: true,
 			actual:   cfg.FSConfig.DirectoryCacheConfig.Direct,
 		},
+		{
+			name:     "fuse directory cache max size",
+			expected: int64(20 * 1024 * 1024 * 1024),
+			actual:   cfg.FSConfig.DirectoryCacheConfig.MaxSize,
+		},
+		{
+			name:     "fuse directory cache compression",
+			expected: defaultDirectoryCacheCompression,
+			actual:   cfg.FSConfig.DirectoryCacheConfig.Compression,
+		},
 		{
 			name:     "bg fetch period",
 			expected: int64(defaultBgFetchPeriodMsec),
@@ -207,6 +227,56 @@ func TestConfigDefaults(t *testing.T) {
 			expected: int64(defaultMaxConcurrentUnpacksPerImage),
 			actual:   cfg.PullModes.Parallel.MaxConcurrentUnpacksPerImage,
 		},
+		{
+			name:     "gc interval",
+			expected: int64(defaultGCIntervalSec),
+			actual:   cfg.GarbageCollectionConfig.IntervalSec,
+		},
+		{
+			name:     "gc grace period",
+			expected: int64(DefaultGCGracePeriodSec),
+			actual:   cfg.GarbageCollectionConfig.GracePeriodSec,
+		},
+		{
+			name:     "gc max store size",
+			expected: int64(0),
+			actual:   cfg.GarbageCollectionConfig.MaxStoreSize,
+		},
+		{
+			name:     "materialize check interval",
+			expected: int64(defaultMaterializeCheckIntervalSec),
+			actual:   cfg.MaterializeConfig.CheckIntervalSec,
+		},
+		{
+			name:     "read ahead max spans",
+			expected: int64(defaultReadAheadMaxSpans),
+			actual:   int64(cfg.ReadAheadConfig.MaxSpans),
+		},
+		{
+			name:     "file cache max bytes",
+			expected: int64(defaultFileCacheMaxBytes),
+			actual:   cfg.FileCacheConfig.MaxBytes,
+		},
+		{
+			name:     "file cache max file size",
+			expected: int64(defaultFileCacheMaxFileSize),
+			actual:   cfg.FileCacheConfig.MaxFileSize,
+		},
+		{
+			name:     "max spans per fetch",
+			expected: int64(defaultMaxSpansPerFetch),
+			actual:   int64(cfg.BlobConfig.MaxSpansPerFetch),
+		},
+		{
+			name:     "fetch max concurrency per registry",
+			expected: int64(defaultFetchMaxConcurrencyPerRegistry),
+			actual:   int64(cfg.FetchSchedulerConfig.MaxConcurrencyPerRegistry),
+		},
+		{
+			name:     "fetch reserved on demand concurrency",
+			expected: int64(defaultFetchReservedOnDemandConcurrency),
+			actual:   int64(cfg.FetchSchedulerConfig.ReservedOnDemandConcurrency),
+		},
 	}
 
 	for _, tc := range tests {
@@ -369,6 +439,308 @@ drop_policy = "random"
 				}
 			},
 		},
+		{
+			name: "GarbageCollection",
+			config: []byte(`
+[garbage_collection]
+enable = true
+interval_sec = 60
+max_store_size = "10GB"
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				if !actual.GarbageCollectionConfig.Enable {
+					t.Error("Expected garbage_collection to be enabled")
+				}
+				if actual.GarbageCollectionConfig.IntervalSec != 60 {
+					t.Errorf("Expected interval_sec 60, got %d", actual.GarbageCollectionConfig.IntervalSec)
+				}
+				if actual.GarbageCollectionConfig.GracePeriodSec != DefaultGCGracePeriodSec {
+					t.Errorf("Expected grace_period_sec to default to %d, got %d", DefaultGCGracePeriodSec, actual.GarbageCollectionConfig.GracePeriodSec)
+				}
+				if actual.GarbageCollectionConfig.MaxStoreSize != 10*1024*1024*1024 {
+					t.Errorf("Expected max_store_size to be %d, got %d", 10*1024*1024*1024, actual.GarbageCollectionConfig.MaxStoreSize)
+				}
+			},
+		},
+		{
+			name: "Materialize",
+			config: []byte(`
+[materialize]
+enable = true
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if !actual.MaterializeConfig.Enable {
+					t.Error("Expected materialize to be enabled")
+				}
+				if actual.MaterializeConfig.CheckIntervalSec != defaultMaterializeCheckIntervalSec {
+					t.Errorf("Expected check_interval_sec to default to %d, got %d", defaultMaterializeCheckIntervalSec, actual.MaterializeConfig.CheckIntervalSec)
+				}
+			},
+		},
+		{
+			name: "FusePassthrough",
+			config: []byte(`
+[fuse]
+passthrough = true
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if !actual.FuseConfig.Passthrough {
+					t.Error("Expected FUSE passthrough to be enabled")
+				}
+				if actual.FuseConfig.AttrTimeout != defaultFuseTimeoutSec {
+					t.Errorf("Expected attr_timeout to default to %d, got %d", defaultFuseTimeoutSec, actual.FuseConfig.AttrTimeout)
+				}
+				if actual.FuseConfig.PassthroughMaxFileSize != defaultPassthroughMaxFileSize {
+					t.Errorf("Expected passthrough_max_file_size to default to %d, got %d", defaultPassthroughMaxFileSize, actual.FuseConfig.PassthroughMaxFileSize)
+				}
+			},
+		},
+		{
+			name: "FusePassthroughNegativeMaxFileSize",
+			config: []byte(`
+[fuse]
+passthrough = true
+passthrough_max_file_size = -1
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err == nil {
+					t.Fatal("Expected an error for a negative passthrough_max_file_size")
+				}
+			},
+		},
+		{
+			name: "FileCache",
+			config: []byte(`
+[file_cache]
+max_bytes = 1048576
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if actual.FileCacheConfig.MaxBytes != 1048576 {
+					t.Errorf("Expected max_bytes to be 1048576, got %d", actual.FileCacheConfig.MaxBytes)
+				}
+				if actual.FileCacheConfig.MaxFileSize != defaultFileCacheMaxFileSize {
+					t.Errorf("Expected max_file_size to default to %d, got %d", defaultFileCacheMaxFileSize, actual.FileCacheConfig.MaxFileSize)
+				}
+			},
+		},
+		{
+			name: "FileCacheNegativeMaxFileSize",
+			config: []byte(`
+[file_cache]
+max_file_size = -1
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err == nil {
+					t.Fatal("Expected an error for a negative max_file_size")
+				}
+			},
+		},
+		{
+			name: "MaterializeNegativeCheckInterval",
+			config: []byte(`
+[materialize]
+check_interval_sec = -1
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err == nil {
+					t.Error("Expected error for negative check_interval_sec, got none")
+				}
+			},
+		},
+		{
+			name: "GarbageCollectionInvalidMaxStoreSize",
+			config: []byte(`
+[garbage_collection]
+max_store_size = "big"
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err == nil {
+					t.Error("Expected error for invalid max_store_size, got none")
+				}
+			},
+		},
+		{
+			name: "DirectoryCacheMaxSize",
+			config: []byte(`
+[directory_cache]
+max_size = "50GB"
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if actual.DirectoryCacheConfig.MaxSize != 50*1024*1024*1024 {
+					t.Errorf("Expected max_size to be %d, got %d", 50*1024*1024*1024, actual.DirectoryCacheConfig.MaxSize)
+				}
+			},
+		},
+		{
+			name: "DirectoryCacheCompression",
+			config: []byte(`
+[directory_cache]
+compression = "zstd"
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if actual.DirectoryCacheConfig.Compression != DirectoryCacheCompressionZstd {
+					t.Errorf("Expected compression zstd, got %q", actual.DirectoryCacheConfig.Compression)
+				}
+			},
+		},
+		{
+			name: "DirectoryCacheInvalidCompression",
+			config: []byte(`
+[directory_cache]
+compression = "lzma"
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err == nil {
+					t.Error("Expected error for invalid compression, got none")
+				}
+			},
+		},
+		{
+			name: "DirectoryCacheInvalidMaxSize",
+			config: []byte(`
+[directory_cache]
+max_size = "big"
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err == nil {
+					t.Error("Expected error for invalid max_size, got none")
+				}
+			},
+		},
+		{
+			name: "DirectoryCacheUnlimitedMaxSize",
+			config: []byte(`
+[directory_cache]
+max_size = "0"
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if actual.DirectoryCacheConfig.MaxSize != 0 {
+					t.Errorf("Expected max_size to be unlimited, got %d", actual.DirectoryCacheConfig.MaxSize)
+				}
+			},
+		},
+		{
+			name: "FetchScheduler",
+			config: []byte(`
+[fetch_scheduler]
+max_concurrency_per_registry = 2
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if actual.FetchSchedulerConfig.MaxConcurrencyPerRegistry != 2 {
+					t.Errorf("Expected max_concurrency_per_registry 2, got %d", actual.FetchSchedulerConfig.MaxConcurrencyPerRegistry)
+				}
+				if actual.FetchSchedulerConfig.ReservedOnDemandConcurrency != 1 {
+					t.Errorf("Expected reserved_on_demand_concurrency to be capped to 1, got %d", actual.FetchSchedulerConfig.ReservedOnDemandConcurrency)
+				}
+			},
+		},
+		{
+			name: "FetchSchedulerInvalidReservedConcurrency",
+			config: []byte(`
+[fetch_scheduler]
+reserved_on_demand_concurrency = -1
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err == nil {
+					t.Error("Expected error for negative reserved_on_demand_concurrency, got none")
+				}
+			},
+		},
+		{
+			name: "IndexSelectionPolicyBuildTool",
+			config: []byte(`
+[pull_modes.soci_v1]
+enable = true
+index_selection_policy = "build_tool"
+preferred_build_tool = "AWS SOCI CLI v0.10.0"
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				if actual.PullModes.SOCIv1.IndexSelectionPolicy != IndexSelectionPolicyBuildTool {
+					t.Errorf("Expected index_selection_policy build_tool, got %q", actual.PullModes.SOCIv1.IndexSelectionPolicy)
+				}
+			},
+		},
+		{
+			name: "IndexSelectionPolicyBuildToolMissing",
+			config: []byte(`
+[pull_modes.soci_v1]
+index_selection_policy = "build_tool"
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err == nil {
+					t.Error("Expected error for build_tool policy without preferred_build_tool, got none")
+				}
+			},
+		},
+		{
+			name: "IndexSelectionPolicyAnnotation",
+			config: []byte(`
+[pull_modes.soci_v1]
+index_selection_policy = "annotation"
+required_annotation = "team=platform=x"
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				key, value, _ := actual.PullModes.SOCIv1.RequiredAnnotationKeyValue()
+				if key != "team" || value != "platform=x" {
+					t.Errorf("Expected annotation team=platform=x, got %s=%s", key, value)
+				}
+			},
+		},
+		{
+			name: "IndexSelectionPolicyAnnotationInvalid",
+			config: []byte(`
+[pull_modes.soci_v1]
+index_selection_policy = "annotation"
+required_annotation = "team"
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err == nil {
+					t.Error("Expected error for invalid required_annotation, got none")
+				}
+			},
+		},
+		{
+			name: "IndexSelectionPolicyInvalid",
+			config: []byte(`
+[pull_modes.soci_v1]
+index_selection_policy = "random"
+`),
+			assert: func(t *testing.T, actual *Config, err error) {
+				if err == nil {
+					t.Error("Expected error for invalid index_selection_policy, got none")
+				}
+			},
+		},
 		{
 			name: "ParallelPullAsFallback",
 			config: []byte(`
@@ -62,6 +62,10 @@ const (
 const (
 	defaultFuseTimeoutSec = 1
 
+	// defaultPassthroughMaxFileSize is the default size of the largest files served
+	// with FUSE passthrough.
+	defaultPassthroughMaxFileSize = 16 * 1024 * 1024 // 16 MiB
+
 	// defaultBgSilencePeriodMsec specifies the amount of time the background fetcher will wait once a new layer comes in
 	// before (re)starting fetches.
 	defaultBgSilencePeriodMsec = 30_000
@@ -82,6 +86,14 @@ const (
 	// defaultBgMetricEmitPeriodSec is the default amount of interval at which the background fetcher emits metrics
 	defaultBgMetricEmitPeriodSec = 10
 
+	// defaultDirectoryCacheCompression is the codec of the spans cached on disk.
+	// Cached spans are stored verbatim by default.
+	defaultDirectoryCacheCompression = DirectoryCacheCompressionNone
+
+	// defaultDirectoryCacheMaxSize is the maximum total size of the spans cached on disk.
+	// Cached spans outlive their layers, so they must be bounded by default.
+	defaultDirectoryCacheMaxSize = "20GB"
+
 	// defaultMountTimeoutSec is the amount of time Mount will time out if a layer can't be resolved.
 	defaultMountTimeoutSec = 30
 
@@ -99,10 +111,49 @@ const (
 	// 0 means no limit
 	defaultPrefetchMaxConcurrency = 0
 
+	// defaultAccessRecordingDurationSec is the default length of the window in which
+	// file accesses are recorded after an image is mounted.
+	defaultAccessRecordingDurationSec = 60
+
+	// defaultGCIntervalSec is the default time between two background garbage collections.
+	defaultGCIntervalSec = 3600
+
+	// DefaultGCGracePeriodSec is the default time during which SOCI indexes are kept
+	// after they are used, even if their image doesn't exist.
+	DefaultGCGracePeriodSec = 600
+
+	// defaultMaterializeCheckIntervalSec is the default time between two checks for
+	// the fully fetched layers to materialize.
+	defaultMaterializeCheckIntervalSec = 10
+
+	// defaultReadAheadMaxSpans is the default maximum number of spans resolved ahead
+	// of the sequential reads of a file handle.
+	defaultReadAheadMaxSpans = 8
+
+	// defaultFileCacheMaxBytes is the default maximum total size of the files cached
+	// per layer by the file cache.
+	defaultFileCacheMaxBytes = 4 * 1024 * 1024 // 4 MiB
+
+	// defaultFileCacheMaxFileSize is the default size of the largest files cached by
+	// the file cache.
+	defaultFileCacheMaxFileSize = 128 * 1024 // 128 KiB
+
+	// defaultFetchMaxConcurrencyPerRegistry is the default maximum number of concurrent
+	// span fetches from a registry.
+	defaultFetchMaxConcurrencyPerRegistry = 32
+
+	// defaultFetchReservedOnDemandConcurrency is the default number of concurrent span
+	// fetches from a registry which are reserved for on-demand reads.
+	defaultFetchReservedOnDemandConcurrency = 4
+
 	defaultValidIntervalSec = 60
 
 	defaultFetchTimeoutSec = 300
 
+	// defaultMaxSpansPerFetch is the default maximum number of spans fetched with a
+	// single multi-range request.
+	defaultMaxSpansPerFetch = 16
+
 	// defaultDialTimeoutMsec is the default number of milliseconds before timeout while connecting to a remote endpoint. See `TimeoutConfig.DialTimeout`.
 	defaultDialTimeoutMsec = 3_000
 	// defaultResponseHeaderTimeoutMsec is the default number of milliseconds before timeout while waiting for response header from a remote endpoint. See `TimeoutConfig.ResponseHeaderTimeout`.
@@ -125,6 +176,9 @@ const (
 	// DefaultSOCIV1Enable is the default value for whether SOCI v1 is enabled
 	DefaultSOCIV1Enable = false
 
+	// DefaultIndexSelectionPolicy is the default policy to select one of the SOCI v1 indexes of an image
+	DefaultIndexSelectionPolicy = IndexSelectionPolicyFirst
+
 	// DefaultSOCIV2Enable is the default value for whether SOCI v2 is enabled
 	DefaultSOCIV2Enable = true
 
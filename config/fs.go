@@ -39,6 +39,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -65,9 +66,91 @@ type FSConfig struct {
 
 	BackgroundFetchConfig `toml:"background_fetch"`
 
+	ReadAheadConfig `toml:"read_ahead"`
+
+	FileCacheConfig `toml:"file_cache"`
+
 	ContentStoreConfig `toml:"content_store"`
 
 	PrefetchConfig `toml:"prefetch"`
+
+	IndexSigningConfig `toml:"index_signing"`
+
+	AccessRecordingConfig `toml:"access_recording"`
+
+	GarbageCollectionConfig `toml:"garbage_collection"`
+
+	FetchSchedulerConfig `toml:"fetch_scheduler"`
+
+	MaterializeConfig `toml:"materialize"`
+}
+
+// MaterializeConfig configures the extraction of fully fetched lazily loaded layers
+// into regular directories, which are mounted instead of their FUSE mounts.
+type MaterializeConfig struct {
+	// Enable extracts every lazily loaded layer once all of its spans are cached,
+	// e.g. by the background fetcher. The FUSE mount of an extracted layer is
+	// kept until its snapshot is removed.
+	Enable bool `toml:"enable"`
+
+	// CheckIntervalSec is the time between two checks for the layers to extract.
+	CheckIntervalSec int64 `toml:"check_interval_sec"`
+}
+
+// FetchSchedulerConfig configures the scheduling of span fetches from remote registries.
+// On-demand reads are fetched before prefetch, which is fetched before background fetch.
+type FetchSchedulerConfig struct {
+	// MaxConcurrencyPerRegistry is the maximum number of concurrent span fetches from
+	// each registry. Further fetches are queued by priority. -1 means no limit.
+	MaxConcurrencyPerRegistry int `toml:"max_concurrency_per_registry"`
+
+	// ReservedOnDemandConcurrency is the number of the MaxConcurrencyPerRegistry fetches
+	// which are only used by on-demand reads, so that prefetch and background fetch never
+	// hold every connection to a registry. It is capped to MaxConcurrencyPerRegistry-1.
+	ReservedOnDemandConcurrency int `toml:"reserved_on_demand_concurrency"`
+}
+
+// GarbageCollectionConfig configures the background garbage collection of the SOCI
+// artifacts in the content store, like `soci gc`.
+type GarbageCollectionConfig struct {
+	// Enable runs the garbage collection periodically.
+	Enable bool `toml:"enable"`
+
+	// IntervalSec is the time between two garbage collections.
+	IntervalSec int64 `toml:"interval_sec"`
+
+	// GracePeriodSec keeps the SOCI indexes used in the last GracePeriodSec seconds,
+	// even if their image doesn't exist yet, e.g. while the image is pulled.
+	GracePeriodSec int64 `toml:"grace_period_sec"`
+
+	// MaxStoreSizeStr is the maximum total size of the SOCI artifacts, e.g. "10GB".
+	// The least recently used SOCI indexes, and their ztocs and prefetch artifacts,
+	// are removed until the SOCI artifacts fit. Empty or 0 means no limit.
+	MaxStoreSizeStr string `toml:"max_store_size"`
+	MaxStoreSize    int64  `toml:"-"`
+}
+
+// AccessRecordingConfig configures the recording of the files read from lazily loaded
+// images. The recorded traces can be turned into prefetch artifacts with `soci prefetch generate`.
+type AccessRecordingConfig struct {
+	// Enable records the file accesses of every lazily loaded image. Recording can
+	// also be enabled per image with the `containerd.io/snapshot/remote/soci.record-access`
+	// snapshot label.
+	Enable bool `toml:"enable"`
+
+	// DurationSec is the length of the window after the first mount of an image in
+	// which file accesses are recorded.
+	DurationSec int64 `toml:"duration_sec"`
+}
+
+// IndexSigningConfig configures the verification of SOCI index signatures.
+type IndexSigningConfig struct {
+	// Enable controls whether SOCI indexes must be signed by one of TrustedPublicKeys.
+	// Images whose SOCI index is unsigned or has no valid signature are not lazy loaded.
+	Enable bool `toml:"enable"`
+
+	// TrustedPublicKeys are paths to PEM encoded ECDSA public keys.
+	TrustedPublicKeys []string `toml:"trusted_public_keys"`
 }
 
 // PrefetchConfig configures the prefetch feature for downloading specified files
@@ -77,8 +160,9 @@ type PrefetchConfig struct {
 	Enable bool `toml:"enable"`
 
 	// MaxConcurrency limits the maximum number of layers that can perform
-	// prefetch operations concurrently at the snapshotter level.
-	// 0 means no limit.
+	// prefetch operations concurrently at the snapshotter level. It is the number
+	// of workers fetching the prefetched spans of every layer.
+	// 0 means one worker per CPU.
 	MaxConcurrency int64 `toml:"max_concurrency"`
 }
 
@@ -95,18 +179,43 @@ type BlobConfig struct {
 	// MaxSpanVerificationRetries defines the number of additional times fetch
 	// will be invoked in case of span verification failure.
 	MaxSpanVerificationRetries int `toml:"max_span_verification_retries"`
+
+	// MaxSpansPerFetch is the maximum number of spans needed by a read or a prefetch
+	// which are fetched with a single multi-range request. 1 fetches every span separately.
+	MaxSpansPerFetch int `toml:"max_spans_per_fetch"`
+
+	// DisableLocalBlobs disables serving the spans of the layer blobs present in the
+	// content store from the content store instead of the registry.
+	DisableLocalBlobs bool `toml:"disable_local_blobs"`
 }
 
+// Codecs of the spans cached on disk.
+const (
+	DirectoryCacheCompressionNone = "none"
+	DirectoryCacheCompressionZstd = "zstd"
+)
+
 // DirectoryCacheConfig is config for directory-based cache.
 type DirectoryCacheConfig struct {
 	MaxLRUCacheEntry int  `toml:"max_lru_cache_entry"`
 	MaxCacheFds      int  `toml:"max_cache_fds"`
 	SyncAdd          bool `toml:"sync_add"`
 	Direct           bool `toml:"direct"`
+
+	// MaxSizeStr is the maximum total size of the cached spans of every layer, e.g. "50GB".
+	// The least recently used spans which aren't being read are removed until the cached
+	// spans fit. Empty means the default size, 0 means no limit.
+	MaxSizeStr string `toml:"max_size"`
+	MaxSize    int64  `toml:"-"`
+
+	// Compression is the codec with which the cached spans are compressed on disk:
+	// "none" or "zstd".
+	Compression string `toml:"compression"`
 }
 
 func defaultDirectoryCacheConfig(cfg *Config) error {
 	cfg.FSConfig.DirectoryCacheConfig.Direct = true
+	cfg.FSConfig.DirectoryCacheConfig.MaxSizeStr = defaultDirectoryCacheMaxSize
 	return nil
 }
 
@@ -116,6 +225,11 @@ func defaultBackgroundFetchConfig(cfg *Config) error {
 	return nil
 }
 
+func defaultFetchSchedulerConfig(cfg *Config) error {
+	cfg.FSConfig.FetchSchedulerConfig.ReservedOnDemandConcurrency = defaultFetchReservedOnDemandConcurrency
+	return nil
+}
+
 type FuseConfig struct {
 	// AttrTimeout defines overall timeout attribute for a file system in seconds.
 	AttrTimeout int64 `toml:"attr_timeout"`
@@ -130,6 +244,41 @@ type FuseConfig struct {
 	// for debugging purposes only. This option may emit sensitive information,
 	// e.g. filenames and paths within an image
 	LogFuseOperations bool `toml:"log_fuse_operations"`
+
+	// Passthrough serves the reads of the regular files whose spans are all cached
+	// with FUSE passthrough (Linux 6.9+), so that the kernel reads them from backing
+	// files without calling the snapshotter. Reads go through the snapshotter
+	// if the kernel doesn't support passthrough.
+	Passthrough bool `toml:"passthrough"`
+
+	// PassthroughMaxFileSize is the size in bytes of the largest files served with
+	// FUSE passthrough. The contents of a file are copied to its backing file when
+	// it's opened, which delays the open.
+	PassthroughMaxFileSize int64 `toml:"passthrough_max_file_size"`
+}
+
+// ReadAheadConfig configures the read-ahead of the spans following the sequential
+// reads of a file.
+type ReadAheadConfig struct {
+	Disable bool `toml:"disable"`
+
+	// MaxSpans is the maximum number of spans resolved ahead of the sequential
+	// reads of a file handle. The read-ahead window starts with one span and
+	// doubles on every span entered by sequential reads, up to MaxSpans.
+	MaxSpans int `toml:"max_spans"`
+}
+
+// FileCacheConfig configures the in-memory cache of the contents of the small files
+// of a layer, which are read without their spans once they were read completely.
+type FileCacheConfig struct {
+	Disable bool `toml:"disable"`
+
+	// MaxBytes is the maximum total size of the files cached per layer. The least
+	// recently used files are evicted beyond it.
+	MaxBytes int64 `toml:"max_bytes"`
+
+	// MaxFileSize is the size of the largest files cached.
+	MaxFileSize int64 `toml:"max_file_size"`
 }
 
 type BackgroundFetchConfig struct {
@@ -234,7 +383,7 @@ func parseFSConfig(cfg *Config) error {
 	}
 
 	// Parse nested fs configs
-	parsers := []configParser{parseFuseConfig, parseBackgroundFetchConfig, parseRetryableHTTPClientConfig, parseBlobConfig, parseContentStoreConfig}
+	parsers := []configParser{parseFuseConfig, parseBackgroundFetchConfig, parseReadAheadConfig, parseFileCacheConfig, parseRetryableHTTPClientConfig, parseBlobConfig, parseDirectoryCacheConfig, parseContentStoreConfig, parseIndexSigningConfig, parseAccessRecordingConfig, parseGarbageCollectionConfig, parseFetchSchedulerConfig, parseMaterializeConfig}
 	for _, p := range parsers {
 		if err := p(cfg); err != nil {
 			return err
@@ -255,6 +404,39 @@ func parseFuseConfig(cfg *Config) error {
 	if cfg.FuseConfig.NegativeTimeout == 0 {
 		cfg.FuseConfig.NegativeTimeout = defaultFuseTimeoutSec
 	}
+
+	if cfg.FuseConfig.PassthroughMaxFileSize == 0 {
+		cfg.FuseConfig.PassthroughMaxFileSize = defaultPassthroughMaxFileSize
+	}
+	if cfg.FuseConfig.PassthroughMaxFileSize < 0 {
+		return errors.New("fuse.passthrough_max_file_size must not be negative")
+	}
+	return nil
+}
+
+func parseReadAheadConfig(cfg *Config) error {
+	if cfg.ReadAheadConfig.MaxSpans == 0 {
+		cfg.ReadAheadConfig.MaxSpans = defaultReadAheadMaxSpans
+	}
+	if cfg.ReadAheadConfig.MaxSpans < 0 {
+		return errors.New("read_ahead.max_spans must not be negative")
+	}
+	return nil
+}
+
+func parseFileCacheConfig(cfg *Config) error {
+	if cfg.FileCacheConfig.MaxBytes == 0 {
+		cfg.FileCacheConfig.MaxBytes = defaultFileCacheMaxBytes
+	}
+	if cfg.FileCacheConfig.MaxFileSize == 0 {
+		cfg.FileCacheConfig.MaxFileSize = defaultFileCacheMaxFileSize
+	}
+	if cfg.FileCacheConfig.MaxBytes < 0 {
+		return errors.New("file_cache.max_bytes must not be negative")
+	}
+	if cfg.FileCacheConfig.MaxFileSize < 0 {
+		return errors.New("file_cache.max_file_size must not be negative")
+	}
 	return nil
 }
 
@@ -328,6 +510,12 @@ func parseBlobConfig(cfg *Config) error {
 	if cfg.BlobConfig.MaxWaitMsec == 0 {
 		cfg.BlobConfig.MaxWaitMsec = cfg.RetryableHTTPClientConfig.RetryConfig.MaxWaitMsec
 	}
+	if cfg.BlobConfig.MaxSpansPerFetch == 0 {
+		cfg.BlobConfig.MaxSpansPerFetch = defaultMaxSpansPerFetch
+	}
+	if cfg.BlobConfig.MaxSpansPerFetch < 0 {
+		return errors.New("blob.max_spans_per_fetch must not be negative")
+	}
 	return nil
 }
 
@@ -344,3 +532,94 @@ func parseContentStoreConfig(cfg *Config) error {
 	}
 	return nil
 }
+
+func parseIndexSigningConfig(cfg *Config) error {
+	if cfg.IndexSigningConfig.Enable && len(cfg.IndexSigningConfig.TrustedPublicKeys) == 0 {
+		return errors.New("index_signing.trusted_public_keys must not be empty when index signing is enabled")
+	}
+	return nil
+}
+
+func parseAccessRecordingConfig(cfg *Config) error {
+	if cfg.AccessRecordingConfig.DurationSec == 0 {
+		cfg.AccessRecordingConfig.DurationSec = defaultAccessRecordingDurationSec
+	}
+	if cfg.AccessRecordingConfig.DurationSec < 0 {
+		return errors.New("access_recording.duration_sec must not be negative")
+	}
+	return nil
+}
+
+func parseGarbageCollectionConfig(cfg *Config) error {
+	if cfg.GarbageCollectionConfig.IntervalSec == 0 {
+		cfg.GarbageCollectionConfig.IntervalSec = defaultGCIntervalSec
+	}
+	if cfg.GarbageCollectionConfig.IntervalSec < 0 {
+		return errors.New("garbage_collection.interval_sec must not be negative")
+	}
+	if cfg.GarbageCollectionConfig.GracePeriodSec == 0 {
+		cfg.GarbageCollectionConfig.GracePeriodSec = DefaultGCGracePeriodSec
+	}
+	if cfg.GarbageCollectionConfig.GracePeriodSec < 0 {
+		return errors.New("garbage_collection.grace_period_sec must not be negative")
+	}
+	cfg.GarbageCollectionConfig.MaxStoreSize = 0
+	if sizeStr := strings.TrimSpace(cfg.GarbageCollectionConfig.MaxStoreSizeStr); sizeStr != "" && sizeStr != "0" {
+		size, err := parseSize(sizeStr)
+		if err != nil {
+			return fmt.Errorf("invalid garbage_collection.max_store_size: %w", err)
+		}
+		cfg.GarbageCollectionConfig.MaxStoreSize = size
+	}
+	return nil
+}
+
+func parseMaterializeConfig(cfg *Config) error {
+	if cfg.MaterializeConfig.CheckIntervalSec == 0 {
+		cfg.MaterializeConfig.CheckIntervalSec = defaultMaterializeCheckIntervalSec
+	}
+	if cfg.MaterializeConfig.CheckIntervalSec < 0 {
+		return errors.New("materialize.check_interval_sec must not be negative")
+	}
+	return nil
+}
+
+func parseDirectoryCacheConfig(cfg *Config) error {
+	if cfg.DirectoryCacheConfig.Compression == "" {
+		cfg.DirectoryCacheConfig.Compression = defaultDirectoryCacheCompression
+	}
+	switch cfg.DirectoryCacheConfig.Compression {
+	case DirectoryCacheCompressionNone, DirectoryCacheCompressionZstd:
+	default:
+		return fmt.Errorf("directory_cache.compression must be %q or %q, got %q", DirectoryCacheCompressionNone, DirectoryCacheCompressionZstd, cfg.DirectoryCacheConfig.Compression)
+	}
+	if strings.TrimSpace(cfg.DirectoryCacheConfig.MaxSizeStr) == "" {
+		cfg.DirectoryCacheConfig.MaxSizeStr = defaultDirectoryCacheMaxSize
+	}
+	cfg.DirectoryCacheConfig.MaxSize = 0
+	if sizeStr := strings.TrimSpace(cfg.DirectoryCacheConfig.MaxSizeStr); sizeStr != "0" {
+		size, err := parseSize(sizeStr)
+		if err != nil {
+			return fmt.Errorf("invalid directory_cache.max_size: %w", err)
+		}
+		cfg.DirectoryCacheConfig.MaxSize = size
+	}
+	return nil
+}
+
+func parseFetchSchedulerConfig(cfg *Config) error {
+	if cfg.FetchSchedulerConfig.MaxConcurrencyPerRegistry == 0 {
+		cfg.FetchSchedulerConfig.MaxConcurrencyPerRegistry = defaultFetchMaxConcurrencyPerRegistry
+	}
+	if cfg.FetchSchedulerConfig.MaxConcurrencyPerRegistry < 0 {
+		cfg.FetchSchedulerConfig.MaxConcurrencyPerRegistry = -1
+		cfg.FetchSchedulerConfig.ReservedOnDemandConcurrency = 0
+		return nil
+	}
+	if cfg.FetchSchedulerConfig.ReservedOnDemandConcurrency < 0 {
+		return errors.New("fetch_scheduler.reserved_on_demand_concurrency must not be negative")
+	}
+	// Leave at least one fetch for prefetch and background fetch.
+	cfg.FetchSchedulerConfig.ReservedOnDemandConcurrency = min(cfg.FetchSchedulerConfig.ReservedOnDemandConcurrency, cfg.FetchSchedulerConfig.MaxConcurrencyPerRegistry-1)
+	return nil
+}
@@ -16,6 +16,12 @@
 
 package config
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
 // PullModes contain config related to the ways in
 // in which the SOCI snapshotter can pull images
 type PullModes struct {
@@ -29,8 +35,38 @@ type PullModes struct {
 // indexes that reference an image
 type V1 struct {
 	Enable bool `toml:"enable"`
+
+	// IndexSelectionPolicy selects the SOCI index used when several
+	// SOCI indexes reference an image.
+	IndexSelectionPolicy IndexSelectionPolicy `toml:"index_selection_policy"`
+
+	// PreferredBuildTool is the build tool identifier of the SOCI indexes
+	// selected by the "build_tool" policy.
+	PreferredBuildTool string `toml:"preferred_build_tool"`
+
+	// RequiredAnnotation is the "key=value" annotation of the SOCI indexes
+	// selected by the "annotation" policy.
+	RequiredAnnotation string `toml:"required_annotation"`
 }
 
+// IndexSelectionPolicy is a policy to select one of the SOCI indexes
+// referencing an image.
+type IndexSelectionPolicy string
+
+const (
+	// IndexSelectionPolicyFirst selects the first SOCI index listed by the registry.
+	IndexSelectionPolicyFirst IndexSelectionPolicy = "first"
+	// IndexSelectionPolicyNewest selects the SOCI index with the most recent
+	// `org.opencontainers.image.created` annotation.
+	IndexSelectionPolicyNewest IndexSelectionPolicy = "newest"
+	// IndexSelectionPolicyBuildTool selects the first SOCI index built by PreferredBuildTool.
+	IndexSelectionPolicyBuildTool IndexSelectionPolicy = "build_tool"
+	// IndexSelectionPolicyAnnotation selects the first SOCI index with RequiredAnnotation.
+	IndexSelectionPolicyAnnotation IndexSelectionPolicy = "annotation"
+	// IndexSelectionPolicyMostLayers selects the SOCI index with ztocs for the most layers.
+	IndexSelectionPolicyMostLayers IndexSelectionPolicy = "most_layers"
+)
+
 // V2 contains config for SOCI v2 which uses annotations
 // on the container's image manifest to discover SOCI indexes
 // without an out-of-band referrers API call
@@ -69,7 +105,8 @@ func defaultPullModes(cfg *Config) error {
 func DefaultPullModes() PullModes {
 	return PullModes{
 		SOCIv1: V1{
-			Enable: DefaultSOCIV1Enable,
+			Enable:               DefaultSOCIV1Enable,
+			IndexSelectionPolicy: DefaultIndexSelectionPolicy,
 		},
 		SOCIv2: V2{
 			Enable: DefaultSOCIV2Enable,
@@ -80,3 +117,29 @@ func DefaultPullModes() PullModes {
 		},
 	}
 }
+
+func parsePullModesConfig(cfg *Config) error {
+	v1 := &cfg.PullModes.SOCIv1
+	switch v1.IndexSelectionPolicy {
+	case "":
+		v1.IndexSelectionPolicy = DefaultIndexSelectionPolicy
+	case IndexSelectionPolicyFirst, IndexSelectionPolicyNewest, IndexSelectionPolicyMostLayers:
+	case IndexSelectionPolicyBuildTool:
+		if v1.PreferredBuildTool == "" {
+			return errors.New("pull_modes.soci_v1.preferred_build_tool is required by the build_tool index selection policy")
+		}
+	case IndexSelectionPolicyAnnotation:
+		if _, _, ok := v1.RequiredAnnotationKeyValue(); !ok {
+			return fmt.Errorf("pull_modes.soci_v1.required_annotation must be \"key=value\" for the annotation index selection policy, got %q", v1.RequiredAnnotation)
+		}
+	default:
+		return fmt.Errorf("invalid pull_modes.soci_v1.index_selection_policy %q", v1.IndexSelectionPolicy)
+	}
+	return nil
+}
+
+// RequiredAnnotationKeyValue returns the key and the value of RequiredAnnotation.
+func (v1 V1) RequiredAnnotationKeyValue() (string, string, bool) {
+	key, value, ok := strings.Cut(v1.RequiredAnnotation, "=")
+	return key, value, ok && key != ""
+}
@@ -379,6 +379,11 @@ func FetchSociArtifacts(ctx context.Context, refspec reference.Spec, indexDesc o
 		}
 	}
 
+	// Record the use of the index for the garbage collection of the least recently used SOCI artifacts.
+	if err := store.MarkUsed(ctx, localStore, desc.Digest); err != nil {
+		log.G(ctx).WithError(err).WithField("digest", desc.Digest).Warn("cannot record the use of the SOCI index")
+	}
+
 	eg, ctx := errgroup.WithContext(ctx)
 	for i, blob := range index.Blobs {
 		eg.Go(func() error {
@@ -0,0 +1,112 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/config"
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/log"
+)
+
+// artifactsDbGCTimeout is how long the garbage collector waits for the artifacts db,
+// which is locked while the soci CLI uses it.
+const artifactsDbGCTimeout = 10 * time.Second
+
+// artifactCollector periodically removes the SOCI artifacts that are no longer
+// needed from the content store and the artifacts db, like `soci gc`.
+type artifactCollector struct {
+	store      store.Store
+	storeType  config.ContentStoreType
+	containerd *store.ContainerdClient
+	dbPath     string
+	interval   time.Duration
+	opts       []soci.GCOption
+}
+
+func newArtifactCollector(cfg config.GarbageCollectionConfig, contentStore store.Store, storeType config.ContentStoreType,
+	containerd *store.ContainerdClient, snapshotterRoot string) *artifactCollector {
+	return &artifactCollector{
+		store:      contentStore,
+		storeType:  storeType,
+		containerd: containerd,
+		dbPath:     soci.ArtifactsDbPath(snapshotterRoot),
+		interval:   time.Duration(cfg.IntervalSec) * time.Second,
+		opts: []soci.GCOption{
+			soci.GCWithMaxStoreSize(cfg.MaxStoreSize),
+			soci.GCWithGracePeriod(time.Duration(cfg.GracePeriodSec) * time.Second),
+		},
+	}
+}
+
+// Run collects garbage every interval until ctx is done.
+func (c *artifactCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.collect(ctx); err != nil {
+				log.G(ctx).WithError(err).Warn("failed to garbage collect SOCI artifacts")
+			}
+		}
+	}
+}
+
+func (c *artifactCollector) collect(ctx context.Context) error {
+	client, err := c.containerd.Client()
+	if err != nil {
+		return err
+	}
+	imageDigests, err := soci.ImageDigests(ctx, client.NamespaceService(), client.ImageService(), client.ContentStore())
+	if err != nil {
+		return err
+	}
+	opts := c.opts
+	if c.storeType == config.ContainerdContentStoreType {
+		nss, err := client.NamespaceService().List(ctx)
+		if err != nil {
+			return fmt.Errorf("cannot list namespaces: %w", err)
+		}
+		opts = append(opts[:len(opts):len(opts)], soci.GCWithNamespaces(nss...))
+	}
+
+	db, err := soci.OpenDB(c.dbPath, artifactsDbGCTimeout)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := soci.GarbageCollect(ctx, c.store, db, imageDigests, opts...)
+	if err != nil {
+		return err
+	}
+	for _, r := range report.Removed {
+		log.G(ctx).WithField("digest", r.Digest).WithField("type", r.Type).WithField("reason", r.Reason).Debug("removed SOCI artifact")
+	}
+	log.G(ctx).WithField("removed", len(report.Removed)).
+		WithField("removedSize", report.RemovedSize).
+		WithField("storeSize", report.StoreSize).
+		Info("garbage collected SOCI artifacts")
+	return nil
+}
@@ -174,6 +174,20 @@ func (bf *BackgroundFetcher) pop() Resolver {
 	return lr
 }
 
+// QueuePosition returns the position of `resolver` in the work queue, 0 being the
+// next one to fetch a span, or -1 if it isn't queued, e.g. while it fetches a span
+// or once its layer is fully fetched.
+func (bf *BackgroundFetcher) QueuePosition(resolver Resolver) int {
+	bf.workQueueMu.Lock()
+	defer bf.workQueueMu.Unlock()
+	for i, r := range bf.workQueue {
+		if r == resolver {
+			return i
+		}
+	}
+	return -1
+}
+
 func (bf *BackgroundFetcher) queueSize() int {
 	bf.workQueueMu.Lock()
 	defer bf.workQueueMu.Unlock()
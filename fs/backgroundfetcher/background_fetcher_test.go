@@ -291,7 +291,7 @@ func TestAddDefaultDropPolicyIsNewest(t *testing.T) {
 // countingCache is an implementation of cache.BlobCache
 // which counts the number of times `cache.Add` was invoked
 // and the number of bytes added to the cache.
-// All writes to the cache succeed.
+// All writes to the cache succeed, and nothing is read from it.
 type countingCache struct {
 	addCount int
 	addBytes int64
@@ -305,7 +305,7 @@ func (c *countingCache) Add(key string, opts ...cache.Option) (cache.Writer, err
 }
 
 func (c *countingCache) Get(key string, opts ...cache.Option) (cache.Reader, error) {
-	return nil, nil
+	return nil, fmt.Errorf("missed cache: %q", key)
 }
 
 func (c *countingCache) Close() error {
@@ -337,3 +337,29 @@ func (c *countingWriter) Commit() error {
 func (c *countingWriter) Abort() error {
 	return nil
 }
+
+func TestQueuePosition(t *testing.T) {
+	bf, err := NewBackgroundFetcher(
+		WithFetchPeriod(time.Second),
+		WithEmitMetricPeriod(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r1 := &mockResolver{id: "r1"}
+	r2 := &mockResolver{id: "r2"}
+	bf.Add(r1)
+	bf.Add(r2)
+	if pos := bf.QueuePosition(r2); pos != 1 {
+		t.Fatalf("expected r2 at position 1, got %d", pos)
+	}
+
+	bf.pop()
+	if pos := bf.QueuePosition(r1); pos != -1 {
+		t.Fatalf("expected r1 not to be queued once popped, got %d", pos)
+	}
+	if pos := bf.QueuePosition(r2); pos != 0 {
+		t.Fatalf("expected r2 at position 0, got %d", pos)
+	}
+}
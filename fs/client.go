@@ -17,11 +17,14 @@
 package fs
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/containerd/log"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"oras.land/oras-go/v2/content"
 )
@@ -37,6 +40,100 @@ func SelectFirstPolicy(descs []ocispec.Descriptor) (ocispec.Descriptor, error) {
 	return descs[0], nil
 }
 
+// SelectNewestPolicy selects the index with the most recent `org.opencontainers.image.created`
+// annotation. Indexes without a valid annotation are older than the others, and indexes
+// created at the same time are selected in the order listed by the registry.
+func SelectNewestPolicy(descs []ocispec.Descriptor) (ocispec.Descriptor, error) {
+	newest := descs[0]
+	newestCreated, _ := indexCreated(newest)
+	for _, desc := range descs[1:] {
+		created, ok := indexCreated(desc)
+		if ok && created.After(newestCreated) {
+			newest, newestCreated = desc, created
+		}
+	}
+	return newest, nil
+}
+
+func indexCreated(desc ocispec.Descriptor) (time.Time, bool) {
+	created, err := time.Parse(time.RFC3339, desc.Annotations[ocispec.AnnotationCreated])
+	return created, err == nil
+}
+
+// NewSelectBuildToolPolicy returns a policy which selects the first index built by `tool`,
+// according to its build tool identifier annotation, or the first index if none was.
+func NewSelectBuildToolPolicy(tool string) IndexSelectionPolicy {
+	return func(descs []ocispec.Descriptor) (ocispec.Descriptor, error) {
+		for _, desc := range descs {
+			if desc.Annotations[soci.IndexAnnotationBuildToolIdentifier] == tool {
+				return desc, nil
+			}
+		}
+		return descs[0], nil
+	}
+}
+
+// NewSelectAnnotationPolicy returns a policy which selects the first index with the annotation
+// `key` set to `value`. It returns ErrNoReferrers if no index has the annotation.
+func NewSelectAnnotationPolicy(key, value string) IndexSelectionPolicy {
+	return func(descs []ocispec.Descriptor) (ocispec.Descriptor, error) {
+		for _, desc := range descs {
+			if v, ok := desc.Annotations[key]; ok && v == value {
+				return desc, nil
+			}
+		}
+		return ocispec.Descriptor{}, fmt.Errorf("no index with annotation %s=%s: %w", key, value, ErrNoReferrers)
+	}
+}
+
+// NewSelectMostLayersPolicy returns a policy which selects the index with ztocs for the largest
+// number of layers. Indexes are fetched from `fetcher`; indexes which cannot be fetched are skipped.
+// Indexes covering the same number of layers are selected in the order listed by the registry.
+func NewSelectMostLayersPolicy(ctx context.Context, fetcher content.Fetcher) IndexSelectionPolicy {
+	return func(descs []ocispec.Descriptor) (ocispec.Descriptor, error) {
+		var (
+			selected ocispec.Descriptor
+			most     = -1
+			errs     []error
+		)
+		for _, desc := range descs {
+			layers, err := countIndexLayers(ctx, fetcher, desc)
+			if err != nil {
+				log.G(ctx).WithError(err).WithField("digest", desc.Digest).Warn("cannot fetch SOCI index to count its layers")
+				errs = append(errs, err)
+				continue
+			}
+			log.G(ctx).WithField("digest", desc.Digest).WithField("layers", layers).Debug("counted layers of SOCI index")
+			if layers > most {
+				selected, most = desc, layers
+			}
+		}
+		if most < 0 {
+			return ocispec.Descriptor{}, fmt.Errorf("cannot fetch any SOCI index: %w", errors.Join(errs...))
+		}
+		return selected, nil
+	}
+}
+
+// countIndexLayers returns the number of layers with a ztoc in a SOCI index.
+func countIndexLayers(ctx context.Context, fetcher content.Fetcher, desc ocispec.Descriptor) (int, error) {
+	b, err := content.FetchAll(ctx, fetcher, desc)
+	if err != nil {
+		return 0, err
+	}
+	var index soci.Index
+	if err := soci.DecodeIndex(bytes.NewReader(b), &index); err != nil {
+		return 0, err
+	}
+	layers := 0
+	for _, blob := range index.Blobs {
+		if blob.MediaType == soci.SociLayerMediaType {
+			layers++
+		}
+	}
+	return layers, nil
+}
+
 // Responsible for making Referrers API calls to remote registry to fetch list of referrers.
 type ReferrersClient interface {
 	/// Takes in an manifest descriptor and IndexSelectionPolicy and returns a single artifact descriptor.
@@ -17,14 +17,18 @@
 package fs
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"testing"
 
+	"github.com/awslabs/soci-snapshotter/soci"
 	"github.com/google/go-cmp/cmp"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content/memory"
 )
 
 type fakeInner struct {
@@ -86,6 +90,61 @@ func TestOCIArtifactClientSelectReferrer(t *testing.T) {
 			},
 			selectionPolicy: SelectFirstPolicy,
 		},
+		{
+			name: "SelectNewestPolicy returns the descriptor with the most recent created annotation",
+			descs: []ocispec.Descriptor{
+				testIndexDesc("no-annotation", nil),
+				testIndexDesc("old", map[string]string{ocispec.AnnotationCreated: "2024-01-01T00:00:00Z"}),
+				testIndexDesc("new", map[string]string{ocispec.AnnotationCreated: "2025-01-01T00:00:00Z"}),
+				testIndexDesc("invalid", map[string]string{ocispec.AnnotationCreated: "yesterday"}),
+			},
+			expectedDesc:    testIndexDesc("new", map[string]string{ocispec.AnnotationCreated: "2025-01-01T00:00:00Z"}),
+			selectionPolicy: SelectNewestPolicy,
+		},
+		{
+			name: "SelectNewestPolicy returns the first descriptor without created annotations",
+			descs: []ocispec.Descriptor{
+				testIndexDesc("foo", nil),
+				testIndexDesc("bar", nil),
+			},
+			expectedDesc:    testIndexDesc("foo", nil),
+			selectionPolicy: SelectNewestPolicy,
+		},
+		{
+			name: "NewSelectBuildToolPolicy returns the first descriptor built by the tool",
+			descs: []ocispec.Descriptor{
+				testIndexDesc("other", map[string]string{soci.IndexAnnotationBuildToolIdentifier: "other"}),
+				testIndexDesc("soci", map[string]string{soci.IndexAnnotationBuildToolIdentifier: "soci"}),
+			},
+			expectedDesc:    testIndexDesc("soci", map[string]string{soci.IndexAnnotationBuildToolIdentifier: "soci"}),
+			selectionPolicy: NewSelectBuildToolPolicy("soci"),
+		},
+		{
+			name: "NewSelectBuildToolPolicy falls back to the first descriptor",
+			descs: []ocispec.Descriptor{
+				testIndexDesc("other", map[string]string{soci.IndexAnnotationBuildToolIdentifier: "other"}),
+				testIndexDesc("another", nil),
+			},
+			expectedDesc:    testIndexDesc("other", map[string]string{soci.IndexAnnotationBuildToolIdentifier: "other"}),
+			selectionPolicy: NewSelectBuildToolPolicy("soci"),
+		},
+		{
+			name: "NewSelectAnnotationPolicy returns the first descriptor with the annotation",
+			descs: []ocispec.Descriptor{
+				testIndexDesc("foo", map[string]string{"team": "foo"}),
+				testIndexDesc("bar", map[string]string{"team": "bar"}),
+			},
+			expectedDesc:    testIndexDesc("bar", map[string]string{"team": "bar"}),
+			selectionPolicy: NewSelectAnnotationPolicy("team", "bar"),
+		},
+		{
+			name: "NewSelectAnnotationPolicy returns ErrNoReferrers without the annotation",
+			descs: []ocispec.Descriptor{
+				testIndexDesc("foo", map[string]string{"team": "foo"}),
+			},
+			expectedErr:     ErrNoReferrers,
+			selectionPolicy: NewSelectAnnotationPolicy("team", "bar"),
+		},
 	}
 
 	for _, tc := range testCases {
@@ -99,6 +158,9 @@ func TestOCIArtifactClientSelectReferrer(t *testing.T) {
 			if err != nil && !errors.Is(err, tc.expectedErr) {
 				t.Fatalf("unexpected error getting descriptor: %v", err)
 			}
+			if err == nil && tc.expectedErr != nil {
+				t.Fatalf("expected error %v, got descriptor %v", tc.expectedErr, desc)
+			}
 
 			if diff := cmp.Diff(desc, tc.expectedDesc); diff != "" {
 				t.Fatalf("unexpected descriptor; diff = %v", diff)
@@ -106,3 +168,46 @@ func TestOCIArtifactClientSelectReferrer(t *testing.T) {
 		})
 	}
 }
+
+func testIndexDesc(content string, annotations map[string]string) ocispec.Descriptor {
+	return ocispec.Descriptor{
+		Digest:      digest.FromString(content),
+		Size:        int64(len(content)),
+		Annotations: annotations,
+	}
+}
+
+func TestSelectMostLayersPolicy(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	pushIndex := func(name string, layers int) ocispec.Descriptor {
+		var blobs []ocispec.Descriptor
+		for i := 0; i < layers; i++ {
+			blobs = append(blobs, ocispec.Descriptor{MediaType: soci.SociLayerMediaType, Digest: digest.FromString(fmt.Sprintf("ztoc %d", i))})
+		}
+		blobs = append(blobs, ocispec.Descriptor{MediaType: soci.SociPrefetchMediaType, Digest: digest.FromString("prefetch")})
+		b, err := soci.MarshalIndex(soci.NewIndex(soci.V1, blobs, nil, map[string]string{"name": name}))
+		if err != nil {
+			t.Fatalf("can't marshal index: %v", err)
+		}
+		desc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(b), Size: int64(len(b))}
+		if err := store.Push(ctx, desc, bytes.NewReader(b)); err != nil {
+			t.Fatalf("can't push index: %v", err)
+		}
+		return desc
+	}
+	missing := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("missing"), Size: 7}
+	one, three, otherThree := pushIndex("one", 1), pushIndex("three", 3), pushIndex("other three", 3)
+
+	policy := NewSelectMostLayersPolicy(ctx, store)
+	desc, err := policy([]ocispec.Descriptor{one, missing, three, otherThree})
+	if err != nil {
+		t.Fatalf("unexpected error selecting index: %v", err)
+	}
+	if desc.Digest != three.Digest {
+		t.Fatalf("expected index %s with the most layers, got %s", three.Digest, desc.Digest)
+	}
+	if _, err := policy([]ocispec.Descriptor{missing}); err == nil {
+		t.Fatal("expected an error without any index")
+	}
+}
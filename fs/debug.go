@@ -0,0 +1,184 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/awslabs/soci-snapshotter/fs/layer"
+	spanmanager "github.com/awslabs/soci-snapshotter/fs/span-manager"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// DebugLayersPath lists the mounted layers as DebugLayer.
+	DebugLayersPath = "/debug/soci/layers"
+	// DebugImagesPath lists the images of the mounted layers as DebugImage.
+	DebugImagesPath = "/debug/soci/images"
+)
+
+// DebugSpansPath returns the path listing the spans of the mounted layer `dgst`
+// as DebugSpans.
+func DebugSpansPath(dgst digest.Digest) string {
+	return fmt.Sprintf("%s/%s/spans", DebugLayersPath, dgst)
+}
+
+// mountInfo is the image a layer was mounted for.
+type mountInfo struct {
+	imageRef    string
+	imageDigest string
+	indexDigest digest.Digest
+}
+
+// DebugLayer is a mounted layer, as listed by DebugLayersPath.
+type DebugLayer struct {
+	Mountpoint  string        `json:"mountpoint"`
+	ImageRef    string        `json:"imageRef"`
+	ImageDigest string        `json:"imageDigest"`
+	IndexDigest digest.Digest `json:"indexDigest"`
+	layer.Status
+}
+
+// DebugImage is an image with mounted layers, as listed by DebugImagesPath.
+type DebugImage struct {
+	ImageRef    string        `json:"imageRef"`
+	ImageDigest string        `json:"imageDigest"`
+	IndexDigest digest.Digest `json:"indexDigest"`
+	Layers      []DebugLayer  `json:"layers"`
+}
+
+// DebugSpans is the state of the spans of a mounted layer, as listed by DebugSpansPath.
+type DebugSpans struct {
+	Digest digest.Digest            `json:"digest"`
+	Spans  []spanmanager.SpanStatus `json:"spans"`
+}
+
+// WithDebugServeMux registers the debug endpoints of the filesystem, which report
+// the state of the mounted layers as JSON, on `mux`.
+func WithDebugServeMux(mux *http.ServeMux) Option {
+	return func(opts *options) {
+		opts.debugServeMux = mux
+	}
+}
+
+func (fs *filesystem) registerDebugHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+DebugLayersPath, func(w http.ResponseWriter, r *http.Request) {
+		writeDebugJSON(w, fs.debugLayers())
+	})
+	mux.HandleFunc("GET "+DebugLayersPath+"/{digest}/spans", func(w http.ResponseWriter, r *http.Request) {
+		dgst, err := digest.Parse(r.PathValue("digest"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		spans, ok := fs.debugSpans(dgst)
+		if !ok {
+			http.Error(w, fmt.Sprintf("layer %s isn't mounted", dgst), http.StatusNotFound)
+			return
+		}
+		writeDebugJSON(w, spans)
+	})
+	mux.HandleFunc("GET "+DebugImagesPath, func(w http.ResponseWriter, r *http.Request) {
+		writeDebugJSON(w, fs.debugImages())
+	})
+}
+
+// debugLayers returns the mounted layers sorted by mountpoint.
+func (fs *filesystem) debugLayers() []DebugLayer {
+	type mounted struct {
+		mountpoint string
+		l          layer.Layer
+		info       mountInfo
+	}
+	fs.layerMu.Lock()
+	mounts := make([]mounted, 0, len(fs.layer))
+	for mountpoint, l := range fs.layer {
+		mounts = append(mounts, mounted{mountpoint, l, fs.mounts[mountpoint]})
+	}
+	fs.layerMu.Unlock()
+
+	layers := make([]DebugLayer, 0, len(mounts))
+	for _, m := range mounts {
+		layers = append(layers, DebugLayer{
+			Mountpoint:  m.mountpoint,
+			ImageRef:    m.info.imageRef,
+			ImageDigest: m.info.imageDigest,
+			IndexDigest: m.info.indexDigest,
+			Status:      m.l.Status(),
+		})
+	}
+	slices.SortFunc(layers, func(a, b DebugLayer) int {
+		return strings.Compare(a.Mountpoint, b.Mountpoint)
+	})
+	return layers
+}
+
+// debugImages returns the mounted layers grouped by image, sorted by image digest.
+func (fs *filesystem) debugImages() []DebugImage {
+	var images []DebugImage
+	for _, l := range fs.debugLayers() {
+		i := slices.IndexFunc(images, func(img DebugImage) bool {
+			return img.ImageDigest == l.ImageDigest
+		})
+		if i < 0 {
+			images = append(images, DebugImage{
+				ImageRef:    l.ImageRef,
+				ImageDigest: l.ImageDigest,
+				IndexDigest: l.IndexDigest,
+			})
+			i = len(images) - 1
+		}
+		images[i].Layers = append(images[i].Layers, l)
+	}
+	slices.SortFunc(images, func(a, b DebugImage) int {
+		return strings.Compare(a.ImageDigest, b.ImageDigest)
+	})
+	if images == nil {
+		images = []DebugImage{}
+	}
+	return images
+}
+
+// debugSpans returns the spans of the mounted layer `dgst`, or false if no mounted
+// layer has this digest.
+func (fs *filesystem) debugSpans(dgst digest.Digest) (DebugSpans, bool) {
+	fs.layerMu.Lock()
+	var l layer.Layer
+	for _, ml := range fs.layer {
+		if ml.Info().Digest == dgst {
+			l = ml
+			break
+		}
+	}
+	fs.layerMu.Unlock()
+	if l == nil {
+		return DebugSpans{}, false
+	}
+	return DebugSpans{Digest: dgst, Spans: l.Spans()}, true
+}
+
+func writeDebugJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.L.WithError(err).Debug("failed to write debug response")
+	}
+}
@@ -0,0 +1,145 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/fs/layer"
+	spanmanager "github.com/awslabs/soci-snapshotter/fs/span-manager"
+	"github.com/opencontainers/go-digest"
+)
+
+// statusLayer is a layer reporting a fixed status.
+type statusLayer struct {
+	breakableLayer
+	status layer.Status
+	spans  []spanmanager.SpanStatus
+}
+
+func (l *statusLayer) Info() layer.Info                { return layer.Info{Digest: l.status.Digest} }
+func (l *statusLayer) Status() layer.Status            { return l.status }
+func (l *statusLayer) Spans() []spanmanager.SpanStatus { return l.spans }
+
+func TestDebugHandlers(t *testing.T) {
+	var (
+		layer1 = digest.FromString("layer1")
+		layer2 = digest.FromString("layer2")
+		layer3 = digest.FromString("layer3")
+		image1 = digest.FromString("image1").String()
+		image2 = digest.FromString("image2").String()
+		index1 = digest.FromString("index1")
+		index2 = digest.FromString("index2")
+	)
+	spans := []spanmanager.SpanStatus{
+		{ID: 0, State: "uncompressed", CompressedSize: 10, UncompressedSize: 20},
+		{ID: 1, State: "unrequested", CompressedOffset: 10, CompressedSize: 10, UncompressedOffset: 20, UncompressedSize: 20},
+	}
+	fs := &filesystem{
+		layer: map[string]layer.Layer{
+			"/mnt/1": &statusLayer{status: layer.Status{Digest: layer1, SpanStates: map[string]int{"uncompressed": 1, "unrequested": 1}}, spans: spans},
+			"/mnt/2": &statusLayer{status: layer.Status{Digest: layer2, BackgroundFetchQueuePosition: 0}},
+			"/mnt/3": &statusLayer{status: layer.Status{Digest: layer3, BackgroundFetchQueuePosition: -1}},
+		},
+		mounts: map[string]mountInfo{
+			"/mnt/1": {imageRef: "example.com/image1:latest", imageDigest: image1, indexDigest: index1},
+			"/mnt/2": {imageRef: "example.com/image1:latest", imageDigest: image1, indexDigest: index1},
+			"/mnt/3": {imageRef: "example.com/image2:latest", imageDigest: image2, indexDigest: index2},
+		},
+	}
+	mux := http.NewServeMux()
+	fs.registerDebugHandlers(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(t *testing.T, path string, v any) int {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	t.Run("layers", func(t *testing.T) {
+		var layers []DebugLayer
+		if code := get(t, DebugLayersPath, &layers); code != http.StatusOK {
+			t.Fatalf("unexpected status code %d", code)
+		}
+		if len(layers) != 3 {
+			t.Fatalf("expected 3 layers, got %d", len(layers))
+		}
+		l := layers[0]
+		if l.Mountpoint != "/mnt/1" || l.Digest != layer1 || l.ImageDigest != image1 || l.IndexDigest != index1 {
+			t.Fatalf("unexpected first layer %+v", l)
+		}
+		if l.SpanStates["uncompressed"] != 1 || l.SpanStates["unrequested"] != 1 {
+			t.Fatalf("unexpected span states %v", l.SpanStates)
+		}
+		if layers[1].BackgroundFetchQueuePosition != 0 || layers[2].BackgroundFetchQueuePosition != -1 {
+			t.Fatalf("unexpected queue positions %d, %d", layers[1].BackgroundFetchQueuePosition, layers[2].BackgroundFetchQueuePosition)
+		}
+	})
+
+	t.Run("images", func(t *testing.T) {
+		var images []DebugImage
+		if code := get(t, DebugImagesPath, &images); code != http.StatusOK {
+			t.Fatalf("unexpected status code %d", code)
+		}
+		if len(images) != 2 {
+			t.Fatalf("expected 2 images, got %d", len(images))
+		}
+		byDigest := map[string]DebugImage{images[0].ImageDigest: images[0], images[1].ImageDigest: images[1]}
+		if img := byDigest[image1]; len(img.Layers) != 2 || img.IndexDigest != index1 {
+			t.Fatalf("unexpected image %+v", img)
+		}
+		if img := byDigest[image2]; len(img.Layers) != 1 || img.Layers[0].Digest != layer3 {
+			t.Fatalf("unexpected image %+v", img)
+		}
+	})
+
+	t.Run("spans", func(t *testing.T) {
+		var s DebugSpans
+		if code := get(t, DebugSpansPath(layer1), &s); code != http.StatusOK {
+			t.Fatalf("unexpected status code %d", code)
+		}
+		if s.Digest != layer1 || len(s.Spans) != 2 || s.Spans[1] != spans[1] {
+			t.Fatalf("unexpected spans %+v", s)
+		}
+	})
+
+	t.Run("spans of unmounted layer", func(t *testing.T) {
+		if code := get(t, DebugSpansPath(digest.FromString("unknown")), nil); code != http.StatusNotFound {
+			t.Fatalf("expected status code %d, got %d", http.StatusNotFound, code)
+		}
+	})
+
+	t.Run("spans of invalid digest", func(t *testing.T) {
+		if code := get(t, DebugLayersPath+"/invalid/spans", nil); code != http.StatusBadRequest {
+			t.Fatalf("expected status code %d, got %d", http.StatusBadRequest, code)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package fetchscheduler schedules the span fetches of every layer of the snapshotter,
+// so that reads a container is blocked on are not starved by prefetch and background
+// fetches of the same registry.
+package fetchscheduler
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	commonmetrics "github.com/awslabs/soci-snapshotter/fs/metrics/common"
+)
+
+// Priority is the priority class of a fetch. Lower values have a higher priority.
+type Priority int
+
+const (
+	// PriorityOnDemand is the priority of fetches for reads of a container.
+	PriorityOnDemand Priority = iota
+	// PriorityPrefetch is the priority of fetches for the prefetch artifacts of an image.
+	PriorityPrefetch
+	// PriorityBackground is the priority of fetches by the background fetcher.
+	PriorityBackground
+
+	numPriorities
+)
+
+// String returns the label of the priority class in metrics.
+func (p Priority) String() string {
+	switch p {
+	case PriorityOnDemand:
+		return "on_demand"
+	case PriorityPrefetch:
+		return "prefetch"
+	case PriorityBackground:
+		return "background"
+	default:
+		return "unknown"
+	}
+}
+
+// Scheduler limits the number of concurrent fetches per registry. When a registry has no
+// free slot, fetches are queued and started by priority class, then in the order in which
+// they were queued, so queued prefetch and background fetches are preempted by reads of a
+// container. Reserved slots of each registry are only used by on-demand fetches, so that
+// a burst of low priority fetches in flight never holds every slot.
+type Scheduler struct {
+	maxConcurrency int
+	maxLowPriority int
+
+	mu         sync.Mutex
+	registries map[string]*registryQueue
+	depth      [numPriorities]int
+}
+
+type registryQueue struct {
+	running int
+	waiters [numPriorities]*list.List
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// New creates a Scheduler running up to `maxConcurrency` fetches per registry,
+// of which `reservedOnDemand` only run on-demand fetches. A `maxConcurrency`
+// of 0 or less means no limit.
+func New(maxConcurrency, reservedOnDemand int) *Scheduler {
+	maxLowPriority := maxConcurrency - reservedOnDemand
+	if maxLowPriority < 1 {
+		maxLowPriority = 1
+	}
+	s := &Scheduler{
+		maxConcurrency: maxConcurrency,
+		maxLowPriority: maxLowPriority,
+		registries:     make(map[string]*registryQueue),
+	}
+	for p := Priority(0); p < numPriorities; p++ {
+		commonmetrics.SetFetchQueueDepth(p.String(), 0)
+	}
+	return s
+}
+
+// Registry returns the queue of the fetches from `registry`.
+func (s *Scheduler) Registry(registry string) *Queue {
+	if s == nil {
+		return nil
+	}
+	return &Queue{s: s, registry: registry}
+}
+
+// QueueDepth returns the number of queued fetches of a priority class across registries.
+func (s *Scheduler) QueueDepth(p Priority) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.depth[p]
+}
+
+// acquire waits for a slot of `registry` to start a fetch with priority `p`.
+func (s *Scheduler) acquire(ctx context.Context, registry string, p Priority) (func(), error) {
+	if s.maxConcurrency <= 0 {
+		return func() {}, nil
+	}
+	s.mu.Lock()
+	q, ok := s.registries[registry]
+	if !ok {
+		q = &registryQueue{}
+		for i := range q.waiters {
+			q.waiters[i] = list.New()
+		}
+		s.registries[registry] = q
+	}
+	w := &waiter{ready: make(chan struct{})}
+	e := q.waiters[p].PushBack(w)
+	s.setDepth(p, 1)
+	s.dispatch(q)
+	s.mu.Unlock()
+
+	release := func() {
+		s.mu.Lock()
+		q.running--
+		s.dispatch(q)
+		s.mu.Unlock()
+	}
+
+	select {
+	case <-w.ready:
+		return sync.OnceFunc(release), nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if w.granted {
+			// The slot was granted while the context was cancelled.
+			q.running--
+			s.dispatch(q)
+		} else {
+			q.waiters[p].Remove(e)
+			s.setDepth(p, -1)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// dispatch starts queued fetches of `q` while it has free slots. s.mu must be held.
+func (s *Scheduler) dispatch(q *registryQueue) {
+	for q.running < s.maxConcurrency {
+		p, e := s.next(q)
+		if e == nil {
+			return
+		}
+		w := q.waiters[p].Remove(e).(*waiter)
+		s.setDepth(p, -1)
+		w.granted = true
+		q.running++
+		close(w.ready)
+	}
+}
+
+// next returns the next queued fetch of `q` which can start. s.mu must be held.
+func (s *Scheduler) next(q *registryQueue) (Priority, *list.Element) {
+	for p := Priority(0); p < numPriorities; p++ {
+		if q.waiters[p].Len() == 0 {
+			continue
+		}
+		if p != PriorityOnDemand && q.running >= s.maxLowPriority {
+			return 0, nil
+		}
+		return p, q.waiters[p].Front()
+	}
+	return 0, nil
+}
+
+// setDepth updates the number of queued fetches of a priority class. s.mu must be held.
+func (s *Scheduler) setDepth(p Priority, delta int) {
+	s.depth[p] += delta
+	commonmetrics.SetFetchQueueDepth(p.String(), s.depth[p])
+}
+
+// Queue schedules the fetches from a registry. A nil Queue doesn't limit fetches.
+type Queue struct {
+	s        *Scheduler
+	registry string
+}
+
+// Acquire waits until a fetch with priority `p` can start. The returned function
+// must be called once the fetch is done.
+func (q *Queue) Acquire(ctx context.Context, p Priority) (func(), error) {
+	if q == nil {
+		return func() {}, nil
+	}
+	return q.s.acquire(ctx, q.registry, p)
+}
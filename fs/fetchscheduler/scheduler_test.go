@@ -0,0 +1,167 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fetchscheduler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForDepth waits until `depth` fetches of priority `p` are queued.
+func waitForDepth(t *testing.T, s *Scheduler, p Priority, depth int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.QueueDepth(p) != depth {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued %s fetches, got %d", depth, p, s.QueueDepth(p))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func mustAcquire(t *testing.T, q *Queue, p Priority) func() {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	release, err := q.Acquire(ctx, p)
+	if err != nil {
+		t.Fatalf("can't acquire a %s fetch: %v", p, err)
+	}
+	return release
+}
+
+func assertBlocked(t *testing.T, q *Queue, p Priority) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if release, err := q.Acquire(ctx, p); !errors.Is(err, context.DeadlineExceeded) {
+		if release != nil {
+			release()
+		}
+		t.Fatalf("expected the %s fetch to wait, got error %v", p, err)
+	}
+}
+
+func TestSchedulerPriorityOrder(t *testing.T) {
+	s := New(1, 0)
+	q := s.Registry("registry.example.com")
+	release := mustAcquire(t, q, PriorityOnDemand)
+
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	enqueue := func(p Priority, depth int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := mustAcquire(t, q, p)
+			mu.Lock()
+			order = append(order, p.String())
+			mu.Unlock()
+			r()
+		}()
+		waitForDepth(t, s, p, depth)
+	}
+	// Low priority fetches are queued first, and are preempted by the later on-demand fetch.
+	enqueue(PriorityBackground, 1)
+	enqueue(PriorityBackground, 2)
+	enqueue(PriorityPrefetch, 1)
+	enqueue(PriorityOnDemand, 1)
+	release()
+	wg.Wait()
+
+	expected := []string{"on_demand", "prefetch", "background", "background"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("unexpected fetch order: got %v, want %v", order, expected)
+	}
+	for p := Priority(0); p < numPriorities; p++ {
+		if depth := s.QueueDepth(p); depth != 0 {
+			t.Fatalf("expected no queued %s fetches, got %d", p, depth)
+		}
+	}
+}
+
+func TestSchedulerPerRegistryLimit(t *testing.T) {
+	s := New(2, 0)
+	q1 := s.Registry("registry1.example.com")
+	q2 := s.Registry("registry2.example.com")
+
+	release1 := mustAcquire(t, q1, PriorityBackground)
+	release2 := mustAcquire(t, q1, PriorityBackground)
+	assertBlocked(t, q1, PriorityOnDemand)
+	// Another registry has its own limit.
+	mustAcquire(t, q2, PriorityBackground)()
+
+	release1()
+	// Releasing twice doesn't free another slot.
+	release1()
+	mustAcquire(t, q1, PriorityOnDemand)
+	assertBlocked(t, q1, PriorityOnDemand)
+	release2()
+}
+
+func TestSchedulerReservedOnDemand(t *testing.T) {
+	s := New(3, 1)
+	q := s.Registry("registry.example.com")
+
+	mustAcquire(t, q, PriorityBackground)
+	mustAcquire(t, q, PriorityPrefetch)
+	assertBlocked(t, q, PriorityPrefetch)
+	assertBlocked(t, q, PriorityBackground)
+	mustAcquire(t, q, PriorityOnDemand)
+	assertBlocked(t, q, PriorityOnDemand)
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := New(1, 0)
+	q := s.Registry("registry.example.com")
+	release := mustAcquire(t, q, PriorityOnDemand)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() {
+		_, err := q.Acquire(ctx, PriorityPrefetch)
+		errCh <- err
+	}()
+	waitForDepth(t, s, PriorityPrefetch, 1)
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancelled fetch to fail, got %v", err)
+	}
+	waitForDepth(t, s, PriorityPrefetch, 0)
+
+	// The cancelled fetch doesn't hold the slot.
+	release()
+	mustAcquire(t, q, PriorityBackground)()
+}
+
+func TestSchedulerUnlimited(t *testing.T) {
+	s := New(-1, 0)
+	q := s.Registry("registry.example.com")
+	for i := 0; i < 100; i++ {
+		mustAcquire(t, q, PriorityBackground)
+	}
+
+	var nilQueue *Queue
+	mustAcquire(t, nilQueue, PriorityBackground)()
+}
@@ -44,6 +44,7 @@ package fs
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -54,6 +55,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -94,9 +96,8 @@ import (
 )
 
 var (
-	defaultIndexSelectionPolicy = SelectFirstPolicy
-	fusermountBin               = "fusermount"
-	preresolverQueueBufferSize  = 1024 // arbitrarily chosen buffer size
+	fusermountBin              = "fusermount"
+	preresolverQueueBufferSize = 1024 // arbitrarily chosen buffer size
 
 	ErrAllLazyPullModesDisabled = errors.New("all lazy pull modes are disabled")
 )
@@ -177,6 +178,7 @@ type options struct {
 	maxConcurrency    int64
 	pullModes         config.PullModes
 	resolverConfig    config.ResolverConfig
+	debugServeMux     *http.ServeMux
 }
 
 func WithGetSources(s source.GetSources) Option {
@@ -304,6 +306,25 @@ func NewFilesystem(ctx context.Context, root string, cfg config.FSConfig, opts .
 		log.G(ctx).Info("background fetch is disabled")
 	}
 
+	if cfg.GarbageCollectionConfig.Enable {
+		log.G(ctx).WithFields(logrus.Fields{
+			"interval":     time.Duration(cfg.GarbageCollectionConfig.IntervalSec) * time.Second,
+			"gracePeriod":  time.Duration(cfg.GarbageCollectionConfig.GracePeriodSec) * time.Second,
+			"maxStoreSize": cfg.GarbageCollectionConfig.MaxStoreSize,
+		}).Info("starting background garbage collection of SOCI artifacts")
+		artifactCollector := newArtifactCollector(cfg.GarbageCollectionConfig, store, cfg.ContentStoreConfig.Type, client, filepath.Dir(root))
+		go artifactCollector.Run(ctx)
+	}
+
+	// Serve the layers whose blob is already in the content store, e.g. because another image
+	// with the same layer was pulled, from the content store instead of the registry.
+	if localStore, ok := store.(remote.LocalStore); ok && !cfg.BlobConfig.DisableLocalBlobs {
+		if fsOpts.resolveHandlers == nil {
+			fsOpts.resolveHandlers = make(map[string]remote.Handler)
+		}
+		fsOpts.resolveHandlers["local-content-store"] = remote.NewLocalStoreHandler(localStore)
+	}
+
 	r, err := layer.NewResolver(root, cfg, fsOpts.resolveHandlers, metadataStore, store, fsOpts.overlayOpaqueType, bgFetcher)
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup resolver: %w", err)
@@ -334,7 +355,16 @@ func NewFilesystem(ctx context.Context, root string, cfg config.FSConfig, opts .
 		return nil, err
 	}
 
-	return &filesystem{
+	var trustedKeys []*ecdsa.PublicKey
+	if cfg.IndexSigningConfig.Enable {
+		trustedKeys, err = soci.LoadTrustedKeys(cfg.IndexSigningConfig.TrustedPublicKeys)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load trusted SOCI index signing keys: %w", err)
+		}
+		log.G(ctx).WithField("keys", len(trustedKeys)).Info("soci index signature verification is enabled")
+	}
+
+	fs := &filesystem{
 		// it's generally considered bad practice to store a context in a struct,
 		// however `filesystem` has it's own lifecycle as well as a per-request lifecycle.
 		// Some operations (e.g. remote calls) exist within a per-request lifecycle and use
@@ -361,7 +391,24 @@ func NewFilesystem(ctx context.Context, root string, cfg config.FSConfig, opts .
 		resolverConfig:              fsOpts.resolverConfig,
 		containerd:                  client,
 		inProgressImageUnpacks:      unpackJobs,
-	}, nil
+		trustedKeys:                 trustedKeys,
+		snapshotterRoot:             filepath.Dir(root),
+		accessRecording:             cfg.AccessRecordingConfig,
+		accessRecorders:             make(map[string]*layer.AccessRecorder),
+		mounts:                      make(map[string]mountInfo),
+	}
+
+	if fsOpts.debugServeMux != nil {
+		fs.registerDebugHandlers(fsOpts.debugServeMux)
+	}
+
+	if cfg.MaterializeConfig.Enable {
+		log.G(ctx).WithField("checkInterval", time.Duration(cfg.MaterializeConfig.CheckIntervalSec)*time.Second).
+			Info("starting materialization of fully fetched layers")
+		go newLayerMaterializer(fs, cfg.MaterializeConfig).Run(ctx)
+	}
+
+	return fs, nil
 }
 
 func createParallelPullStructs(ctx context.Context, storage LayerUnpackJobStorage, parallelConfig *config.Parallel) (*unpackJobs, error) {
@@ -385,18 +432,22 @@ type sociContext struct {
 	bgFetchPauseOnce     sync.Once
 	fetchOnce            sync.Once
 	sociIndex            *soci.Index
+	sociIndexDigest      digest.Digest
 	imageLayerToSociDesc map[string]ocispec.Descriptor
 	fuseOperationCounter *layer.FuseOperationCounter
+	recordOnce           sync.Once
+	accessRecorder       *layer.AccessRecorder
 }
 
 func (c *sociContext) Init(ctx context.Context, fs *filesystem, imageRef, indexDigest, imageManifestDigest string, client *http.Client) error {
 	c.fetchOnce.Do(func() {
-		index, err := fs.fetchSociIndex(ctx, imageRef, indexDigest, imageManifestDigest, client)
+		index, sociIndexDigest, err := fs.fetchSociIndex(ctx, imageRef, indexDigest, imageManifestDigest, client)
 		if err != nil {
 			c.cachedErr = err
 			return
 		}
 		c.sociIndex = index
+		c.sociIndexDigest = sociIndexDigest
 		c.populateImageLayerToSociMapping(index)
 
 		// Create the FUSE operation counter.
@@ -407,6 +458,47 @@ func (c *sociContext) Init(ctx context.Context, fs *filesystem, imageRef, indexD
 	return c.cachedErr
 }
 
+// startAccessRecording starts recording the file accesses of the image if it is enabled
+// by the `RecordAccessLabel` label or the config. Only the first mount of an image starts
+// a recording. The trace is saved under the snapshotter root when the recording window ends.
+func (c *sociContext) startAccessRecording(ctx context.Context, fs *filesystem, labels map[string]string, imageManifestDigest string) *layer.AccessRecorder {
+	c.recordOnce.Do(func() {
+		duration := time.Duration(fs.accessRecording.DurationSec) * time.Second
+		value, ok := labels[source.RecordAccessLabel]
+		if !ok && !fs.accessRecording.Enable {
+			return
+		}
+		if value != "" {
+			sec, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || sec <= 0 {
+				log.G(ctx).WithField("value", value).Warnf("invalid %s label, using the configured duration", source.RecordAccessLabel)
+			} else {
+				duration = time.Duration(sec) * time.Second
+			}
+		}
+		if duration <= 0 {
+			return
+		}
+
+		manifestDigest := digest.Digest(imageManifestDigest)
+		c.accessRecorder = layer.NewAccessRecorder(manifestDigest, digest.Digest(labels[source.TargetSociIndexDigestLabel]), duration)
+		log.G(ctx).WithField("duration", duration).Info("recording file accesses of image")
+		go func() {
+			trace := c.accessRecorder.Run(fs.ctx)
+			if trace == nil {
+				return
+			}
+			path := soci.AccessTracePath(fs.snapshotterRoot, manifestDigest)
+			if err := soci.WriteAccessTrace(path, trace); err != nil {
+				log.G(fs.ctx).WithError(err).WithField("image", manifestDigest).Warn("failed to save access trace")
+				return
+			}
+			log.G(fs.ctx).WithField("image", manifestDigest).WithField("path", path).Info("saved access trace")
+		}()
+	})
+	return c.accessRecorder
+}
+
 func (c *sociContext) populateImageLayerToSociMapping(sociIndex *soci.Index) {
 	c.imageLayerToSociDesc = make(map[string]ocispec.Descriptor, len(sociIndex.Blobs))
 	for _, desc := range sociIndex.Blobs {
@@ -454,6 +546,18 @@ type filesystem struct {
 	resolverConfig              config.ResolverConfig
 	containerd                  *store.ContainerdClient
 	inProgressImageUnpacks      *unpackJobs
+	// trustedKeys are the keys which must have signed a SOCI index before it is
+	// used. If nil, SOCI index signatures are not verified.
+	trustedKeys []*ecdsa.PublicKey
+	// snapshotterRoot is the root directory of the snapshotter, where access traces are saved.
+	snapshotterRoot string
+	accessRecording config.AccessRecordingConfig
+	// accessRecorders maps a mountpoint to the recorder of the image it belongs to.
+	// Protected by layerMu.
+	accessRecorders map[string]*layer.AccessRecorder
+	// mounts maps a mountpoint to the image its layer was mounted for.
+	// Protected by layerMu.
+	mounts map[string]mountInfo
 }
 
 // isInsecureHost reports whether the given registry host is configured as an
@@ -863,29 +967,35 @@ func (fs *filesystem) getSociContext(ctx context.Context, imageRef, indexDigest,
 	return c, err
 }
 
-func (fs *filesystem) fetchSociIndex(ctx context.Context, imageRef, indexDigest, imageManifestDigest string, client *http.Client) (*soci.Index, error) {
+func (fs *filesystem) fetchSociIndex(ctx context.Context, imageRef, indexDigest, imageManifestDigest string, client *http.Client) (*soci.Index, digest.Digest, error) {
 	refspec, err := reference.Parse(imageRef)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	remoteStore, err := newRemoteStore(refspec, client, fs.isInsecureHost(refspec.Hostname()))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	indexDesc, err := fs.findSociIndexDesc(ctx, imageManifestDigest, indexDigest, remoteStore)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", snapshot.ErrNoIndex, err)
+		return nil, "", fmt.Errorf("%w: %w", snapshot.ErrNoIndex, err)
+	}
+
+	if fs.trustedKeys != nil {
+		if err := verifyIndexSignature(ctx, indexDesc.Digest, remoteStore, fs.trustedKeys); err != nil {
+			return nil, "", fmt.Errorf("%w: soci index %s is not signed by a trusted key: %w", snapshot.ErrNoIndex, indexDesc.Digest, err)
+		}
 	}
 
 	log.G(ctx).WithField("digest", indexDesc.Digest.String()).Infof("fetching SOCI artifacts using index descriptor")
 
 	index, err := FetchSociArtifacts(ctx, refspec, indexDesc, fs.contentStore, remoteStore)
 	if err != nil {
-		return nil, fmt.Errorf("%w: error trying to fetch SOCI artifacts: %w", snapshot.ErrNoIndex, err)
+		return nil, "", fmt.Errorf("%w: error trying to fetch SOCI artifacts: %w", snapshot.ErrNoIndex, err)
 	}
-	return index, nil
+	return index, indexDesc.Digest, nil
 }
 
 func (fs *filesystem) findSociIndexDesc(ctx context.Context, imageManifestDigest string, sociIndexDigest string, remoteStore *orasremote.Repository) (ocispec.Descriptor, error) {
@@ -924,7 +1034,7 @@ func (fs *filesystem) findSociIndexDesc(ctx context.Context, imageManifestDigest
 	// 3. Try to find an index using the referrers API if SOCI v1 is enabled.
 	if fs.pullModes.SOCIv1.Enable {
 		log.G(ctx).Debug("checking for soci v1 index via referrers API")
-		desc, err := findSociIndexDescReferrer(ctx, imgDigest, remoteStore)
+		desc, err := findSociIndexDescReferrer(ctx, imgDigest, remoteStore, fs.pullModes.SOCIv1)
 		if err == nil {
 			log.G(ctx).Debug("using soci v1 index via referrers API")
 			return desc, nil
@@ -973,16 +1083,66 @@ func findSociIndexDescAnnotation(ctx context.Context, imgDigest digest.Digest, r
 	return ocispec.Descriptor{}, errdefs.ErrNotFound
 }
 
-func findSociIndexDescReferrer(ctx context.Context, imgDigest digest.Digest, remoteStore *orasremote.Repository) (ocispec.Descriptor, error) {
+func findSociIndexDescReferrer(ctx context.Context, imgDigest digest.Digest, remoteStore *orasremote.Repository, cfg config.V1) (ocispec.Descriptor, error) {
 	artifactClient := NewOCIArtifactClient(remoteStore)
 
-	desc, err := artifactClient.SelectReferrer(ctx, ocispec.Descriptor{Digest: imgDigest}, defaultIndexSelectionPolicy)
+	var candidates int
+	policy := newIndexSelectionPolicy(ctx, cfg, remoteStore)
+	desc, err := artifactClient.SelectReferrer(ctx, ocispec.Descriptor{Digest: imgDigest}, func(descs []ocispec.Descriptor) (ocispec.Descriptor, error) {
+		candidates = len(descs)
+		return policy(descs)
+	})
 	if err != nil {
 		return ocispec.Descriptor{}, fmt.Errorf("cannot fetch list of referrers: %w", err)
 	}
+	log.G(ctx).WithFields(logrus.Fields{
+		"digest":     desc.Digest,
+		"policy":     cfg.IndexSelectionPolicy,
+		"candidates": candidates,
+		"reason":     indexSelectionReason(cfg, desc),
+	}).Info("selected soci v1 index")
 	return desc, nil
 }
 
+// newIndexSelectionPolicy returns the index selection policy configured for SOCI v1.
+func newIndexSelectionPolicy(ctx context.Context, cfg config.V1, remoteStore *orasremote.Repository) IndexSelectionPolicy {
+	switch cfg.IndexSelectionPolicy {
+	case config.IndexSelectionPolicyNewest:
+		return SelectNewestPolicy
+	case config.IndexSelectionPolicyBuildTool:
+		return NewSelectBuildToolPolicy(cfg.PreferredBuildTool)
+	case config.IndexSelectionPolicyAnnotation:
+		key, value, _ := cfg.RequiredAnnotationKeyValue()
+		return NewSelectAnnotationPolicy(key, value)
+	case config.IndexSelectionPolicyMostLayers:
+		return NewSelectMostLayersPolicy(ctx, remoteStore)
+	default:
+		return SelectFirstPolicy
+	}
+}
+
+// indexSelectionReason describes why the index selection policy selected `desc`.
+func indexSelectionReason(cfg config.V1, desc ocispec.Descriptor) string {
+	switch cfg.IndexSelectionPolicy {
+	case config.IndexSelectionPolicyNewest:
+		if created, ok := indexCreated(desc); ok {
+			return fmt.Sprintf("newest index, created at %s", created.Format(time.RFC3339))
+		}
+		return "no index has a valid created annotation, first index listed by the registry"
+	case config.IndexSelectionPolicyBuildTool:
+		if desc.Annotations[soci.IndexAnnotationBuildToolIdentifier] == cfg.PreferredBuildTool {
+			return fmt.Sprintf("index built by %s", cfg.PreferredBuildTool)
+		}
+		return fmt.Sprintf("no index built by %s, first index listed by the registry", cfg.PreferredBuildTool)
+	case config.IndexSelectionPolicyAnnotation:
+		return fmt.Sprintf("index with annotation %s", cfg.RequiredAnnotation)
+	case config.IndexSelectionPolicyMostLayers:
+		return "index with ztocs for the most layers"
+	default:
+		return "first index listed by the registry"
+	}
+}
+
 func getIDMappedMountpoint(mountpoint, activeLayerID string) string {
 	d := filepath.Dir(mountpoint)
 	return filepath.Join(fmt.Sprintf("%s_%s", d, activeLayerID), "fs")
@@ -1008,8 +1168,13 @@ func (fs *filesystem) IDMapMount(ctx context.Context, mountpoint, activeLayerID
 		return "", errdefs.ErrNotFound
 	}
 	fs.layer[newMountpoint] = l
+	fs.mounts[newMountpoint] = fs.mounts[mountpoint]
+	recorder := fs.accessRecorders[mountpoint]
+	if recorder != nil {
+		fs.accessRecorders[newMountpoint] = recorder
+	}
 	fs.layerMu.Unlock()
-	node, err := l.RootNode(0, idmapper)
+	node, err := l.RootNode(0, idmapper, recorder)
 	if err != nil {
 		return "", err
 	}
@@ -1166,7 +1331,8 @@ func (fs *filesystem) Mount(ctx context.Context, mountpoint string, labels map[s
 		}
 	}()
 
-	node, err := l.RootNode(0, idtools.IDMap{})
+	recorder := c.startAccessRecording(ctx, fs, labels, imgDigest)
+	node, err := l.RootNode(0, idtools.IDMap{}, recorder)
 	if err != nil {
 		log.G(ctx).WithError(err).Warnf("Failed to get root node")
 		retErr = fmt.Errorf("failed to get root node: %w", err)
@@ -1180,6 +1346,14 @@ func (fs *filesystem) Mount(ctx context.Context, mountpoint string, labels map[s
 	// Register the mountpoint layer
 	fs.layerMu.Lock()
 	fs.layer[mountpoint] = l
+	fs.mounts[mountpoint] = mountInfo{
+		imageRef:    imageRef,
+		imageDigest: imgDigest,
+		indexDigest: c.sociIndexDigest,
+	}
+	if recorder != nil {
+		fs.accessRecorders[mountpoint] = recorder
+	}
 	fs.layerMu.Unlock()
 	fs.metricsController.Add(mountpoint, l)
 
@@ -1311,6 +1485,8 @@ func (fs *filesystem) Unmount(ctx context.Context, mountpoint string) error {
 	}
 
 	delete(fs.layer, mountpoint)
+	delete(fs.mounts, mountpoint)
+	delete(fs.accessRecorders, mountpoint)
 	// If the mountpoint is an id-mapped layer, it is pointing to the
 	// underlying layer, so we cannot call done on it.
 	// We do a evict call to call the registered evict functions.
@@ -39,13 +39,17 @@
 package fs
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"testing"
 
+	"github.com/awslabs/soci-snapshotter/config"
 	"github.com/awslabs/soci-snapshotter/fs/layer"
 	"github.com/awslabs/soci-snapshotter/fs/remote"
 	"github.com/awslabs/soci-snapshotter/fs/source"
+	spanmanager "github.com/awslabs/soci-snapshotter/fs/span-manager"
 	"github.com/awslabs/soci-snapshotter/idtools"
 	"github.com/containerd/containerd/v2/core/remotes/docker"
 	"github.com/containerd/containerd/v2/pkg/reference"
@@ -77,6 +81,38 @@ func TestCheck(t *testing.T) {
 	}
 }
 
+func TestIndexSelectionReason(t *testing.T) {
+	cfg := config.V1{IndexSelectionPolicy: config.IndexSelectionPolicyNewest}
+	testcases := []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			name:        "valid created annotation",
+			annotations: map[string]string{ocispec.AnnotationCreated: "2025-01-01T00:00:00Z"},
+			expected:    "newest index, created at 2025-01-01T00:00:00Z",
+		},
+		{
+			name:        "invalid created annotation",
+			annotations: map[string]string{ocispec.AnnotationCreated: "yesterday"},
+			expected:    "no index has a valid created annotation, first index listed by the registry",
+		},
+		{
+			name:     "no created annotation",
+			expected: "no index has a valid created annotation, first index listed by the registry",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			reason := indexSelectionReason(cfg, ocispec.Descriptor{Annotations: tc.annotations})
+			if reason != tc.expected {
+				t.Fatalf("expected reason %q, got %q", tc.expected, reason)
+			}
+		})
+	}
+}
+
 type breakableLayer struct {
 	success bool
 }
@@ -87,7 +123,7 @@ func (l *breakableLayer) Info() layer.Info {
 	}
 }
 func (l *breakableLayer) DisableXAttrs() bool { return false }
-func (l *breakableLayer) RootNode(uint32, idtools.IDMap) (fusefs.InodeEmbedder, error) {
+func (l *breakableLayer) RootNode(uint32, idtools.IDMap, *layer.AccessRecorder) (fusefs.InodeEmbedder, error) {
 	return nil, nil
 }
 func (l *breakableLayer) Verify(tocDigest digest.Digest) error { return nil }
@@ -96,7 +132,15 @@ func (l *breakableLayer) ReadAt([]byte, int64, ...remote.Option) (int, error) {
 	return 0, fmt.Errorf("fail")
 }
 func (l *breakableLayer) GetCacheRefKey() string { return "" }
-func (l *breakableLayer) BackgroundFetch() error { return fmt.Errorf("fail") }
+func (l *breakableLayer) Complete() bool         { return false }
+func (l *breakableLayer) UncompressedReader() io.ReadCloser {
+	return io.NopCloser(bytes.NewReader(nil))
+}
+func (l *breakableLayer) Status() layer.Status {
+	return layer.Status{BackgroundFetchQueuePosition: -1}
+}
+func (l *breakableLayer) Spans() []spanmanager.SpanStatus { return nil }
+func (l *breakableLayer) BackgroundFetch() error          { return fmt.Errorf("fail") }
 func (l *breakableLayer) Check() error {
 	if !l.success {
 		return fmt.Errorf("failed")
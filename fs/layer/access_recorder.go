@@ -0,0 +1,177 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package layer
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/opencontainers/go-digest"
+)
+
+// AccessRecorder records which files of the layers of an image are opened and read
+// through FUSE during a window of time after the image is mounted.
+// The recorded accesses can be turned into prefetch artifacts.
+type AccessRecorder struct {
+	imageDigest digest.Digest
+	indexDigest digest.Digest
+	duration    time.Duration
+	startedAt   time.Time
+
+	mu     sync.Mutex
+	layers []*layerAccesses
+	byDesc map[digest.Digest]*layerAccesses
+}
+
+type layerAccesses struct {
+	digest digest.Digest
+	files  []*fileAccesses
+	byPath map[string]*fileAccesses
+}
+
+type fileAccesses struct {
+	path   string
+	ranges []soci.AccessRange
+}
+
+// NewAccessRecorder constructs an AccessRecorder for an image with digest imgDigest
+// and SOCI index indexDigest. Accesses are recorded for `duration`, starting now.
+func NewAccessRecorder(imgDigest, indexDigest digest.Digest, duration time.Duration) *AccessRecorder {
+	return &AccessRecorder{
+		imageDigest: imgDigest,
+		indexDigest: indexDigest,
+		duration:    duration,
+		startedAt:   time.Now(),
+		byDesc:      make(map[digest.Digest]*layerAccesses),
+	}
+}
+
+// recording returns whether the recording window is still open.
+func (r *AccessRecorder) recording() bool {
+	return r != nil && time.Since(r.startedAt) < r.duration
+}
+
+// RecordOpen records that the file `path` of a layer was opened.
+func (r *AccessRecorder) RecordOpen(layerDigest digest.Digest, path string) {
+	if !r.recording() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.file(layerDigest, path)
+}
+
+// RecordRead records that `length` bytes at `offset` of the file `path` of a layer were read.
+func (r *AccessRecorder) RecordRead(layerDigest digest.Digest, path string, offset, length int64) {
+	if !r.recording() || length <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := r.file(layerDigest, path)
+	// Files are mostly read sequentially, so extend the last range if the read is contiguous with it.
+	if n := len(f.ranges); n > 0 {
+		last := &f.ranges[n-1]
+		if offset >= last.Offset && offset <= last.Offset+last.Length {
+			last.Length = max(last.Length, offset+length-last.Offset)
+			return
+		}
+	}
+	f.ranges = append(f.ranges, soci.AccessRange{Offset: offset, Length: length})
+}
+
+// file returns the accesses of a file, creating them if needed. r.mu must be held.
+func (r *AccessRecorder) file(layerDigest digest.Digest, path string) *fileAccesses {
+	l, ok := r.byDesc[layerDigest]
+	if !ok {
+		l = &layerAccesses{digest: layerDigest, byPath: make(map[string]*fileAccesses)}
+		r.byDesc[layerDigest] = l
+		r.layers = append(r.layers, l)
+	}
+	f, ok := l.byPath[path]
+	if !ok {
+		f = &fileAccesses{path: path}
+		l.byPath[path] = f
+		l.files = append(l.files, f)
+	}
+	return f
+}
+
+// Run waits until the recording window is closed and returns the recorded trace.
+// It returns nil if ctx is cancelled first. Should be started in a different goroutine
+// so that it doesn't block the current goroutine.
+func (r *AccessRecorder) Run(ctx context.Context) *soci.AccessTrace {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(time.Until(r.startedAt.Add(r.duration))):
+		return r.Trace()
+	}
+}
+
+// Trace returns the accesses recorded so far. The ranges of each file are sorted
+// and merged when they overlap.
+func (r *AccessRecorder) Trace() *soci.AccessTrace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	trace := &soci.AccessTrace{
+		Version:             soci.AccessTraceVersion,
+		ImageManifestDigest: r.imageDigest,
+		SociIndexDigest:     r.indexDigest,
+		StartedAt:           r.startedAt,
+		DurationSec:         int64(r.duration.Seconds()),
+		Layers:              make([]soci.LayerAccessTrace, 0, len(r.layers)),
+	}
+	for _, l := range r.layers {
+		lt := soci.LayerAccessTrace{
+			LayerDigest: l.digest,
+			Files:       make([]soci.FileAccessTrace, 0, len(l.files)),
+		}
+		for _, f := range l.files {
+			lt.Files = append(lt.Files, soci.FileAccessTrace{
+				Path:   f.path,
+				Ranges: mergeAccessRanges(f.ranges),
+			})
+		}
+		trace.Layers = append(trace.Layers, lt)
+	}
+	return trace
+}
+
+func mergeAccessRanges(ranges []soci.AccessRange) []soci.AccessRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b soci.AccessRange) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+	merged := []soci.AccessRange{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.Offset <= last.Offset+last.Length {
+			last.Length = max(last.Length, r.Offset+r.Length-last.Offset)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
@@ -0,0 +1,94 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package layer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestAccessRecorder(t *testing.T) {
+	imgDigest := digest.FromString("image")
+	layer1 := digest.FromString("layer1")
+	layer2 := digest.FromString("layer2")
+
+	r := NewAccessRecorder(imgDigest, "", time.Hour)
+	r.RecordOpen(layer1, "bin/sh")
+	r.RecordRead(layer1, "bin/sh", 0, 4096)
+	r.RecordRead(layer1, "bin/sh", 4096, 4096)
+	r.RecordRead(layer1, "bin/sh", 65536, 100)
+	r.RecordRead(layer1, "bin/sh", 8000, 1000)
+	r.RecordOpen(layer2, "etc/passwd")
+	r.RecordRead(layer2, "lib/libc.so", 10, 0)
+	r.RecordRead(layer1, "lib/libc.so", 100, 10)
+	r.RecordRead(layer1, "lib/libc.so", 0, 10)
+
+	trace := r.Trace()
+	if trace.Version != soci.AccessTraceVersion || trace.ImageManifestDigest != imgDigest || trace.DurationSec != 3600 {
+		t.Fatalf("unexpected trace metadata: %+v", trace)
+	}
+	expected := []soci.LayerAccessTrace{
+		{
+			LayerDigest: layer1,
+			Files: []soci.FileAccessTrace{
+				{Path: "bin/sh", Ranges: []soci.AccessRange{{Offset: 0, Length: 9000}, {Offset: 65536, Length: 100}}},
+				{Path: "lib/libc.so", Ranges: []soci.AccessRange{{Offset: 0, Length: 10}, {Offset: 100, Length: 10}}},
+			},
+		},
+		{
+			LayerDigest: layer2,
+			Files:       []soci.FileAccessTrace{{Path: "etc/passwd"}},
+		},
+	}
+	if !reflect.DeepEqual(trace.Layers, expected) {
+		t.Fatalf("unexpected layers: got %+v, want %+v", trace.Layers, expected)
+	}
+}
+
+func TestAccessRecorderWindow(t *testing.T) {
+	r := NewAccessRecorder(digest.FromString("image"), "", 50*time.Millisecond)
+	layerDigest := digest.FromString("layer")
+	r.RecordRead(layerDigest, "early", 0, 10)
+
+	trace := r.Run(context.Background())
+	if trace == nil || len(trace.Layers) != 1 {
+		t.Fatalf("expected a trace with one layer, got %+v", trace)
+	}
+
+	// Accesses after the window closes are not recorded.
+	r.RecordRead(layerDigest, "late", 0, 10)
+	if files := r.Trace().Files(); !reflect.DeepEqual(files, []string{"early"}) {
+		t.Fatalf("unexpected files: %v", files)
+	}
+
+	// A nil recorder doesn't record anything.
+	var nilRecorder *AccessRecorder
+	if nilRecorder.recording() {
+		t.Fatal("nil recorder should not be recording")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if trace := NewAccessRecorder(digest.FromString("image"), "", time.Hour).Run(ctx); trace != nil {
+		t.Fatalf("expected no trace when the context is cancelled, got %+v", trace)
+	}
+}
@@ -49,12 +49,14 @@ import (
 	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/awslabs/soci-snapshotter/cache"
 	"github.com/awslabs/soci-snapshotter/config"
 
 	backgroundfetcher "github.com/awslabs/soci-snapshotter/fs/backgroundfetcher"
+	"github.com/awslabs/soci-snapshotter/fs/fetchscheduler"
 	commonmetrics "github.com/awslabs/soci-snapshotter/fs/metrics/common"
 	"github.com/awslabs/soci-snapshotter/fs/reader"
 	"github.com/awslabs/soci-snapshotter/fs/remote"
@@ -74,7 +76,6 @@ import (
 	digest "github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/sync/semaphore"
 	"oras.land/oras-go/v2/content"
 )
 
@@ -83,6 +84,10 @@ const (
 	defaultMaxLRUCacheEntry   = 10
 	defaultMaxCacheFds        = 10
 	memoryCacheType           = "memory"
+
+	// passthroughDirName is the directory under the root directory which holds the
+	// backing files of the layers served with FUSE passthrough.
+	passthroughDirName = "passthrough"
 )
 
 // Layer represents a layer.
@@ -90,8 +95,9 @@ type Layer interface {
 	// Info returns the information of this layer.
 	Info() Info
 
-	// RootNode returns the root node of this layer.
-	RootNode(baseInode uint32, idMapper idtools.IDMap) (fusefs.InodeEmbedder, error)
+	// RootNode returns the root node of this layer. If recorder is not nil,
+	// the files opened and read through the node are recorded by it.
+	RootNode(baseInode uint32, idMapper idtools.IDMap, recorder *AccessRecorder) (fusefs.InodeEmbedder, error)
 
 	// Check checks if the layer is still connectable.
 	Check() error
@@ -111,6 +117,19 @@ type Layer interface {
 
 	// GetCacheRefKey returns the reference key for the cache used by the layer
 	GetCacheRefKey() string
+
+	// Complete reports whether every span of this layer is fetched and cached.
+	Complete() bool
+
+	// UncompressedReader returns a reader of the uncompressed layer tar. Spans which
+	// aren't cached are fetched.
+	UncompressedReader() io.ReadCloser
+
+	// Status returns the current state of this layer, for debugging.
+	Status() Status
+
+	// Spans returns the current state of every span of this layer, for debugging.
+	Spans() []spanmanager.SpanStatus
 }
 
 // Info is the current status of a layer.
@@ -121,6 +140,40 @@ type Info struct {
 	ReadTime    time.Time // last time the layer was read
 }
 
+// Status is the state of a layer reported by the debug endpoints of the filesystem.
+type Status struct {
+	Digest      digest.Digest `json:"digest"`
+	Size        int64         `json:"size"`
+	FetchedSize int64         `json:"fetchedSize"`
+	ReadTime    time.Time     `json:"readTime"`
+
+	// SpanStates counts the spans of the layer per state (see spanmanager.SpanStatus).
+	SpanStates map[string]int `json:"spanStates"`
+
+	// BackgroundFetchQueuePosition is the position of the layer in the work queue
+	// of the background fetcher, or -1 if it isn't queued.
+	BackgroundFetchQueuePosition int `json:"backgroundFetchQueuePosition"`
+
+	// Prefetch is the result of the prefetch of the layer, if it has a prefetch artifact.
+	Prefetch *PrefetchStatus `json:"prefetch,omitempty"`
+
+	// SpanCacheBytes is the size of the cached spans of the layer: the compressed
+	// size of the fetched spans and the uncompressed size of the uncompressed spans,
+	// plus their compressed size with a directory cache.
+	SpanCacheBytes int64 `json:"spanCacheBytes"`
+
+	// FileCacheBytes is the size of the file contents of the layer in the file cache.
+	FileCacheBytes int64 `json:"fileCacheBytes"`
+}
+
+// PrefetchStatus is the progress of the prefetch of a layer.
+type PrefetchStatus struct {
+	// Spans is the number of spans to prefetch.
+	Spans int `json:"spans"`
+	// ResolvedSpans is the number of spans prefetched so far.
+	ResolvedSpans int `json:"resolvedSpans"`
+}
+
 // Resolver resolves the layer location and provieds the handler of that layer.
 type Resolver struct {
 	rootDir           string
@@ -135,7 +188,9 @@ type Resolver struct {
 	artifactStore     content.Storage
 	overlayOpaqueType OverlayOpaqueType
 	bgFetcher         *backgroundfetcher.BackgroundFetcher
-	prefetchSemaphore *semaphore.Weighted
+	prefetchQueue     *prefetchQueue
+	fetchScheduler    *fetchscheduler.Scheduler
+	spanCacheQuota    *cache.Quota
 }
 
 // NewResolver returns a new layer resolver.
@@ -172,9 +227,32 @@ func NewResolver(root string, cfg config.FSConfig, resolveHandlers map[string]re
 		return nil, err
 	}
 
-	var prefetchSem *semaphore.Weighted
-	if cfg.PrefetchConfig.Enable && cfg.PrefetchConfig.MaxConcurrency > 0 {
-		prefetchSem = semaphore.NewWeighted(cfg.PrefetchConfig.MaxConcurrency)
+	if cfg.FuseConfig.Passthrough {
+		// The backing files of the previous run aren't used by any mount anymore.
+		passthroughDir := filepath.Join(root, passthroughDirName)
+		if err := os.RemoveAll(passthroughDir); err != nil {
+			return nil, fmt.Errorf("failed to clean up passthrough backing stores: %w", err)
+		}
+		if err := os.Mkdir(passthroughDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create passthrough directory: %w", err)
+		}
+	}
+
+	// The span caches of the layers outlive the snapshotter, and share a quota.
+	var spanCacheQuota *cache.Quota
+	if cfg.FSCacheType != memoryCacheType {
+		q, err := cache.NewQuota(filepath.Join(root, "spancache"), cfg.DirectoryCacheConfig.MaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize span cache quota: %w", err)
+		}
+		spanCacheQuota = q
+	}
+
+	// The prefetches of every layer share a pool of workers, so that at most
+	// max_concurrency layers are prefetched at once.
+	prefetchWorkers := int(cfg.PrefetchConfig.MaxConcurrency)
+	if prefetchWorkers <= 0 {
+		prefetchWorkers = runtime.GOMAXPROCS(0)
 	}
 
 	return &Resolver{
@@ -188,11 +266,15 @@ func NewResolver(root string, cfg config.FSConfig, resolveHandlers map[string]re
 		artifactStore:     artifactStore,
 		overlayOpaqueType: overlayOpaqueType,
 		bgFetcher:         bgFetcher,
-		prefetchSemaphore: prefetchSem,
+		prefetchQueue:     newPrefetchQueue(prefetchWorkers),
+		fetchScheduler:    fetchscheduler.New(cfg.FetchSchedulerConfig.MaxConcurrencyPerRegistry, cfg.FetchSchedulerConfig.ReservedOnDemandConcurrency),
+		spanCacheQuota:    spanCacheQuota,
 	}, nil
 }
 
-func newCache(root string, cacheType string, cfg config.FSConfig) (cache.BlobCache, error) {
+// newCache creates the span cache of a layer. A directory cache is persisted in
+// `root`, so that the spans survive the layer and snapshotter restarts.
+func newCache(root string, cacheType string, cfg config.FSConfig, quota *cache.Quota, layerDigest digest.Digest) (cache.BlobCache, error) {
 	if cacheType == memoryCacheType {
 		return cache.NewMemoryCache(), nil
 	}
@@ -220,24 +302,33 @@ func newCache(root string, cacheType string, cfg config.FSConfig) (cache.BlobCac
 	fCache.OnEvicted = func(key string, value interface{}) {
 		value.(*os.File).Close()
 	}
-	// create a cache on an unique directory
-	if err := os.MkdirAll(root, 0700); err != nil {
-		return nil, err
-	}
-	cachePath, err := os.MkdirTemp(root, "")
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize directory cache: %w", err)
-	}
-	return cache.NewDirectoryCache(
-		cachePath,
+	c, err := cache.NewDirectoryCache(
+		root,
 		cache.DirectoryCacheConfig{
-			SyncAdd:   dcc.SyncAdd,
-			DataCache: dCache,
-			FdCache:   fCache,
-			BufPool:   bufPool,
-			Direct:    dcc.Direct,
+			SyncAdd:    dcc.SyncAdd,
+			DataCache:  dCache,
+			FdCache:    fCache,
+			BufPool:    bufPool,
+			Direct:     dcc.Direct,
+			Persistent: true,
+			Quota:      quota,
 		},
 	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize directory cache: %w", err)
+	}
+	if dcc.Compression == config.DirectoryCacheCompressionZstd {
+		c = cache.NewCompressedCache(c, cache.CompressedCacheConfig{
+			OnAdd: func(size, storedSize int64) {
+				commonmetrics.AddBytesCount(commonmetrics.SpanCacheUncompressedBytes, layerDigest, size)
+				commonmetrics.AddBytesCount(commonmetrics.SpanCacheStoredBytes, layerDigest, storedSize)
+			},
+			OnDecode: func(start time.Time) {
+				commonmetrics.MeasureLatencyInMicroseconds(commonmetrics.SpanCacheDecode, layerDigest, start)
+			},
+		})
+	}
+	return c, nil
 }
 
 func (r *Resolver) Evict(name string) {
@@ -283,7 +374,13 @@ func (r *Resolver) Resolve(ctx context.Context, hosts []docker.RegistryHost, ref
 	commonmetrics.IncOperationCount(commonmetrics.ResolveCacheMiss, desc.Digest)
 	log.G(ctx).Debugf("resolving")
 
-	spanCache, err := newCache(filepath.Join(r.rootDir, "spancache"), r.config.FSCacheType, r.config)
+	// Spans are cached by layer digest, so that the layers of every image sharing the
+	// layer, and the layer resolved again after a restart, reuse them.
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid layer digest %q: %w", desc.Digest, err)
+	}
+	spanCacheDir := filepath.Join(r.rootDir, "spancache", desc.Digest.Algorithm().String(), desc.Digest.Encoded())
+	spanCache, err := newCache(spanCacheDir, r.config.FSCacheType, r.config, r.spanCacheQuota, desc.Digest)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create span manager cache: %w", err)
 	}
@@ -374,23 +471,47 @@ func (r *Resolver) Resolve(ctx context.Context, hosts []docker.RegistryHost, ref
 	if err != nil {
 		return nil, fmt.Errorf("error creating span manager: %w", err)
 	}
+	if r.config.FSCacheType != memoryCacheType {
+		spanManager.SetPersistentCache()
+	}
+	spanManager.SetFetchQueue(r.fetchScheduler.Registry(refspec.Hostname()))
+	spanManager.SetMultiRangeReader(multiRangeReaderAtFunc(func(ps [][]byte, offsets []int64) error {
+		return blobR.ReadAtMulti(ps, offsets)
+	}), r.config.BlobConfig.MaxSpansPerFetch)
 	var bgLayerResolver backgroundfetcher.Resolver
 	if r.bgFetcher != nil {
 		bgLayerResolver = backgroundfetcher.NewSequentialResolver(desc.Digest, spanManager)
 		r.bgFetcher.Add(bgLayerResolver)
 	}
 
-	if err := r.executePrefetch(ctx, spanManager, prefetchDesc); err != nil {
+	prefetch, err := r.executePrefetch(ctx, spanManager, prefetchDesc)
+	if err != nil {
 		log.G(ctx).WithError(err).Warn("Failed to execute prefetch, continuing without prefetch")
 	}
 
-	vr, err := reader.NewReader(meta, desc.Digest, spanManager, disableVerification)
+	var readerOpts []reader.Option
+	if !r.config.ReadAheadConfig.Disable {
+		readerOpts = append(readerOpts, reader.WithMaxReadAheadSpans(r.config.ReadAheadConfig.MaxSpans))
+	}
+	if !r.config.FileCacheConfig.Disable {
+		readerOpts = append(readerOpts, reader.WithFileCache(r.config.FileCacheConfig.MaxBytes, r.config.FileCacheConfig.MaxFileSize))
+	}
+	if r.config.FuseConfig.Passthrough {
+		// Every instance of the layer has its own backing store, removed with its reader.
+		backingDir, err := os.MkdirTemp(filepath.Join(r.rootDir, passthroughDirName), desc.Digest.Encoded()+"-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create passthrough backing store: %w", err)
+		}
+		readerOpts = append(readerOpts, reader.WithBackingStore(backingDir, r.config.FuseConfig.PassthroughMaxFileSize, r.spanCacheQuota))
+	}
+	vr, err := reader.NewReader(meta, desc.Digest, spanManager, disableVerification, readerOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read layer: %w", err)
 	}
 	disableXAttrs := getDisableXAttrAnnotation(sociDesc)
 	// Combine layer information together and cache it.
-	l := newLayer(r, desc, name, blobR, vr, bgLayerResolver, opCounter, disableXAttrs)
+	l := newLayer(r, desc, name, blobR, vr, spanManager, bgLayerResolver, opCounter, disableXAttrs)
+	l.prefetch = prefetch
 	r.layerCacheMu.Lock()
 	cachedL, done2, added := r.layerCache.Add(name, l)
 	r.layerCacheMu.Unlock()
@@ -441,6 +562,7 @@ func newLayer(
 	cacheRefKey string,
 	blob *blobRef,
 	r reader.Reader,
+	spanManager *spanmanager.SpanManager,
 	bgResolver backgroundfetcher.Resolver,
 	opCounter *FuseOperationCounter,
 	disableXAttrs bool,
@@ -451,6 +573,7 @@ func newLayer(
 		cacheRefKey:          cacheRefKey,
 		blob:                 blob,
 		r:                    r,
+		spanManager:          spanManager,
 		bgResolver:           bgResolver,
 		fuseOperationCounter: opCounter,
 		disableXAttrs:        disableXAttrs,
@@ -465,7 +588,12 @@ type layer struct {
 
 	bgResolver backgroundfetcher.Resolver
 
-	r reader.Reader
+	r           reader.Reader
+	spanManager *spanmanager.SpanManager
+
+	// prefetch is the progress of the prefetch of the layer, nil if the layer
+	// wasn't prefetched.
+	prefetch *prefetchProgress
 
 	fuseOperationCounter *FuseOperationCounter
 	disableXAttrs        bool
@@ -505,17 +633,65 @@ func (l *layerRef) Done() {
 	l.done()
 }
 
-func (l *layer) RootNode(baseInode uint32, idMapper idtools.IDMap) (fusefs.InodeEmbedder, error) {
+func (l *layer) RootNode(baseInode uint32, idMapper idtools.IDMap, recorder *AccessRecorder) (fusefs.InodeEmbedder, error) {
 	if l.isClosed() {
 		return nil, fmt.Errorf("layer is already closed")
 	}
-	return newNode(l, baseInode, idMapper)
+	return newNode(l, baseInode, idMapper, recorder)
 }
 
 func (l *layer) ReadAt(p []byte, offset int64, opts ...remote.Option) (int, error) {
 	return l.blob.ReadAt(p, offset, opts...)
 }
 
+func (l *layer) Complete() bool {
+	return l.spanManager.Complete()
+}
+
+func (l *layer) Status() Status {
+	info := l.Info()
+	st := Status{
+		Digest:                       info.Digest,
+		Size:                         info.Size,
+		FetchedSize:                  info.FetchedSize,
+		ReadTime:                     info.ReadTime,
+		SpanStates:                   make(map[string]int),
+		BackgroundFetchQueuePosition: -1,
+		FileCacheBytes:               l.r.FileCacheSize(),
+	}
+	for _, s := range l.spanManager.Spans() {
+		st.SpanStates[s.State]++
+		switch s.State {
+		case "fetched":
+			st.SpanCacheBytes += int64(s.CompressedSize)
+		case "uncompressed":
+			st.SpanCacheBytes += int64(s.UncompressedSize)
+			// The compressed span is kept along in a persistent cache.
+			if l.resolver.config.FSCacheType != memoryCacheType {
+				st.SpanCacheBytes += int64(s.CompressedSize)
+			}
+		}
+	}
+	if l.bgResolver != nil && l.resolver.bgFetcher != nil {
+		st.BackgroundFetchQueuePosition = l.resolver.bgFetcher.QueuePosition(l.bgResolver)
+	}
+	if l.prefetch != nil {
+		st.Prefetch = &PrefetchStatus{
+			Spans:         l.prefetch.spans,
+			ResolvedSpans: int(l.prefetch.resolvedSpans.Load()),
+		}
+	}
+	return st
+}
+
+func (l *layer) Spans() []spanmanager.SpanStatus {
+	return l.spanManager.Spans()
+}
+
+func (l *layer) UncompressedReader() io.ReadCloser {
+	return l.spanManager.UncompressedReader()
+}
+
 func (l *layer) DisableXAttrs() bool {
 	return l.disableXAttrs
 }
@@ -574,68 +750,80 @@ type readerAtFunc func([]byte, int64) (int, error)
 
 func (f readerAtFunc) ReadAt(p []byte, offset int64) (int, error) { return f(p, offset) }
 
-func (r *Resolver) executePrefetch(ctx context.Context, spanManager *spanmanager.SpanManager, prefetchDesc *ocispec.Descriptor) error {
+type multiRangeReaderAtFunc func([][]byte, []int64) error
+
+func (f multiRangeReaderAtFunc) ReadAtMulti(ps [][]byte, offsets []int64) error {
+	return f(ps, offsets)
+}
+
+// prefetchProgress is the progress of the prefetch of a layer.
+type prefetchProgress struct {
+	spans         int
+	resolvedSpans atomic.Int64
+}
+
+// executePrefetch prefetches the spans of the prefetch artifact `prefetchDesc`. It
+// returns the progress of the prefetch, or nil if the layer isn't prefetched.
+func (r *Resolver) executePrefetch(ctx context.Context, spanManager *spanmanager.SpanManager, prefetchDesc *ocispec.Descriptor) (*prefetchProgress, error) {
 	if prefetchDesc == nil {
-		return nil
+		return nil, nil
 	}
 
 	if !r.config.PrefetchConfig.Enable {
 		log.G(ctx).Debug("Prefetch is disabled in config, skipping prefetch")
-		return nil
+		return nil, nil
 	}
 
 	prefetchArtifact, err := r.loadPrefetchArtifact(ctx, prefetchDesc)
 	if err != nil {
 		if errors.Is(err, soci.ErrEmptyPrefetchArtifact) {
 			log.G(ctx).Debug("Prefetch artifact is empty, skipping prefetch")
-			return nil
+			return nil, nil
 		}
-		return fmt.Errorf("failed to load prefetch artifact: %w", err)
+		return nil, fmt.Errorf("failed to load prefetch artifact: %w", err)
 	}
 
-	var spansToFetch []compression.SpanID
+	// Contiguous spans are fetched together, up to max_spans_per_fetch spans at once.
+	batchSize := max(r.config.BlobConfig.MaxSpansPerFetch, 1)
+	numBatches := 0
+	progress := &prefetchProgress{}
 	for _, prefetchSpan := range prefetchArtifact.PrefetchSpans {
-		for spanID := prefetchSpan.StartSpan; spanID <= prefetchSpan.EndSpan; spanID++ {
-			spansToFetch = append(spansToFetch, spanID)
-		}
-	}
-
-	if len(spansToFetch) == 0 {
-		return nil
-	}
-
-	if r.prefetchSemaphore != nil {
-		if err := r.prefetchSemaphore.Acquire(ctx, 1); err != nil {
-			return err
+		if prefetchSpan.EndSpan >= prefetchSpan.StartSpan {
+			numSpans := int(prefetchSpan.EndSpan-prefetchSpan.StartSpan) + 1
+			numBatches += (numSpans + batchSize - 1) / batchSize
+			progress.spans += numSpans
 		}
-		defer r.prefetchSemaphore.Release(1)
 	}
 
-	spanChan := make(chan compression.SpanID, len(spansToFetch))
-	numWorkers := runtime.GOMAXPROCS(0)
-	if numWorkers > len(spansToFetch) {
-		numWorkers = len(spansToFetch)
+	if numBatches == 0 {
+		return nil, nil
 	}
 
+	// Spans are fetched from a queue shared with the prefetches of other layers,
+	// so that spans with a lower priority value are fetched first across layers.
 	var wg sync.WaitGroup
-
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for spanID := range spanChan {
-				spanManager.ResolveSpan(spanID)
+	wg.Add(numBatches)
+	for _, prefetchSpan := range prefetchArtifact.PrefetchSpans {
+		var fetches []func()
+		var batch []compression.SpanID
+		for spanID := prefetchSpan.StartSpan; spanID <= prefetchSpan.EndSpan; spanID++ {
+			batch = append(batch, spanID)
+			if len(batch) < batchSize && spanID < prefetchSpan.EndSpan {
+				continue
 			}
-		}()
-	}
-
-	for _, spanID := range spansToFetch {
-		spanChan <- spanID
+			spanIDs := batch
+			fetches = append(fetches, func() {
+				defer wg.Done()
+				if spanManager.ResolveSpans(ctx, spanIDs) == nil {
+					progress.resolvedSpans.Add(int64(len(spanIDs)))
+				}
+			})
+			batch = nil
+		}
+		r.prefetchQueue.push(prefetchSpan.Priority, fetches...)
 	}
-	close(spanChan)
-
 	wg.Wait()
-	return nil
+	return progress, nil
 }
 
 func (r *Resolver) loadPrefetchArtifact(ctx context.Context, prefetchDesc *ocispec.Descriptor) (*soci.PrefetchArtifact, error) {
@@ -190,7 +190,7 @@ func (f *FuseOperationCounter) Run(ctx context.Context) {
 
 // logFSOperations may cause sensitive information to be emitted to logs
 // e.g. filenames and paths within an image
-func newNode(l *layer, baseInode uint32, idMapper idtools.IDMap) (fusefs.InodeEmbedder, error) {
+func newNode(l *layer, baseInode uint32, idMapper idtools.IDMap, recorder *AccessRecorder) (fusefs.InodeEmbedder, error) {
 	r := l.r
 	rootID := r.Metadata().RootID()
 	rootAttr, err := r.Metadata().GetAttr(rootID)
@@ -210,6 +210,8 @@ func newNode(l *layer, baseInode uint32, idMapper idtools.IDMap) (fusefs.InodeEm
 		logFSOperations:  l.resolver.config.LogFuseOperations,
 		operationCounter: l.fuseOperationCounter,
 		statfsBase:       l.resolver.rootDir,
+		accessRecorder:   recorder,
+		passthrough:      l.resolver.config.FuseConfig.Passthrough,
 	}
 	ffs.s = ffs.newState(l.desc.Digest, l.blob)
 	return &node{
@@ -231,6 +233,8 @@ type fs struct {
 	logFSOperations  bool
 	operationCounter *FuseOperationCounter
 	statfsBase       string
+	accessRecorder   *AccessRecorder
+	passthrough      bool
 }
 
 func (fs *fs) inodeOfState() uint64 {
@@ -486,12 +490,39 @@ func (n *node) Open(ctx context.Context, flags uint32) (fh fusefs.FileHandle, fu
 		n.fs.reportFailure(fuseOpOpen, fmt.Errorf("%s: %w", fuseOpOpen, err))
 		return nil, 0, syscall.EIO
 	}
+	if n.fs.accessRecorder.recording() {
+		n.fs.accessRecorder.RecordOpen(n.fs.layerDigest, n.Path(nil))
+	}
 	return &file{
-		n:  n,
-		ra: ra,
+		n:       n,
+		ra:      ra,
+		backing: n.backingFile(ctx, ra),
 	}, fuse.FOPEN_KEEP_CACHE, 0
 }
 
+// backingFile returns the backing file of the opened file `ra` for FUSE passthrough,
+// or nil if the file is read through the snapshotter, e.g. because it isn't fully
+// cached yet or is too large. Files aren't read with passthrough while their reads
+// are recorded.
+func (n *node) backingFile(ctx context.Context, ra io.ReaderAt) *reader.BackingFile {
+	if !n.fs.passthrough || !n.attr.Mode.IsRegular() || n.attr.Size == 0 || n.fs.accessRecorder.recording() {
+		return nil
+	}
+	bf, ok := ra.(reader.BackingFiler)
+	if !ok {
+		return nil
+	}
+	f, err := bf.BackingFile()
+	if err != nil {
+		if !errors.Is(err, reader.ErrNotCached) && !errors.Is(err, reader.ErrTooLarge) {
+			log.G(ctx).WithError(err).WithField("path", n.Path(nil)).Debug("failed to open passthrough backing file")
+		}
+		return nil
+	}
+	commonmetrics.IncOperationCount(commonmetrics.FusePassthroughOpenCount, n.fs.layerDigest)
+	return f
+}
+
 var _ = (fusefs.NodeGetattrer)((*node)(nil))
 
 func (n *node) Getattr(ctx context.Context, f fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
@@ -574,6 +605,28 @@ func (n *node) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
 type file struct {
 	n  *node
 	ra io.ReaderAt
+
+	// backing is the file read by the kernel with FUSE passthrough, if any. Read is
+	// only called if the kernel doesn't support passthrough.
+	backing *reader.BackingFile
+}
+
+var _ = (fusefs.FilePassthroughFder)((*file)(nil))
+
+func (f *file) PassthroughFd() (int, bool) {
+	if f.backing == nil {
+		return -1, false
+	}
+	return int(f.backing.Fd()), true
+}
+
+var _ = (fusefs.FileReleaser)((*file)(nil))
+
+func (f *file) Release(ctx context.Context) syscall.Errno {
+	if f.backing != nil {
+		f.backing.Close()
+	}
+	return 0
 }
 
 var _ = (fusefs.FileReader)((*file)(nil))
@@ -588,6 +641,9 @@ func (f *file) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResul
 		f.n.fs.reportFailure(fuseOpFileRead, fmt.Errorf("%s: %w", fuseOpFileRead, err))
 		return nil, syscall.EIO
 	}
+	if f.n.fs.accessRecorder.recording() {
+		f.n.fs.accessRecorder.RecordRead(f.n.fs.layerDigest, f.n.Path(nil), off, int64(n))
+	}
 	return fuse.ReadResultData(dest[:n]), 0
 }
 
@@ -17,10 +17,31 @@
 package layer
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/awslabs/soci-snapshotter/cache"
+	"github.com/awslabs/soci-snapshotter/config"
+	"github.com/awslabs/soci-snapshotter/fs/reader"
+	spanmanager "github.com/awslabs/soci-snapshotter/fs/span-manager"
+	"github.com/awslabs/soci-snapshotter/idtools"
 	"github.com/awslabs/soci-snapshotter/metadata"
+	"github.com/awslabs/soci-snapshotter/util/testutil"
+	"github.com/awslabs/soci-snapshotter/ztoc"
+	"github.com/awslabs/soci-snapshotter/ztoc/compression"
+	ctdtestutil "github.com/containerd/containerd/v2/pkg/testutil"
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sys/unix"
 )
 
 func TestEntryToAttr(t *testing.T) {
@@ -58,3 +79,153 @@ func TestEntryToAttr(t *testing.T) {
 		})
 	}
 }
+
+// makePassthroughLayer returns a layer with a file "test" of `contents`, served with
+// FUSE passthrough if `passthrough` is true, and the span manager of the layer.
+func makePassthroughLayer(tb testing.TB, contents []byte, spanSize int64, passthrough bool) (*layer, *spanmanager.SpanManager) {
+	toc, sr, err := ztoc.BuildZtocReader(tb, []testutil.TarEntry{testutil.File("test", string(contents))}, gzip.DefaultCompression, spanSize)
+	if err != nil {
+		tb.Fatalf("failed to build ztoc: %v", err)
+	}
+	mr, err := metadata.NewTempDbStore(sr, toc.TOC)
+	if err != nil {
+		tb.Fatalf("failed to create metadata reader: %v", err)
+	}
+	spanManager, err := spanmanager.New(toc, sr, cache.NewMemoryCache(), 0, digest.FromString(""))
+	if err != nil {
+		tb.Fatalf("failed to create span manager: %v", err)
+	}
+	var opts []reader.Option
+	if passthrough {
+		opts = append(opts, reader.WithBackingStore(tb.TempDir(), int64(len(contents)), nil))
+	}
+	r, err := reader.NewReader(mr, digest.FromString(""), spanManager, false, opts...)
+	if err != nil {
+		mr.Close()
+		tb.Fatalf("failed to make new reader: %v", err)
+	}
+	tb.Cleanup(func() { r.Close() })
+	return &layer{
+		resolver: &Resolver{
+			overlayOpaqueType: OverlayOpaqueAll,
+			config: config.FSConfig{
+				FuseConfig: config.FuseConfig{Passthrough: passthrough, PassthroughMaxFileSize: int64(len(contents))},
+			},
+		},
+		desc: ocispec.Descriptor{
+			Digest: testStateLayerDigest,
+		},
+		blob: &blobRef{
+			Blob: &testBlobState{10, 5},
+			done: func() {},
+		},
+		r: &testReader{r},
+	}, spanManager
+}
+
+func TestNodePassthrough(t *testing.T) {
+	const spanSize = 1 << 16
+	ctx := context.Background()
+	contents := testutil.NewTestRand(t).RandomByteData(spanSize * 4)
+	l, _ := makePassthroughLayer(t, contents, spanSize, true)
+	rootNode, err := newNode(l, 100, idtools.IDMap{}, nil)
+	if err != nil {
+		t.Fatalf("failed to get root node: %v", err)
+	}
+	fusefs.NewNodeFS(rootNode, &fusefs.Options{})
+	var eo fuse.EntryOut
+	inode, errno := rootNode.(*node).Lookup(ctx, "test", &eo)
+	if errno != 0 {
+		t.Fatalf("failed to lookup test node; errno: %v", errno)
+	}
+	open := func() *file {
+		fh, _, errno := inode.Operations().(fusefs.NodeOpener).Open(ctx, 0)
+		if errno != 0 {
+			t.Fatalf("failed to open test file; errno: %v", errno)
+		}
+		return fh.(*file)
+	}
+
+	// The file isn't cached yet, so it's read through the snapshotter.
+	f := open()
+	if _, ok := f.PassthroughFd(); ok {
+		t.Fatalf("expected no passthrough fd before the file is cached")
+	}
+	if _, errno := f.Read(ctx, make([]byte, len(contents)), 0); errno != 0 {
+		t.Fatalf("failed to read test file; errno: %v", errno)
+	}
+	f.Release(ctx)
+
+	f = open()
+	if _, ok := f.PassthroughFd(); !ok {
+		t.Fatalf("expected a passthrough fd once the file is cached")
+	}
+	got := make([]byte, len(contents))
+	if _, err := f.backing.ReadAt(got, 0); err != nil {
+		t.Fatalf("failed to read backing file: %v", err)
+	}
+	if !bytes.Equal(got, contents) {
+		t.Fatalf("unexpected contents of backing file")
+	}
+	f.Release(ctx)
+	if _, err := f.backing.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected the backing file to be closed on release, got %v", err)
+	}
+}
+
+// BenchmarkNodeRead compares the throughput of the reads of a fully cached file
+// through a FUSE mount of the layer, with and without passthrough. The page cache of
+// the file is dropped before every read, so that the reads without passthrough reach
+// the snapshotter. It falls back to reads through the snapshotter if the kernel doesn't
+// support passthrough.
+func BenchmarkNodeRead(b *testing.B) {
+	ctdtestutil.RequiresRoot(b)
+	const (
+		spanSize = 1 << 20
+		readSize = 128 << 10
+	)
+	contents := testutil.NewTestRand(b).RandomByteData(spanSize * 16)
+	for _, passthrough := range []bool{false, true} {
+		b.Run(fmt.Sprintf("passthrough=%t", passthrough), func(b *testing.B) {
+			l, spanManager := makePassthroughLayer(b, contents, spanSize, passthrough)
+			for spanID := compression.SpanID(0); spanID <= spanManager.SpanIDAt(compression.Offset(len(contents))); spanID++ {
+				if err := spanManager.ResolveSpan(spanID); err != nil {
+					b.Fatalf("failed to resolve span %d: %v", spanID, err)
+				}
+			}
+			rootNode, err := newNode(l, 100, idtools.IDMap{}, nil)
+			if err != nil {
+				b.Fatalf("failed to get root node: %v", err)
+			}
+			mountpoint := b.TempDir()
+			server, err := fusefs.Mount(mountpoint, rootNode, &fusefs.Options{
+				MountOptions: fuse.MountOptions{FsName: "soci", DirectMountStrict: true},
+			})
+			if err != nil {
+				b.Skipf("failed to mount FUSE: %v", err)
+			}
+			defer server.Unmount()
+
+			buf := make([]byte, readSize)
+			b.SetBytes(int64(len(contents)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f, err := os.Open(filepath.Join(mountpoint, "test"))
+				if err != nil {
+					b.Fatalf("failed to open file: %v", err)
+				}
+				if err := unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED); err != nil {
+					b.Fatalf("failed to drop page cache: %v", err)
+				}
+				for {
+					if _, err := f.Read(buf); err == io.EOF {
+						break
+					} else if err != nil {
+						b.Fatalf("failed to read file: %v", err)
+					}
+				}
+				f.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package layer
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// prefetchQueue is a priority queue of spans to prefetch shared by the prefetches
+// of every layer, so that high priority spans of one layer are fetched before low
+// priority spans of another. Spans with the same priority are fetched in the order
+// in which they were queued.
+//
+// The queued fetches are run by up to `maxWorkers` workers, which are started when
+// fetches are queued and exit once the queue is empty.
+type prefetchQueue struct {
+	maxWorkers int
+
+	mu      sync.Mutex
+	items   prefetchItems
+	seq     uint64
+	workers int
+}
+
+type prefetchItem struct {
+	priority int
+	seq      uint64
+	fetch    func()
+}
+
+// newPrefetchQueue creates a prefetchQueue which runs up to `maxWorkers` fetches at once.
+func newPrefetchQueue(maxWorkers int) *prefetchQueue {
+	return &prefetchQueue{maxWorkers: max(maxWorkers, 1)}
+}
+
+// push queues fetches with a priority, and starts workers for them if needed.
+func (q *prefetchQueue) push(priority int, fetches ...func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, fetch := range fetches {
+		heap.Push(&q.items, &prefetchItem{priority: priority, seq: q.seq, fetch: fetch})
+		q.seq++
+	}
+	for q.workers < q.maxWorkers && q.workers < q.items.Len() {
+		q.workers++
+		go q.work()
+	}
+}
+
+// work runs queued fetches, highest priority first, until the queue is empty.
+func (q *prefetchQueue) work() {
+	for {
+		q.mu.Lock()
+		if q.items.Len() == 0 {
+			q.workers--
+			q.mu.Unlock()
+			return
+		}
+		fetch := heap.Pop(&q.items).(*prefetchItem).fetch
+		q.mu.Unlock()
+		fetch()
+	}
+}
+
+// prefetchItems implements heap.Interface.
+type prefetchItems []*prefetchItem
+
+func (p prefetchItems) Len() int { return len(p) }
+
+func (p prefetchItems) Less(i, j int) bool {
+	if p[i].priority != p[j].priority {
+		return p[i].priority < p[j].priority
+	}
+	return p[i].seq < p[j].seq
+}
+
+func (p prefetchItems) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func (p *prefetchItems) Push(x any) { *p = append(*p, x.(*prefetchItem)) }
+
+func (p *prefetchItems) Pop() any {
+	old := *p
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*p = old[:n-1]
+	return item
+}
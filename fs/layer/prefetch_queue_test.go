@@ -0,0 +1,116 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package layer
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPrefetchQueueOrder(t *testing.T) {
+	var (
+		q     = newPrefetchQueue(1)
+		order []string
+		wg    sync.WaitGroup
+	)
+	fetch := func(name string) func() {
+		wg.Add(1)
+		return func() {
+			defer wg.Done()
+			order = append(order, name)
+		}
+	}
+	// The only worker is busy while two layers queue their spans.
+	unblock := make(chan struct{})
+	wg.Add(1)
+	q.push(0, func() {
+		defer wg.Done()
+		<-unblock
+	})
+	q.push(5, fetch("layer1/assets1"), fetch("layer1/assets2"))
+	q.push(0, fetch("layer1/entrypoint"))
+	q.push(5, fetch("layer2/assets"))
+	q.push(-1, fetch("layer2/loader"))
+	q.push(0, fetch("layer2/libc"))
+	close(unblock)
+	wg.Wait()
+
+	expected := []string{
+		"layer2/loader",
+		"layer1/entrypoint",
+		"layer2/libc",
+		"layer1/assets1",
+		"layer1/assets2",
+		"layer2/assets",
+	}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("unexpected fetch order: got %v, want %v", order, expected)
+	}
+}
+
+func TestPrefetchQueueMaxWorkers(t *testing.T) {
+	const (
+		maxWorkers = 4
+		numFetches = 1000
+	)
+	var (
+		q                   = newPrefetchQueue(maxWorkers)
+		running, maxRunning atomic.Int64
+		count               atomic.Int64
+		wg                  sync.WaitGroup
+	)
+	fetch := func() {
+		defer wg.Done()
+		n := running.Add(1)
+		for m := maxRunning.Load(); n > m && !maxRunning.CompareAndSwap(m, n); m = maxRunning.Load() {
+		}
+		time.Sleep(time.Microsecond)
+		count.Add(1)
+		running.Add(-1)
+	}
+	// Several layers queue their spans concurrently.
+	wg.Add(numFetches)
+	for i := 0; i < 10; i++ {
+		go func() {
+			for j := 0; j < numFetches/10; j++ {
+				q.push(j%7, fetch)
+			}
+		}()
+	}
+	wg.Wait()
+	if count.Load() != numFetches {
+		t.Fatalf("expected %d fetches, got %d", numFetches, count.Load())
+	}
+	if maxRunning.Load() > maxWorkers {
+		t.Fatalf("expected at most %d concurrent fetches, got %d", maxWorkers, maxRunning.Load())
+	}
+	// The workers exit once the queue is empty.
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		q.mu.Lock()
+		workers := q.workers
+		q.mu.Unlock()
+		if workers == 0 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected the workers to exit, %d are still running", workers)
+		}
+	}
+}
@@ -385,7 +385,7 @@ func getRootNode(t *testing.T, r reader.Reader, opaque OverlayOpaqueType) *node
 		},
 		r: &testReader{r},
 	}
-	rootNode, err := newNode(l, 100, idtools.IDMap{})
+	rootNode, err := newNode(l, 100, idtools.IDMap{}, nil)
 	if err != nil {
 		t.Fatalf("failed to get root node: %v", err)
 	}
@@ -408,7 +408,7 @@ func getRootNodeWithStatfsBase(t *testing.T, r reader.Reader, opaque OverlayOpaq
 		},
 		r: &testReader{r},
 	}
-	rootNode, err := newNode(l, 100, idtools.IDMap{})
+	rootNode, err := newNode(l, 100, idtools.IDMap{}, nil)
 	if err != nil {
 		t.Fatalf("failed to get root node: %v", err)
 	}
@@ -425,6 +425,7 @@ func (tr *testReader) Metadata() metadata.Reader               { return tr.r.Met
 func (tr *testReader) Cache(opts ...reader.CacheOption) error  { return nil }
 func (tr *testReader) Close() error                            { return nil }
 func (tr *testReader) LastOnDemandReadTime() time.Time         { return time.Now() }
+func (tr *testReader) FileCacheSize() int64                    { return 0 }
 
 type testBlobState struct {
 	size        int64
@@ -437,6 +438,9 @@ func (tb *testBlobState) FetchedSize() int64 { return tb.fetchedSize }
 func (tb *testBlobState) ReadAt(p []byte, offset int64, opts ...remote.Option) (int, error) {
 	return 0, nil
 }
+func (tb *testBlobState) ReadAtMulti(ps [][]byte, offsets []int64, opts ...remote.Option) error {
+	return nil
+}
 func (tb *testBlobState) Cache(offset int64, size int64, opts ...remote.Option) error { return nil }
 func (tb *testBlobState) Refresh(ctx context.Context, hosts []docker.RegistryHost, refspec reference.Spec, desc ocispec.Descriptor) error {
 	return nil
@@ -0,0 +1,134 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/config"
+	"github.com/awslabs/soci-snapshotter/fs/layer"
+	commonmetrics "github.com/awslabs/soci-snapshotter/fs/metrics/common"
+	"github.com/awslabs/soci-snapshotter/snapshot"
+	"github.com/containerd/containerd/v2/pkg/archive"
+	"github.com/containerd/log"
+)
+
+// layerMaterializer periodically extracts the lazily loaded layers whose spans are all
+// cached into regular directories, which the snapshotter mounts instead of their FUSE
+// mounts (see snapshot.MaterializedPath).
+//
+// The FUSE mount of a materialized layer is kept until its snapshot is removed: mounts
+// handed out before the layer was materialized, e.g. the overlay of a running container,
+// may still use it, and the snapshotter doesn't know when they are released.
+type layerMaterializer struct {
+	fs       *filesystem
+	interval time.Duration
+
+	// materialized is the set of the mountpoints of the materialized layers which are
+	// still mounted. Only used by Run.
+	materialized map[string]struct{}
+}
+
+func newLayerMaterializer(fs *filesystem, cfg config.MaterializeConfig) *layerMaterializer {
+	return &layerMaterializer{
+		fs:           fs,
+		interval:     time.Duration(cfg.CheckIntervalSec) * time.Second,
+		materialized: make(map[string]struct{}),
+	}
+}
+
+// Run materializes the complete layers every interval until ctx is done.
+func (m *layerMaterializer) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check(ctx)
+		}
+	}
+}
+
+func (m *layerMaterializer) check(ctx context.Context) {
+	m.fs.layerMu.Lock()
+	layers := maps.Clone(m.fs.layer)
+	m.fs.layerMu.Unlock()
+
+	for mountpoint := range m.materialized {
+		if _, ok := layers[mountpoint]; !ok {
+			// The snapshot was removed.
+			delete(m.materialized, mountpoint)
+		}
+	}
+
+	for mountpoint, l := range layers {
+		if _, ok := m.materialized[mountpoint]; ok || isIDMappedDir(filepath.Dir(mountpoint)) {
+			continue
+		}
+		dir := snapshot.MaterializedPath(mountpoint)
+		if _, err := os.Stat(dir); err == nil {
+			m.materialized[mountpoint] = struct{}{}
+			continue
+		}
+		if !l.Complete() {
+			continue
+		}
+		lCtx := log.WithLogger(ctx, log.G(ctx).WithField("mountpoint", mountpoint).WithField("layerDigest", l.Info().Digest))
+		if err := materializeLayer(lCtx, l, dir); err != nil {
+			log.G(lCtx).WithError(err).Warn("failed to materialize layer")
+			continue
+		}
+		log.G(lCtx).WithField("path", dir).Info("materialized layer")
+		m.materialized[mountpoint] = struct{}{}
+	}
+}
+
+// materializeLayer extracts the layer `l` from the span cache into the directory
+// `dir`, with whiteouts converted to the overlayfs format. The layer is extracted
+// into a temporary directory first, so that `dir` only exists once complete.
+func materializeLayer(ctx context.Context, l layer.Layer, dir string) error {
+	start := time.Now()
+	tmp := dir + ".tmp"
+	// Remove the leftovers of an extraction interrupted, e.g., by a restart.
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		return err
+	}
+	r := l.UncompressedReader()
+	defer r.Close()
+	if _, err := archive.Apply(ctx, tmp, r, archive.WithConvertWhiteout(archive.OverlayConvertWhiteout)); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("failed to extract layer: %w", err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	dgst := l.Info().Digest
+	commonmetrics.MeasureLatencyInMilliseconds(commonmetrics.LayerMaterialize, dgst, start)
+	commonmetrics.IncOperationCount(commonmetrics.LayerMaterializeCount, dgst)
+	return nil
+}
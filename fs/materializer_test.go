@@ -0,0 +1,117 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/config"
+	"github.com/awslabs/soci-snapshotter/fs/layer"
+	"github.com/awslabs/soci-snapshotter/snapshot"
+	"github.com/awslabs/soci-snapshotter/util/testutil"
+)
+
+// cachedLayer is a layer whose uncompressed contents are cached once complete.
+type cachedLayer struct {
+	breakableLayer
+	complete bool
+	tar      []testutil.TarEntry
+}
+
+func (l *cachedLayer) Complete() bool { return l.complete }
+func (l *cachedLayer) UncompressedReader() io.ReadCloser {
+	return io.NopCloser(testutil.BuildTar(l.tar))
+}
+
+func TestLayerMaterializer(t *testing.T) {
+	ctx := context.Background()
+	mountpoint := filepath.Join(t.TempDir(), "snapshots", "1", "fs")
+	if err := os.MkdirAll(mountpoint, 0755); err != nil {
+		t.Fatal(err)
+	}
+	l := &cachedLayer{
+		tar: []testutil.TarEntry{
+			testutil.Dir("etc/"),
+			testutil.File("etc/hostname", "soci"),
+			testutil.Symlink("hostname", "etc/hostname"),
+		},
+	}
+	fs := &filesystem{
+		layer: map[string]layer.Layer{mountpoint: l},
+	}
+	m := newLayerMaterializer(fs, config.MaterializeConfig{CheckIntervalSec: 10})
+	dir := snapshot.MaterializedPath(mountpoint)
+
+	m.check(ctx)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected an incomplete layer not to be materialized, got %v", err)
+	}
+
+	l.complete = true
+	m.check(ctx)
+	b, err := os.ReadFile(filepath.Join(dir, "etc", "hostname"))
+	if err != nil || string(b) != "soci" {
+		t.Fatalf("unexpected contents of materialized file: %q, %v", b, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "hostname")); err != nil || target != "etc/hostname" {
+		t.Fatalf("unexpected materialized symlink: %q, %v", target, err)
+	}
+	if _, err := os.Stat(dir + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected the temporary directory to be removed, got %v", err)
+	}
+	if _, ok := m.materialized[mountpoint]; !ok {
+		t.Fatalf("expected the layer to be tracked as materialized")
+	}
+
+	// The FUSE mount may still be used by mounts returned before the layer was
+	// materialized, so it is kept until the snapshot is removed.
+	m.check(ctx)
+	if fs.layer[mountpoint] == nil {
+		t.Fatalf("expected the FUSE mount of the materialized layer to be kept")
+	}
+
+	// Once the snapshot is removed, the layer isn't tracked anymore.
+	delete(fs.layer, mountpoint)
+	m.check(ctx)
+	if len(m.materialized) != 0 {
+		t.Fatalf("expected the removed layer not to be tracked anymore")
+	}
+}
+
+func TestMaterializeLayerFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "materialized")
+	l := &cachedLayer{
+		complete: true,
+		tar:      []testutil.TarEntry{testutil.File("file", "contents")},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	if err := materializeLayer(ctx, l, dir); err == nil {
+		t.Fatalf("expected the extraction to fail once the context is done")
+	}
+	for _, p := range []string{dir, dir + ".tmp"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("expected %s not to exist after a failed extraction, got %v", p, err)
+		}
+	}
+}
@@ -58,6 +58,9 @@ const (
 	// ImageOperationCountKey is the key for any metric related to operation count metric at the image level (as opposed to layer).
 	ImageOperationCountKey = "image_operation_count_key"
 
+	// FetchQueueDepthKey is the key for the number of span fetches queued by the fetch scheduler.
+	FetchQueueDepthKey = "fetch_queue_depth"
+
 	// Keep namespace as soci and subsystem as fs.
 	namespace = "soci"
 	subsystem = "fs"
@@ -141,6 +144,40 @@ const (
 	// Number of entries evicted from the background fetcher work queue because it was full
 	BackgroundFetchWorkQueueEvicted = "background_fetch_work_queue_evicted"
 
+	// ReadAheadHitCount counts sequential reads of a file handle entering a span
+	// which was already resolved ahead by the read-ahead of the file handle.
+	ReadAheadHitCount = "read_ahead_hit_count"
+	// ReadAheadMissCount counts sequential reads of a file handle entering a span
+	// which was not resolved ahead, because the read-ahead window was too small.
+	ReadAheadMissCount = "read_ahead_miss_count"
+
+	// LocalBlobFetchCount counts the reads of layer blobs served from a local content
+	// store, and RemoteBlobFetchCount the requests for layer blobs sent to a registry.
+	LocalBlobFetchCount  = "local_blob_fetch_count"
+	RemoteBlobFetchCount = "remote_blob_fetch_count"
+
+	// SpanCacheUncompressedBytes counts the bytes of the spans added to a compressed
+	// span cache, and SpanCacheStoredBytes the bytes they are stored with. Their
+	// ratio is the compression ratio of the span cache.
+	SpanCacheUncompressedBytes = "span_cache_uncompressed_bytes"
+	SpanCacheStoredBytes       = "span_cache_stored_bytes"
+	// SpanCacheDecode measures the time to decompress a block of a span read
+	// from a compressed span cache.
+	SpanCacheDecode = "span_cache_decode"
+
+	// LayerMaterialize measures the time to extract a fully fetched layer into a
+	// regular directory, and LayerMaterializeCount counts the layers extracted.
+	LayerMaterialize      = "layer_materialize"
+	LayerMaterializeCount = "layer_materialize_count"
+
+	// FusePassthroughOpenCount counts the opens of fully cached files which are
+	// offered to the kernel with a backing file for FUSE passthrough.
+	FusePassthroughOpenCount = "fuse_passthrough_open_count"
+
+	// FileCacheHitCount counts the reads of files served from the file cache,
+	// without reading their spans.
+	FileCacheHitCount = "file_cache_hit_count"
+
 	// ResolveCacheHit counts layer resolves served from the resolver LRU cache
 	// (e.g. a layer that was pre-resolved and is still cached when containerd
 	// mounts it). A high hit ratio means pre-resolution is landing.
@@ -230,6 +267,16 @@ var (
 			Help:      "The count of soci snapshotter operations. Broken down by operation type and image digest.",
 		},
 		[]string{"operation_type", "image"})
+
+	// fetchQueueDepth reflects the number of span fetches waiting for a registry slot per priority class.
+	fetchQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      FetchQueueDepthKey,
+			Help:      "The number of span fetches waiting for a registry connection. Broken down by priority class.",
+		},
+		[]string{"priority"})
 )
 
 var register sync.Once
@@ -256,6 +303,7 @@ func Register() {
 		prometheus.MustRegister(operationCount)
 		prometheus.MustRegister(bytesCount)
 		prometheus.MustRegister(imageOperationCount)
+		prometheus.MustRegister(fetchQueueDepth)
 	})
 }
 
@@ -309,6 +357,11 @@ func AddImageOperationCount(operation string, image digest.Digest, count int32)
 	imageOperationCount.WithLabelValues(operation, image.String()).Add(float64(count))
 }
 
+// SetFetchQueueDepth wraps the labels attachment as well as calling Set into a single method.
+func SetFetchQueueDepth(priority string, depth int) {
+	fetchQueueDepth.WithLabelValues(priority).Set(float64(depth))
+}
+
 // ListenForFuseFailure infinitely listens for any FUSE failure.
 // If one occurs, it increments the `FuseFailureState` metric and
 // sleeps for a time block. This should be run at an FS level
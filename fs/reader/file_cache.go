@@ -0,0 +1,66 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package reader
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/awslabs/soci-snapshotter/util/lrucache"
+)
+
+// fileCache caches the contents of the small files of a layer which were read
+// completely, keyed by their metadata file ID. Cached files are read without their
+// spans, which can be evicted from the span cache meanwhile.
+type fileCache struct {
+	cache       *lrucache.Cache
+	maxFileSize int64
+}
+
+func newFileCache(maxBytes, maxFileSize int64) *fileCache {
+	return &fileCache{
+		cache: lrucache.NewWithMaxSize(maxBytes, func(value interface{}) int64 {
+			return int64(len(value.([]byte)))
+		}),
+		maxFileSize: maxFileSize,
+	}
+}
+
+// get returns the contents of the file `id` if they're cached. The caller must call
+// `done` once it doesn't use the contents anymore.
+func (fc *fileCache) get(id uint32) (data []byte, done func(), ok bool) {
+	if fc == nil {
+		return nil, nil, false
+	}
+	v, done, ok := fc.cache.Get(strconv.FormatUint(uint64(id), 10))
+	if !ok {
+		return nil, nil, false
+	}
+	return v.([]byte), done, true
+}
+
+func (fc *fileCache) add(id uint32, data []byte) {
+	_, done, _ := fc.cache.Add(strconv.FormatUint(uint64(id), 10), data)
+	done()
+}
+
+// wholeRead accumulates the contents of a file handle read sequentially from the
+// start of the file, until the file is read completely.
+type wholeRead struct {
+	mu  sync.Mutex
+	buf []byte
+}
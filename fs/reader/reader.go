@@ -40,9 +40,13 @@ package reader
 
 import (
 	"archive/tar"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -54,7 +58,9 @@ import (
 	"github.com/awslabs/soci-snapshotter/util/ioutils"
 	"github.com/awslabs/soci-snapshotter/ztoc"
 	"github.com/awslabs/soci-snapshotter/ztoc/compression"
+	"github.com/containerd/log"
 	digest "github.com/opencontainers/go-digest"
+	"golang.org/x/sync/semaphore"
 )
 
 type Reader interface {
@@ -62,16 +68,98 @@ type Reader interface {
 	Metadata() metadata.Reader
 	Close() error
 	LastOnDemandReadTime() time.Time
+	// FileCacheSize returns the size of the file contents in the file cache.
+	FileCacheSize() int64
+}
+
+// ErrNotCached is returned by BackingFile if some spans of the file aren't cached.
+var ErrNotCached = errors.New("file is not fully cached")
+
+// ErrTooLarge is returned by BackingFile if the file is larger than the maximum
+// size of the backing files.
+var ErrTooLarge = errors.New("file is too large for a backing file")
+
+// BackingFiler is implemented by the files opened by a Reader with a backing store.
+type BackingFiler interface {
+	// BackingFile returns a read-only regular file with the contents of the file.
+	// The contents are written to the backing store the first time, which requires
+	// every span of the file to be cached. It returns ErrNotCached otherwise, and
+	// ErrTooLarge if the file is larger than the maximum size of the backing files.
+	BackingFile() (*BackingFile, error)
+}
+
+// BackingFile is a file of the backing store. It isn't evicted from the backing
+// store until it's closed.
+type BackingFile struct {
+	*os.File
+	release func()
+}
+
+// Close closes the file.
+func (f *BackingFile) Close() error {
+	err := f.File.Close()
+	f.release()
+	return err
+}
+
+// maxReadAheads is the maximum number of read-aheads running at once for a Reader.
+// The sequential reads of the files don't read ahead while the limit is reached.
+const maxReadAheads = 16
+
+// backingFileChunkSize is the size of the contents read from the span manager at
+// once when writing a backing file.
+const backingFileChunkSize = 1 << 20
+
+// Option configures a Reader.
+type Option func(*reader)
+
+// WithMaxReadAheadSpans enables the read-ahead of up to `maxSpans` spans after the
+// sequential reads of a file handle.
+func WithMaxReadAheadSpans(maxSpans int) Option {
+	return func(gr *reader) {
+		gr.maxReadAheadSpans = maxSpans
+	}
+}
+
+// WithFileCache caches the contents of the files of up to `maxFileSize` bytes once
+// they're read completely, up to `maxBytes` bytes in total for the Reader.
+func WithFileCache(maxBytes, maxFileSize int64) Option {
+	return func(gr *reader) {
+		if maxBytes > 0 && maxFileSize > 0 {
+			gr.fileCache = newFileCache(maxBytes, maxFileSize)
+		}
+	}
+}
+
+// WithBackingStore makes the files opened by the Reader implement BackingFiler, with
+// the backing files of the files of up to `maxFileSize` bytes written to the directory
+// `dir`. The directory is removed when the Reader is closed. If `quota` is not nil,
+// the backing files are counted in it and the least recently used ones are evicted
+// once they're closed.
+func WithBackingStore(dir string, maxFileSize int64, quota *cache.Quota) Option {
+	return func(gr *reader) {
+		gr.backingDir = dir
+		gr.backingMaxFileSize = maxFileSize
+		gr.backingQuota = quota
+	}
 }
 
 // NewReader creates a Reader based on the given soci blob and Span Manager.
-func NewReader(r metadata.Reader, layerSha digest.Digest, spanManager *spanmanager.SpanManager, disableVerification bool) (Reader, error) {
-	return &reader{
+func NewReader(r metadata.Reader, layerSha digest.Digest, spanManager *spanmanager.SpanManager, disableVerification bool, opts ...Option) (Reader, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	gr := &reader{
 		spanManager:         spanManager,
 		r:                   r,
 		layerSha:            layerSha,
+		ctx:                 ctx,
+		cancel:              cancel,
+		readAheadSlots:      semaphore.NewWeighted(maxReadAheads),
 		disableVerification: disableVerification,
-	}, nil
+	}
+	for _, o := range opts {
+		o(gr)
+	}
+	return gr, nil
 }
 
 type reader struct {
@@ -85,7 +173,29 @@ type reader struct {
 	closed   bool
 	closedMu sync.Mutex
 
+	// ctx is cancelled when the reader is closed, which stops the running read-aheads.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// readAheads tracks the running read-aheads, which must end before the span manager is closed.
+	readAheads sync.WaitGroup
+	// readAheadSlots limits the number of running read-aheads to maxReadAheads.
+	readAheadSlots *semaphore.Weighted
+
 	disableVerification bool
+
+	// maxReadAheadSpans is the maximum number of spans resolved ahead of the
+	// sequential reads of a file handle. 0 disables read-ahead.
+	maxReadAheadSpans int
+
+	// fileCache caches the contents of the small files read completely. nil if
+	// the files aren't cached.
+	fileCache *fileCache
+
+	// backingDir is the directory of the backing files of the fully cached files.
+	// Empty if the files have no backing store.
+	backingDir         string
+	backingMaxFileSize int64
+	backingQuota       *cache.Quota
 }
 
 func (gr *reader) Metadata() metadata.Reader {
@@ -98,6 +208,13 @@ func (gr *reader) setLastReadTime(lastReadTime time.Time) {
 	gr.lastReadTimeMu.Unlock()
 }
 
+func (gr *reader) FileCacheSize() int64 {
+	if gr.fileCache == nil {
+		return 0
+	}
+	return gr.fileCache.cache.Size()
+}
+
 func (gr *reader) LastOnDemandReadTime() time.Time {
 	gr.lastReadTimeMu.Lock()
 	t := gr.lastReadTime
@@ -114,29 +231,63 @@ func (gr *reader) OpenFile(id uint32) (io.ReaderAt, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file %d: %w", id, err)
 	}
-	return &file{
+	f := &file{
 		id: id,
 		fr: fr,
 		gr: gr,
-	}, nil
+	}
+	if gr.backingDir != "" {
+		return &backedFile{f}, nil
+	}
+	return f, nil
 }
 
 func (gr *reader) Close() (retErr error) {
 	gr.closedMu.Lock()
-	defer gr.closedMu.Unlock()
 	if gr.closed {
+		gr.closedMu.Unlock()
 		return nil
 	}
 	gr.closed = true
+	gr.closedMu.Unlock()
+	// No read-ahead starts once the reader is closed.
+	gr.cancel()
+	gr.readAheads.Wait()
 	if gr.spanManager != nil {
 		gr.spanManager.Close()
 	}
 	if err := gr.r.Close(); err != nil {
 		retErr = errors.Join(retErr, err)
 	}
+	if gr.backingDir != "" {
+		if gr.backingQuota != nil {
+			gr.backingQuota.RemoveAll(gr.backingDir)
+		}
+		// Backing files still open, e.g. by the kernel, remain readable.
+		if err := os.RemoveAll(gr.backingDir); err != nil {
+			retErr = errors.Join(retErr, err)
+		}
+	}
 	return
 }
 
+// openBackingFile opens the backing file at `path`, which isn't evicted until it's closed.
+func (gr *reader) openBackingFile(path string) (*BackingFile, error) {
+	release := func() {}
+	if gr.backingQuota != nil {
+		if !gr.backingQuota.Acquire(path) {
+			return nil, os.ErrNotExist
+		}
+		release = sync.OnceFunc(func() { gr.backingQuota.Release(path) })
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &BackingFile{File: f, release: release}, nil
+}
+
 func (gr *reader) isClosed() bool {
 	gr.closedMu.Lock()
 	closed := gr.closed
@@ -150,10 +301,38 @@ type file struct {
 	gr       *reader
 	verified atomic.Bool
 	lock     sync.Mutex
+	ra       readAhead
+	whole    wholeRead
+}
+
+// readAhead is the state of the read-ahead of a file handle.
+type readAhead struct {
+	mu sync.Mutex
+	// nextOffset is the file offset following the last read.
+	nextOffset int64
+	// lastSpan is the last span of the last read.
+	lastSpan compression.SpanID
+	// window is the number of spans resolved ahead of the last read. It is 0
+	// until the reads of the file handle are sequential.
+	window int
+	// resolvedEnd is the last span resolved ahead, if window > 0.
+	resolvedEnd compression.SpanID
 }
 
 // ReadAt reads the file when the file is requested by the container
 func (sf *file) ReadAt(p []byte, offset int64) (int, error) {
+	// The cached contents were read by a verified file.
+	if data, done, ok := sf.gr.fileCache.get(sf.id); ok {
+		defer done()
+		if offset >= int64(len(data)) {
+			return 0, io.EOF
+		}
+		n := copy(p, data[offset:])
+		sf.gr.setLastReadTime(time.Now())
+		commonmetrics.IncOperationCount(commonmetrics.FileCacheHitCount, sf.gr.layerSha)
+		commonmetrics.AddBytesCount(commonmetrics.SynchronousBytesServed, sf.gr.layerSha, int64(n))
+		return n, nil
+	}
 	if !sf.gr.disableVerification {
 		if err := sf.Verify(); err != nil {
 			return 0, err
@@ -172,6 +351,7 @@ func (sf *file) ReadAt(p []byte, offset int64) (int, error) {
 	}
 	fileOffsetStart := sf.fr.GetUncompressedOffset() + compression.Offset(offset)
 	fileOffsetEnd := fileOffsetStart + expectedSize
+	sf.readAhead(offset, fileOffsetStart, fileOffsetEnd)
 	r, err := sf.gr.spanManager.GetContents(fileOffsetStart, fileOffsetEnd)
 	if err != nil {
 		return 0, fmt.Errorf("failed to read the file: %w", err)
@@ -187,9 +367,106 @@ func (sf *file) ReadAt(p []byte, offset int64) (int, error) {
 
 	commonmetrics.AddBytesCount(commonmetrics.SynchronousBytesServed, sf.gr.layerSha, int64(n)) // measure the number of bytes served synchronously
 
+	sf.cacheContents(offset, p[:n])
 	return n, nil
 }
 
+// cacheContents accumulates the reads of the file handle while they are sequential from
+// the start of the file, and adds the contents of the file to the file cache once it's
+// read completely. Only files of up to the max file size of the cache are cached.
+func (sf *file) cacheContents(offset int64, data []byte) {
+	fc := sf.gr.fileCache
+	size := int64(sf.fr.GetUncompressedFileSize())
+	if fc == nil || size == 0 || size > fc.maxFileSize {
+		return
+	}
+	w := &sf.whole
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case offset == 0:
+		w.buf = make([]byte, 0, size)
+	case w.buf == nil || offset != int64(len(w.buf)):
+		return
+	}
+	w.buf = append(w.buf, data...)
+	if int64(len(w.buf)) == size {
+		fc.add(sf.id, w.buf)
+		w.buf = nil
+	}
+}
+
+// readAhead detects sequential reads of the file handle, and resolves the spans
+// following a sequential read asynchronously, before they are read. The read-ahead
+// window starts with one span and doubles every time a sequential read enters a new
+// span, up to maxReadAheadSpans spans. A non-sequential read resets the window.
+//
+// `offset` is the offset of the read in the file, and `start` and `end` are the
+// uncompressed offsets of the read in the layer.
+func (sf *file) readAhead(offset int64, start, end compression.Offset) {
+	if sf.gr.maxReadAheadSpans <= 0 {
+		return
+	}
+	sm := sf.gr.spanManager
+	lastSpan := sm.SpanIDAt(end - 1)
+
+	ra := &sf.ra
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	sequential := offset > 0 && offset == ra.nextOffset
+	newSpan := lastSpan > ra.lastSpan
+	ra.nextOffset = offset + int64(end-start)
+	ra.lastSpan = lastSpan
+	if !sequential {
+		ra.window = 0
+		return
+	}
+	if ra.window > 0 {
+		if !newSpan {
+			return
+		}
+		if lastSpan <= ra.resolvedEnd {
+			commonmetrics.IncOperationCount(commonmetrics.ReadAheadHitCount, sf.gr.layerSha)
+		} else {
+			commonmetrics.IncOperationCount(commonmetrics.ReadAheadMissCount, sf.gr.layerSha)
+		}
+		ra.window = min(ra.window*2, sf.gr.maxReadAheadSpans)
+	} else {
+		ra.window = 1
+		ra.resolvedEnd = lastSpan
+	}
+
+	fileLastSpan := sm.SpanIDAt(sf.fr.GetUncompressedOffset() + sf.fr.GetUncompressedFileSize() - 1)
+	first := max(lastSpan, ra.resolvedEnd) + 1
+	last := min(lastSpan+compression.SpanID(ra.window), fileLastSpan)
+	if first > last {
+		return
+	}
+	// The spans are resolved by a later read-ahead if the reader runs too many already.
+	if !sf.gr.readAheadSlots.TryAcquire(1) {
+		return
+	}
+	sf.gr.closedMu.Lock()
+	defer sf.gr.closedMu.Unlock()
+	if sf.gr.closed {
+		sf.gr.readAheadSlots.Release(1)
+		return
+	}
+	ra.resolvedEnd = last
+	spanIDs := make([]compression.SpanID, 0, last-first+1)
+	for spanID := first; spanID <= last; spanID++ {
+		spanIDs = append(spanIDs, spanID)
+	}
+	sf.gr.readAheads.Add(1)
+	go func() {
+		defer sf.gr.readAheads.Done()
+		defer sf.gr.readAheadSlots.Release(1)
+		if err := sm.ResolveSpans(sf.gr.ctx, spanIDs); err != nil && sf.gr.ctx.Err() == nil {
+			log.L.WithError(err).WithField("layer_sha", sf.gr.layerSha).Debug("failed to read ahead spans")
+		}
+	}()
+}
+
 // Verify verifies that the file's attributes match the tar header in the image layer
 func (sf *file) Verify() (retErr error) {
 	if sf.verified.Load() {
@@ -240,6 +517,73 @@ func (sf *file) Verify() (retErr error) {
 	return nil
 }
 
+// backedFile is a file of a Reader with a backing store.
+type backedFile struct {
+	*file
+}
+
+var _ BackingFiler = (*backedFile)(nil)
+
+func (bf *backedFile) BackingFile() (*BackingFile, error) {
+	sf := bf.file
+	if sf.gr.isClosed() {
+		return nil, fmt.Errorf("reader is already closed")
+	}
+	// The backing file is written while the file is opened, so the size of
+	// the files is limited to bound the time it takes.
+	size := sf.fr.GetUncompressedFileSize()
+	if int64(size) > sf.gr.backingMaxFileSize {
+		return nil, ErrTooLarge
+	}
+	if !sf.gr.disableVerification {
+		if err := sf.Verify(); err != nil {
+			return nil, err
+		}
+	}
+	start := sf.fr.GetUncompressedOffset()
+	end := start + size
+	// Files are identified by their offset in the layer, which hard links share.
+	path := filepath.Join(sf.gr.backingDir, strconv.FormatInt(int64(start), 10))
+	if f, err := sf.gr.openBackingFile(path); err == nil {
+		return f, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if !sf.gr.spanManager.Cached(start, end) {
+		return nil, ErrNotCached
+	}
+
+	// Concurrent opens may write the same file, so it's renamed once complete.
+	tmp, err := os.CreateTemp(sf.gr.backingDir, "tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	for off := start; off < end; off += backingFileChunkSize {
+		r, err := sf.gr.spanManager.GetContents(off, min(off+backingFileChunkSize, end))
+		if err != nil {
+			tmp.Close()
+			return nil, fmt.Errorf("failed to read the file: %w", err)
+		}
+		_, err = io.Copy(tmp, r)
+		r.Close()
+		if err != nil {
+			tmp.Close()
+			return nil, fmt.Errorf("failed to write backing file: %w", err)
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	if sf.gr.backingQuota != nil {
+		sf.gr.backingQuota.Add(path, int64(size))
+	}
+	return sf.gr.openBackingFile(path)
+}
+
 func attrMatchesTarHeader(attr metadata.Attr, tarh *tar.Header) bool {
 	// specifically, we don't look at attr.NumLink because it doesn't exist in a tar header
 	if attr.Size != tarh.Size ||
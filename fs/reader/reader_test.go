@@ -44,10 +44,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/awslabs/soci-snapshotter/cache"
+	commonmetrics "github.com/awslabs/soci-snapshotter/fs/metrics/common"
 	spanmanager "github.com/awslabs/soci-snapshotter/fs/span-manager"
 	"github.com/awslabs/soci-snapshotter/metadata"
 	"github.com/awslabs/soci-snapshotter/util/testutil"
@@ -150,7 +154,7 @@ func testFileReadAt(t *testing.T, factory metadata.Store) {
 	}
 }
 
-func makeFile(t *testing.T, contents []byte, prefix string, factory metadata.Store, spanSize int64) (*file, func() error) {
+func makeFile(t testing.TB, contents []byte, prefix string, factory metadata.Store, spanSize int64, opts ...Option) (*file, func() error) {
 	testName := "test"
 	tarEntry := []testutil.TarEntry{
 		testutil.File(testName, string(contents)),
@@ -166,7 +170,7 @@ func makeFile(t *testing.T, contents []byte, prefix string, factory metadata.Sto
 	}
 	spanManager, err := spanmanager.New(ztoc, sr, cache.NewMemoryCache(), 0, digest.FromString(""))
 	assert.Nil(t, err)
-	r, err := NewReader(mr, digest.FromString(""), spanManager, false)
+	r, err := NewReader(mr, digest.FromString(t.Name()), spanManager, false, opts...)
 	if err != nil {
 		mr.Close()
 		t.Fatalf("failed to make new reader: %v", err)
@@ -181,6 +185,9 @@ func makeFile(t *testing.T, contents []byte, prefix string, factory metadata.Sto
 		r.Close()
 		t.Fatalf("Failed to open testing file: %v", err)
 	}
+	if bf, ok := ra.(*backedFile); ok {
+		ra = bf.file
+	}
 	f, ok := ra.(*file)
 	if !ok {
 		r.Close()
@@ -253,3 +260,309 @@ func testFailReader(t *testing.T, factory metadata.Store) {
 		})
 	}
 }
+
+func TestReadAhead(t *testing.T) {
+	const (
+		spanSize  = 1 << 16
+		chunkSize = 1 << 14
+	)
+	contents := testutil.NewTestRand(t).RandomByteData(spanSize * 16)
+
+	testCases := []struct {
+		name    string
+		opts    []Option
+		reverse bool
+		// runningReadAheads is the number of read-aheads running during the reads.
+		runningReadAheads int64
+		expectedHits      bool
+		expectedMisses    bool
+	}{
+		{
+			name:         "sequential reads",
+			opts:         []Option{WithMaxReadAheadSpans(4)},
+			expectedHits: true,
+		},
+		{
+			name:    "reverse reads",
+			opts:    []Option{WithMaxReadAheadSpans(4)},
+			reverse: true,
+		},
+		{
+			name:              "too many running read-aheads",
+			opts:              []Option{WithMaxReadAheadSpans(4)},
+			runningReadAheads: maxReadAheads,
+			expectedMisses:    true,
+		},
+		{
+			name: "read-ahead disabled",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, closeFn := makeFile(t, contents, "", metadata.NewTempDbStore, spanSize, tc.opts...)
+			defer closeFn()
+			if !f.gr.readAheadSlots.TryAcquire(tc.runningReadAheads) {
+				t.Fatal("failed to acquire read-ahead slots")
+			}
+
+			offsets := make([]int64, 0, len(contents)/chunkSize)
+			for offset := int64(0); offset < int64(len(contents)); offset += chunkSize {
+				offsets = append(offsets, offset)
+			}
+			if tc.reverse {
+				slices.Reverse(offsets)
+			}
+			read := make([]byte, len(contents))
+			for _, offset := range offsets {
+				if _, err := f.ReadAt(read[offset:offset+chunkSize], offset); err != nil && !errors.Is(err, io.EOF) {
+					t.Fatalf("failed to read at %d: %v", offset, err)
+				}
+			}
+			if !bytes.Equal(contents, read) {
+				t.Fatal("read contents differ from the file contents")
+			}
+
+			hits := commonmetrics.GetOperationCount(commonmetrics.ReadAheadHitCount, f.gr.layerSha)
+			misses := commonmetrics.GetOperationCount(commonmetrics.ReadAheadMissCount, f.gr.layerSha)
+			if tc.expectedHits != (hits > 0) {
+				t.Fatalf("expected read-ahead hits: %v, got %v hits", tc.expectedHits, hits)
+			}
+			if tc.expectedMisses != (misses > 0) {
+				t.Fatalf("expected read-ahead misses: %v, got %v misses", tc.expectedMisses, misses)
+			}
+		})
+	}
+}
+
+func TestBackingFile(t *testing.T) {
+	const spanSize = 1 << 16
+	contents := testutil.NewTestRand(t).RandomByteData(spanSize * 4)
+	dir := t.TempDir()
+	quota, err := cache.NewQuota(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("failed to create quota: %v", err)
+	}
+	f, closeFn := makeFile(t, contents, "", metadata.NewTempDbStore, spanSize, WithBackingStore(dir, int64(len(contents)), quota))
+	bf := &backedFile{f}
+
+	if _, err := bf.BackingFile(); !errors.Is(err, ErrNotCached) {
+		closeFn()
+		t.Fatalf("expected ErrNotCached before the file is read, got %v", err)
+	}
+	// Reading the file caches all its spans.
+	if _, err := f.ReadAt(make([]byte, len(contents)), 0); err != nil {
+		closeFn()
+		t.Fatalf("failed to read file: %v", err)
+	}
+	// The backing file is written the first time, then reused.
+	for i := 0; i < 2; i++ {
+		backing, err := bf.BackingFile()
+		if err != nil {
+			closeFn()
+			t.Fatalf("failed to get backing file: %v", err)
+		}
+		got, err := io.ReadAll(backing)
+		backing.Close()
+		if err != nil {
+			closeFn()
+			t.Fatalf("failed to read backing file: %v", err)
+		}
+		if !bytes.Equal(got, contents) {
+			closeFn()
+			t.Fatalf("unexpected contents of backing file: got %d bytes, want %d bytes", len(got), len(contents))
+		}
+	}
+	if quota.Size() != int64(len(contents)) {
+		closeFn()
+		t.Fatalf("expected the backing file to be counted in the quota, got %d bytes", quota.Size())
+	}
+
+	closeFn()
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the backing store to be removed with the reader, got %v", err)
+	}
+	if quota.Size() != 0 {
+		t.Fatalf("expected the backing store to be removed from the quota, got %d bytes", quota.Size())
+	}
+}
+
+func TestBackingFileTooLarge(t *testing.T) {
+	const spanSize = 1 << 16
+	contents := testutil.NewTestRand(t).RandomByteData(spanSize * 4)
+	dir := t.TempDir()
+	f, closeFn := makeFile(t, contents, "", metadata.NewTempDbStore, spanSize, WithBackingStore(dir, int64(len(contents))-1, nil))
+	defer closeFn()
+	if _, err := f.ReadAt(make([]byte, len(contents)), 0); err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if _, err := (&backedFile{f}).BackingFile(); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read backing store: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no backing file to be written, got %d", len(entries))
+	}
+}
+
+func TestBackingFileEviction(t *testing.T) {
+	const spanSize = 1 << 16
+	contents := testutil.NewTestRand(t).RandomByteData(spanSize * 4)
+	quotaDir := t.TempDir()
+	quota, err := cache.NewQuota(quotaDir, int64(len(contents)))
+	if err != nil {
+		t.Fatalf("failed to create quota: %v", err)
+	}
+	f, closeFn := makeFile(t, contents, "", metadata.NewTempDbStore, spanSize, WithBackingStore(t.TempDir(), int64(len(contents)), quota))
+	defer closeFn()
+	if _, err := f.ReadAt(make([]byte, len(contents)), 0); err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	backing, err := (&backedFile{f}).BackingFile()
+	if err != nil {
+		t.Fatalf("failed to get backing file: %v", err)
+	}
+
+	// Another entry exceeds the quota, but the open backing file isn't evicted.
+	addEntry := func(name string) {
+		path := filepath.Join(quotaDir, name)
+		if err := os.WriteFile(path, []byte(name), 0600); err != nil {
+			t.Fatalf("failed to write entry: %v", err)
+		}
+		quota.Add(path, int64(len(name)))
+	}
+	addEntry("other")
+	if _, err := os.Stat(backing.Name()); err != nil {
+		t.Fatalf("expected the open backing file to be kept, got %v", err)
+	}
+	backing.Close()
+	addEntry("another")
+	if _, err := os.Stat(backing.Name()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the backing file to be evicted once closed, got %v", err)
+	}
+	if quota.Size() != int64(len("another")) {
+		t.Fatalf("unexpected quota size after eviction: %d", quota.Size())
+	}
+}
+
+// BenchmarkFileRead compares the throughput of the reads of a fully cached file served
+// through the span manager, as FUSE reads are without passthrough, with the reads of
+// its backing file, as the kernel reads it with FUSE passthrough.
+func BenchmarkFileRead(b *testing.B) {
+	const (
+		spanSize = 1 << 20
+		readSize = 128 << 10
+	)
+	contents := testutil.NewTestRand(b).RandomByteData(spanSize * 16)
+	f, closeFn := makeFile(b, contents, "", metadata.NewTempDbStore, spanSize, WithBackingStore(b.TempDir(), int64(len(contents)), nil))
+	defer closeFn()
+	if _, err := f.ReadAt(make([]byte, len(contents)), 0); err != nil {
+		b.Fatalf("failed to read file: %v", err)
+	}
+	backing, err := (&backedFile{f}).BackingFile()
+	if err != nil {
+		b.Fatalf("failed to get backing file: %v", err)
+	}
+	defer backing.Close()
+
+	readAll := func(b *testing.B, ra io.ReaderAt) {
+		buf := make([]byte, readSize)
+		b.SetBytes(int64(len(contents)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for off := int64(0); off < int64(len(contents)); off += readSize {
+				if _, err := ra.ReadAt(buf, off); err != nil && err != io.EOF {
+					b.Fatalf("failed to read at %d: %v", off, err)
+				}
+			}
+		}
+	}
+	b.Run("span_manager", func(b *testing.B) { readAll(b, f) })
+	b.Run("backing_file", func(b *testing.B) { readAll(b, backing) })
+}
+
+func TestFileCache(t *testing.T) {
+	const (
+		spanSize  = 1 << 10
+		chunkSize = 1 << 10
+	)
+	contents := testutil.NewTestRand(t).RandomByteData(chunkSize * 4)
+
+	testCases := []struct {
+		name        string
+		maxFileSize int64
+		offsets     []int64
+		cached      bool
+	}{
+		{
+			name:        "sequential reads",
+			maxFileSize: int64(len(contents)),
+			offsets:     []int64{0, chunkSize, chunkSize * 2, chunkSize * 3},
+			cached:      true,
+		},
+		{
+			name:        "restarted sequential reads",
+			maxFileSize: int64(len(contents)),
+			offsets:     []int64{0, chunkSize, 0, chunkSize, chunkSize * 2, chunkSize * 3},
+			cached:      true,
+		},
+		{
+			name:        "non-sequential reads",
+			maxFileSize: int64(len(contents)),
+			offsets:     []int64{chunkSize, 0, chunkSize * 2, chunkSize * 3},
+		},
+		{
+			name:        "file larger than max file size",
+			maxFileSize: int64(len(contents)) - 1,
+			offsets:     []int64{0, chunkSize, chunkSize * 2, chunkSize * 3},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, closeFn := makeFile(t, contents, "", metadata.NewTempDbStore, spanSize, WithFileCache(1<<20, tc.maxFileSize))
+			defer closeFn()
+
+			buf := make([]byte, chunkSize)
+			for _, offset := range tc.offsets {
+				if _, _, ok := f.gr.fileCache.get(f.id); ok {
+					t.Fatalf("file must not be cached before it is read completely")
+				}
+				n, err := f.ReadAt(buf, offset)
+				if err != nil {
+					t.Fatalf("failed to read at %d: %v", offset, err)
+				}
+				if !bytes.Equal(buf[:n], contents[offset:offset+chunkSize]) {
+					t.Fatalf("unexpected contents at %d", offset)
+				}
+			}
+			data, done, ok := f.gr.fileCache.get(f.id)
+			if ok != tc.cached {
+				t.Fatalf("unexpected cached state: got %v, want %v", ok, tc.cached)
+			}
+			if !ok {
+				return
+			}
+			done()
+			if !bytes.Equal(data, contents) {
+				t.Fatalf("unexpected cached contents")
+			}
+
+			// Other file handles of the file are served from the cache.
+			other := &file{id: f.id, fr: f.fr, gr: f.gr}
+			got := make([]byte, len(contents)+1)
+			n, err := other.ReadAt(got[:10], 5)
+			if err != nil || !bytes.Equal(got[:n], contents[5:15]) {
+				t.Fatalf("unexpected cached read: n=%d, err=%v", n, err)
+			}
+			n, err = other.ReadAt(got, 0)
+			if err != nil || !bytes.Equal(got[:n], contents) {
+				t.Fatalf("unexpected cached read of the whole file: n=%d, err=%v", n, err)
+			}
+			if _, err := other.ReadAt(got, int64(len(contents))); !errors.Is(err, io.EOF) {
+				t.Fatalf("expected EOF at the end of the file, got %v", err)
+			}
+		})
+	}
+}
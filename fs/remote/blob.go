@@ -44,7 +44,7 @@ import (
 	"fmt"
 	"io"
 	"regexp"
-
+	"sort"
 	"sync"
 	"time"
 
@@ -60,6 +60,7 @@ type Blob interface {
 	Size() int64
 	FetchedSize() int64
 	ReadAt(p []byte, offset int64, opts ...Option) (int, error)
+	ReadAtMulti(ps [][]byte, offsets []int64, opts ...Option) error
 	Refresh(ctx context.Context, hosts []docker.RegistryHost, refspec reference.Spec, desc ocispec.Descriptor) error
 	Close() error
 }
@@ -213,6 +214,127 @@ func (b *blob) ReadAt(p []byte, offset int64, opts ...Option) (int, error) {
 	return len(p), nil
 }
 
+// ReadAtMulti fills each buffer of `ps` with the remote blob from the offset at the
+// same index of `offsets`. The regions are fetched with a single multi-range request,
+// unless the registry doesn't support multi-range requests, in which case each run
+// of contiguous regions is fetched with its own request, so that the gaps between
+// the regions are not fetched. Every region must be within the blob.
+func (b *blob) ReadAtMulti(ps [][]byte, offsets []int64, opts ...Option) error {
+	if b.isClosed() {
+		return fmt.Errorf("blob is already closed")
+	}
+	if len(ps) != len(offsets) {
+		return fmt.Errorf("got %d buffers for %d offsets", len(ps), len(offsets))
+	}
+
+	var reads []regionRead
+	for i, p := range ps {
+		if len(p) == 0 {
+			continue
+		}
+		reg := region{offsets[i], offsets[i] + int64(len(p)) - 1}
+		if reg.b < 0 || reg.e >= b.size {
+			return fmt.Errorf("region %v is out of the blob of size %d", reg, b.size)
+		}
+		reads = append(reads, regionRead{reg: reg, buf: p})
+	}
+	if len(reads) == 0 {
+		return nil
+	}
+	sort.Slice(reads, func(i, j int) bool { return reads[i].reg.b < reads[j].reg.b })
+
+	b.fetcherMu.Lock()
+	fr := b.fetcher
+	b.fetcherMu.Unlock()
+
+	if hf, ok := fr.(*httpFetcher); ok && !hf.isSingleRangeMode() {
+		return b.fetchRegions(fr, reads)
+	}
+	// Fetch each run of contiguous regions separately to avoid fetching the gaps
+	// between the regions.
+	start := 0
+	for i := 1; i <= len(reads); i++ {
+		if i < len(reads) && reads[i].reg.b <= reads[i-1].reg.e+1 {
+			continue
+		}
+		if err := b.fetchRegions(fr, reads[start:i]); err != nil {
+			return err
+		}
+		start = i
+	}
+	return nil
+}
+
+// regionRead is a region requested by ReadAtMulti and the buffer it is read into.
+type regionRead struct {
+	reg     region
+	buf     []byte
+	fetched int64
+}
+
+// fetchRegions fetches `reads` with a single request, and writes each part of
+// the response to the buffers of the regions it overlaps.
+func (b *blob) fetchRegions(fr fetcher, reads []regionRead) error {
+	fetchCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req := make([]region, len(reads))
+	for i, r := range reads {
+		req[i] = r.reg
+	}
+	mr, err := fr.fetch(fetchCtx, req, true)
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	// Update the check timer because we succeeded to access the blob
+	b.lastCheckMu.Lock()
+	b.lastCheck = time.Now()
+	b.lastCheckMu.Unlock()
+
+	for {
+		reg, p, err := mr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read multipart resp: %w", err)
+		}
+
+		// The part may contain several regions, e.g. when the registry merged adjacent
+		// ranges or returned the whole blob.
+		var (
+			ws  []io.Writer
+			end = int64(-1)
+		)
+		for i := range reads {
+			r := &reads[i]
+			if r.reg.e < reg.b || reg.e < r.reg.b {
+				continue
+			}
+			ws = append(ws, newBytesWriter(r.buf, r.reg.b-reg.b))
+			r.fetched += min(r.reg.e, reg.e) - max(r.reg.b, reg.b) + 1
+			end = max(end, min(r.reg.e, reg.e))
+		}
+		if len(ws) == 0 {
+			continue
+		}
+		if _, err := io.CopyN(io.MultiWriter(ws...), p, end-reg.b+1); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range reads {
+		if r.fetched < r.reg.size() {
+			return fmt.Errorf("failed to fetch region %v", r.reg)
+		}
+		b.fetchedRegionSetMu.Lock()
+		b.fetchedRegionSet.add(r.reg)
+		b.fetchedRegionSetMu.Unlock()
+	}
+	return nil
+}
+
 // fetchRegion fetches content from remote blob. It must be called from within fetchRange
 // and need to ensure that it is inside the singleflight `Do` operation.
 func (b *blob) fetchRegion(reg region, w io.Writer, fetched bool, opts *options) error {
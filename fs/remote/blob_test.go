@@ -142,6 +142,76 @@ func TestReadAt(t *testing.T) {
 	}
 }
 
+func TestReadAtMulti(t *testing.T) {
+	contents := []byte("0123456789abcdefghij")
+	offsets := []int64{12, 2, 4, 17}
+	sizes := []int64{3, 2, 3, 2}
+	tests := []struct {
+		name            string
+		allowMultiRange bool
+		singleRangeMode bool
+		roundTrips      int64
+	}{
+		{
+			name:            "multi_range",
+			allowMultiRange: true,
+			roundTrips:      1,
+		},
+		{
+			// The registry rejects the multi-range request, so the regions are fetched
+			// again with a single range.
+			name:       "multi_range_rejected",
+			roundTrips: 2,
+		},
+		{
+			// Each run of contiguous regions is fetched separately.
+			name:            "single_range_mode",
+			singleRangeMode: true,
+			roundTrips:      3,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []interface{}
+			opts = append(opts, allowMultiRange(tc.allowMultiRange))
+			if tc.singleRangeMode {
+				// The gaps between the regions must not be fetched.
+				opts = append(opts, exceptRegions{{7, 11}, {15, 16}})
+			}
+			tr := multiRoundTripper(t, contents, opts...)
+			var roundTrips atomic.Int64
+			b := makeTestBlob(t, int64(len(contents)), func(req *http.Request) *http.Response {
+				roundTrips.Add(1)
+				return tr(req)
+			})
+			if tc.singleRangeMode {
+				b.fetcher.(*httpFetcher).singleRangeMode()
+			}
+
+			ps := make([][]byte, len(offsets))
+			for i := range ps {
+				ps[i] = make([]byte, sizes[i])
+			}
+			if err := b.ReadAtMulti(ps, offsets); err != nil {
+				t.Fatalf("failed to read regions: %v", err)
+			}
+			for i, p := range ps {
+				if want := contents[offsets[i] : offsets[i]+sizes[i]]; !bytes.Equal(p, want) {
+					t.Errorf("region at %d: got %q, want %q", offsets[i], p, want)
+				}
+			}
+			if n := roundTrips.Load(); n != tc.roundTrips {
+				t.Errorf("expected %d round trips, got %d", tc.roundTrips, n)
+			}
+		})
+	}
+
+	b := makeTestBlob(t, int64(len(contents)), multiRoundTripper(t, contents))
+	if err := b.ReadAtMulti([][]byte{make([]byte, 2)}, []int64{19}); err == nil {
+		t.Error("must fail for a region out of the blob")
+	}
+}
+
 func checkRead(t *testing.T, wantData []byte, r *blob, offset int64, wantSize int64) {
 	respData := make([]byte, wantSize)
 	t.Logf("reading offset:%d, size:%d", offset, wantSize)
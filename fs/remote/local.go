@@ -0,0 +1,115 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	commonmetrics "github.com/awslabs/soci-snapshotter/fs/metrics/common"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// LocalStore is a store of the blobs present on the host, like the containerd content store.
+type LocalStore interface {
+	// ReaderAt returns a reader of the described blob, or an error if the store doesn't have it.
+	ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error)
+}
+
+// NewLocalStoreHandler returns a Handler which serves the layer blobs present in one of
+// `stores`, so that their spans are read from the host instead of the registry. Layers
+// which aren't present in any store are left to the other handlers.
+func NewLocalStoreHandler(stores ...LocalStore) Handler {
+	return &localStoreHandler{stores: stores}
+}
+
+type localStoreHandler struct {
+	stores []LocalStore
+}
+
+func (h *localStoreHandler) Handle(ctx context.Context, desc ocispec.Descriptor) (Fetcher, int64, error) {
+	// The blob is read later without the context of the mount, which carries the
+	// containerd namespace of the image.
+	ns, _ := namespaces.Namespace(ctx)
+	var errs error
+	for _, s := range h.stores {
+		ra, err := s.ReaderAt(ctx, desc)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		size := ra.Size()
+		ra.Close()
+		if desc.Size != 0 && size != desc.Size {
+			errs = errors.Join(errs, fmt.Errorf("unexpected size of local blob %s: got %d, want %d", desc.Digest, size, desc.Size))
+			continue
+		}
+		return &localFetcher{store: s, desc: desc, namespace: ns}, size, nil
+	}
+	return nil, 0, fmt.Errorf("layer blob %s is not present locally: %w", desc.Digest, errs)
+}
+
+// localFetcher reads a blob of a LocalStore.
+type localFetcher struct {
+	store     LocalStore
+	desc      ocispec.Descriptor
+	namespace string
+}
+
+func (f *localFetcher) context(ctx context.Context) context.Context {
+	if f.namespace == "" {
+		return ctx
+	}
+	return namespaces.WithNamespace(ctx, f.namespace)
+}
+
+func (f *localFetcher) Fetch(ctx context.Context, off int64, size int64) (io.ReadCloser, error) {
+	ra, err := f.store.ReaderAt(f.context(ctx), f.desc)
+	if err != nil {
+		return nil, err
+	}
+	commonmetrics.IncOperationCount(commonmetrics.LocalBlobFetchCount, f.desc.Digest)
+	return &readCloser{
+		Reader:    io.NewSectionReader(ra, off, size),
+		closeFunc: ra.Close,
+	}, nil
+}
+
+// Check fails once the blob is removed from the store, so that the blob is fetched
+// from the registry again.
+func (f *localFetcher) Check() error {
+	ra, err := f.store.ReaderAt(f.context(context.Background()), f.desc)
+	if err != nil {
+		return fmt.Errorf("local blob %s is not available: %w", f.desc.Digest, err)
+	}
+	return ra.Close()
+}
+
+func (f *localFetcher) GenID(off int64, size int64) string {
+	return fmt.Sprintf("local-%s-%d-%d", f.desc.Digest, off, size)
+}
+
+type readCloser struct {
+	io.Reader
+	closeFunc func() error
+}
+
+func (r *readCloser) Close() error { return r.closeFunc() }
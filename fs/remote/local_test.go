@@ -0,0 +1,115 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/config"
+	commonmetrics "github.com/awslabs/soci-snapshotter/fs/metrics/common"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/containerd/v2/pkg/reference"
+	"github.com/containerd/errdefs"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type memReaderAt struct {
+	*bytes.Reader
+}
+
+func (memReaderAt) Close() error { return nil }
+
+// memLocalStore is a LocalStore of blobs in memory, which are only visible from
+// the namespace they were added to.
+type memLocalStore struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func (s *memLocalStore) key(ctx context.Context, dgst digest.Digest) string {
+	ns, _ := namespaces.Namespace(ctx)
+	return ns + "/" + dgst.String()
+}
+
+func (s *memLocalStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.blobs[s.key(ctx, desc.Digest)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", desc.Digest, errdefs.ErrNotFound)
+	}
+	return memReaderAt{bytes.NewReader(b)}, nil
+}
+
+func TestLocalStoreHandler(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "test")
+	refspec, err := reference.Parse("registry.example.com/test/image:latest")
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	data := []byte("0123456789abcdef")
+	desc := ocispec.Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+	key := "test/" + desc.Digest.String()
+	store := &memLocalStore{blobs: map[string][]byte{key: data}}
+	r := NewResolver(config.BlobConfig{}, map[string]Handler{"local": NewLocalStoreHandler(store)})
+
+	// No registry host is given, so the blob can only be served by the local store.
+	b, err := r.Resolve(ctx, nil, refspec, desc)
+	if err != nil {
+		t.Fatalf("failed to resolve local blob: %v", err)
+	}
+	defer b.Close()
+	if b.Size() != desc.Size {
+		t.Fatalf("unexpected blob size: got %d, want %d", b.Size(), desc.Size)
+	}
+	before := commonmetrics.GetOperationCount(commonmetrics.LocalBlobFetchCount, desc.Digest)
+	p := make([]byte, 4)
+	if _, err := b.ReadAt(p, 10); err != nil || string(p) != "abcd" {
+		t.Fatalf("unexpected read of local blob: %q, %v", p, err)
+	}
+	if after := commonmetrics.GetOperationCount(commonmetrics.LocalBlobFetchCount, desc.Digest); after != before+1 {
+		t.Fatalf("expected a local fetch to be counted, got %v", after-before)
+	}
+	if err := b.Check(); err != nil {
+		t.Fatalf("unexpected check failure of local blob: %v", err)
+	}
+
+	// Once the blob is removed from the store, the check fails so that the blob is refreshed.
+	store.mu.Lock()
+	delete(store.blobs, key)
+	store.mu.Unlock()
+	if err := b.Check(); err == nil {
+		t.Fatalf("expected check to fail after the local blob was removed")
+	}
+
+	// A blob which isn't present locally is left to the registry.
+	if _, err := r.Resolve(ctx, nil, refspec, desc); err == nil {
+		t.Fatalf("expected missing local blob to be fetched from the registry")
+	}
+
+	// A local blob of an unexpected size isn't used.
+	store.blobs[key] = data[:8]
+	if _, err := r.Resolve(ctx, nil, refspec, desc); err == nil {
+		t.Fatalf("expected local blob of unexpected size not to be used")
+	}
+}
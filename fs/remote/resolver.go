@@ -315,6 +315,7 @@ func (f *httpFetcher) fetch(ctx context.Context, rs []region, retry bool) (multi
 	start := time.Now()
 	res, err := f.roundTripper.RoundTrip(req)
 	commonmetrics.MeasureLatencyInMilliseconds(commonmetrics.RemoteRegistryGet, f.digest, start)
+	commonmetrics.IncOperationCount(commonmetrics.RemoteBlobFetchCount, f.digest)
 	if err != nil {
 		return nil, err
 	}
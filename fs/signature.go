@@ -0,0 +1,63 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+)
+
+// verifyIndexSignature checks that the SOCI index `indexDigest` has a signature referrer
+// in the remote repository created by one of `keys`. It returns `soci.ErrNoSignature`
+// if the index is unsigned and `soci.ErrInvalidSignature` if no signature is valid.
+func verifyIndexSignature(ctx context.Context, indexDigest digest.Digest, remoteStore Inner, keys []*ecdsa.PublicKey) error {
+	var signatures []ocispec.Descriptor
+	err := remoteStore.Referrers(ctx, ocispec.Descriptor{Digest: indexDigest}, soci.SociSignatureArtifactType, func(referrers []ocispec.Descriptor) error {
+		signatures = append(signatures, referrers...)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot fetch list of signatures: %w", err)
+	}
+	if len(signatures) == 0 {
+		return soci.ErrNoSignature
+	}
+
+	var errs []error
+	for _, desc := range signatures {
+		b, err := content.FetchAll(ctx, remoteStore, desc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot fetch signature %s: %w", desc.Digest, err))
+			continue
+		}
+		err = soci.VerifySignatureManifest(b, indexDigest, keys)
+		if err == nil {
+			log.G(ctx).WithField("signature", desc.Digest).Debug("verified soci index signature")
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,120 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/soci"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content/memory"
+)
+
+// fakeSignatureRepository serves signature manifests and lists them as referrers.
+type fakeSignatureRepository struct {
+	*memory.Store
+	referrers []ocispec.Descriptor
+}
+
+func (f *fakeSignatureRepository) Referrers(ctx context.Context, desc ocispec.Descriptor, artifactType string, fn func(referrers []ocispec.Descriptor) error) error {
+	return fn(f.referrers)
+}
+
+func (f *fakeSignatureRepository) addSignature(t *testing.T, key *ecdsa.PrivateKey, indexDesc ocispec.Descriptor) {
+	manifest, err := soci.NewSignatureManifest(key, indexDesc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: soci.SociSignatureArtifactType,
+		Digest:       digest.FromBytes(b),
+		Size:         int64(len(b)),
+	}
+	if err := f.Push(context.Background(), desc, bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	f.referrers = append(f.referrers, desc)
+}
+
+func TestVerifyIndexSignature(t *testing.T) {
+	trustedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrustedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString("index"),
+		Size:      5,
+	}
+	keys := []*ecdsa.PublicKey{&trustedKey.PublicKey}
+
+	testCases := []struct {
+		name        string
+		signers     []*ecdsa.PrivateKey
+		expectedErr error
+	}{
+		{
+			name:        "unsigned index",
+			expectedErr: soci.ErrNoSignature,
+		},
+		{
+			name:        "index signed by untrusted key",
+			signers:     []*ecdsa.PrivateKey{untrustedKey},
+			expectedErr: soci.ErrInvalidSignature,
+		},
+		{
+			name:    "index signed by trusted key",
+			signers: []*ecdsa.PrivateKey{trustedKey},
+		},
+		{
+			name:    "index signed by untrusted and trusted keys",
+			signers: []*ecdsa.PrivateKey{untrustedKey, trustedKey},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &fakeSignatureRepository{Store: memory.New()}
+			for _, key := range tc.signers {
+				repo.addSignature(t, key, indexDesc)
+			}
+			err := verifyIndexSignature(context.Background(), indexDesc.Digest, repo, keys)
+			if tc.expectedErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.expectedErr != nil && !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
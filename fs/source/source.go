@@ -90,6 +90,11 @@ const (
 
 	// HasSociIndexDigest is a label that tells if the layer was pulled with a SOCI index.
 	HasSociIndexDigest = "containerd.io/snapshot/remote/has.soci.index.digest"
+
+	// RecordAccessLabel is a label which enables recording the files read from the image
+	// after it is mounted. The value is the length of the recording window in seconds,
+	// or empty to use the configured duration.
+	RecordAccessLabel = "containerd.io/snapshot/remote/soci.record-access"
 )
 
 // RegistryHosts is copied from [github.com/awslabs/soci-snapshotter/service/resolver.RegistryHosts]
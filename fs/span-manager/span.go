@@ -40,6 +40,21 @@ const (
 	uncompressed
 )
 
+// String returns the name of the state reported by SpanManager.Spans.
+func (s spanState) String() string {
+	switch s {
+	case unrequested:
+		return "unrequested"
+	case requested:
+		return "requested"
+	case fetched:
+		return "fetched"
+	case uncompressed:
+		return "uncompressed"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
 const (
 	// Default number of tries fetching data from remote and verifying the digest.
 	defaultSpanVerificationFailureRetries = 3
@@ -64,6 +79,12 @@ var stateTransitionMap = map[spanState][]spanState{
 	fetched: {
 		// when span data request comes and span is fetched by bg-fetcher; compressed span is available in cache
 		uncompressed,
+		// when the compressed span was evicted from cache; the span needs to be fetched again
+		unrequested,
+	},
+	uncompressed: {
+		// when the uncompressed span was evicted from cache; the span needs to be fetched again
+		unrequested,
 	},
 }
 
@@ -26,6 +26,7 @@ import (
 	"sync"
 
 	"github.com/awslabs/soci-snapshotter/cache"
+	"github.com/awslabs/soci-snapshotter/fs/fetchscheduler"
 	commonmetrics "github.com/awslabs/soci-snapshotter/fs/metrics/common"
 	"github.com/awslabs/soci-snapshotter/util/ioutils"
 	"github.com/awslabs/soci-snapshotter/ztoc"
@@ -54,9 +55,20 @@ type SpanManager struct {
 	ztoc                              *ztoc.Ztoc
 	layerSha                          digest.Digest
 	maxSpanVerificationFailureRetries int
+	fetchQueue                        *fetchscheduler.Queue // schedules the span fetches from the registry of the layer
+	mr                                MultiRangeReaderAt    // reader for several spans at once; nil fetches every span separately
+	maxSpansPerFetch                  int
+	persistent                        bool // the cache outlives the SpanManager; see SetPersistentCache
 	closeOnce                         sync.Once
 }
 
+// MultiRangeReaderAt reads several ranges of a layer at once, ideally with a single
+// request to the remote registry. Each buffer of `ps` is filled from the offset at
+// the same index of `offsets`.
+type MultiRangeReaderAt interface {
+	ReadAtMulti(ps [][]byte, offsets []int64) error
+}
+
 type spanInfo struct {
 	// starting span id of the requested contents
 	spanStart compression.SpanID
@@ -91,11 +103,19 @@ func New(ztoc *ztoc.Ztoc, r *io.SectionReader, cache cache.BlobCache, retries in
 
 	// We don't need the header anywhere else, but we want to ensure we read every byte from the layer.
 	// This also serves as a sanity check to make sure the file isn't corrupted.
-	b := make([]byte, index.StartCompressedOffset(0))
-	if _, err := r.Read(b); err != nil {
-		return nil, fmt.Errorf("unable to read ztoc header: %w", err)
+	var header io.Reader
+	if headerSize := index.StartCompressedOffset(0); headerSize > 0 {
+		b := make([]byte, headerSize)
+		if _, err := r.Read(b); err != nil {
+			return nil, fmt.Errorf("unable to read ztoc header: %w", err)
+		}
+		header = bytes.NewReader(b)
+	} else {
+		// Some compression algorithms (e.g. zstd) have no header in front of the first span,
+		// so the header is verified against the beginning of the first span instead.
+		header = io.NewSectionReader(r, 0, r.Size())
 	}
-	if err := index.VerifyHeader(bytes.NewReader(b)); err != nil {
+	if err := index.VerifyHeader(header); err != nil {
 		return nil, fmt.Errorf("unable to verify %v header: %w", ztoc.CompressionAlgorithm, err)
 	}
 
@@ -125,6 +145,30 @@ func New(ztoc *ztoc.Ztoc, r *io.SectionReader, cache cache.BlobCache, retries in
 	return m, nil
 }
 
+// SetFetchQueue sets the queue through which every span fetch from the remote
+// registry is scheduled. Without a queue, spans are fetched without a limit.
+func (m *SpanManager) SetFetchQueue(q *fetchscheduler.Queue) {
+	m.fetchQueue = q
+}
+
+// SetPersistentCache tells the SpanManager that its cache outlives it, e.g. across
+// snapshotter restarts. The compressed contents of the spans are then kept in the
+// cache once the spans are uncompressed, and the spans cached by an earlier SpanManager
+// of the layer are restored from their compressed contents, verified against the ztoc.
+// The uncompressed contents cached by an earlier SpanManager are never trusted.
+func (m *SpanManager) SetPersistentCache() {
+	m.persistent = true
+}
+
+// SetMultiRangeReader lets the SpanManager fetch up to `maxSpansPerFetch` spans needed
+// by a read or a prefetch with a single call to `r`. `r` must read the same contents
+// as the reader given to New. With a `maxSpansPerFetch` of 1 or less, every span is
+// fetched separately.
+func (m *SpanManager) SetMultiRangeReader(r MultiRangeReaderAt, maxSpansPerFetch int) {
+	m.mr = r
+	m.maxSpansPerFetch = maxSpansPerFetch
+}
+
 func (m *SpanManager) buildAllSpans() error {
 	var i compression.SpanID
 	for i = 0; i <= m.ztoc.MaxSpanID; i++ {
@@ -150,6 +194,11 @@ func (m *SpanManager) buildAllSpans() error {
 	return nil
 }
 
+// SpanIDAt returns the ID of the span containing the uncompressed offset.
+func (m *SpanManager) SpanIDAt(offset compression.Offset) compression.SpanID {
+	return m.zinfo.UncompressedOffsetToSpanID(offset)
+}
+
 // FetchSingleSpan invokes the reader to fetch the span in the background and cache
 // the span without uncompressing. It is invoked by the BackgroundFetcher.
 // span state change: unrequested -> requested -> fetched.
@@ -164,6 +213,14 @@ func (m *SpanManager) FetchSingleSpan(spanID compression.SpanID) error {
 		return nil
 	}
 
+	// Wait for a fetch slot before locking the span, so that an on-demand read
+	// of the span doesn't wait for the span lock behind the queued background fetch.
+	release, err := m.fetchQueue.Acquire(context.Background(), fetchscheduler.PriorityBackground)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	// check again after acquiring Lock
@@ -171,7 +228,7 @@ func (m *SpanManager) FetchSingleSpan(spanID compression.SpanID) error {
 		return nil
 	}
 
-	_, err := m.fetchAndCacheSpan(spanID, false)
+	_, err = m.fetchAndCacheSpan(spanID, false, fetchscheduler.PriorityBackground, true)
 	return err
 }
 
@@ -188,6 +245,18 @@ func (m *SpanManager) ResolveSpan(spanID compression.SpanID) error {
 		return nil
 	}
 
+	// Like FetchSingleSpan, wait for a fetch slot before locking a span which
+	// needs to be fetched.
+	slotHeld := false
+	if s.checkState(unrequested) {
+		release, err := m.fetchQueue.Acquire(context.Background(), fetchscheduler.PriorityPrefetch)
+		if err != nil {
+			return err
+		}
+		defer release()
+		slotHeld = true
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -195,7 +264,16 @@ func (m *SpanManager) ResolveSpan(spanID compression.SpanID) error {
 		return nil
 	}
 
-	_, err := m.fetchAndCacheSpan(spanID, true) // true = uncompress
+	// the compressed span is cached, e.g. by bg-fetcher or before a restart
+	if s.checkState(fetched) {
+		r, err := m.uncompressCachedSpan(s, 0, 0, fetchscheduler.PriorityPrefetch)
+		if err != nil {
+			return err
+		}
+		return r.Close()
+	}
+
+	_, err := m.fetchAndCacheSpan(spanID, true, fetchscheduler.PriorityPrefetch, slotHeld) // true = uncompress
 	if err != nil {
 		return err
 	}
@@ -203,6 +281,30 @@ func (m *SpanManager) ResolveSpan(spanID compression.SpanID) error {
 	return nil
 }
 
+// ResolveSpans ensures the spans exist in cache and are uncompressed. The spans
+// which are not cached yet are fetched together when possible. The spans which
+// aren't resolved yet when `ctx` is cancelled are skipped.
+func (m *SpanManager) ResolveSpans(ctx context.Context, spanIDs []compression.SpanID) error {
+	for _, spanID := range spanIDs {
+		if spanID > m.ztoc.MaxSpanID {
+			return ErrExceedMaxSpan
+		}
+	}
+
+	m.fetchSpans(ctx, spanIDs, fetchscheduler.PriorityPrefetch)
+	var errs []error
+	for _, spanID := range spanIDs {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if err := m.ResolveSpan(spanID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // resolveSpan ensures the span exists in cache and is uncompressed by calling
 // `getSpanContent`. Only for testing.
 func (m *SpanManager) resolveSpan(spanID compression.SpanID) error {
@@ -224,6 +326,14 @@ func (m *SpanManager) GetContents(startUncompOffset, endUncompOffset compression
 	numSpans := si.spanEnd - si.spanStart + 1
 	spanReaders := make([]io.ReadCloser, numSpans)
 
+	if numSpans > 1 {
+		spanIDs := make([]compression.SpanID, 0, numSpans)
+		for spanID := si.spanStart; spanID <= si.spanEnd; spanID++ {
+			spanIDs = append(spanIDs, spanID)
+		}
+		m.fetchSpans(ctx, spanIDs, fetchscheduler.PriorityOnDemand)
+	}
+
 	eg, _ := errgroup.WithContext(ctx)
 	var i compression.SpanID
 	for i = 0; i < numSpans; i++ {
@@ -244,6 +354,108 @@ func (m *SpanManager) GetContents(startUncompOffset, endUncompOffset compression
 	return ioutils.NewMultiReadCloser(spanReaders), nil
 }
 
+// Complete reports whether every span of the layer is cached, compressed or not,
+// e.g. once the background fetcher has fetched the whole layer.
+func (m *SpanManager) Complete() bool {
+	for _, s := range m.spans {
+		if !s.checkState(fetched) && !s.checkState(uncompressed) {
+			return false
+		}
+	}
+	return true
+}
+
+// Cached reports whether every span overlapping the uncompressed range
+// [start, end) is cached, compressed or not.
+func (m *SpanManager) Cached(start, end compression.Offset) bool {
+	if end <= start {
+		return true
+	}
+	for spanID := m.SpanIDAt(start); spanID <= m.SpanIDAt(end-1); spanID++ {
+		s := m.spans[spanID]
+		if !s.checkState(fetched) && !s.checkState(uncompressed) {
+			return false
+		}
+	}
+	return true
+}
+
+// SpanStatus is the state of a span of a layer.
+type SpanStatus struct {
+	ID compression.SpanID `json:"id"`
+	// State is one of "unrequested", "requested", "fetched" or "uncompressed".
+	State              string             `json:"state"`
+	CompressedOffset   compression.Offset `json:"compressedOffset"`
+	CompressedSize     compression.Offset `json:"compressedSize"`
+	UncompressedOffset compression.Offset `json:"uncompressedOffset"`
+	UncompressedSize   compression.Offset `json:"uncompressedSize"`
+}
+
+// Spans returns the current state of every span of the layer.
+func (m *SpanManager) Spans() []SpanStatus {
+	spans := make([]SpanStatus, len(m.spans))
+	for i, s := range m.spans {
+		spans[i] = SpanStatus{
+			ID:                 s.id,
+			State:              s.state.Load().(spanState).String(),
+			CompressedOffset:   s.startCompOffset,
+			CompressedSize:     s.endCompOffset - s.startCompOffset,
+			UncompressedOffset: s.startUncompOffset,
+			UncompressedSize:   s.endUncompOffset - s.startUncompOffset,
+		}
+	}
+	return spans
+}
+
+// UncompressedReader returns a reader of the whole uncompressed layer. Spans are
+// read one at a time, and fetched if they aren't cached.
+func (m *SpanManager) UncompressedReader() io.ReadCloser {
+	return &layerReader{m: m}
+}
+
+// layerReader reads the uncompressed layer span by span.
+type layerReader struct {
+	m    *SpanManager
+	next compression.SpanID
+	cur  io.ReadCloser
+}
+
+func (r *layerReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if r.next > r.m.ztoc.MaxSpanID {
+				return 0, io.EOF
+			}
+			s := r.m.spans[r.next]
+			rc, err := r.m.getSpanContent(s.id, 0, s.endUncompOffset-s.startUncompOffset)
+			if err != nil {
+				return 0, err
+			}
+			r.cur = rc
+			r.next++
+		}
+		n, err := r.cur.Read(p)
+		if errors.Is(err, io.EOF) {
+			r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *layerReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	err := r.cur.Close()
+	r.cur = nil
+	return err
+}
+
 // getSpanInfo returns spanInfo from the offsets of the requested file
 func (m *SpanManager) getSpanInfo(offsetStart, offsetEnd compression.Offset) *spanInfo {
 	spanStart := m.zinfo.UncompressedOffsetToSpanID(offsetStart)
@@ -289,71 +501,100 @@ func (m *SpanManager) getSpanInfo(offsetStart, offsetEnd compression.Offset) *sp
 //  3. For `unrequested` span, fetch-uncompress-cache the span data, return the reader
 //     from the uncompressed span
 //  4. No span state lock will be acquired in `requested` state.
+//
+// A `fetched/uncompressed` span evicted from the cache is set back to `unrequested`
+// and fetched again.
 func (m *SpanManager) getSpanContent(spanID compression.SpanID, offsetStart, offsetEnd compression.Offset) (io.ReadCloser, error) {
 	s := m.spans[spanID]
 	size := offsetEnd - offsetStart
 
 	// return from cache directly if cached and uncompressed
 	if s.checkState(uncompressed) {
-		return m.getSpanFromCache(s.id, offsetStart, size)
+		if r, err := m.getSpanFromCache(s.id, uncompressed, offsetStart, size); err == nil {
+			return r, nil
+		}
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	// check again after acquiring lock
 	if s.checkState(uncompressed) {
-		return m.getSpanFromCache(s.id, offsetStart, size)
+		r, err := m.getSpanFromCache(s.id, uncompressed, offsetStart, size)
+		if err == nil {
+			return r, nil
+		}
+		log.L.WithError(err).WithField("layer_sha", m.layerSha).Debugf("span %d was evicted from cache, fetching it again", s.id)
+		if err := s.setState(unrequested); err != nil {
+			return nil, err
+		}
 	}
 
 	// if cached but not uncompressed, uncompress and cache the span content
 	if s.checkState(fetched) {
-		// get compressed span from the cache
-		compressedSize := s.endCompOffset - s.startCompOffset
-		r, err := m.getSpanFromCache(s.id, 0, compressedSize)
-		if err != nil {
-			return nil, err
-		}
-		defer r.Close()
+		return m.uncompressCachedSpan(s, offsetStart, size, fetchscheduler.PriorityOnDemand)
+	}
 
-		// read compressed span
-		compressedBuf, err := io.ReadAll(r)
-		if err != nil {
+	// fetch-uncompress-cache span: span state can only be `unrequested` since
+	// no goroutine will release span state lock in `requested` state
+	uncompBuf, err := m.fetchAndCacheSpan(s.id, true, fetchscheduler.PriorityOnDemand, false)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(uncompBuf[offsetStart : offsetStart+size])
+	return io.NopCloser(buf), nil
+}
+
+// uncompressCachedSpan uncompresses and caches the content of a `fetched` span, and
+// returns the requested contents. If the compressed span was evicted from the cache,
+// the span is fetched again with `priority`. The caller needs to acquire the span's state lock.
+func (m *SpanManager) uncompressCachedSpan(s *span, offsetStart, size compression.Offset, priority fetchscheduler.Priority) (io.ReadCloser, error) {
+	// get compressed span from the cache
+	compressedSize := s.endCompOffset - s.startCompOffset
+	r, err := m.getSpanFromCache(s.id, fetched, 0, compressedSize)
+	if err != nil {
+		log.L.WithError(err).WithField("layer_sha", m.layerSha).Debugf("span %d was evicted from cache, fetching it again", s.id)
+		if err := s.setState(unrequested); err != nil {
 			return nil, err
 		}
-
-		// uncompress span
-		uncompSpanBuf, err := m.uncompressSpan(s, compressedBuf)
+		uncompBuf, err := m.fetchAndCacheSpan(s.id, true, priority, false)
 		if err != nil {
 			return nil, err
 		}
+		return io.NopCloser(bytes.NewReader(uncompBuf[offsetStart : offsetStart+size])), nil
+	}
+	defer r.Close()
 
-		// cache uncompressed span
-		if err := m.addSpanToCache(s.id, uncompSpanBuf); err != nil {
-			return nil, err
-		}
-		if err := s.setState(uncompressed); err != nil {
-			return nil, err
-		}
-		return io.NopCloser(bytes.NewReader(uncompSpanBuf[offsetStart : offsetStart+size])), nil
+	// read compressed span
+	compressedBuf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
 	}
 
-	// fetch-uncompress-cache span: span state can only be `unrequested` since
-	// no goroutine will release span state lock in `requested` state
-	commonmetrics.IncOperationCount(commonmetrics.SynchronousReadRegistryFetchCount, m.layerSha)
-	uncompBuf, err := m.fetchAndCacheSpan(s.id, true)
+	// uncompress span
+	uncompSpanBuf, err := m.uncompressSpan(s, compressedBuf)
 	if err != nil {
 		return nil, err
 	}
-	buf := bytes.NewBuffer(uncompBuf[offsetStart : offsetStart+size])
-	return io.NopCloser(buf), nil
+
+	// cache uncompressed span
+	if err := m.addSpanToCache(s.id, uncompressed, uncompSpanBuf); err != nil {
+		return nil, err
+	}
+	if err := s.setState(uncompressed); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(uncompSpanBuf[offsetStart : offsetStart+size])), nil
 }
 
 // fetchAndCacheSpan fetches a span, uncompresses the span if `uncompress == true`,
 // caches and returns the span content. The span state is set to `fetched/uncompressed`,
-// depending on if `uncompress` is enabled.
+// depending on if `uncompress` is enabled. The fetch is scheduled with `priority`,
+// unless the caller already holds a slot of the fetch queue (`slotHeld`).
+// A span cached by an earlier SpanManager of the layer is restored from the cache
+// instead of being fetched.
 // The caller needs to check the span state (e.g. `unrequested`) and acquires the
 // span's state lock before calling.
-func (m *SpanManager) fetchAndCacheSpan(spanID compression.SpanID, uncompress bool) (buf []byte, err error) {
+func (m *SpanManager) fetchAndCacheSpan(spanID compression.SpanID, uncompress bool, priority fetchscheduler.Priority, slotHeld bool) (buf []byte, err error) {
 	s := m.spans[spanID]
 
 	// change to `requested`; if fetch/cache fails, change back to `unrequested`
@@ -367,13 +608,26 @@ func (m *SpanManager) fetchAndCacheSpan(spanID compression.SpanID, uncompress bo
 		}
 	}()
 
+	if buf, ok := m.restoreSpan(s, uncompress); ok {
+		return buf, nil
+	}
+	if priority == fetchscheduler.PriorityOnDemand {
+		commonmetrics.IncOperationCount(commonmetrics.SynchronousReadRegistryFetchCount, m.layerSha)
+	}
+
 	// fetch compressed span
-	compressedBuf, err := m.fetchSpanWithRetries(spanID)
+	compressedBuf, err := m.fetchSpanWithRetries(spanID, priority, slotHeld)
 	if err != nil {
 		return nil, err
 	}
 
-	buf = compressedBuf
+	return m.cacheSpan(s, compressedBuf, uncompress)
+}
+
+// cacheSpan uncompresses the fetched span if `uncompress == true`, caches and
+// returns the span content, and sets the span state to `fetched/uncompressed`.
+func (m *SpanManager) cacheSpan(s *span, compressedBuf []byte, uncompress bool) ([]byte, error) {
+	buf := compressedBuf
 	var state = fetched
 
 	if uncompress {
@@ -382,12 +636,18 @@ func (m *SpanManager) fetchAndCacheSpan(spanID compression.SpanID, uncompress bo
 		if err != nil {
 			return nil, err
 		}
+		// keep the compressed span, from which the span is restored later
+		if m.persistent {
+			if err := m.addSpanToCache(s.id, fetched, compressedBuf); err != nil {
+				return nil, err
+			}
+		}
 		buf = uncompSpanBuf
 		state = uncompressed
 	}
 
 	// cache span data
-	if err := m.addSpanToCache(spanID, buf); err != nil {
+	if err := m.addSpanToCache(s.id, state, buf); err != nil {
 		return nil, err
 	}
 	if err := s.setState(state); err != nil {
@@ -396,22 +656,169 @@ func (m *SpanManager) fetchAndCacheSpan(spanID compression.SpanID, uncompress bo
 	return buf, nil
 }
 
+// restoreSpan restores a `requested` span from its compressed contents cached by an
+// earlier SpanManager of the layer with a persistent cache, e.g. before the snapshotter
+// restarted. The compressed contents are verified against the ztoc, and uncompressed
+// and cached if `uncompress == true`. The span state is set to `fetched/uncompressed`
+// accordingly. It returns the span content, and false if the span isn't cached or the
+// cached contents are invalid.
+func (m *SpanManager) restoreSpan(s *span, uncompress bool) ([]byte, bool) {
+	if !m.persistent {
+		return nil, false
+	}
+	compressedSize := s.endCompOffset - s.startCompOffset
+	r, err := m.getSpanFromCache(s.id, fetched, 0, compressedSize+1)
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+	buf, err := io.ReadAll(r)
+	if err != nil || compression.Offset(len(buf)) != compressedSize {
+		return nil, false
+	}
+	if err := m.verifySpanContents(buf, s.id); err != nil {
+		log.L.WithError(err).WithField("layer_sha", m.layerSha).Debugf("discarding invalid cached span %d", s.id)
+		return nil, false
+	}
+	if !uncompress {
+		return buf, s.setState(fetched) == nil
+	}
+	uncompSpanBuf, err := m.uncompressSpan(s, buf)
+	if err != nil {
+		return nil, false
+	}
+	if err := m.addSpanToCache(s.id, uncompressed, uncompSpanBuf); err != nil {
+		return nil, false
+	}
+	return uncompSpanBuf, s.setState(uncompressed) == nil
+}
+
+// fetchSpans fetches the `unrequested` spans of `spanIDs` in batches of up to
+// m.maxSpansPerFetch spans with a single call to the multi-range reader, then
+// verifies, uncompresses and caches each span separately.
+//
+// It is best effort: spans being resolved by another goroutine are skipped, and
+// spans which fail to be fetched or verified are set back to `unrequested`, so
+// that the caller fetches them again separately with retries. It stops once `ctx`
+// is cancelled.
+func (m *SpanManager) fetchSpans(ctx context.Context, spanIDs []compression.SpanID, priority fetchscheduler.Priority) {
+	if m.mr == nil || m.maxSpansPerFetch < 2 {
+		return
+	}
+
+	var spans []*span
+	for _, spanID := range spanIDs {
+		if s := m.spans[spanID]; s.checkState(unrequested) {
+			spans = append(spans, s)
+		}
+	}
+	for len(spans) > 1 && ctx.Err() == nil {
+		// Wait for a fetch slot before locking the spans of the batch, so that reads
+		// of the spans don't wait for the span locks behind a lower priority fetch.
+		release, err := m.fetchQueue.Acquire(ctx, priority)
+		if err != nil {
+			return
+		}
+		var batch []*span
+		for len(spans) > 0 && len(batch) < m.maxSpansPerFetch {
+			s := spans[0]
+			spans = spans[1:]
+			// Never wait for a span lock while holding the locks of the batch.
+			if !s.checkState(unrequested) || !s.mu.TryLock() {
+				continue
+			}
+			if !s.checkState(unrequested) {
+				s.mu.Unlock()
+				continue
+			}
+			batch = append(batch, s)
+		}
+		if len(batch) > 1 {
+			m.fetchAndCacheSpanBatch(batch, priority, release)
+		}
+		release()
+		for _, s := range batch {
+			s.mu.Unlock()
+		}
+	}
+}
+
+// fetchAndCacheSpanBatch fetches `spans` with a single call to the multi-range reader,
+// then verifies, uncompresses and caches each span. Spans cached by an earlier
+// SpanManager of the layer are restored instead. The caller needs to acquire a slot
+// of the fetch queue, which is released with `release` once the spans are fetched, and
+// the state locks of the `unrequested` spans before calling.
+func (m *SpanManager) fetchAndCacheSpanBatch(spans []*span, priority fetchscheduler.Priority, release func()) {
+	var pending []*span
+	for _, s := range spans {
+		s.setState(requested)
+		if _, ok := m.restoreSpan(s, true); !ok {
+			pending = append(pending, s)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	spans = pending
+
+	ps := make([][]byte, len(spans))
+	offsets := make([]int64, len(spans))
+	for i, s := range spans {
+		ps[i] = make([]byte, s.endCompOffset-s.startCompOffset)
+		offsets[i] = int64(s.startCompOffset)
+	}
+
+	if priority == fetchscheduler.PriorityOnDemand {
+		commonmetrics.IncOperationCount(commonmetrics.SynchronousReadRegistryFetchCount, m.layerSha)
+	}
+	err := m.mr.ReadAtMulti(ps, offsets)
+	release()
+	if err != nil {
+		log.L.WithError(err).WithField("layer_sha", m.layerSha).Debug("failed to fetch spans together, fetching them separately")
+	}
+
+	for i, s := range spans {
+		if err == nil {
+			spanErr := m.verifySpanContents(ps[i], s.id)
+			if spanErr == nil {
+				if _, spanErr = m.cacheSpan(s, ps[i], true); spanErr == nil {
+					continue
+				}
+			}
+			log.L.WithError(spanErr).WithField("layer_sha", m.layerSha).Debugf("failed to cache span %d fetched with other spans", s.id)
+		}
+		if s.checkState(requested) {
+			s.setState(unrequested)
+		}
+	}
+}
+
 // fetchSpanWithRetries fetches the requested data and verifies that the span digest matches the one in the ztoc.
 // It will retry the fetch and verification m.maxSpanVerificationFailureRetries times.
 // It does not retry when there is an error fetching the data, because retries already happen lower in the stack in httpFetcher.
 // If there is an error fetching data from remote, it is not an transient error.
-func (m *SpanManager) fetchSpanWithRetries(spanID compression.SpanID) ([]byte, error) {
+// Every fetch waits for a slot of the fetch queue with the given priority, unless the
+// caller already holds one (`slotHeld`).
+func (m *SpanManager) fetchSpanWithRetries(spanID compression.SpanID, priority fetchscheduler.Priority, slotHeld bool) ([]byte, error) {
 	s := m.spans[spanID]
 	offset := s.startCompOffset
 	compressedSize := s.endCompOffset - s.startCompOffset
 	compressedBuf := make([]byte, compressedSize)
 
 	var (
-		err error
-		n   int
+		err     error
+		n       int
+		release = func() {}
 	)
 	for i := 0; i < m.maxSpanVerificationFailureRetries+1; i++ {
+		if !slotHeld {
+			release, err = m.fetchQueue.Acquire(context.Background(), priority)
+			if err != nil {
+				return []byte{}, err
+			}
+		}
 		n, err = m.r.ReadAt(compressedBuf, int64(offset))
+		release()
 		// if the n = len(p) bytes returned by ReadAt are at the end of the input source,
 		// ReadAt may return either err == EOF or err == nil: https://pkg.go.dev/io#ReaderAt
 		if err != nil && !errors.Is(err, io.EOF) {
@@ -446,10 +853,24 @@ func (m *SpanManager) uncompressSpan(s *span, compressedBuf []byte) ([]byte, err
 	return bytes, nil
 }
 
-// addSpanToCache adds contents of the span to the cache.
+// spanCacheKey returns the key of the contents of a span cached in `state`, either
+// `fetched` (compressed) or `uncompressed`. In a persistent cache, both contents are
+// cached under different keys, so that restoreSpan never has to guess what a cached
+// entry holds. Otherwise, the uncompressed contents replace the compressed ones.
+func (m *SpanManager) spanCacheKey(spanID compression.SpanID, state spanState) string {
+	if !m.persistent {
+		return fmt.Sprintf("%d", spanID)
+	}
+	if state == uncompressed {
+		return fmt.Sprintf("%d.uncompressed", spanID)
+	}
+	return fmt.Sprintf("%d.compressed", spanID)
+}
+
+// addSpanToCache adds contents of the span in `state` to the cache.
 // A non-nil error is returned if the data is not written to the cache.
-func (m *SpanManager) addSpanToCache(spanID compression.SpanID, contents []byte) error {
-	w, err := m.cache.Add(fmt.Sprintf("%d", spanID), m.cacheOpt...)
+func (m *SpanManager) addSpanToCache(spanID compression.SpanID, state spanState, contents []byte) error {
+	w, err := m.cache.Add(m.spanCacheKey(spanID, state), m.cacheOpt...)
 	if err != nil {
 		return err
 	}
@@ -465,11 +886,11 @@ func (m *SpanManager) addSpanToCache(spanID compression.SpanID, contents []byte)
 	return nil
 }
 
-// getSpanFromCache returns the cached span content as an `io.Reader`.
+// getSpanFromCache returns the span content cached in `state` as an `io.Reader`.
 // `offset` is the offset of the requested contents within the span.
 // `size` is the size of the requested contents.
-func (m *SpanManager) getSpanFromCache(spanID compression.SpanID, offset, size compression.Offset) (io.ReadCloser, error) {
-	rc, err := m.cache.Get(fmt.Sprintf("%d", spanID), m.cacheOpt...)
+func (m *SpanManager) getSpanFromCache(spanID compression.SpanID, state spanState, offset, size compression.Offset) (io.ReadCloser, error) {
+	rc, err := m.cache.Get(m.spanCacheKey(spanID, state), m.cacheOpt...)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrSpanNotAvailable, err)
 	}
@@ -19,13 +19,19 @@ package spanmanager
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/awslabs/soci-snapshotter/cache"
+	"github.com/awslabs/soci-snapshotter/fs/fetchscheduler"
 	commonmetrics "github.com/awslabs/soci-snapshotter/fs/metrics/common"
 	"github.com/awslabs/soci-snapshotter/util/testutil"
 	"github.com/awslabs/soci-snapshotter/ztoc"
@@ -163,6 +169,57 @@ func TestSpanManager(t *testing.T) {
 	}
 }
 
+func TestSpanManagerZstd(t *testing.T) {
+	var spanSize compression.Offset = 65536 // 64 KiB
+	fileName := "span-manager-zstd-test"
+	tRand := testutil.NewTestRand(t)
+	fileContent := tRand.RandomByteData(int64(spanSize) * 10)
+	tarEntries := []testutil.TarEntry{
+		testutil.File(fileName, string(fileContent)),
+	}
+
+	toc, r, err := ztoc.BuildZtocReaderZstd(t, tarEntries, 3, int64(spanSize), testutil.WithZstdFrameSize(int(spanSize)))
+	if err != nil {
+		t.Fatalf("failed to create ztoc: %v", err)
+	}
+	if toc.MaxSpanID < 9 {
+		t.Fatalf("expected a multi-span ztoc, got max span id %d", toc.MaxSpanID)
+	}
+
+	cache := cache.NewMemoryCache()
+	defer cache.Close()
+	m, err := New(toc, r, cache, 0, digest.FromString(""))
+	if err != nil {
+		t.Fatalf("failed to create span manager: %v", err)
+	}
+
+	// Fetch one span in the background to exercise the fetched -> uncompressed path.
+	if err := m.FetchSingleSpan(1); err != nil {
+		t.Fatalf("failed to fetch span 1: %v", err)
+	}
+	fileContentFromSpans, err := getFileContentFromSpans(m, toc, fileName)
+	if err != nil {
+		t.Fatalf("failed to get file content from spans: %v", err)
+	}
+	if !bytes.Equal(fileContent, fileContentFromSpans) {
+		t.Fatalf("file contents are not the same as span contents")
+	}
+}
+
+func TestSpanManagerZstdHeaderVerification(t *testing.T) {
+	tarEntries := []testutil.TarEntry{
+		testutil.File("zstd-header-test", "zstd header test"),
+	}
+	toc, _, err := ztoc.BuildZtocReaderZstd(t, tarEntries, 3, 65536)
+	if err != nil {
+		t.Fatalf("failed to create ztoc: %v", err)
+	}
+	r := io.NewSectionReader(bytes.NewReader(bytes.Repeat([]byte{0xAB}, 1000)), 0, 1000)
+	if _, err := New(toc, r, cache.NewMemoryCache(), 0, digest.FromString("")); err == nil {
+		t.Fatalf("expected header verification to fail for a non-zstd layer")
+	}
+}
+
 func TestSpanManagerCache(t *testing.T) {
 	tRand := testutil.NewTestRand(t)
 	var spanSize compression.Offset = 65536 // 64 KiB
@@ -328,25 +385,25 @@ func TestValidateState(t *testing.T) {
 		{
 			name:         "span in Fetched state with valid new state",
 			currentState: fetched,
-			newState:     []spanState{uncompressed},
+			newState:     []spanState{uncompressed, unrequested},
 			expectedErr:  nil,
 		},
 		{
 			name:         "span in Fetched state with invalid new state",
 			currentState: fetched,
-			newState:     []spanState{unrequested, requested, fetched},
+			newState:     []spanState{requested, fetched},
 			expectedErr:  errInvalidSpanStateTransition,
 		},
 		{
 			name:         "span in Uncompressed state with valid new state",
 			currentState: uncompressed,
-			newState:     []spanState{},
+			newState:     []spanState{unrequested},
 			expectedErr:  nil,
 		},
 		{
 			name:         "span in Uncompressed state with invalid new state",
 			currentState: uncompressed,
-			newState:     []spanState{unrequested, requested, fetched, uncompressed},
+			newState:     []spanState{requested, fetched, uncompressed},
 			expectedErr:  errInvalidSpanStateTransition,
 		},
 	}
@@ -410,7 +467,7 @@ func TestSpanManagerRetries(t *testing.T) {
 			for i := 0; i < int(ztoc.MaxSpanID); i++ {
 				rdr.errCount = 0
 
-				_, err := sm.fetchAndCacheSpan(compression.SpanID(i), true)
+				_, err := sm.fetchAndCacheSpan(compression.SpanID(i), true, fetchscheduler.PriorityOnDemand, false)
 				if !errors.Is(err, tc.expectedErr) {
 					t.Fatalf("unexpected err; expected %v, got %v", tc.expectedErr, err)
 				}
@@ -558,3 +615,516 @@ func TestSynchronousFetchMetricOnlyFiresOnNetworkFetch(t *testing.T) {
 		assert.Equal(t, before, after, "metric should not increment when bg fetcher already fetched span")
 	}
 }
+
+func TestSpanManagerFetchQueue(t *testing.T) {
+	var spanSize compression.Offset = 65536 // 64 KiB
+	tRand := testutil.NewTestRand(t)
+	tarEntries := []testutil.TarEntry{
+		testutil.File("fetch-queue-test", string(tRand.RandomByteData(int64(spanSize)*4))),
+	}
+	toc, r, err := ztoc.BuildZtocReader(t, tarEntries, gzip.DefaultCompression, int64(spanSize))
+	if err != nil {
+		t.Fatalf("failed to create ztoc: %v", err)
+	}
+	m, err := New(toc, r, cache.NewMemoryCache(), 0, digest.FromString(""))
+	if err != nil {
+		t.Fatalf("failed to create span manager: %v", err)
+	}
+	defer m.Close()
+
+	scheduler := fetchscheduler.New(1, 0)
+	queue := scheduler.Registry("registry.example.com")
+	m.SetFetchQueue(queue)
+	release, err := queue.Acquire(context.Background(), fetchscheduler.PriorityOnDemand)
+	if err != nil {
+		t.Fatalf("failed to acquire the fetch queue: %v", err)
+	}
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- m.FetchSingleSpan(1)
+	}()
+	// The background fetch waits for the registry slot held above.
+	deadline := time.Now().Add(5 * time.Second)
+	for scheduler.QueueDepth(fetchscheduler.PriorityBackground) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the background fetch to be queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	if err := <-errCh; err != nil {
+		t.Fatalf("failed to fetch span 1: %v", err)
+	}
+	if !m.spans[1].checkState(fetched) {
+		t.Fatal("expected span 1 to be fetched")
+	}
+}
+
+// countingReaderAt counts the reads of a layer.
+type countingReaderAt struct {
+	r     io.ReaderAt
+	mu    sync.Mutex
+	reads int
+}
+
+func (cr *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	cr.mu.Lock()
+	cr.reads++
+	cr.mu.Unlock()
+	return cr.r.ReadAt(p, off)
+}
+
+func TestSpanManagerFetchQueueOnDemandNotBlockedByQueuedFetches(t *testing.T) {
+	var spanSize compression.Offset = 65536 // 64 KiB
+	tRand := testutil.NewTestRand(t)
+	tarEntries := []testutil.TarEntry{
+		testutil.File("fetch-queue-test", string(tRand.RandomByteData(int64(spanSize)*4))),
+	}
+	waitForDepth := func(t *testing.T, scheduler *fetchscheduler.Scheduler, p fetchscheduler.Priority, depth int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for scheduler.QueueDepth(p) != depth {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d queued %s fetches, got %d", depth, p, scheduler.QueueDepth(p))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		priority fetchscheduler.Priority
+		fetch    func(m *SpanManager) error
+	}{
+		{
+			name:     "background fetch",
+			priority: fetchscheduler.PriorityBackground,
+			fetch:    func(m *SpanManager) error { return m.FetchSingleSpan(1) },
+		},
+		{
+			name:     "prefetch",
+			priority: fetchscheduler.PriorityPrefetch,
+			fetch:    func(m *SpanManager) error { return m.ResolveSpan(1) },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			toc, r, err := ztoc.BuildZtocReader(t, tarEntries, gzip.DefaultCompression, int64(spanSize))
+			if err != nil {
+				t.Fatalf("failed to create ztoc: %v", err)
+			}
+			cr := &countingReaderAt{r: r}
+			m, err := New(toc, io.NewSectionReader(cr, 0, r.Size()), cache.NewMemoryCache(), 0, digest.FromString(""))
+			if err != nil {
+				t.Fatalf("failed to create span manager: %v", err)
+			}
+			defer m.Close()
+			readsBefore := cr.reads
+			scheduler := fetchscheduler.New(1, 0)
+			queue := scheduler.Registry("registry.example.com")
+			m.SetFetchQueue(queue)
+
+			// Hold the only slot of the registry, so that the fetch of span 1 is queued.
+			release, err := queue.Acquire(context.Background(), fetchscheduler.PriorityOnDemand)
+			if err != nil {
+				t.Fatalf("failed to acquire the fetch queue: %v", err)
+			}
+			fetchErr := make(chan error, 1)
+			go func() {
+				fetchErr <- tc.fetch(m)
+			}()
+			waitForDepth(t, scheduler, tc.priority, 1)
+
+			// A read of span 1 is queued ahead of the queued fetch instead of waiting
+			// for it to be done.
+			s := m.spans[1]
+			readErr := make(chan error, 1)
+			go func() {
+				_, err := m.getSpanContent(1, 0, s.endUncompOffset-s.startUncompOffset)
+				readErr <- err
+			}()
+			waitForDepth(t, scheduler, fetchscheduler.PriorityOnDemand, 1)
+			release()
+			if err := <-readErr; err != nil {
+				t.Fatalf("failed to read span 1: %v", err)
+			}
+			if err := <-fetchErr; err != nil {
+				t.Fatalf("failed to fetch span 1: %v", err)
+			}
+			if !s.checkState(uncompressed) {
+				t.Fatal("expected span 1 to be uncompressed")
+			}
+			// The queued fetch finds the span cached by the read.
+			if fetches := cr.reads - readsBefore; fetches != 1 {
+				t.Fatalf("expected span 1 to be fetched once, got %d fetches", fetches)
+			}
+		})
+	}
+}
+
+// multiRangeReader reads several ranges of a layer and records the number of
+// ranges of each read. Reads of the span at `corruptOffset` are corrupted.
+type multiRangeReader struct {
+	r             *io.SectionReader
+	corruptOffset int64
+	mu            sync.Mutex
+	reads         []int
+}
+
+func (mr *multiRangeReader) ReadAtMulti(ps [][]byte, offsets []int64) error {
+	mr.mu.Lock()
+	mr.reads = append(mr.reads, len(ps))
+	mr.mu.Unlock()
+	for i, p := range ps {
+		if _, err := mr.r.ReadAt(p, offsets[i]); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if offsets[i] == mr.corruptOffset {
+			p[0] ^= 0xff
+		}
+	}
+	return nil
+}
+
+func TestSpanManagerMultiRangeFetch(t *testing.T) {
+	var spanSize compression.Offset = 65536 // 64 KiB
+	fileName := "multi-range-test"
+	tRand := testutil.NewTestRand(t)
+	fileContent := tRand.RandomByteData(int64(spanSize) * 6)
+	tarEntries := []testutil.TarEntry{
+		testutil.File(fileName, string(fileContent)),
+	}
+
+	// The file spans 4 spans.
+	testCases := []struct {
+		name          string
+		maxSpans      int
+		corruptSpan   compression.SpanID
+		expectedReads []int
+	}{
+		{
+			name:          "all spans in one fetch",
+			maxSpans:      16,
+			expectedReads: []int{4},
+		},
+		{
+			name:          "spans in batches",
+			maxSpans:      2,
+			expectedReads: []int{2, 2},
+		},
+		{
+			name:     "single span fetches",
+			maxSpans: 1,
+		},
+		{
+			// The corrupted span is fetched again separately.
+			name:          "corrupted span",
+			maxSpans:      16,
+			corruptSpan:   2,
+			expectedReads: []int{4},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			toc, r, err := ztoc.BuildZtocReader(t, tarEntries, gzip.DefaultCompression, int64(spanSize))
+			if err != nil {
+				t.Fatalf("failed to create ztoc: %v", err)
+			}
+			if toc.MaxSpanID != 3 {
+				t.Fatalf("expected 4 spans, got %d", toc.MaxSpanID+1)
+			}
+			m, err := New(toc, r, cache.NewMemoryCache(), 0, digest.FromString(""))
+			if err != nil {
+				t.Fatalf("failed to create span manager: %v", err)
+			}
+			defer m.Close()
+			mr := &multiRangeReader{r: r, corruptOffset: -1}
+			if tc.corruptSpan != 0 {
+				mr.corruptOffset = int64(m.spans[tc.corruptSpan].startCompOffset)
+			}
+			m.SetMultiRangeReader(mr, tc.maxSpans)
+
+			content, err := getFileContentFromSpans(m, toc, fileName)
+			if err != nil {
+				t.Fatalf("failed to get file content from spans: %v", err)
+			}
+			if !bytes.Equal(fileContent, content) {
+				t.Fatal("file contents are not the same as span contents")
+			}
+			assert.Equal(t, tc.expectedReads, mr.reads)
+		})
+	}
+}
+
+func TestResolveSpans(t *testing.T) {
+	var spanSize compression.Offset = 65536 // 64 KiB
+	tRand := testutil.NewTestRand(t)
+	tarEntries := []testutil.TarEntry{
+		testutil.File("resolve-spans-test", string(tRand.RandomByteData(int64(spanSize)*6))),
+	}
+	toc, r, err := ztoc.BuildZtocReader(t, tarEntries, gzip.DefaultCompression, int64(spanSize))
+	if err != nil {
+		t.Fatalf("failed to create ztoc: %v", err)
+	}
+	m, err := New(toc, r, cache.NewMemoryCache(), 0, digest.FromString(""))
+	if err != nil {
+		t.Fatalf("failed to create span manager: %v", err)
+	}
+	defer m.Close()
+	mr := &multiRangeReader{r: r, corruptOffset: -1}
+	m.SetMultiRangeReader(mr, 16)
+
+	// Spans are not resolved once the context is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.ResolveSpans(ctx, []compression.SpanID{0, 1}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	assert.Empty(t, mr.reads)
+
+	// Span 1 is already cached, so only spans 0, 2 and 3 are fetched.
+	if err := m.ResolveSpan(1); err != nil {
+		t.Fatalf("failed to resolve span 1: %v", err)
+	}
+	if err := m.ResolveSpans(context.Background(), []compression.SpanID{0, 1, 2, 3}); err != nil {
+		t.Fatalf("failed to resolve spans: %v", err)
+	}
+	assert.Equal(t, []int{3}, mr.reads)
+	for i := compression.SpanID(0); i <= 3; i++ {
+		if !m.spans[i].checkState(uncompressed) {
+			t.Fatalf("expected span %d to be uncompressed", i)
+		}
+	}
+	if err := m.ResolveSpans(context.Background(), []compression.SpanID{toc.MaxSpanID + 1}); !errors.Is(err, ErrExceedMaxSpan) {
+		t.Fatalf("expected ErrExceedMaxSpan, got %v", err)
+	}
+}
+
+func TestSpanManagerPersistentCache(t *testing.T) {
+	var spanSize compression.Offset = 65536 // 64 KiB
+	tRand := testutil.NewTestRand(t)
+	content := tRand.RandomByteData(int64(spanSize) * 6)
+	fileName := "persistent-cache-test"
+	tarEntries := []testutil.TarEntry{
+		testutil.File(fileName, string(content)),
+	}
+	toc, r, err := ztoc.BuildZtocReader(t, tarEntries, gzip.BestCompression, int64(spanSize))
+	if err != nil {
+		t.Fatalf("failed to create ztoc: %v", err)
+	}
+	if toc.MaxSpanID < 2 {
+		t.Fatalf("expected at least 3 spans, got %d", toc.MaxSpanID+1)
+	}
+	checkpoints := toc.Checkpoints
+	cacheDir := t.TempDir()
+
+	var reads int
+	newSpanManager := func() *SpanManager {
+		c, err := cache.NewDirectoryCache(cacheDir, cache.DirectoryCacheConfig{SyncAdd: true, Direct: true, Persistent: true})
+		if err != nil {
+			t.Fatalf("failed to create cache: %v", err)
+		}
+		// New consumes the checkpoints of the ztoc.
+		toc.Checkpoints = checkpoints
+		sr := io.NewSectionReader(readerFn(func(b []byte, off int64) (int, error) {
+			reads++
+			return r.ReadAt(b, off)
+		}), 0, r.Size())
+		m, err := New(toc, sr, c, 0, digest.FromString(t.Name()), cache.Direct())
+		if err != nil {
+			t.Fatalf("failed to create span manager: %v", err)
+		}
+		m.SetPersistentCache()
+		return m
+	}
+
+	// Span 0 is cached uncompressed and span 1 compressed.
+	m := newSpanManager()
+	s0 := m.spans[0]
+	if _, err := m.getSpanContent(0, 0, s0.endUncompOffset-s0.startUncompOffset); err != nil {
+		t.Fatalf("failed to get span 0: %v", err)
+	}
+	if err := m.FetchSingleSpan(1); err != nil {
+		t.Fatalf("failed to fetch span 1: %v", err)
+	}
+	m.Close()
+
+	// After a restart, the cached spans are restored from their compressed contents
+	// without fetching them again.
+	reads = 0
+	m = newSpanManager()
+	defer m.Close()
+	reads = 0
+	for _, id := range []compression.SpanID{0, 1} {
+		if err := m.FetchSingleSpan(id); err != nil {
+			t.Fatalf("failed to restore span %d: %v", id, err)
+		}
+	}
+	if !m.spans[0].checkState(fetched) || !m.spans[1].checkState(fetched) {
+		t.Fatalf("unexpected states of restored spans: %v, %v", m.spans[0].state.Load(), m.spans[1].state.Load())
+	}
+	for _, id := range []compression.SpanID{0, 1} {
+		if err := m.ResolveSpan(id); err != nil {
+			t.Fatalf("failed to resolve span %d: %v", id, err)
+		}
+	}
+	if reads != 0 {
+		t.Fatalf("expected cached spans to be restored without fetching, got %d reads", reads)
+	}
+
+	// A span evicted from the cache is fetched again.
+	for _, key := range []string{"0.compressed", "0.uncompressed"} {
+		if err := os.Remove(filepath.Join(cacheDir, key)); err != nil {
+			t.Fatalf("failed to evict span 0: %v", err)
+		}
+	}
+	actual, err := getFileContentFromSpans(m, toc, fileName)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Fatalf("unexpected file content")
+	}
+	if reads == 0 {
+		t.Fatalf("expected the evicted span to be fetched again")
+	}
+	m.Close()
+
+	// After a restart, tampered uncompressed contents aren't served, and corrupted
+	// compressed contents are fetched again.
+	tampered := bytes.Repeat([]byte{'x'}, int(s0.endUncompOffset-s0.startUncompOffset))
+	if err := os.WriteFile(filepath.Join(cacheDir, "0.uncompressed"), tampered, 0600); err != nil {
+		t.Fatalf("failed to tamper with span 0: %v", err)
+	}
+	s1 := m.spans[1]
+	corrupted := bytes.Repeat([]byte{'x'}, int(s1.endCompOffset-s1.startCompOffset))
+	if err := os.WriteFile(filepath.Join(cacheDir, "1.compressed"), corrupted, 0600); err != nil {
+		t.Fatalf("failed to corrupt span 1: %v", err)
+	}
+	m = newSpanManager()
+	defer m.Close()
+	reads = 0
+	actual, err = getFileContentFromSpans(m, toc, fileName)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Fatalf("unexpected file content after tampering with the cache")
+	}
+	if reads == 0 {
+		t.Fatalf("expected the corrupted span to be fetched again")
+	}
+}
+
+func TestSpanManagerComplete(t *testing.T) {
+	var spanSize compression.Offset = 65536 // 64 KiB
+	tRand := testutil.NewTestRand(t)
+	tarEntries := []testutil.TarEntry{
+		testutil.File("complete-test", string(tRand.RandomByteData(int64(spanSize)*4))),
+	}
+	toc, r, err := ztoc.BuildZtocReader(t, tarEntries, gzip.DefaultCompression, int64(spanSize))
+	if err != nil {
+		t.Fatalf("failed to create ztoc: %v", err)
+	}
+	m, err := New(toc, r, cache.NewMemoryCache(), 0, digest.FromString(""))
+	if err != nil {
+		t.Fatalf("failed to create span manager: %v", err)
+	}
+	defer m.Close()
+
+	// Spans are cached compressed by the background fetcher or uncompressed by reads.
+	for i := compression.SpanID(0); i < toc.MaxSpanID; i++ {
+		if m.Complete() {
+			t.Fatalf("expected the layer not to be complete before span %d is cached", i)
+		}
+		if i%2 == 0 {
+			err = m.FetchSingleSpan(i)
+		} else {
+			err = m.ResolveSpan(i)
+		}
+		if err != nil {
+			t.Fatalf("failed to fetch span %d: %v", i, err)
+		}
+	}
+	if m.Complete() {
+		t.Fatalf("expected the layer not to be complete before the last span is cached")
+	}
+	if err := m.FetchSingleSpan(toc.MaxSpanID); err != nil {
+		t.Fatalf("failed to fetch span %d: %v", toc.MaxSpanID, err)
+	}
+	if !m.Complete() {
+		t.Fatalf("expected the layer to be complete once every span is cached")
+	}
+
+	gr, err := gzip.NewReader(io.NewSectionReader(r, 0, r.Size()))
+	if err != nil {
+		t.Fatalf("failed to decompress layer: %v", err)
+	}
+	want, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("failed to decompress layer: %v", err)
+	}
+	lr := m.UncompressedReader()
+	defer lr.Close()
+	got, err := io.ReadAll(lr)
+	if err != nil {
+		t.Fatalf("failed to read uncompressed layer: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("unexpected uncompressed layer: got %d bytes, want %d bytes", len(got), len(want))
+	}
+}
+
+func TestSpanManagerCached(t *testing.T) {
+	var spanSize compression.Offset = 65536 // 64 KiB
+	tRand := testutil.NewTestRand(t)
+	tarEntries := []testutil.TarEntry{
+		testutil.File("cached-test", string(tRand.RandomByteData(int64(spanSize)*3))),
+	}
+	toc, r, err := ztoc.BuildZtocReader(t, tarEntries, gzip.DefaultCompression, int64(spanSize))
+	if err != nil {
+		t.Fatalf("failed to create ztoc: %v", err)
+	}
+	m, err := New(toc, r, cache.NewMemoryCache(), 0, digest.FromString(""))
+	if err != nil {
+		t.Fatalf("failed to create span manager: %v", err)
+	}
+	defer m.Close()
+
+	s0, s1 := m.spans[0], m.spans[1]
+	if !m.Cached(s0.startUncompOffset, s0.startUncompOffset) {
+		t.Fatalf("expected an empty range to be cached")
+	}
+	if m.Cached(s0.startUncompOffset, s0.endUncompOffset) {
+		t.Fatalf("expected span 0 not to be cached before it is fetched")
+	}
+	if err := m.FetchSingleSpan(0); err != nil {
+		t.Fatalf("failed to fetch span 0: %v", err)
+	}
+	if !m.Cached(s0.startUncompOffset, s0.endUncompOffset) {
+		t.Fatalf("expected span 0 to be cached once fetched")
+	}
+	if m.Cached(s0.startUncompOffset, s1.startUncompOffset+1) {
+		t.Fatalf("expected a range overlapping span 1 not to be cached")
+	}
+	if err := m.ResolveSpan(1); err != nil {
+		t.Fatalf("failed to resolve span 1: %v", err)
+	}
+	if !m.Cached(s0.startUncompOffset, s1.endUncompOffset) {
+		t.Fatalf("expected spans 0 and 1 to be cached")
+	}
+
+	spans := m.Spans()
+	if len(spans) != int(toc.MaxSpanID)+1 {
+		t.Fatalf("unexpected number of spans: got %d, want %d", len(spans), toc.MaxSpanID+1)
+	}
+	for i, want := range []string{"fetched", "uncompressed", "unrequested"} {
+		if spans[i].State != want {
+			t.Fatalf("unexpected state of span %d: got %q, want %q", i, spans[i].State, want)
+		}
+	}
+	if spans[1].UncompressedOffset != s1.startUncompOffset || spans[1].UncompressedSize != s1.endUncompOffset-s1.startUncompOffset {
+		t.Fatalf("unexpected uncompressed range of span 1: %+v", spans[1])
+	}
+}
@@ -353,3 +353,90 @@ func readIndex(t *testing.T, sh *dockershell.Shell, indexDigest string) ocispec.
 	}
 	return idx
 }
+
+func TestStandaloneConvertDockerArchive(t *testing.T) {
+	regConfig := newRegistryConfig()
+	sh, done := newShellWithRegistry(t, regConfig)
+	defer done()
+
+	rebootContainerd(t, sh, getContainerdConfigToml(t, false), getSnapshotterConfigToml(t))
+
+	imageRef := nginxImage
+	mirrorImg := regConfig.mirror(imageRef)
+	srcRef := mirrorImg.ref
+
+	baseDir, err := testutil.TempDir(sh)
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer sh.X("rm", "-rf", baseDir)
+
+	inputDir := filepath.Join(baseDir, "input")
+	inputTar := filepath.Join(baseDir, "input.tar")
+	outputTar := filepath.Join(baseDir, "output.tar")
+
+	copyImage(sh, dockerhub(imageRef), mirrorImg)
+
+	// `nerdctl save` writes both an OCI image layout and the docker-archive manifest.json.
+	// Remove the OCI image layout to keep only the docker-archive.
+	exportToOCIDir(sh, srcRef, inputDir)
+	sh.X("rm", "-f", filepath.Join(inputDir, "index.json"), filepath.Join(inputDir, "oci-layout"))
+	sh.X("tar", "-cf", inputTar, "-C", inputDir, ".")
+
+	stopContainerd(t, sh)
+
+	sh.X("soci", "convert",
+		"--standalone",
+		"--min-layer-size=0",
+		inputTar,
+		outputTar,
+	)
+
+	rebootContainerd(t, sh, getContainerdConfigToml(t, false), getSnapshotterConfigToml(t))
+
+	dstRef := srcRef + "-standalone-docker-archive"
+	sh.X("ctr", "images", "import", "--no-unpack", "--index-name", dstRef, outputTar)
+
+	var sociIndexes int
+	for _, m := range readIndex(t, sh, getImageDigest(sh, dstRef)).Manifests {
+		if m.ArtifactType == soci.SociIndexArtifactTypeV2 {
+			sociIndexes++
+		}
+	}
+	if sociIndexes != 1 {
+		t.Fatalf("expected the converted docker-archive to have 1 SOCI index, got %d", sociIndexes)
+	}
+}
+
+func TestStandaloneConvertRegistry(t *testing.T) {
+	regConfig := newRegistryConfig()
+	sh, done := newShellWithRegistry(t, regConfig)
+	defer done()
+
+	rebootContainerd(t, sh, getContainerdConfigToml(t, false), getSnapshotterConfigToml(t))
+
+	imageRef := nginxImage
+	mirrorImg := regConfig.mirror(imageRef)
+	srcRef := mirrorImg.ref
+	dstRef := srcRef + "-standalone-registry"
+
+	copyImage(sh, dockerhub(imageRef), mirrorImg)
+	srcDigest := getImageDigest(sh, srcRef)
+
+	stopContainerd(t, sh)
+
+	sh.X("soci", "convert",
+		"--standalone",
+		"--format", "registry",
+		"--user", regConfig.creds(),
+		"--min-layer-size=0",
+		srcRef,
+		dstRef,
+	)
+
+	rebootContainerd(t, sh, getContainerdConfigToml(t, false), getSnapshotterConfigToml(t))
+
+	sh.X("nerdctl", "pull", "-q", dstRef)
+	dstDigest := getImageDigest(sh, dstRef)
+	validateConversion(t, sh, srcDigest, dstDigest)
+}
@@ -383,3 +383,66 @@ func TestSociIndexRemoveAndRebuildWithSharedLayers(t *testing.T) {
 		}
 	})
 }
+
+func TestSociIndexExportImport(t *testing.T) {
+	sh, done := newSnapshotterBaseShell(t)
+	defer done()
+	rebootContainerd(t, sh, "", "")
+
+	testImages := prepareCustomSociIndices(t, sh, []testImageIndex{{imgName: alpineImage}})
+	target := testImages[alpineImage]
+
+	archive := "/tmp/soci-index.tar"
+	sh.X("soci", "index", "export", "-o", archive, target.imgInfo.ref)
+	sh.X("soci", "index", "rm", target.sociIndexDigest)
+	if strings.Contains(string(sh.O("soci", "index", "list", "-q")), target.sociIndexDigest) {
+		t.Fatalf("index %s was not removed before import", target.sociIndexDigest)
+	}
+
+	sh.X("soci", "index", "import", archive)
+	if !strings.Contains(string(sh.O("soci", "index", "list", "-q")), target.sociIndexDigest) {
+		t.Fatalf("index %s was not imported", target.sociIndexDigest)
+	}
+	ztocsRaw := string(sh.O("soci", "ztoc", "list", "-q"))
+	for _, dgst := range target.ztocDigests {
+		if !strings.Contains(ztocsRaw, dgst) {
+			t.Fatalf("ztoc %s was not imported", dgst)
+		}
+	}
+	sh.X("soci", "index", "verify", target.sociIndexDigest)
+}
+
+func TestSociGC(t *testing.T) {
+	sh, done := newSnapshotterBaseShell(t)
+	defer done()
+	rebootContainerd(t, sh, "", "")
+
+	testImages := prepareCustomSociIndices(t, sh, []testImageIndex{{imgName: alpineImage}, {imgName: nginxImage}})
+	kept := testImages[alpineImage]
+	removed := testImages[nginxImage]
+	sh.X("nerdctl", "rmi", removed.imgInfo.ref)
+
+	dryRun := string(sh.O("soci", "gc", "--dry-run"))
+	if !strings.Contains(dryRun, removed.sociIndexDigest) || strings.Contains(dryRun, kept.sociIndexDigest) {
+		t.Fatalf("unexpected dry run output: %s", dryRun)
+	}
+	if !strings.Contains(string(sh.O("soci", "index", "list", "-q")), removed.sociIndexDigest) {
+		t.Fatalf("index %s was removed by a dry run", removed.sociIndexDigest)
+	}
+
+	sh.X("soci", "gc")
+	indexes := string(sh.O("soci", "index", "list", "-q"))
+	if strings.Contains(indexes, removed.sociIndexDigest) {
+		t.Fatalf("index %s of a removed image was not garbage collected", removed.sociIndexDigest)
+	}
+	if !strings.Contains(indexes, kept.sociIndexDigest) {
+		t.Fatalf("index %s of an existing image was garbage collected", kept.sociIndexDigest)
+	}
+	ztocs := string(sh.O("soci", "ztoc", "list", "-q"))
+	for _, dgst := range kept.ztocDigests {
+		if !strings.Contains(ztocs, dgst) {
+			t.Fatalf("ztoc %s of an existing image was garbage collected", dgst)
+		}
+	}
+	sh.X("soci", "index", "verify", kept.sociIndexDigest)
+}
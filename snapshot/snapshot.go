@@ -79,6 +79,10 @@ const (
 	deferredSnapshotLogKey = "defer-snapshot-runtime"
 	prepareSucceeded       = "true"
 	prepareFailed          = "false"
+
+	// materializedDirName is the directory of a remote snapshot into which its layer
+	// is extracted once it is fully fetched.
+	materializedDirName = "materialized"
 )
 
 var (
@@ -118,6 +122,13 @@ type FileSystem interface {
 	CleanImage(ctx context.Context, digest string) error
 }
 
+// MaterializedPath returns the directory into which the remote snapshot mounted on
+// `mountpoint` is extracted once its layer is fully fetched. Once the directory exists,
+// it is mounted instead of `mountpoint`, so that the layer is read without FUSE.
+func MaterializedPath(mountpoint string) string {
+	return filepath.Join(filepath.Dir(mountpoint), materializedDirName)
+}
+
 // SnapshotterConfig is used to configure the remote snapshotter instance
 type SnapshotterConfig struct {
 	asyncRemove bool
@@ -834,7 +845,7 @@ func (o *snapshotter) createSnapshot(ctx context.Context, kind snapshots.Kind, k
 	}
 
 	if len(s.ParentIDs) > 0 {
-		st, err := os.Stat(o.upperPath(s.ParentIDs[0]))
+		st, err := os.Stat(o.lowerPath(s.ParentIDs[0]))
 		if err != nil {
 			return storage.Snapshot{}, fmt.Errorf("failed to stat parent: %w", err)
 		}
@@ -917,7 +928,7 @@ func (o *snapshotter) mounts(ctx context.Context, s storage.Snapshot, checkKey s
 	} else if len(s.ParentIDs) == 1 {
 		return []mount.Mount{
 			{
-				Source: o.upperPath(s.ParentIDs[0]),
+				Source: o.lowerPath(s.ParentIDs[0]),
 				Type:   "bind",
 				Options: []string{
 					"ro",
@@ -951,9 +962,10 @@ func (o *snapshotter) getParentPaths(s storage.Snapshot) ([]string, error) {
 
 	for i, id := range s.ParentIDs {
 		if _, ok := o.idmapped.Load(s.ID); ok {
-			id = fmt.Sprintf("%s_%s", id, s.ID)
+			parentPaths[i] = o.upperPath(fmt.Sprintf("%s_%s", id, s.ID))
+			continue
 		}
-		parentPaths[i] = o.upperPath(id)
+		parentPaths[i] = o.lowerPath(id)
 	}
 
 	return parentPaths, nil
@@ -963,7 +975,7 @@ func (o *snapshotter) createIDMapMounts(ctx context.Context, s storage.Snapshot,
 	log.G(ctx).Debug("mapping ids")
 
 	for _, id := range s.ParentIDs {
-		err := o.createIDMapMount(ctx, o.upperPath(id), s.ID, idmap)
+		err := o.createIDMapMount(ctx, o.lowerPath(id), s.ID, idmap)
 		if err != nil {
 			return err
 		}
@@ -994,6 +1006,22 @@ func (o *snapshotter) upperPath(id string) string {
 	return filepath.Join(o.root, "snapshots", id, "fs")
 }
 
+// lowerPath produces the file path mounted for the snapshot `id` as a lower layer:
+// "{snapshotter.root}/snapshots/{id}/materialized" once a remote snapshot is
+// materialized, and upperPath otherwise.
+func (o *snapshotter) lowerPath(id string) string {
+	upper := o.upperPath(id)
+	if materialized := MaterializedPath(upper); isDir(materialized) {
+		return materialized
+	}
+	return upper
+}
+
+func isDir(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.IsDir()
+}
+
 // workPath produces a file path like "{snapshotter.root}/snapshots/{id}/work"
 func (o *snapshotter) workPath(id string) string {
 	return filepath.Join(o.root, "snapshots", id, "work")
@@ -1101,7 +1129,9 @@ func (o *snapshotter) checkAvailability(ctx context.Context, key string) bool {
 		}
 		mp := o.upperPath(id)
 		lCtx := log.WithLogger(ctx, log.G(ctx).WithField("mount-point", mp))
-		if _, ok := info.Labels[remoteLabel]; ok {
+		if _, ok := info.Labels[remoteLabel]; ok && isDir(MaterializedPath(mp)) {
+			log.G(lCtx).Debug("layer is materialized")
+		} else if ok {
 			eg.Go(func() error {
 				log.G(lCtx).Debug("checking mount point")
 				if err := o.fs.Check(egCtx, mp, info.Labels); err != nil {
@@ -1144,26 +1174,32 @@ func (o *snapshotter) restoreRemoteSnapshot(ctx context.Context) error {
 		return err
 	}
 	for _, info := range task {
-		if err := func() error {
+		id, err := func() (string, error) {
 			ctx, t, err := o.ms.TransactionContext(ctx, false)
 			if err != nil {
-				return err
+				return "", err
 			}
 			defer t.Rollback()
 			id, _, _, err := storage.GetInfo(ctx, info.Name)
 			if err != nil {
-				return err
+				return "", err
 			}
 			if err := os.Mkdir(filepath.Join(o.root, "snapshots", id), 0700); err != nil && !os.IsExist(err) {
-				return err
+				return "", err
 			}
 			if err := os.Mkdir(o.upperPath(id), 0755); err != nil && !os.IsExist(err) {
-				return err
+				return "", err
 			}
-			return nil
-		}(); err != nil {
+			return id, nil
+		}()
+		if err != nil {
 			return fmt.Errorf("failed to create remote snapshot directory: %s: %w", info.Name, err)
 		}
+		if isDir(MaterializedPath(o.upperPath(id))) {
+			// The layer was extracted before the restart, so it is mounted without FUSE.
+			log.G(ctx).WithField("key", info.Name).Debug("remote snapshot is materialized")
+			continue
+		}
 		ns, ok := info.Labels[source.TargetNamespace]
 		if !ok {
 			return ErrNoNamespace
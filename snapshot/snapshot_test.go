@@ -183,6 +183,50 @@ func TestRemoteOverlay(t *testing.T) {
 	}
 }
 
+func TestRemoteMaterialized(t *testing.T) {
+	testutil.RequiresRoot(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = namespaces.WithNamespace(ctx, namespaces.Default)
+	root := t.TempDir()
+	sn, err := NewSnapshotter(ctx, root, bindFileSystem(t))
+	if err != nil {
+		t.Fatalf("failed to make new remote snapshotter: %q", err)
+	}
+
+	// Prepare a remote snapshot and materialize it.
+	target := prepareWithTarget(ctx, t, sn, "testTarget", "/tmp/prepareTarget", "", nil)
+	defer failOnError(t, func() error { return sn.Remove(ctx, target) })
+	pKey := "/tmp/test"
+	if _, err := sn.Prepare(ctx, pKey, target); err != nil {
+		t.Fatalf("failed to prepare using lower remote layer: %v", err)
+	}
+	defer failOnError(t, func() error { return sn.Remove(ctx, pKey) })
+	lower := getParents(ctx, sn, root, pKey)[0]
+	materialized := MaterializedPath(lower)
+	if err := os.Mkdir(materialized, 0755); err != nil {
+		t.Fatalf("failed to create materialized layer: %v", err)
+	}
+
+	// Later mounts use the materialized layer instead of the remote snapshot.
+	mounts, err := sn.Mounts(ctx, pKey)
+	if err != nil {
+		t.Fatalf("failed to get mounts: %v", err)
+	}
+	if len(mounts) != 1 || mounts[0].Options[2] != "lowerdir="+materialized {
+		t.Fatalf("expected the materialized layer as lower directory, got %v", mounts)
+	}
+	vKey := "/tmp/view"
+	mounts, err = sn.View(ctx, vKey, target)
+	if err != nil {
+		t.Fatalf("failed to view remote snapshot: %v", err)
+	}
+	defer failOnError(t, func() error { return sn.Remove(ctx, vKey) })
+	if len(mounts) != 1 || mounts[0].Type != "bind" || mounts[0].Source != materialized {
+		t.Fatalf("expected a bind mount of the materialized layer, got %v", mounts)
+	}
+}
+
 func TestRemoteCommit(t *testing.T) {
 	testutil.RequiresRoot(t)
 	ctx, cancel := context.WithCancel(context.Background())
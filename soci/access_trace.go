@@ -0,0 +1,177 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/ztoc"
+	"github.com/awslabs/soci-snapshotter/ztoc/compression"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// AccessTraceVersion is the version of the access trace format.
+	AccessTraceVersion = "1.0"
+
+	accessTracesDirName = "access_traces"
+)
+
+// AccessTrace records which files of the lazily loaded layers of an image were read
+// during the first seconds after the image was mounted. It is written by the snapshotter
+// and turned into prefetch artifacts by `soci prefetch generate`.
+type AccessTrace struct {
+	Version             string        `json:"version"`
+	ImageManifestDigest digest.Digest `json:"image_manifest_digest"`
+	SociIndexDigest     digest.Digest `json:"soci_index_digest,omitempty"`
+	StartedAt           time.Time     `json:"started_at"`
+	// DurationSec is the length of the recording window.
+	DurationSec int64              `json:"duration_sec"`
+	Layers      []LayerAccessTrace `json:"layers"`
+}
+
+// LayerAccessTrace contains the files read from a layer.
+type LayerAccessTrace struct {
+	LayerDigest digest.Digest `json:"layer_digest"`
+	// Files are in the order in which they were first opened.
+	Files []FileAccessTrace `json:"files"`
+}
+
+// FileAccessTrace contains the ranges read from a file.
+type FileAccessTrace struct {
+	// Path is the path of the file in the layer, as it appears in the ztoc.
+	Path string `json:"path"`
+	// Ranges are the byte ranges read from the file. A file which was opened
+	// but never read has no ranges.
+	Ranges []AccessRange `json:"ranges,omitempty"`
+}
+
+// AccessRange is a range of bytes read from a file.
+type AccessRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// AccessTracePath returns the path of the access trace of an image manifest under
+// the snapshotter root directory.
+func AccessTracePath(root string, manifestDigest digest.Digest) string {
+	return filepath.Join(root, accessTracesDirName, manifestDigest.Algorithm().String(), manifestDigest.Encoded()+".json")
+}
+
+// WriteAccessTrace writes an access trace to `path`, creating its directory if needed.
+func WriteAccessTrace(path string, trace *AccessTrace) error {
+	b, err := json.Marshal(trace)
+	if err != nil {
+		return fmt.Errorf("failed to marshal access trace: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create access trace directory: %w", err)
+	}
+	// Write to a temporary file first so that readers never see a partial trace.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("failed to write access trace: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadAccessTrace reads an access trace written by `WriteAccessTrace`.
+func ReadAccessTrace(path string) (*AccessTrace, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access trace: %w", err)
+	}
+	var trace AccessTrace
+	if err := json.Unmarshal(b, &trace); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal access trace: %w", err)
+	}
+	if trace.Version != AccessTraceVersion {
+		return nil, fmt.Errorf("unsupported access trace version: %s (expected %s)", trace.Version, AccessTraceVersion)
+	}
+	return &trace, nil
+}
+
+// Files returns the paths of the files read in every layer of the trace, in the order
+// in which they were first opened within each layer. Files which were opened but never
+// read are skipped.
+func (t *AccessTrace) Files() []string {
+	var files []string
+	seen := make(map[string]struct{})
+	for _, l := range t.Layers {
+		for _, f := range l.Files {
+			if len(f.Ranges) == 0 {
+				continue
+			}
+			if _, ok := seen[f.Path]; ok {
+				continue
+			}
+			seen[f.Path] = struct{}{}
+			files = append(files, f.Path)
+		}
+	}
+	return files
+}
+
+// PrefetchSpansFromAccessTrace returns the spans of a layer which contain the ranges
+// read from `files`, including the tar headers of the files, which are read when a
+// file is verified. Files which don't exist in the ztoc are ignored.
+func PrefetchSpansFromAccessTrace(toc *ztoc.Ztoc, files []FileAccessTrace) ([]PrefetchSpan, error) {
+	zinfo, err := toc.Zinfo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get zinfo: %w", err)
+	}
+	defer zinfo.Close()
+
+	metadata := make(map[string]ztoc.FileMetadata, len(toc.FileMetadata))
+	for _, m := range toc.FileMetadata {
+		metadata[m.Name] = m
+	}
+
+	var spans []PrefetchSpan
+	addRange := func(start, end compression.Offset) {
+		if end < start {
+			end = start
+		}
+		spans = append(spans, PrefetchSpan{
+			StartSpan: zinfo.UncompressedOffsetToSpanID(start),
+			EndSpan:   zinfo.UncompressedOffsetToSpanID(end),
+		})
+	}
+	for _, f := range files {
+		// Names in the ztoc are cleaned when it is built, so clean the path the same way.
+		name := strings.TrimPrefix(filepath.Clean(string(os.PathSeparator)+f.Path), string(os.PathSeparator))
+		m, ok := metadata[name]
+		if !ok || len(f.Ranges) == 0 {
+			continue
+		}
+		addRange(m.TarHeaderOffset, m.UncompressedOffset)
+		for _, r := range f.Ranges {
+			if r.Offset < 0 || r.Length <= 0 || compression.Offset(r.Offset) >= m.UncompressedSize {
+				continue
+			}
+			start := m.UncompressedOffset + compression.Offset(r.Offset)
+			end := m.UncompressedOffset + min(compression.Offset(r.Offset+r.Length), m.UncompressedSize) - 1
+			addRange(start, end)
+		}
+	}
+	return normalizePrefetchSpans(spans), nil
+}
@@ -0,0 +1,170 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/util/testutil"
+	"github.com/awslabs/soci-snapshotter/ztoc"
+	"github.com/awslabs/soci-snapshotter/ztoc/compression"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestAccessTraceRoundTrip(t *testing.T) {
+	manifestDigest := digest.FromString("manifest")
+	trace := &AccessTrace{
+		Version:             AccessTraceVersion,
+		ImageManifestDigest: manifestDigest,
+		StartedAt:           time.Now().UTC().Round(time.Second),
+		DurationSec:         60,
+		Layers: []LayerAccessTrace{
+			{
+				LayerDigest: digest.FromString("layer1"),
+				Files: []FileAccessTrace{
+					{Path: "bin/sh", Ranges: []AccessRange{{Offset: 0, Length: 4096}}},
+					{Path: "etc/passwd"},
+				},
+			},
+			{
+				LayerDigest: digest.FromString("layer2"),
+				Files: []FileAccessTrace{
+					{Path: "app/main", Ranges: []AccessRange{{Offset: 0, Length: 10}}},
+					{Path: "bin/sh", Ranges: []AccessRange{{Offset: 0, Length: 10}}},
+				},
+			},
+		},
+	}
+
+	root := t.TempDir()
+	path := AccessTracePath(root, manifestDigest)
+	if want := filepath.Join(root, "access_traces", "sha256", manifestDigest.Encoded()+".json"); path != want {
+		t.Fatalf("unexpected trace path: got %s, want %s", path, want)
+	}
+	if err := WriteAccessTrace(path, trace); err != nil {
+		t.Fatalf("failed to write trace: %v", err)
+	}
+	got, err := ReadAccessTrace(path)
+	if err != nil {
+		t.Fatalf("failed to read trace: %v", err)
+	}
+	if !reflect.DeepEqual(got, trace) {
+		t.Fatalf("unexpected trace: got %+v, want %+v", got, trace)
+	}
+
+	// Files which were only opened are skipped, and duplicates are removed.
+	if files, want := got.Files(), []string{"bin/sh", "app/main"}; !reflect.DeepEqual(files, want) {
+		t.Fatalf("unexpected files: got %v, want %v", files, want)
+	}
+
+	trace.Version = "0.1"
+	if err := WriteAccessTrace(path, trace); err != nil {
+		t.Fatalf("failed to write trace: %v", err)
+	}
+	if _, err := ReadAccessTrace(path); err == nil {
+		t.Fatal("expected an error reading a trace with an unsupported version")
+	}
+}
+
+func TestPrefetchSpansFromAccessTrace(t *testing.T) {
+	r := testutil.NewTestRand(t)
+	layer, err := io.ReadAll(testutil.BuildTarGz([]testutil.TarEntry{
+		testutil.File("small", "hello"),
+		testutil.File("big", string(r.RandomByteData(400000))),
+		testutil.File("last", "world"),
+	}, gzip.BestSpeed))
+	if err != nil {
+		t.Fatalf("can't build layer: %v", err)
+	}
+	toc, err := ztoc.NewBuilder("test").BuildZtocFromReader(bytes.NewReader(layer), 1<<16, ztoc.WithCompression(compression.Gzip))
+	if err != nil {
+		t.Fatalf("can't build ztoc: %v", err)
+	}
+	zinfo, err := toc.Zinfo()
+	if err != nil {
+		t.Fatalf("can't get zinfo: %v", err)
+	}
+	defer zinfo.Close()
+
+	metadata := make(map[string]ztoc.FileMetadata)
+	for _, m := range toc.FileMetadata {
+		metadata[m.Name] = m
+	}
+	spanOf := func(name string, offset int64) compression.SpanID {
+		return zinfo.UncompressedOffsetToSpanID(metadata[name].UncompressedOffset + compression.Offset(offset))
+	}
+	headerSpan := zinfo.UncompressedOffsetToSpanID(metadata["big"].TarHeaderOffset)
+	lastSpan := spanOf("last", 0)
+
+	testcases := []struct {
+		name     string
+		files    []FileAccessTrace
+		expected []PrefetchSpan
+	}{
+		{
+			name: "unknown and unread files are ignored",
+			files: []FileAccessTrace{
+				{Path: "unknown", Ranges: []AccessRange{{Offset: 0, Length: 10}}},
+				{Path: "big"},
+			},
+		},
+		{
+			name: "read at the end of a file includes the tar header",
+			files: []FileAccessTrace{
+				{Path: "big", Ranges: []AccessRange{{Offset: 399990, Length: 10}}},
+			},
+			expected: normalizePrefetchSpans([]PrefetchSpan{
+				{StartSpan: headerSpan, EndSpan: headerSpan},
+				{StartSpan: spanOf("big", 399990), EndSpan: spanOf("big", 399999)},
+			}),
+		},
+		{
+			name: "reads are clamped to the file",
+			files: []FileAccessTrace{
+				{Path: "/./big", Ranges: []AccessRange{{Offset: 0, Length: 1 << 30}}},
+			},
+			expected: []PrefetchSpan{{StartSpan: headerSpan, EndSpan: spanOf("big", 399999)}},
+		},
+		{
+			name: "last file",
+			files: []FileAccessTrace{
+				{Path: "last", Ranges: []AccessRange{{Offset: 0, Length: 5}}},
+			},
+			expected: []PrefetchSpan{{StartSpan: zinfo.UncompressedOffsetToSpanID(metadata["last"].TarHeaderOffset), EndSpan: lastSpan}},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			spans, err := PrefetchSpansFromAccessTrace(toc, tc.files)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(spans) == 0 && len(tc.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(spans, tc.expected) {
+				t.Fatalf("unexpected spans: got %v, want %v", spans, tc.expected)
+			}
+		})
+	}
+}
@@ -50,7 +50,7 @@ import (
 //         - imageDigest: <string>      : the digest of the image index
 //         - platform: <string>         : the platform for the index
 //         - location: <string>         : the location of the artifact
-//         - type: <string>             : the type of the artifact (can be either "soci_index" or "soci_layer")
+//         - type: <string>             : the type of the artifact (e.g. "soci_index", "soci_layer" or "soci_signature")
 
 // ArtifactsDB is a store for SOCI artifact metadata
 type ArtifactsDb struct {
@@ -83,6 +83,8 @@ var (
 	ArtifactEntryTypeLayer ArtifactEntryType = "soci_layer"
 	// ArtifactEntryTypePrefetch indicates that an ArtifactEntry is a SOCI prefetch artifact
 	ArtifactEntryTypePrefetch ArtifactEntryType = "soci_prefetch"
+	// ArtifactEntryTypeSignature indicates that an ArtifactEntry is a SOCI index signature
+	ArtifactEntryTypeSignature ArtifactEntryType = "soci_signature"
 
 	db   *ArtifactsDb
 	once sync.Once
@@ -152,6 +154,23 @@ func NewDB(path string) (*ArtifactsDb, error) {
 	return db, nil
 }
 
+// OpenDB opens the artifacts db at `path` for a limited time, e.g. in a long running process which
+// must not prevent other processes from using the db. Unlike NewDB, the db is not shared in the
+// process, and it must be closed with Close. OpenDB waits at most `timeout` for other processes to
+// close the db.
+func OpenDB(path string, timeout time.Duration) (*ArtifactsDb, error) {
+	database, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open artifacts db %s: %w", path, err)
+	}
+	return &ArtifactsDb{db: database}, nil
+}
+
+// Close closes an artifacts db opened with OpenDB.
+func (db *ArtifactsDb) Close() error {
+	return db.db.Close()
+}
+
 func (db *ArtifactsDb) getIndexArtifactEntries(indexDigest string) ([]ArtifactEntry, error) {
 	artifactEntries := []ArtifactEntry{}
 	err := db.Walk(func(ae *ArtifactEntry) error {
@@ -392,6 +411,29 @@ func (db *ArtifactsDb) RemoveArtifactEntryByIndexDigest(digest []byte) error {
 	})
 }
 
+// RemoveArtifactEntries removes the artifact entries of `digests`, whatever their type.
+// Digests without an artifact entry are ignored.
+func (db *ArtifactsDb) RemoveArtifactEntries(digests ...string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := getArtifactsBucket(tx)
+		if err != nil {
+			if errors.Is(err, ErrArtifactBucketNotFound) {
+				return nil
+			}
+			return err
+		}
+		for _, dgst := range digests {
+			if bucket.Bucket([]byte(dgst)) == nil {
+				continue
+			}
+			if err := bucket.DeleteBucket([]byte(dgst)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 // GetArtifactEntriesByImageDigest returns all index digests greated from a given image digest
 func (db *ArtifactsDb) GetArtifactEntriesByImageDigest(digest string) ([][]byte, error) {
 	entries := make([][]byte, 0)
@@ -0,0 +1,450 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// maxGCIndexSize is the size above which blobs of a store are not read to find
+	// SOCI indexes without artifact entries. SOCI indexes are a few KiB per layer.
+	maxGCIndexSize = 4 << 20
+
+	gcReasonImageNotFound = "image not found"
+	gcReasonNotInStore    = "not found in the content store"
+	gcReasonLRU           = "least recently used"
+	gcReasonUnreferenced  = "not referenced by any index"
+)
+
+// GCOption is a functional argument for GarbageCollect.
+type GCOption func(*gcConfig)
+
+type gcConfig struct {
+	dryRun       bool
+	maxStoreSize int64
+	gracePeriod  time.Duration
+	namespaces   []string
+}
+
+// contexts returns the contexts in which the store is read and written.
+func (c *gcConfig) contexts(ctx context.Context) []context.Context {
+	if len(c.namespaces) == 0 {
+		return []context.Context{ctx}
+	}
+	ctxs := make([]context.Context, 0, len(c.namespaces))
+	for _, ns := range c.namespaces {
+		ctxs = append(ctxs, namespaces.WithNamespace(ctx, ns))
+	}
+	return ctxs
+}
+
+// GCWithDryRun reports the SOCI artifacts that would be removed without removing them.
+func GCWithDryRun() GCOption {
+	return func(c *gcConfig) {
+		c.dryRun = true
+	}
+}
+
+// GCWithMaxStoreSize removes the least recently used SOCI indexes, with their ztocs and
+// prefetch artifacts, until the SOCI artifacts in the store use at most `size` bytes.
+// A size of 0 means no limit.
+func GCWithMaxStoreSize(size int64) GCOption {
+	return func(c *gcConfig) {
+		c.maxStoreSize = size
+	}
+}
+
+// GCWithGracePeriod keeps the SOCI indexes used in the last `d`, even if their image
+// doesn't exist. The image of an index fetched by the snapshotter is only created
+// once the image is pulled. The artifacts created in the last `d` are kept as well,
+// even if no index references them, e.g. the ztocs of an index being built.
+func GCWithGracePeriod(d time.Duration) GCOption {
+	return func(c *gcConfig) {
+		c.gracePeriod = d
+	}
+}
+
+// GCWithNamespaces looks up and removes the SOCI artifacts in each of the containerd
+// `namespaces`, for stores which are namespaced like the containerd content store.
+// The artifacts db is shared by all namespaces, so an artifact is only missing from
+// the store if it is missing from every namespace.
+func GCWithNamespaces(namespaces ...string) GCOption {
+	return func(c *gcConfig) {
+		c.namespaces = namespaces
+	}
+}
+
+// GCRemoval is a SOCI artifact removed by GarbageCollect.
+type GCRemoval struct {
+	Digest digest.Digest
+	Size   int64
+	Type   ArtifactEntryType
+	Reason string
+}
+
+// GCReport describes the SOCI artifacts removed by GarbageCollect,
+// or that would be removed in dry run mode.
+type GCReport struct {
+	Removed []GCRemoval
+	// RemovedSize is the total size of the removed artifacts.
+	RemovedSize int64
+	// StoreSize is the total size of the SOCI artifacts that are kept.
+	StoreSize int64
+}
+
+// gcIndex is a SOCI index found in the artifacts db or in the store.
+type gcIndex struct {
+	desc ocispec.Descriptor
+	// entry is nil for indexes without artifact entry, e.g. fetched by the snapshotter.
+	entry *ArtifactEntry
+	// index is nil if the index is not in the store.
+	index      *Index
+	signatures []digest.Digest
+	lastUsed   time.Time
+}
+
+// referencedBy returns true if one of `digests` is the index, its image or its image manifest.
+func (i *gcIndex) referencedBy(digests map[digest.Digest]struct{}) bool {
+	refs := []digest.Digest{i.desc.Digest}
+	if i.entry != nil {
+		refs = append(refs, digest.Digest(i.entry.ImageDigest), digest.Digest(i.entry.OriginalDigest))
+	}
+	if i.index != nil && i.index.Subject != nil {
+		refs = append(refs, i.index.Subject.Digest)
+	}
+	for _, ref := range refs {
+		if _, ok := digests[ref]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GarbageCollect removes SOCI artifacts that are no longer needed from `blobStore` and
+// `artifactsDb`: the SOCI indexes whose image doesn't exist, i.e. none of `imageDigests`
+// is the index, its image or its image manifest, and the ztocs, prefetch artifacts and
+// signatures that are not referenced by any remaining index. SOCI indexes without artifact
+// entry, e.g. fetched by the snapshotter, are found in the store if it implements store.Walker.
+//
+// With GCWithMaxStoreSize, the least recently used indexes are removed as well, using the
+// usage recorded by the store if it implements store.UsageTracker.
+func GarbageCollect(ctx context.Context, blobStore store.Store, artifactsDb *ArtifactsDb, imageDigests map[digest.Digest]struct{}, opts ...GCOption) (*GCReport, error) {
+	var cfg gcConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	entries := make(map[digest.Digest]*ArtifactEntry)
+	err := artifactsDb.Walk(func(ae *ArtifactEntry) error {
+		entries[digest.Digest(ae.Digest)] = ae
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctxs := cfg.contexts(ctx)
+	indexes, err := findGCIndexes(ctxs, blobStore, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make(map[digest.Digest]GCRemoval)
+	removeIndex := func(i *gcIndex, reason string) {
+		removed[i.desc.Digest] = GCRemoval{Digest: i.desc.Digest, Size: i.desc.Size, Type: ArtifactEntryTypeIndex, Reason: reason}
+	}
+	var kept []*gcIndex
+	for _, i := range indexes {
+		switch {
+		case i.index == nil:
+			removeIndex(i, gcReasonNotInStore)
+		case i.referencedBy(imageDigests) || time.Since(i.lastUsed) < cfg.gracePeriod:
+			kept = append(kept, i)
+		default:
+			removeIndex(i, gcReasonImageNotFound)
+		}
+	}
+
+	sizes := make(map[digest.Digest]int64)
+	for dgst, entry := range entries {
+		sizes[dgst] = entry.Size
+	}
+	for _, i := range indexes {
+		sizes[i.desc.Digest] = i.desc.Size
+		if i.index != nil {
+			for _, blob := range i.index.Blobs {
+				sizes[blob.Digest] = blob.Size
+			}
+		}
+	}
+	storeSize := func(indexes []*gcIndex) int64 {
+		var size int64
+		for dgst := range gcReferences(indexes) {
+			size += sizes[dgst]
+		}
+		return size
+	}
+
+	if cfg.maxStoreSize > 0 {
+		sort.SliceStable(kept, func(a, b int) bool {
+			return kept[a].lastUsed.Before(kept[b].lastUsed)
+		})
+		for len(kept) > 0 && storeSize(kept) > cfg.maxStoreSize && time.Since(kept[0].lastUsed) >= cfg.gracePeriod {
+			removeIndex(kept[0], gcReasonLRU)
+			kept = kept[1:]
+		}
+	}
+
+	referenced := gcReferences(kept)
+	// Artifacts created within the grace period are kept even if no index references
+	// them, e.g. the ztocs written by `soci create` before the index being built.
+	recent := func(dgst digest.Digest) bool {
+		entry, ok := entries[dgst]
+		return ok && time.Since(entry.CreatedAt) < cfg.gracePeriod
+	}
+	for _, i := range indexes {
+		if _, ok := removed[i.desc.Digest]; !ok || i.index == nil {
+			continue
+		}
+		// Artifacts fetched by the snapshotter don't have artifact entries.
+		for _, blob := range i.index.Blobs {
+			if _, ok := referenced[blob.Digest]; ok {
+				continue
+			}
+			if _, ok := removed[blob.Digest]; ok || recent(blob.Digest) {
+				continue
+			}
+			removed[blob.Digest] = GCRemoval{Digest: blob.Digest, Size: blob.Size, Type: blobArtifactType(blob), Reason: gcReasonUnreferenced}
+		}
+	}
+	for dgst, entry := range entries {
+		if _, ok := referenced[dgst]; ok {
+			continue
+		}
+		if _, ok := removed[dgst]; ok || recent(dgst) {
+			continue
+		}
+		removed[dgst] = GCRemoval{Digest: dgst, Size: entry.Size, Type: entry.Type, Reason: gcReasonUnreferenced}
+	}
+
+	report := &GCReport{StoreSize: storeSize(kept)}
+	for _, r := range removed {
+		report.Removed = append(report.Removed, r)
+		report.RemovedSize += r.Size
+	}
+	// Indexes are removed first, so that a failure never leaves an index without its ztocs.
+	sort.Slice(report.Removed, func(a, b int) bool {
+		ra, rb := report.Removed[a], report.Removed[b]
+		if (ra.Type == ArtifactEntryTypeIndex) != (rb.Type == ArtifactEntryTypeIndex) {
+			return ra.Type == ArtifactEntryTypeIndex
+		}
+		return ra.Digest < rb.Digest
+	})
+	if cfg.dryRun {
+		return report, nil
+	}
+
+	digests := make([]string, 0, len(report.Removed))
+	for _, r := range report.Removed {
+		for _, ctx := range ctxs {
+			if err := blobStore.Delete(ctx, r.Digest); err != nil && !store.IsErrNotFound(err) {
+				return nil, fmt.Errorf("cannot remove %s from the content store: %w", r.Digest, err)
+			}
+		}
+		digests = append(digests, r.Digest.String())
+	}
+	if err := artifactsDb.RemoveArtifactEntries(digests...); err != nil {
+		return nil, fmt.Errorf("cannot remove artifact entries: %w", err)
+	}
+	return report, nil
+}
+
+// findGCIndexes reads the SOCI indexes of the artifacts db and of the store.
+func findGCIndexes(ctxs []context.Context, blobStore store.Store, entries map[digest.Digest]*ArtifactEntry) ([]*gcIndex, error) {
+	signatures := make(map[digest.Digest][]digest.Digest)
+	for dgst, entry := range entries {
+		if entry.Type == ArtifactEntryTypeSignature {
+			indexDigest := digest.Digest(entry.OriginalDigest)
+			signatures[indexDigest] = append(signatures[indexDigest], dgst)
+		}
+	}
+
+	var indexes []*gcIndex
+	newIndex := func(desc ocispec.Descriptor, entry *ArtifactEntry, index *Index) {
+		i := &gcIndex{desc: desc, entry: entry, index: index, signatures: signatures[desc.Digest]}
+		if tracker, ok := blobStore.(store.UsageTracker); ok && index != nil {
+			for _, ctx := range ctxs {
+				if lastUsed, err := tracker.LastUsed(ctx, desc.Digest); err == nil && lastUsed.After(i.lastUsed) {
+					i.lastUsed = lastUsed
+				}
+			}
+		}
+		if i.lastUsed.IsZero() && entry != nil {
+			i.lastUsed = entry.CreatedAt
+		}
+		indexes = append(indexes, i)
+	}
+
+	for dgst, entry := range entries {
+		if entry.Type != ArtifactEntryTypeIndex {
+			continue
+		}
+		desc := ocispec.Descriptor{MediaType: entry.MediaType, Digest: dgst, Size: entry.Size}
+		index, err := readGCIndex(ctxs, blobStore, desc)
+		if err != nil {
+			return nil, err
+		}
+		newIndex(desc, entry, index)
+	}
+
+	walker, ok := blobStore.(store.Walker)
+	if !ok {
+		return indexes, nil
+	}
+	seen := make(map[digest.Digest]struct{})
+	for _, ctx := range ctxs {
+		err := walker.Walk(ctx, func(desc ocispec.Descriptor) error {
+			if _, ok := entries[desc.Digest]; ok || desc.Size > maxGCIndexSize {
+				return nil
+			}
+			if _, ok := seen[desc.Digest]; ok {
+				return nil
+			}
+			seen[desc.Digest] = struct{}{}
+			index, err := readGCIndex([]context.Context{ctx}, blobStore, desc)
+			if err != nil || index == nil {
+				return err
+			}
+			if index.MediaType != ocispec.MediaTypeImageManifest ||
+				(index.ArtifactType != SociIndexArtifactTypeV1 && index.ArtifactType != SociIndexArtifactTypeV2) {
+				return nil
+			}
+			newIndex(desc, nil, index)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot walk the content store: %w", err)
+		}
+	}
+	return indexes, nil
+}
+
+// readGCIndex reads a SOCI index from the store in the first of `ctxs` where it is found.
+// It returns nil if the index is not in the store or if the blob is not a SOCI index.
+func readGCIndex(ctxs []context.Context, blobStore store.Store, desc ocispec.Descriptor) (*Index, error) {
+	var b []byte
+	for _, ctx := range ctxs {
+		var (
+			problem string
+			err     error
+		)
+		b, problem, err = fetchBlob(ctx, desc, blobStore)
+		if err != nil {
+			if store.IsErrNotFound(err) {
+				// The blob is in another namespace, or was removed after it was listed.
+				continue
+			}
+			return nil, err
+		}
+		if problem != "" {
+			return nil, nil
+		}
+		break
+	}
+	if b == nil {
+		return nil, nil
+	}
+	var index Index
+	if err := DecodeIndex(bytes.NewReader(b), &index); err != nil {
+		return nil, nil
+	}
+	return &index, nil
+}
+
+// gcReferences returns the digests of the indexes and of their config,
+// ztocs, prefetch artifacts and signatures.
+func gcReferences(indexes []*gcIndex) map[digest.Digest]struct{} {
+	refs := make(map[digest.Digest]struct{})
+	for _, i := range indexes {
+		refs[i.desc.Digest] = struct{}{}
+		refs[i.index.Config.Digest] = struct{}{}
+		for _, blob := range i.index.Blobs {
+			refs[blob.Digest] = struct{}{}
+		}
+		for _, sig := range i.signatures {
+			refs[sig] = struct{}{}
+		}
+	}
+	return refs
+}
+
+func blobArtifactType(blob ocispec.Descriptor) ArtifactEntryType {
+	if blob.MediaType == SociPrefetchMediaType {
+		return ArtifactEntryTypePrefetch
+	}
+	return ArtifactEntryTypeLayer
+}
+
+// ImageDigests returns the digests of the images of every containerd namespace, and of
+// the manifests of the images which are indexes, to find the SOCI indexes still needed
+// with GarbageCollect.
+func ImageDigests(ctx context.Context, nsStore namespaces.Store, imageStore images.Store, provider content.Provider) (map[digest.Digest]struct{}, error) {
+	nss, err := nsStore.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list namespaces: %w", err)
+	}
+	digests := make(map[digest.Digest]struct{})
+	for _, ns := range nss {
+		nsCtx := namespaces.WithNamespace(ctx, ns)
+		imgs, err := imageStore.List(nsCtx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list images of namespace %s: %w", ns, err)
+		}
+		for _, img := range imgs {
+			digests[img.Target.Digest] = struct{}{}
+			if !images.IsIndexType(img.Target.MediaType) {
+				continue
+			}
+			children, err := images.Children(nsCtx, provider, img.Target)
+			if err != nil {
+				if errors.Is(err, errdefs.ErrNotFound) {
+					log.G(ctx).WithField("image", img.Name).Debug("cannot read image index, only its digest keeps SOCI indexes")
+					continue
+				}
+				return nil, fmt.Errorf("cannot read image index of %s: %w", img.Name, err)
+			}
+			for _, child := range children {
+				digests[child.Digest] = struct{}{}
+			}
+		}
+	}
+	return digests, nil
+}
@@ -0,0 +1,262 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/awslabs/soci-snapshotter/util/testutil"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type gcTestEnv struct {
+	cs          content.Store
+	root        string
+	blobStore   *store.SociStore
+	artifactsDb *ArtifactsDb
+	builder     *IndexBuilder
+}
+
+func newGCTestEnv(t *testing.T) *gcTestEnv {
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("can't create content store: %v", err)
+	}
+	root := t.TempDir()
+	blobStore, err := store.NewSociStore(root)
+	if err != nil {
+		t.Fatalf("can't create SOCI store: %v", err)
+	}
+	artifactsDb, err := newTestableDb()
+	if err != nil {
+		t.Fatalf("can't create a test db: %v", err)
+	}
+	builder, err := NewIndexBuilder(cs, blobStore, WithArtifactsDb(artifactsDb), WithSpanSize(1<<16), WithMinLayerSize(0))
+	if err != nil {
+		t.Fatalf("can't create index builder: %v", err)
+	}
+	return &gcTestEnv{cs: cs, root: root, blobStore: blobStore, artifactsDb: artifactsDb, builder: builder}
+}
+
+func (e *gcTestEnv) build(ctx context.Context, t *testing.T, platform ocispec.Platform, data string) (ocispec.Descriptor, *IndexWithMetadata) {
+	img := newTestImage(ctx, t, e.cs, platform, testutil.File("app/main", data))
+	index, err := e.builder.Build(ctx, img, WithPlatform(platform))
+	if err != nil {
+		t.Fatalf("can't build SOCI index: %v", err)
+	}
+	return img.Target, index
+}
+
+// setLastUsed sets the time at which the SOCI store recorded the last use of a blob.
+func (e *gcTestEnv) setLastUsed(t *testing.T, dgst digest.Digest, lastUsed time.Time) {
+	path := filepath.Join(e.root, "blobs", dgst.Algorithm().String(), dgst.Encoded())
+	if err := os.Chtimes(path, lastUsed, lastUsed); err != nil {
+		t.Fatalf("can't set last use of %s: %v", dgst, err)
+	}
+}
+
+func (e *gcTestEnv) assertExists(ctx context.Context, t *testing.T, desc ocispec.Descriptor, expected bool) {
+	t.Helper()
+	exists, err := e.blobStore.Exists(ctx, desc)
+	if err != nil {
+		t.Fatalf("can't check %s: %v", desc.Digest, err)
+	}
+	if exists != expected {
+		t.Fatalf("expected blob %s to exist: %v, got %v", desc.Digest, expected, exists)
+	}
+	_, err = e.artifactsDb.GetArtifactEntry(desc.Digest.String())
+	if (err == nil) != expected {
+		t.Fatalf("expected artifact entry %s to exist: %v, got error %v", desc.Digest, expected, err)
+	}
+}
+
+func gcReasons(report *GCReport) map[digest.Digest]string {
+	reasons := make(map[digest.Digest]string)
+	for _, r := range report.Removed {
+		reasons[r.Digest] = r.Reason
+	}
+	return reasons
+}
+
+func TestGarbageCollect(t *testing.T) {
+	ctx := context.Background()
+	r := testutil.NewTestRand(t)
+	env := newGCTestEnv(t)
+
+	shared := string(r.RandomByteData(100000))
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	kept, keptIndex := env.build(ctx, t, amd64, shared)
+	_, sharedIndex := env.build(ctx, t, arm64, shared)
+	_, removedIndex := env.build(ctx, t, amd64, string(r.RandomByteData(100000)))
+
+	if keptIndex.Desc.Digest == sharedIndex.Desc.Digest || keptIndex.Index.Blobs[0].Digest != sharedIndex.Index.Blobs[0].Digest {
+		t.Fatalf("expected different SOCI indexes sharing a ztoc")
+	}
+
+	imageDigests := map[digest.Digest]struct{}{kept.Digest: {}}
+	dryRun, err := GarbageCollect(ctx, env.blobStore, env.artifactsDb, imageDigests, GCWithDryRun())
+	if err != nil {
+		t.Fatalf("can't garbage collect: %v", err)
+	}
+	expected := map[digest.Digest]string{
+		sharedIndex.Desc.Digest:            gcReasonImageNotFound,
+		removedIndex.Desc.Digest:           gcReasonImageNotFound,
+		removedIndex.Index.Blobs[0].Digest: gcReasonUnreferenced,
+	}
+	if reasons := gcReasons(dryRun); len(reasons) != len(expected) {
+		t.Fatalf("expected removals %v, got %v", expected, reasons)
+	}
+	for dgst, reason := range gcReasons(dryRun) {
+		if expected[dgst] != reason {
+			t.Fatalf("expected %s to be removed with reason %q, got %q", dgst, expected[dgst], reason)
+		}
+	}
+	// A dry run doesn't remove anything.
+	env.assertExists(ctx, t, removedIndex.Desc, true)
+	env.assertExists(ctx, t, removedIndex.Index.Blobs[0], true)
+
+	report, err := GarbageCollect(ctx, env.blobStore, env.artifactsDb, imageDigests)
+	if err != nil {
+		t.Fatalf("can't garbage collect: %v", err)
+	}
+	if report.RemovedSize != dryRun.RemovedSize || report.StoreSize != dryRun.StoreSize {
+		t.Fatalf("expected the same report as the dry run %+v, got %+v", dryRun, report)
+	}
+	env.assertExists(ctx, t, keptIndex.Desc, true)
+	env.assertExists(ctx, t, keptIndex.Index.Blobs[0], true)
+	env.assertExists(ctx, t, sharedIndex.Desc, false)
+	env.assertExists(ctx, t, removedIndex.Desc, false)
+	env.assertExists(ctx, t, removedIndex.Index.Blobs[0], false)
+
+	report, err = GarbageCollect(ctx, env.blobStore, env.artifactsDb, imageDigests)
+	if err != nil {
+		t.Fatalf("can't garbage collect: %v", err)
+	}
+	if len(report.Removed) != 0 {
+		t.Fatalf("expected nothing left to remove, got %+v", report.Removed)
+	}
+}
+
+func TestGarbageCollectMaxStoreSize(t *testing.T) {
+	ctx := context.Background()
+	r := testutil.NewTestRand(t)
+	env := newGCTestEnv(t)
+
+	platform := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	oldImage, oldIndex := env.build(ctx, t, platform, string(r.RandomByteData(100000)))
+	recentImage, recentIndex := env.build(ctx, t, platform, string(r.RandomByteData(100000)))
+	env.setLastUsed(t, oldIndex.Desc.Digest, time.Now().Add(-2*time.Hour))
+	env.setLastUsed(t, recentIndex.Desc.Digest, time.Now().Add(-time.Hour))
+
+	imageDigests := map[digest.Digest]struct{}{oldImage.Digest: {}, recentImage.Digest: {}}
+	maxStoreSize := recentIndex.Desc.Size
+	for _, blob := range recentIndex.Index.Blobs {
+		maxStoreSize += blob.Size
+	}
+	report, err := GarbageCollect(ctx, env.blobStore, env.artifactsDb, imageDigests, GCWithMaxStoreSize(maxStoreSize))
+	if err != nil {
+		t.Fatalf("can't garbage collect: %v", err)
+	}
+	if report.StoreSize != maxStoreSize {
+		t.Fatalf("expected store size %d, got %d", maxStoreSize, report.StoreSize)
+	}
+	if reasons := gcReasons(report); reasons[oldIndex.Desc.Digest] != gcReasonLRU || len(reasons) != 2 {
+		t.Fatalf("expected the least recently used index to be removed, got %+v", report.Removed)
+	}
+	env.assertExists(ctx, t, oldIndex.Desc, false)
+	env.assertExists(ctx, t, oldIndex.Index.Blobs[0], false)
+	env.assertExists(ctx, t, recentIndex.Desc, true)
+	env.assertExists(ctx, t, recentIndex.Index.Blobs[0], true)
+}
+
+func TestGarbageCollectGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	r := testutil.NewTestRand(t)
+	env := newGCTestEnv(t)
+
+	platform := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	_, recentIndex := env.build(ctx, t, platform, string(r.RandomByteData(100000)))
+	_, oldIndex := env.build(ctx, t, platform, string(r.RandomByteData(100000)))
+	env.setLastUsed(t, oldIndex.Desc.Digest, time.Now().Add(-time.Hour))
+
+	// SOCI indexes fetched by the snapshotter don't have artifact entries.
+	err := env.artifactsDb.RemoveArtifactEntries(oldIndex.Desc.Digest.String(), oldIndex.Index.Blobs[0].Digest.String())
+	if err != nil {
+		t.Fatalf("can't remove artifact entries: %v", err)
+	}
+
+	report, err := GarbageCollect(ctx, env.blobStore, env.artifactsDb, nil, GCWithGracePeriod(10*time.Minute))
+	if err != nil {
+		t.Fatalf("can't garbage collect: %v", err)
+	}
+	if reasons := gcReasons(report); reasons[oldIndex.Desc.Digest] != gcReasonImageNotFound || len(reasons) != 2 {
+		t.Fatalf("expected only the index used before the grace period to be removed, got %+v", report.Removed)
+	}
+	env.assertExists(ctx, t, recentIndex.Desc, true)
+	if exists, _ := env.blobStore.Exists(ctx, oldIndex.Desc); exists {
+		t.Fatalf("expected SOCI index %s to be removed", oldIndex.Desc.Digest)
+	}
+	if exists, _ := env.blobStore.Exists(ctx, oldIndex.Index.Blobs[0]); exists {
+		t.Fatalf("expected ztoc %s to be removed", oldIndex.Index.Blobs[0].Digest)
+	}
+}
+
+func TestGarbageCollectGracePeriodKeepsNewArtifacts(t *testing.T) {
+	ctx := context.Background()
+	r := testutil.NewTestRand(t)
+	env := newGCTestEnv(t)
+
+	// `soci create` writes the ztocs before the index, so a ztoc can exist without
+	// any index referencing it yet.
+	platform := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	_, index := env.build(ctx, t, platform, string(r.RandomByteData(100000)))
+	if err := env.blobStore.Delete(ctx, index.Desc.Digest); err != nil {
+		t.Fatalf("can't remove SOCI index: %v", err)
+	}
+	if err := env.artifactsDb.RemoveArtifactEntries(index.Desc.Digest.String()); err != nil {
+		t.Fatalf("can't remove artifact entry: %v", err)
+	}
+	ztoc := index.Index.Blobs[0]
+
+	report, err := GarbageCollect(ctx, env.blobStore, env.artifactsDb, nil, GCWithGracePeriod(10*time.Minute))
+	if err != nil {
+		t.Fatalf("can't garbage collect: %v", err)
+	}
+	if len(report.Removed) != 0 {
+		t.Fatalf("expected the new ztoc to be kept, got %+v", report.Removed)
+	}
+	env.assertExists(ctx, t, ztoc, true)
+
+	// Once the grace period is over, the unreferenced ztoc is removed.
+	report, err = GarbageCollect(ctx, env.blobStore, env.artifactsDb, nil)
+	if err != nil {
+		t.Fatalf("can't garbage collect: %v", err)
+	}
+	if reasons := gcReasons(report); reasons[ztoc.Digest] != gcReasonUnreferenced || len(reasons) != 1 {
+		t.Fatalf("expected the unreferenced ztoc to be removed, got %+v", report.Removed)
+	}
+	env.assertExists(ctx, t, ztoc, false)
+}
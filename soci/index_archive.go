@@ -0,0 +1,353 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	orascontent "oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/oci"
+)
+
+const (
+	// IndexArchiveAnnotationArtifactEntries is the annotation of a SOCI index in the
+	// `index.json` of an archive created by `ExportIndexes`. It holds the JSON encoded
+	// artifacts db entries of the index, its ztocs, prefetch artifacts and signatures.
+	IndexArchiveAnnotationArtifactEntries = "com.amazon.soci.artifact-entries"
+)
+
+// ExportIndexes writes the SOCI indexes `indexDigests` to `w` as a tar archive of an
+// OCI image layout. The archive contains the indexes with their ztocs, prefetch artifacts
+// and signatures, and the artifacts db entries of every artifact, so that the indexes
+// can be added to the SOCI store of another host with `ImportIndexes`.
+func ExportIndexes(ctx context.Context, w io.Writer, blobStore store.Store, artifactsDb *ArtifactsDb, indexDigests ...digest.Digest) error {
+	tw := tar.NewWriter(w)
+	layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, ocispec.ImageLayoutFile, bytes.NewReader(layout), int64(len(layout))); err != nil {
+		return err
+	}
+
+	ociIndex := ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+	}
+	written := make(map[digest.Digest]struct{})
+	for _, indexDigest := range indexDigests {
+		desc, err := exportIndex(ctx, tw, blobStore, artifactsDb, indexDigest, written)
+		if err != nil {
+			return fmt.Errorf("cannot export SOCI index %s: %w", indexDigest, err)
+		}
+		ociIndex.Manifests = append(ociIndex.Manifests, desc)
+	}
+
+	b, err := json.Marshal(ociIndex)
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, ocispec.ImageIndexFile, bytes.NewReader(b), int64(len(b))); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// exportIndex writes the blobs of a SOCI index and of its signatures to `tw` and returns the
+// descriptor of the index for `index.json`. Blobs in `written` are skipped.
+func exportIndex(ctx context.Context, tw *tar.Writer, blobStore store.Store, artifactsDb *ArtifactsDb, indexDigest digest.Digest, written map[digest.Digest]struct{}) (ocispec.Descriptor, error) {
+	indexEntry, err := artifactsDb.GetArtifactEntry(indexDigest.String())
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if indexEntry.Type != ArtifactEntryTypeIndex {
+		return ocispec.Descriptor{}, fmt.Errorf("artifact is a %s, not a SOCI index: %w", indexEntry.Type, errdefs.ErrInvalidArgument)
+	}
+	desc := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: indexEntry.ArtifactType,
+		Digest:       indexDigest,
+		Size:         indexEntry.Size,
+	}
+	manifest, err := exportManifest(ctx, tw, blobStore, desc, written)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	entries := []*ArtifactEntry{indexEntry}
+	for _, blob := range manifest.Layers {
+		entry, err := artifactsDb.GetArtifactEntry(blob.Digest.String())
+		if err != nil {
+			// Entries of ztocs are only informational, e.g. for `soci ztoc list`.
+			if errors.Is(err, errdefs.ErrNotFound) {
+				continue
+			}
+			return ocispec.Descriptor{}, err
+		}
+		entries = append(entries, entry)
+	}
+
+	signatures, err := artifactsDb.GetIndexSignatures(indexDigest.String())
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	for _, sig := range signatures {
+		if _, err := exportManifest(ctx, tw, blobStore, sig, written); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		entry, err := artifactsDb.GetArtifactEntry(sig.Digest.String())
+		if err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		entries = append(entries, entry)
+	}
+
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc.Annotations = map[string]string{
+		IndexArchiveAnnotationArtifactEntries: string(b),
+	}
+	return desc, nil
+}
+
+// exportManifest writes a manifest, its config and its layers to `tw` and returns the manifest.
+func exportManifest(ctx context.Context, tw *tar.Writer, blobStore store.Store, desc ocispec.Descriptor, written map[digest.Digest]struct{}) (*ocispec.Manifest, error) {
+	b, problem, err := fetchBlob(ctx, desc, blobStore)
+	if err != nil {
+		return nil, err
+	}
+	if problem != "" {
+		return nil, fmt.Errorf("manifest %s: %s", desc.Digest, problem)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal manifest %s: %w", desc.Digest, err)
+	}
+
+	if err := writeTarBlob(tw, desc.Digest, bytes.NewReader(b), desc.Size, written); err != nil {
+		return nil, err
+	}
+	for _, blob := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+		if _, ok := written[blob.Digest]; ok {
+			continue
+		}
+		rc, err := blobStore.Fetch(ctx, blob)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch blob %s: %w", blob.Digest, err)
+		}
+		err = writeTarBlob(tw, blob.Digest, rc, blob.Size, written)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &manifest, nil
+}
+
+func writeTarBlob(tw *tar.Writer, dgst digest.Digest, r io.Reader, size int64, written map[digest.Digest]struct{}) error {
+	if _, ok := written[dgst]; ok {
+		return nil
+	}
+	name := path.Join(ocispec.ImageBlobsDir, dgst.Algorithm().String(), dgst.Encoded())
+	if err := writeTarFile(tw, name, r, size); err != nil {
+		return fmt.Errorf("cannot write blob %s: %w", dgst, err)
+	}
+	written[dgst] = struct{}{}
+	return nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, r io.Reader, size int64) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+// ImportIndexes adds the SOCI indexes of an archive created by `ExportIndexes` at `archivePath`
+// to `blobStore` and `artifactsDb`, with their ztocs, prefetch artifacts and signatures.
+// Like indexes created by `Build`, SOCI index manifests v1 are garbage collection roots.
+// SOCI index manifests v2 are kept by the image which references them, so the SOCI enabled
+// image must be imported as well.
+//
+// It returns the descriptors of the imported indexes.
+func ImportIndexes(ctx context.Context, archivePath string, blobStore store.Store, artifactsDb *ArtifactsDb) ([]ocispec.Descriptor, error) {
+	ociIndex, err := readArchiveIndex(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := oci.NewFromTar(ctx, archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read OCI image layout archive: %w", err)
+	}
+
+	var imported []ocispec.Descriptor
+	for _, desc := range ociIndex.Manifests {
+		encodedEntries, ok := desc.Annotations[IndexArchiveAnnotationArtifactEntries]
+		if !ok {
+			return nil, fmt.Errorf("manifest %s of the archive is not a SOCI index: missing %s annotation: %w",
+				desc.Digest, IndexArchiveAnnotationArtifactEntries, errdefs.ErrInvalidArgument)
+		}
+		var entries []*ArtifactEntry
+		if err := json.Unmarshal([]byte(encodedEntries), &entries); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal artifact entries of SOCI index %s: %w", desc.Digest, err)
+		}
+		desc.Annotations = nil
+		if err := importIndex(ctx, archive, blobStore, artifactsDb, desc, entries); err != nil {
+			return nil, fmt.Errorf("cannot import SOCI index %s: %w", desc.Digest, err)
+		}
+		imported = append(imported, desc)
+	}
+	return imported, nil
+}
+
+// importIndex copies a SOCI index and its signatures from `archive` to `blobStore`,
+// labels them for garbage collection and writes their artifacts db entries.
+func importIndex(ctx context.Context, archive orascontent.ReadOnlyStorage, blobStore store.Store, artifactsDb *ArtifactsDb, desc ocispec.Descriptor, entries []*ArtifactEntry) error {
+	var (
+		indexEntry *ArtifactEntry
+		signatures []ocispec.Descriptor
+	)
+	for _, entry := range entries {
+		switch {
+		case entry.Digest == desc.Digest.String():
+			indexEntry = entry
+		case entry.Type == ArtifactEntryTypeSignature:
+			signatures = append(signatures, ocispec.Descriptor{
+				MediaType:    entry.MediaType,
+				ArtifactType: entry.ArtifactType,
+				Digest:       digest.Digest(entry.Digest),
+				Size:         entry.Size,
+			})
+		}
+	}
+	if indexEntry == nil || indexEntry.Type != ArtifactEntryTypeIndex {
+		return fmt.Errorf("missing artifact entry of the index: %w", errdefs.ErrInvalidArgument)
+	}
+
+	// Blobs are copied before the manifests that reference them, and the
+	// manifests are labeled, so that they are never collected in between.
+	manifest, err := importManifest(ctx, archive, blobStore, desc)
+	if err != nil {
+		return err
+	}
+	if err := labelSociIndex(ctx, blobStore, desc, manifest.Layers, indexEntry.ArtifactType == SociIndexArtifactTypeV1); err != nil {
+		return err
+	}
+	for _, sig := range signatures {
+		if _, err := importManifest(ctx, archive, blobStore, sig); err != nil {
+			return err
+		}
+		err = store.LabelGCRefContent(ctx, blobStore, desc, "signature", sig.Digest.String())
+		if err != nil {
+			return fmt.Errorf("cannot apply garbage collection label to index %s referencing signature: %w", desc.Digest, err)
+		}
+	}
+
+	for _, entry := range entries {
+		if err := artifactsDb.WriteArtifactEntry(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importManifest copies the config and the layers of a manifest, then the manifest
+// itself, from `archive` to `blobStore`, and returns the manifest.
+func importManifest(ctx context.Context, archive orascontent.ReadOnlyStorage, blobStore store.Store, desc ocispec.Descriptor) (*ocispec.Manifest, error) {
+	b, err := orascontent.FetchAll(ctx, archive, desc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest %s: %w", desc.Digest, err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal manifest %s: %w", desc.Digest, err)
+	}
+
+	for _, blob := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+		if err := importBlob(ctx, archive, blobStore, blob); err != nil {
+			return nil, err
+		}
+	}
+	err = blobStore.Push(ctx, desc, bytes.NewReader(b))
+	if err != nil && !store.IsErrAlreadyExists(err) {
+		return nil, fmt.Errorf("cannot write manifest %s to local store: %w", desc.Digest, err)
+	}
+	return &manifest, nil
+}
+
+func importBlob(ctx context.Context, archive orascontent.ReadOnlyStorage, blobStore store.Store, desc ocispec.Descriptor) error {
+	rc, err := archive.Fetch(ctx, desc)
+	if err != nil {
+		return fmt.Errorf("cannot read blob %s: %w", desc.Digest, err)
+	}
+	defer rc.Close()
+	// Stores verify the digest of pushed content.
+	err = blobStore.Push(ctx, desc, rc)
+	if err != nil && !store.IsErrAlreadyExists(err) {
+		return fmt.Errorf("cannot write blob %s to local store: %w", desc.Digest, err)
+	}
+	return nil
+}
+
+// readArchiveIndex reads the `index.json` of an OCI image layout archive.
+func readArchiveIndex(archivePath string) (*ocispec.Index, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found in archive: %w", ocispec.ImageIndexFile, errdefs.ErrNotFound)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read archive: %w", err)
+		}
+		if path.Clean(hdr.Name) != ocispec.ImageIndexFile {
+			continue
+		}
+		var index ocispec.Index
+		if err := json.NewDecoder(tr).Decode(&index); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal %s: %w", ocispec.ImageIndexFile, err)
+		}
+		return &index, nil
+	}
+}
@@ -0,0 +1,206 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/util/testutil"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content/oci"
+)
+
+// digestMemoryStore is an in-memory Store. Like the SOCI and containerd content stores,
+// it identifies blobs by digest, regardless of the media type of the descriptors.
+type digestMemoryStore struct {
+	*OrasMemoryStore
+}
+
+func newDigestMemoryStore() digestMemoryStore {
+	return digestMemoryStore{NewOrasMemoryStore()}
+}
+
+func blobDescriptor(desc ocispec.Descriptor) ocispec.Descriptor {
+	return ocispec.Descriptor{Digest: desc.Digest, Size: desc.Size}
+}
+
+func (m digestMemoryStore) Exists(ctx context.Context, target ocispec.Descriptor) (bool, error) {
+	return m.OrasMemoryStore.Exists(ctx, blobDescriptor(target))
+}
+
+func (m digestMemoryStore) Fetch(ctx context.Context, target ocispec.Descriptor) (io.ReadCloser, error) {
+	return m.OrasMemoryStore.Fetch(ctx, blobDescriptor(target))
+}
+
+func (m digestMemoryStore) Push(ctx context.Context, expected ocispec.Descriptor, reader io.Reader) error {
+	return m.OrasMemoryStore.Push(ctx, blobDescriptor(expected), reader)
+}
+
+// newTestImage writes a single layer image with `entries` to `cs`.
+func newTestImage(ctx context.Context, t *testing.T, cs content.Store, platform ocispec.Platform, entries ...testutil.TarEntry) images.Image {
+	writeBlob := func(mediaType string, b []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{
+			MediaType: mediaType,
+			Digest:    digest.FromBytes(b),
+			Size:      int64(len(b)),
+		}
+		if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+			t.Fatalf("can't write blob: %v", err)
+		}
+		return desc
+	}
+	writeJSON := func(mediaType string, v any) ocispec.Descriptor {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("can't marshal %s: %v", mediaType, err)
+		}
+		return writeBlob(mediaType, b)
+	}
+
+	layer, err := io.ReadAll(testutil.BuildTarGz(entries, gzip.BestSpeed))
+	if err != nil {
+		t.Fatalf("can't build layer: %v", err)
+	}
+	layerDesc := writeBlob(ocispec.MediaTypeImageLayerGzip, layer)
+	configDesc := writeJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: platform,
+		RootFS: ocispec.RootFS{
+			Type: "layers",
+		},
+	})
+	manifestDesc := writeJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	})
+	return images.Image{Name: "example.com/test:latest", Target: manifestDesc}
+}
+
+func TestExportImportIndexes(t *testing.T) {
+	ctx := context.Background()
+	r := testutil.NewTestRand(t)
+
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("can't create content store: %v", err)
+	}
+	platform := platforms.DefaultSpec()
+	img := newTestImage(ctx, t, cs, platform, testutil.File("app/main", string(r.RandomByteData(200000))))
+
+	artifactsDb, err := newTestableDb()
+	if err != nil {
+		t.Fatalf("can't create a test db: %v", err)
+	}
+	blobStore := newDigestMemoryStore()
+	builder, err := NewIndexBuilder(cs, blobStore, WithArtifactsDb(artifactsDb), WithSpanSize(1<<16), WithMinLayerSize(0),
+		WithPrefetchPaths([]string{"/app/main"}))
+	if err != nil {
+		t.Fatalf("can't create index builder: %v", err)
+	}
+	index, err := builder.Build(ctx, img, WithPlatform(platform))
+	if err != nil {
+		t.Fatalf("can't build SOCI index: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("can't generate key: %v", err)
+	}
+	sigDesc, err := WriteIndexSignature(ctx, blobStore, artifactsDb, key, index.Desc)
+	if err != nil {
+		t.Fatalf("can't sign SOCI index: %v", err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "soci.tar")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("can't create archive: %v", err)
+	}
+	err = ExportIndexes(ctx, f, blobStore, artifactsDb, index.Desc.Digest)
+	f.Close()
+	if err != nil {
+		t.Fatalf("can't export SOCI index: %v", err)
+	}
+
+	// The archive is a valid OCI image layout.
+	archive, err := oci.NewFromTar(ctx, archivePath)
+	if err != nil {
+		t.Fatalf("can't read archive as an OCI image layout: %v", err)
+	}
+	if exists, err := archive.Exists(ctx, index.Desc); err != nil || !exists {
+		t.Fatalf("expected SOCI index in the archive, exists=%v err=%v", exists, err)
+	}
+
+	importedDb, err := newTestableDb()
+	if err != nil {
+		t.Fatalf("can't create a test db: %v", err)
+	}
+	importedStore := newDigestMemoryStore()
+	imported, err := ImportIndexes(ctx, archivePath, importedStore, importedDb)
+	if err != nil {
+		t.Fatalf("can't import SOCI index: %v", err)
+	}
+	if len(imported) != 1 || imported[0].Digest != index.Desc.Digest {
+		t.Fatalf("expected SOCI index %s to be imported, got %+v", index.Desc.Digest, imported)
+	}
+
+	blobs := append([]ocispec.Descriptor{index.Desc, sigDesc, index.Index.Config, signatureConfigDescriptor, ocispec.DescriptorEmptyJSON}, index.Index.Blobs...)
+	for _, blob := range blobs {
+		if exists, err := importedStore.Exists(ctx, blob); err != nil || !exists {
+			t.Fatalf("expected blob %s to be imported, exists=%v err=%v", blob.Digest, exists, err)
+		}
+	}
+
+	digests := []string{index.Desc.Digest.String(), sigDesc.Digest.String()}
+	for _, blob := range index.Index.Blobs {
+		digests = append(digests, blob.Digest.String())
+	}
+	for _, dgst := range digests {
+		expected, err := artifactsDb.GetArtifactEntry(dgst)
+		if err != nil {
+			t.Fatalf("can't get artifact entry %s: %v", dgst, err)
+		}
+		actual, err := importedDb.GetArtifactEntry(dgst)
+		if err != nil {
+			t.Fatalf("expected artifact entry %s to be imported: %v", dgst, err)
+		}
+		if !expected.CreatedAt.Equal(actual.CreatedAt) {
+			t.Fatalf("expected artifact entry %s to be created at %v, got %v", dgst, expected.CreatedAt, actual.CreatedAt)
+		}
+		actual.CreatedAt = expected.CreatedAt
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("expected artifact entry %+v, got %+v", expected, actual)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/awslabs/soci-snapshotter/ztoc"
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// PrefetchSpansFunc returns the spans of a layer to prefetch, given the ztoc of the layer.
+type PrefetchSpansFunc func(layerDigest digest.Digest, toc *ztoc.Ztoc) ([]PrefetchSpan, error)
+
+// indexPrefetchSpansFunc returns the spans to prefetch for every layer of an index, given
+// the ztocs of the index and the layers which have a ztoc, from the bottom layer to the top.
+type indexPrefetchSpansFunc func(ctx context.Context, ztocs []*ztocWithLayer, layers []ocispec.Descriptor) (map[string][]PrefetchSpan, error)
+
+// UpdatePrefetchArtifacts writes a new SOCI index which is a copy of the existing index
+// `indexEntry` with new prefetch artifacts. `spansFn` is called with the ztoc of every
+// layer in the index. If `merge` is true, the spans are merged with the spans of the
+// existing prefetch artifacts of the index; otherwise they replace every existing
+// prefetch artifact.
+// The ztocs of the index are not changed.
+//
+// SOCI index manifest v2 is referenced by the digest of the index in the image manifest,
+// so the image must be updated to use the new index with `UpdateConvertedImage`.
+func (b *IndexBuilder) UpdatePrefetchArtifacts(ctx context.Context, indexEntry *ArtifactEntry, spansFn PrefetchSpansFunc, merge bool) (*IndexWithMetadata, error) {
+	return b.updatePrefetchArtifacts(ctx, indexEntry, func(_ context.Context, ztocs []*ztocWithLayer, _ []ocispec.Descriptor) (map[string][]PrefetchSpan, error) {
+		layerSpans := make(map[string][]PrefetchSpan, len(ztocs))
+		for _, zwl := range ztocs {
+			spans, err := spansFn(digest.Digest(zwl.layerDigest), zwl.ztoc)
+			if err != nil {
+				return nil, fmt.Errorf("cannot get prefetch spans of layer %s: %w", zwl.layerDigest, err)
+			}
+			layerSpans[zwl.layerDigest] = append(layerSpans[zwl.layerDigest], spans...)
+		}
+		return layerSpans, nil
+	}, merge)
+}
+
+// UpdatePrefetchPaths writes a new SOCI index which is a copy of the existing index
+// `indexEntry` with prefetch artifacts for the files `paths`, like `WithPrefetchPaths`
+// and `WithPrefetchPathPriorities` do when an index is built. The paths are resolved
+// against the ztocs of the index, which are reused. If `merge` is true, the spans are
+// merged with the spans of the existing prefetch artifacts of the index; otherwise they
+// replace every existing prefetch artifact.
+//
+// SOCI index manifest v2 is referenced by the digest of the index in the image manifest,
+// so the image must be updated to use the new index with `UpdateConvertedImage`.
+func (b *IndexBuilder) UpdatePrefetchPaths(ctx context.Context, indexEntry *ArtifactEntry, paths []string, priorities map[string]int, merge bool) (*IndexWithMetadata, error) {
+	return b.updatePrefetchArtifacts(ctx, indexEntry, func(ctx context.Context, ztocs []*ztocWithLayer, layers []ocispec.Descriptor) (map[string][]PrefetchSpan, error) {
+		return prefetchSpansForPaths(ctx, ztocs, layers, paths, priorities), nil
+	}, merge)
+}
+
+func (b *IndexBuilder) updatePrefetchArtifacts(ctx context.Context, indexEntry *ArtifactEntry, spansFn indexPrefetchSpansFunc, merge bool) (*IndexWithMetadata, error) {
+	ctx, done, err := b.blobStore.BatchOpen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done(ctx)
+
+	indexDesc, index, err := b.readIndex(ctx, indexEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		blobs []ocispec.Descriptor
+		// ztocs are in the order of the layers in the image.
+		ztocs      []*ztocWithLayer
+		layers     []ocispec.Descriptor
+		layerSpans = make(map[string][]PrefetchSpan)
+	)
+	for _, blob := range index.Blobs {
+		layerDigest := blob.Annotations[IndexAnnotationImageLayerDigest]
+		switch blob.MediaType {
+		case SociLayerMediaType:
+			blobs = append(blobs, blob)
+			toc, problem, err := fetchZtoc(ctx, blob, b.blobStore)
+			if err != nil {
+				return nil, err
+			}
+			if problem != "" {
+				return nil, fmt.Errorf("cannot read ztoc %s of layer %s: %s", blob.Digest, layerDigest, problem)
+			}
+			ztocs = append(ztocs, &ztocWithLayer{ztoc: toc, layerDigest: layerDigest})
+			layers = append(layers, ocispec.Descriptor{Digest: digest.Digest(layerDigest)})
+		case SociPrefetchMediaType:
+			if !merge {
+				continue
+			}
+			data, problem, err := fetchBlob(ctx, blob, b.blobStore)
+			if err != nil {
+				return nil, err
+			}
+			if problem != "" {
+				return nil, fmt.Errorf("cannot read prefetch artifact %s of layer %s: %s", blob.Digest, layerDigest, problem)
+			}
+			artifact, err := UnmarshalPrefetchArtifact(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("cannot unmarshal prefetch artifact %s: %w", blob.Digest, err)
+			}
+			layerSpans[layerDigest] = append(layerSpans[layerDigest], artifact.PrefetchSpans...)
+		default:
+			blobs = append(blobs, blob)
+		}
+	}
+
+	newSpans, err := spansFn(ctx, ztocs, layers)
+	if err != nil {
+		return nil, err
+	}
+	for layerDigest, spans := range newSpans {
+		// The spans may overlap the spans of the existing prefetch artifact of the layer,
+		// e.g. when files are added again.
+		layerSpans[layerDigest] = normalizePrefetchSpans(append(layerSpans[layerDigest], spans...))
+	}
+
+	// Prefetch artifacts are added after the ztocs, in the order of the layers.
+	for _, layer := range layers {
+		layerDigest := layer.Digest.String()
+		if len(layerSpans[layerDigest]) == 0 {
+			continue
+		}
+		desc, err := b.storePrefetchLayer(ctx, layerDigest, layerSpans[layerDigest])
+		if err != nil {
+			return nil, fmt.Errorf("failed to store prefetch artifact for layer %s: %w", layerDigest, err)
+		}
+		if desc != nil {
+			blobs = append(blobs, *desc)
+		}
+	}
+
+	version := V1
+	if index.ArtifactType == SociIndexArtifactTypeV2 {
+		version = V2
+	}
+	platform, err := platforms.Parse(indexEntry.Platform)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse platform of SOCI index %s: %w", indexDesc.Digest, err)
+	}
+	manifestDesc := ocispec.Descriptor{Digest: digest.Digest(indexEntry.OriginalDigest)}
+	if index.Subject != nil {
+		manifestDesc = *index.Subject
+	}
+	annotations := maps.Clone(index.Annotations)
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	b.config.annotateCreated(annotations)
+	indexWithMetadata := &IndexWithMetadata{
+		Index:        NewIndex(version, blobs, index.Subject, annotations),
+		Platform:     &platform,
+		ImageDesc:    ocispec.Descriptor{Digest: digest.Digest(indexEntry.ImageDigest)},
+		ManifestDesc: manifestDesc,
+		CreatedAt:    time.Now(),
+	}
+	// SOCI index manifest v2 is kept by the image which references it, like indexes created by `Convert`.
+	indexWithMetadata.Desc, err = b.writeSociIndex(ctx, indexWithMetadata, version.version != V2.version)
+	if err != nil && !store.IsErrAlreadyExists(err) {
+		return nil, err
+	}
+	return indexWithMetadata, nil
+}
+
+// readIndex reads the SOCI index of an artifacts db entry from the blob store.
+func (b *IndexBuilder) readIndex(ctx context.Context, indexEntry *ArtifactEntry) (ocispec.Descriptor, *Index, error) {
+	indexDesc := ocispec.Descriptor{
+		MediaType: indexEntry.MediaType,
+		Digest:    digest.Digest(indexEntry.Digest),
+		Size:      indexEntry.Size,
+	}
+	indexBytes, problem, err := fetchBlob(ctx, indexDesc, b.blobStore)
+	if err != nil {
+		return indexDesc, nil, err
+	}
+	if problem != "" {
+		return indexDesc, nil, fmt.Errorf("cannot read SOCI index %s: %s", indexDesc.Digest, problem)
+	}
+	var index Index
+	if err := UnmarshalIndex(indexBytes, &index); err != nil {
+		return indexDesc, nil, fmt.Errorf("cannot unmarshal SOCI index %s: %w", indexDesc.Digest, err)
+	}
+	return indexDesc, &index, nil
+}
@@ -0,0 +1,368 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/util/testutil"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestUpdatePrefetchPaths(t *testing.T) {
+	ctx := context.Background()
+	r := testutil.NewTestRand(t)
+
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("can't create content store: %v", err)
+	}
+	newLayer := func(entries ...testutil.TarEntry) ocispec.Descriptor {
+		b, err := io.ReadAll(testutil.BuildTarGz(entries, gzip.BestSpeed))
+		if err != nil {
+			t.Fatalf("can't build layer: %v", err)
+		}
+		desc := ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayerGzip,
+			Digest:    digest.FromBytes(b),
+			Size:      int64(len(b)),
+		}
+		if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+			t.Fatalf("can't write layer: %v", err)
+		}
+		return desc
+	}
+	lower := newLayer(
+		testutil.File("lib/a", string(r.RandomByteData(200000))),
+		testutil.File("shared", string(r.RandomByteData(200000))),
+	)
+	upper := newLayer(
+		testutil.File("shared", string(r.RandomByteData(200000))),
+		testutil.File("app/main", string(r.RandomByteData(200000))),
+	)
+
+	artifactsDb, err := newTestableDb()
+	if err != nil {
+		t.Fatalf("can't create a test db: %v", err)
+	}
+	blobStore := NewOrasMemoryStore()
+	builder, err := NewIndexBuilder(cs, blobStore, WithArtifactsDb(artifactsDb), WithSpanSize(1<<16), WithMinLayerSize(0))
+	if err != nil {
+		t.Fatalf("can't create index builder: %v", err)
+	}
+
+	var blobs []ocispec.Descriptor
+	for _, l := range []ocispec.Descriptor{lower, upper} {
+		desc, _, err := builder.buildSociLayer(ctx, l)
+		if err != nil {
+			t.Fatalf("can't build ztoc: %v", err)
+		}
+		// The test blob store is keyed by media type, so store the ztoc again
+		// with the media type it has in the index.
+		rc, err := blobStore.Fetch(ctx, ocispec.Descriptor{Digest: desc.Digest, Size: desc.Size})
+		if err != nil {
+			t.Fatalf("can't fetch ztoc: %v", err)
+		}
+		err = blobStore.Push(ctx, *desc, rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("can't store ztoc: %v", err)
+		}
+		blobs = append(blobs, *desc)
+	}
+	lowerPrefetch, err := builder.storePrefetchLayer(ctx, lower.Digest.String(), []PrefetchSpan{{StartSpan: 0, EndSpan: 0}})
+	if err != nil {
+		t.Fatalf("can't store prefetch artifact: %v", err)
+	}
+	blobs = append(blobs, *lowerPrefetch)
+
+	manifestDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString("manifest"),
+		Size:      100,
+	}
+	platform := platforms.DefaultSpec()
+	original := &IndexWithMetadata{
+		Index:        NewIndex(V1, blobs, &manifestDesc, nil),
+		Platform:     &platform,
+		ManifestDesc: manifestDesc,
+		CreatedAt:    time.Now(),
+	}
+	original.Desc, err = builder.writeSociIndex(ctx, original, true)
+	if err != nil {
+		t.Fatalf("can't write index: %v", err)
+	}
+	entry, err := artifactsDb.GetArtifactEntry(original.Desc.Digest.String())
+	if err != nil {
+		t.Fatalf("can't get index entry: %v", err)
+	}
+
+	prefetchLayers := func(index *Index) map[string][]PrefetchSpan {
+		layers := make(map[string][]PrefetchSpan)
+		for _, blob := range index.Blobs {
+			if blob.MediaType != SociPrefetchMediaType {
+				continue
+			}
+			rc, err := blobStore.Fetch(ctx, blob)
+			if err != nil {
+				t.Fatalf("can't fetch prefetch artifact: %v", err)
+			}
+			artifact, err := UnmarshalPrefetchArtifact(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("can't unmarshal prefetch artifact: %v", err)
+			}
+			layers[blob.Annotations[IndexAnnotationImageLayerDigest]] = artifact.PrefetchSpans
+		}
+		return layers
+	}
+
+	testcases := []struct {
+		name           string
+		merge          bool
+		extraPaths     []string
+		expectedLayers []digest.Digest
+	}{
+		{
+			name:           "set replaces existing prefetch artifacts",
+			expectedLayers: []digest.Digest{upper.Digest},
+		},
+		{
+			name:           "add keeps existing prefetch artifacts",
+			merge:          true,
+			expectedLayers: []digest.Digest{lower.Digest, upper.Digest},
+		},
+		{
+			// The spans of "lib/a" include the span of the existing prefetch artifact.
+			name:           "add merges overlapping spans",
+			merge:          true,
+			extraPaths:     []string{"lib/a"},
+			expectedLayers: []digest.Digest{lower.Digest, upper.Digest},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			// "shared" is overridden by the upper layer, so it is only prefetched from the upper layer.
+			paths := append([]string{"shared", "/app/main"}, tc.extraPaths...)
+			updated, err := builder.UpdatePrefetchPaths(ctx, entry, paths, map[string]int{"/app/main": -1}, tc.merge)
+			if err != nil {
+				t.Fatalf("can't update prefetch paths: %v", err)
+			}
+			if updated.Desc.Digest == original.Desc.Digest {
+				t.Fatal("expected a new index digest")
+			}
+			if updated.Index.ArtifactType != SociIndexArtifactTypeV1 || updated.Index.Subject == nil || updated.Index.Subject.Digest != manifestDesc.Digest {
+				t.Fatalf("unexpected index: %+v", updated.Index)
+			}
+			for i, ztocDesc := range blobs[:2] {
+				if updated.Index.Blobs[i].Digest != ztocDesc.Digest {
+					t.Fatalf("expected ztoc %s to be reused, got %s", ztocDesc.Digest, updated.Index.Blobs[i].Digest)
+				}
+			}
+
+			layers := prefetchLayers(updated.Index)
+			if len(layers) != len(tc.expectedLayers) {
+				t.Fatalf("expected prefetch artifacts for %v, got %v", tc.expectedLayers, layers)
+			}
+			for _, l := range tc.expectedLayers {
+				spans, ok := layers[l.String()]
+				if !ok {
+					t.Fatalf("expected a prefetch artifact for layer %s", l)
+				}
+				for i := 1; i < len(spans); i++ {
+					if spans[i].StartSpan <= spans[i-1].EndSpan {
+						t.Fatalf("expected sorted spans without overlap for layer %s, got %v", l, spans)
+					}
+				}
+			}
+			var hasPriority bool
+			for _, span := range layers[upper.Digest.String()] {
+				if span.Priority == -1 {
+					hasPriority = true
+				}
+			}
+			if !hasPriority {
+				t.Fatalf("expected the spans of /app/main to have priority -1: %v", layers[upper.Digest.String()])
+			}
+
+			updatedEntry, err := artifactsDb.GetArtifactEntry(updated.Desc.Digest.String())
+			if err != nil {
+				t.Fatalf("can't get updated index entry: %v", err)
+			}
+			if updatedEntry.OriginalDigest != manifestDesc.Digest.String() {
+				t.Fatalf("unexpected manifest digest of updated index: %s", updatedEntry.OriginalDigest)
+			}
+		})
+	}
+}
+
+func TestUpdateConvertedImage(t *testing.T) {
+	ctx := context.Background()
+	r := testutil.NewTestRand(t)
+
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("can't create content store: %v", err)
+	}
+	platform := platforms.DefaultSpec()
+	img := newTestImage(ctx, t, cs, platform,
+		testutil.File("lib/a", string(r.RandomByteData(200000))),
+		testutil.File("app/main", string(r.RandomByteData(200000))),
+	)
+
+	artifactsDb, err := newTestableDb()
+	if err != nil {
+		t.Fatalf("can't create a test db: %v", err)
+	}
+	blobStore := NewOrasMemoryStore()
+	builder, err := NewIndexBuilder(cs, blobStore, WithArtifactsDb(artifactsDb), WithSpanSize(1<<16), WithMinLayerSize(0),
+		WithPrefetchPaths([]string{"/app/main"}))
+	if err != nil {
+		t.Fatalf("can't create index builder: %v", err)
+	}
+
+	// readOCIIndex reads the OCI index of a converted image, and copies it and its image
+	// manifests to the content store, where the converted image is in containerd.
+	readOCIIndex := func(desc ocispec.Descriptor) ocispec.Index {
+		copyBlob := func(desc ocispec.Descriptor) []byte {
+			rc, err := blobStore.Fetch(ctx, ocispec.Descriptor{Digest: desc.Digest, Size: desc.Size})
+			if err != nil {
+				t.Fatalf("can't fetch %s: %v", desc.Digest, err)
+			}
+			b, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("can't read %s: %v", desc.Digest, err)
+			}
+			if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+				t.Fatalf("can't write %s: %v", desc.Digest, err)
+			}
+			return b
+		}
+		var ociIndex ocispec.Index
+		if err := json.Unmarshal(copyBlob(desc), &ociIndex); err != nil {
+			t.Fatalf("can't unmarshal OCI index: %v", err)
+		}
+		for _, m := range ociIndex.Manifests {
+			if m.ArtifactType != SociIndexArtifactTypeV2 {
+				copyBlob(m)
+			}
+		}
+		return ociIndex
+	}
+
+	desc, err := builder.Convert(ctx, img)
+	if err != nil {
+		t.Fatalf("can't convert image: %v", err)
+	}
+	img.Target = *desc
+	converted := readOCIIndex(img.Target)
+	if len(converted.Manifests) != 2 {
+		t.Fatalf("expected an image manifest and a SOCI index in the OCI index, got %+v", converted.Manifests)
+	}
+	entry, err := artifactsDb.GetArtifactEntry(converted.Manifests[1].Digest.String())
+	if err != nil {
+		t.Fatalf("can't get SOCI index entry: %v", err)
+	}
+	_, index, err := builder.readIndex(ctx, entry)
+	if err != nil {
+		t.Fatalf("can't read SOCI index: %v", err)
+	}
+	for _, blob := range index.Blobs {
+		if blob.MediaType != SociLayerMediaType {
+			continue
+		}
+		// The test blob store is keyed by media type, so store the ztoc again
+		// with the media type it has in the index.
+		rc, err := blobStore.Fetch(ctx, ocispec.Descriptor{Digest: blob.Digest, Size: blob.Size})
+		if err != nil {
+			t.Fatalf("can't fetch ztoc: %v", err)
+		}
+		err = blobStore.Push(ctx, blob, rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("can't store ztoc: %v", err)
+		}
+	}
+
+	updated, err := builder.UpdatePrefetchPaths(ctx, entry, []string{"/lib/a", "/app/main"}, nil, true)
+	if err != nil {
+		t.Fatalf("can't update prefetch paths: %v", err)
+	}
+	if updated.Index.ArtifactType != SociIndexArtifactTypeV2 || updated.Index.Subject != nil {
+		t.Fatalf("expected a SOCI index manifest v2 without subject, got %+v", updated.Index)
+	}
+	if updated.Desc.Digest.String() == entry.Digest {
+		t.Fatal("expected a new index digest")
+	}
+	var prefetchDescs []ocispec.Descriptor
+	for _, blob := range updated.Index.Blobs {
+		if blob.MediaType == SociPrefetchMediaType {
+			prefetchDescs = append(prefetchDescs, blob)
+		}
+	}
+	if len(prefetchDescs) != 1 {
+		t.Fatalf("expected a single prefetch artifact, got %+v", prefetchDescs)
+	}
+	rc, err := blobStore.Fetch(ctx, prefetchDescs[0])
+	if err != nil {
+		t.Fatalf("can't fetch prefetch artifact: %v", err)
+	}
+	artifact, err := UnmarshalPrefetchArtifact(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("can't unmarshal prefetch artifact: %v", err)
+	}
+	// Both files are in the layer, so their spans are merged with the spans of "/app/main".
+	if len(artifact.PrefetchSpans) != 1 || artifact.PrefetchSpans[0].StartSpan != 0 {
+		t.Fatalf("expected a single span range from span 0, got %v", artifact.PrefetchSpans)
+	}
+
+	desc, err = builder.UpdateConvertedImage(ctx, img, []*IndexWithMetadata{updated})
+	if err != nil {
+		t.Fatalf("can't update converted image: %v", err)
+	}
+	ociIndex := readOCIIndex(*desc)
+	if len(ociIndex.Manifests) != 2 {
+		t.Fatalf("expected an image manifest and a SOCI index in the OCI index, got %+v", ociIndex.Manifests)
+	}
+	annotatedManifest, sociIndexDesc := ociIndex.Manifests[0], ociIndex.Manifests[1]
+	if sociIndexDesc.Digest != updated.Desc.Digest {
+		t.Fatalf("expected the OCI index to contain SOCI index %s, got %s", updated.Desc.Digest, sociIndexDesc.Digest)
+	}
+	if annotatedManifest.Annotations[ImageAnnotationSociIndexDigest] != updated.Desc.Digest.String() {
+		t.Fatalf("expected the image manifest to reference SOCI index %s, got %v", updated.Desc.Digest, annotatedManifest.Annotations)
+	}
+	updatedEntry, err := artifactsDb.GetArtifactEntry(updated.Desc.Digest.String())
+	if err != nil {
+		t.Fatalf("can't get updated index entry: %v", err)
+	}
+	if updatedEntry.OriginalDigest != annotatedManifest.Digest.String() || updatedEntry.ImageDigest != desc.Digest.String() {
+		t.Fatalf("expected SOCI index to reference manifest %s and image %s, got %s and %s",
+			annotatedManifest.Digest, desc.Digest, updatedEntry.OriginalDigest, updatedEntry.ImageDigest)
+	}
+}
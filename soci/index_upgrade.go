@@ -0,0 +1,117 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Upgrade converts an image indexed with SOCI index manifests v1 into a SOCI enabled image,
+// like `Convert`, without reading its layers. For each platform, the most recent SOCI index
+// manifest v1 of the image manifest is upgraded with `UpgradeIndex`, so every ztoc and
+// prefetch artifact is reused unchanged.
+//
+// It returns the descriptor of the new OCI index, which should be used as the target of the image.
+func (b *IndexBuilder) Upgrade(ctx context.Context, img images.Image, opts ...ConvertOption) (*ocispec.Descriptor, error) {
+	convertCfg, err := b.newConvertConfig(ctx, &img, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []*IndexWithMetadata
+	for _, platform := range convertCfg.platforms {
+		manifestDesc, err := GetImageManifestDescriptor(ctx, b.contentStore, img.Target, platforms.OnlyStrict(platform))
+		if err != nil {
+			return nil, err
+		}
+		indexEntry, err := b.latestV1Index(manifestDesc.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		if indexEntry == nil {
+			return nil, fmt.Errorf("no SOCI index manifest v1 for image manifest %s (platform %s): %w",
+				manifestDesc.Digest, platforms.Format(platform), errdefs.ErrNotFound)
+		}
+		index, err := b.UpgradeIndex(ctx, indexEntry)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+
+	return b.addIndexesToImage(ctx, img, indexes, convertCfg.gcRoot)
+}
+
+// UpgradeIndex writes a SOCI index manifest v2 with the same ztocs and prefetch artifacts
+// as the SOCI index manifest v1 `indexEntry`. The new index is not referenced by the image
+// manifest yet, so it is not labeled as a GC root; add it to the image with `UpdateConvertedImage`
+// or use `Upgrade`.
+func (b *IndexBuilder) UpgradeIndex(ctx context.Context, indexEntry *ArtifactEntry) (*IndexWithMetadata, error) {
+	indexDesc, index, err := b.readIndex(ctx, indexEntry)
+	if err != nil {
+		return nil, err
+	}
+	if index.ArtifactType != SociIndexArtifactTypeV1 || index.Subject == nil {
+		return nil, fmt.Errorf("SOCI index %s is not a SOCI index manifest v1: %w", indexDesc.Digest, errdefs.ErrInvalidArgument)
+	}
+	platform, err := platforms.Parse(indexEntry.Platform)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse platform of SOCI index %s: %w", indexDesc.Digest, err)
+	}
+
+	indexWithMetadata := &IndexWithMetadata{
+		Index:        NewIndex(V2, index.Blobs, nil, maps.Clone(index.Annotations)),
+		Platform:     &platform,
+		ImageDesc:    ocispec.Descriptor{Digest: digest.Digest(indexEntry.ImageDigest)},
+		ManifestDesc: *index.Subject,
+		CreatedAt:    time.Now(),
+	}
+	// Like indexes created by `Convert`, the index is kept by the image which references it.
+	indexWithMetadata.Desc, err = b.writeSociIndex(ctx, indexWithMetadata, false)
+	if err != nil {
+		return nil, err
+	}
+	return indexWithMetadata, nil
+}
+
+// latestV1Index returns the most recent SOCI index manifest v1 of an image manifest
+// in the artifacts db, or nil if there isn't any.
+func (b *IndexBuilder) latestV1Index(manifestDigest string) (*ArtifactEntry, error) {
+	entries, err := b.config.artifactsDb.getIndexArtifactEntries(manifestDigest)
+	if err != nil {
+		return nil, err
+	}
+	var latest *ArtifactEntry
+	for i := range entries {
+		if entries[i].ArtifactType != SociIndexArtifactTypeV1 {
+			continue
+		}
+		if latest == nil || entries[i].CreatedAt.After(latest.CreatedAt) {
+			latest = &entries[i]
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,162 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/util/testutil"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestUpgrade(t *testing.T) {
+	ctx := context.Background()
+	r := testutil.NewTestRand(t)
+
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("can't create content store: %v", err)
+	}
+	writeBlob := func(mediaType string, b []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{
+			MediaType: mediaType,
+			Digest:    digest.FromBytes(b),
+			Size:      int64(len(b)),
+		}
+		if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(b), desc); err != nil {
+			t.Fatalf("can't write blob: %v", err)
+		}
+		return desc
+	}
+	writeJSON := func(mediaType string, v any) ocispec.Descriptor {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("can't marshal %s: %v", mediaType, err)
+		}
+		return writeBlob(mediaType, b)
+	}
+
+	layer, err := io.ReadAll(testutil.BuildTarGz([]testutil.TarEntry{
+		testutil.File("app/main", string(r.RandomByteData(200000))),
+	}, gzip.BestSpeed))
+	if err != nil {
+		t.Fatalf("can't build layer: %v", err)
+	}
+	layerDesc := writeBlob(ocispec.MediaTypeImageLayerGzip, layer)
+	platform := platforms.DefaultSpec()
+	configDesc := writeJSON(ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: platform,
+		RootFS: ocispec.RootFS{
+			Type: "layers",
+		},
+	})
+	manifestDesc := writeJSON(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	})
+	img := images.Image{Name: "example.com/upgrade:latest", Target: manifestDesc}
+
+	artifactsDb, err := newTestableDb()
+	if err != nil {
+		t.Fatalf("can't create a test db: %v", err)
+	}
+	blobStore := NewOrasMemoryStore()
+	builder, err := NewIndexBuilder(cs, blobStore, WithArtifactsDb(artifactsDb), WithSpanSize(1<<16), WithMinLayerSize(0),
+		WithPrefetchPaths([]string{"/app/main"}))
+	if err != nil {
+		t.Fatalf("can't create index builder: %v", err)
+	}
+
+	if _, err := builder.Upgrade(ctx, img); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Fatalf("expected upgrading an image without SOCI index manifest v1 to fail with not found, got %v", err)
+	}
+
+	v1, err := builder.Build(ctx, img, WithPlatform(platform))
+	if err != nil {
+		t.Fatalf("can't build SOCI index manifest v1: %v", err)
+	}
+
+	desc, err := builder.Upgrade(ctx, img)
+	if err != nil {
+		t.Fatalf("can't upgrade image: %v", err)
+	}
+	rc, err := blobStore.Fetch(ctx, ocispec.Descriptor{Digest: desc.Digest, Size: desc.Size})
+	if err != nil {
+		t.Fatalf("can't fetch OCI index: %v", err)
+	}
+	var ociIndex ocispec.Index
+	err = json.NewDecoder(rc).Decode(&ociIndex)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("can't decode OCI index: %v", err)
+	}
+	if len(ociIndex.Manifests) != 2 {
+		t.Fatalf("expected an image manifest and a SOCI index in the OCI index, got %+v", ociIndex.Manifests)
+	}
+	annotatedManifest, sociIndexDesc := ociIndex.Manifests[0], ociIndex.Manifests[1]
+	if sociIndexDesc.ArtifactType != SociIndexArtifactTypeV2 {
+		t.Fatalf("expected a SOCI index manifest v2, got %+v", sociIndexDesc)
+	}
+	if annotatedManifest.Annotations[ImageAnnotationSociIndexDigest] != sociIndexDesc.Digest.String() {
+		t.Fatalf("expected the image manifest to reference SOCI index %s, got %v", sociIndexDesc.Digest, annotatedManifest.Annotations)
+	}
+
+	entry, err := artifactsDb.GetArtifactEntry(sociIndexDesc.Digest.String())
+	if err != nil {
+		t.Fatalf("can't get SOCI index entry: %v", err)
+	}
+	if entry.OriginalDigest != annotatedManifest.Digest.String() || entry.ImageDigest != desc.Digest.String() {
+		t.Fatalf("expected SOCI index to reference manifest %s and image %s, got %s and %s",
+			annotatedManifest.Digest, desc.Digest, entry.OriginalDigest, entry.ImageDigest)
+	}
+	_, v2, err := builder.readIndex(ctx, entry)
+	if err != nil {
+		t.Fatalf("can't read SOCI index manifest v2: %v", err)
+	}
+	if v2.Subject != nil {
+		t.Fatalf("expected SOCI index manifest v2 not to have a subject, got %+v", v2.Subject)
+	}
+	// Every ztoc and prefetch artifact of the SOCI index manifest v1 is reused unchanged.
+	if !reflect.DeepEqual(v2.Blobs, v1.Index.Blobs) {
+		t.Fatalf("expected blobs %+v, got %+v", v1.Index.Blobs, v2.Blobs)
+	}
+	var hasPrefetch bool
+	for _, blob := range v2.Blobs {
+		if blob.MediaType == SociPrefetchMediaType {
+			hasPrefetch = true
+		}
+	}
+	if !hasPrefetch {
+		t.Fatal("expected the prefetch artifact to be reused")
+	}
+}
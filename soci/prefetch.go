@@ -46,8 +46,8 @@ type PrefetchSpan struct {
 	StartSpan compression.SpanID `json:"start_span"`
 	EndSpan   compression.SpanID `json:"end_span"`
 
-	// Priority is an optional field for future use to support prioritized prefetching.
-	// Lower values indicate higher priority. If omitted, all spans have equal priority.
+	// Priority is the order in which spans are prefetched. Spans with lower values are
+	// fetched first, across every layer of the image. If omitted, all spans have equal priority.
 	Priority int `json:"priority,omitempty"`
 }
 
@@ -0,0 +1,268 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/awslabs/soci-snapshotter/ztoc"
+	"github.com/awslabs/soci-snapshotter/ztoc/compression"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	orascontent "oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry"
+)
+
+// RemoteZtocRepository is a registry repository which is searched for existing
+// ztocs by `RemoteZtocSource`. `*remote.Repository` from oras-go implements it.
+type RemoteZtocRepository interface {
+	orascontent.Fetcher
+	orascontent.Resolver
+	registry.TagLister
+	registry.ReferrerLister
+}
+
+// RemoteZtocSource finds existing ztocs for layers in the SOCI indexes of the
+// images in a registry repository, so that identical ztocs (e.g. for shared base
+// layers) don't have to be rebuilt by every builder.
+//
+// SOCI indexes are found through the `ImageAnnotationSociIndexDigest` annotation
+// of image manifests (SOCI index manifest v2) and the referrers of image manifests
+// (SOCI index manifest v1). The repository is scanned once, the first time a ztoc
+// is looked up.
+type RemoteZtocSource struct {
+	repo RemoteZtocRepository
+
+	once sync.Once
+	// ztocs maps a layer digest to the descriptors of the ztocs of the layer.
+	ztocs map[digest.Digest][]ocispec.Descriptor
+	err   error
+}
+
+// NewRemoteZtocSource returns a `RemoteZtocSource` which looks for ztocs in `repo`.
+func NewRemoteZtocSource(repo RemoteZtocRepository) *RemoteZtocSource {
+	return &RemoteZtocSource{repo: repo}
+}
+
+// Find returns the descriptors of the ztocs of a layer found in the repository.
+// The descriptors contain the annotations from the SOCI index which contains them.
+func (s *RemoteZtocSource) Find(ctx context.Context, layerDigest digest.Digest) ([]ocispec.Descriptor, error) {
+	s.once.Do(func() {
+		s.ztocs, s.err = s.scan(ctx)
+	})
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.ztocs[layerDigest], nil
+}
+
+// Fetch fetches a ztoc from the repository and verifies its digest.
+func (s *RemoteZtocSource) Fetch(ctx context.Context, ztocDesc ocispec.Descriptor) ([]byte, error) {
+	return orascontent.FetchAll(ctx, s.repo, ztocDesc)
+}
+
+// scan finds every SOCI index of the tagged images in the repository and
+// indexes their ztocs by layer digest. Tags, manifests and SOCI indexes which
+// can't be read are skipped, so that they don't prevent the ztocs of the other
+// images from being found.
+func (s *RemoteZtocSource) scan(ctx context.Context) (map[digest.Digest][]ocispec.Descriptor, error) {
+	var tags []string
+	err := s.repo.Tags(ctx, "", func(t []string) error {
+		tags = append(tags, t...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+
+	ztocs := make(map[digest.Digest][]ocispec.Descriptor)
+	seen := make(map[digest.Digest]struct{})
+	for _, tag := range tags {
+		desc, err := s.repo.Resolve(ctx, tag)
+		if err != nil {
+			// A tag may be deleted between listing and resolving it.
+			log.G(ctx).WithError(err).WithField("tag", tag).Warn("failed to resolve tag, skipping")
+			continue
+		}
+		s.scanManifest(ctx, desc, seen, ztocs)
+	}
+	return ztocs, nil
+}
+
+// scanManifest adds the ztocs of the SOCI indexes of an image manifest, or of every
+// image manifest in an image index, to `ztocs`. Errors are logged and the manifest,
+// or the part of it which can't be read, is skipped.
+func (s *RemoteZtocSource) scanManifest(ctx context.Context, desc ocispec.Descriptor, seen map[digest.Digest]struct{}, ztocs map[digest.Digest][]ocispec.Descriptor) {
+	if _, ok := seen[desc.Digest]; ok {
+		return
+	}
+	seen[desc.Digest] = struct{}{}
+	logger := log.G(ctx).WithField("manifest", desc.Digest)
+
+	switch desc.ArtifactType {
+	case SociIndexArtifactTypeV1, SociIndexArtifactTypeV2:
+		if err := s.scanIndex(ctx, desc, ztocs); err != nil {
+			logger.WithError(err).Warn("failed to scan SOCI index, skipping")
+		}
+		return
+	}
+
+	b, err := orascontent.FetchAll(ctx, s.repo, desc)
+	if err != nil {
+		logger.WithError(err).Warn("failed to fetch manifest, skipping")
+		return
+	}
+
+	if images.IsIndexType(desc.MediaType) {
+		var index ocispec.Index
+		if err := json.Unmarshal(b, &index); err != nil {
+			logger.WithError(err).Warn("failed to unmarshal image index, skipping")
+			return
+		}
+		for _, m := range index.Manifests {
+			s.scanManifest(ctx, m, seen, ztocs)
+		}
+		return
+	}
+	if !images.IsManifestType(desc.MediaType) {
+		return
+	}
+
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		logger.WithError(err).Warn("failed to unmarshal image manifest, skipping")
+		return
+	}
+
+	if indexDigest, ok := manifest.Annotations[ImageAnnotationSociIndexDigest]; ok {
+		indexDesc, err := s.repo.Resolve(ctx, indexDigest)
+		if err != nil {
+			logger.WithError(err).WithField("index", indexDigest).Warn("failed to resolve SOCI index, skipping")
+		} else {
+			s.scanManifest(ctx, indexDesc, seen, ztocs)
+		}
+	}
+
+	err = s.repo.Referrers(ctx, desc, SociIndexArtifactTypeV1, func(referrers []ocispec.Descriptor) error {
+		for _, r := range referrers {
+			s.scanManifest(ctx, r, seen, ztocs)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.WithError(err).Warn("failed to list referrers, skipping")
+	}
+}
+
+// scanIndex adds the ztocs of a SOCI index to `ztocs`.
+func (s *RemoteZtocSource) scanIndex(ctx context.Context, desc ocispec.Descriptor, ztocs map[digest.Digest][]ocispec.Descriptor) error {
+	b, err := orascontent.FetchAll(ctx, s.repo, desc)
+	if err != nil {
+		return fmt.Errorf("failed to fetch SOCI index %s: %w", desc.Digest, err)
+	}
+	var index Index
+	if err := UnmarshalIndex(b, &index); err != nil {
+		return fmt.Errorf("failed to unmarshal SOCI index %s: %w", desc.Digest, err)
+	}
+	for _, blob := range index.Blobs {
+		if blob.MediaType != SociLayerMediaType {
+			continue
+		}
+		layerDigest, err := digest.Parse(blob.Annotations[IndexAnnotationImageLayerDigest])
+		if err != nil {
+			continue
+		}
+		ztocs[layerDigest] = append(ztocs[layerDigest], blob)
+	}
+	return nil
+}
+
+// getRemoteZtocForLayer looks for a ztoc of a layer with `RemoteZtocSource`. A ztoc is only
+// reused if it was built with the same settings as the builder would use. If a ztoc
+// is found, it is returned with its content and descriptor.
+func (b *IndexBuilder) getRemoteZtocForLayer(ctx context.Context, layerDesc ocispec.Descriptor, compressionAlgo string) (*ztoc.Ztoc, []byte, *ocispec.Descriptor) {
+	if b.config.forceRecreateZtocs || b.config.remoteZtocSource == nil {
+		return nil, nil, nil
+	}
+	candidates, err := b.config.remoteZtocSource.Find(ctx, layerDesc.Digest)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to look up remote ztocs")
+		return nil, nil, nil
+	}
+	for _, candidate := range candidates {
+		if candidate.Annotations[IndexAnnotationSociSpanSize] != strconv.FormatInt(b.config.spanSize, 10) {
+			continue
+		}
+		data, err := b.config.remoteZtocSource.Fetch(ctx, candidate)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("ztoc", candidate.Digest).Warn("failed to fetch remote ztoc")
+			continue
+		}
+		toc, err := ztoc.Unmarshal(bytes.NewReader(data))
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("ztoc", candidate.Digest).Warn("failed to unmarshal remote ztoc")
+			continue
+		}
+		if reason := b.ztocMismatch(toc, layerDesc, compressionAlgo); reason != "" {
+			log.G(ctx).WithField("ztoc", candidate.Digest).Debugf("not reusing remote ztoc: %s", reason)
+			continue
+		}
+		ztocDesc := ocispec.Descriptor{
+			Digest: candidate.Digest,
+			Size:   candidate.Size,
+		}
+		return toc, data, &ztocDesc
+	}
+	return nil, nil, nil
+}
+
+// ztocMismatch returns why `toc` is not the ztoc the builder would build for
+// the layer, or an empty string if it is.
+func (b *IndexBuilder) ztocMismatch(toc *ztoc.Ztoc, layerDesc ocispec.Descriptor, compressionAlgo string) string {
+	if toc.BuildToolIdentifier != b.config.buildToolIdentifier {
+		return fmt.Sprintf("build tool %q doesn't match %q", toc.BuildToolIdentifier, b.config.buildToolIdentifier)
+	}
+	if toc.CompressionAlgorithm != compressionAlgo {
+		return fmt.Sprintf("compression algorithm %s doesn't match %s", toc.CompressionAlgorithm, compressionAlgo)
+	}
+	if toc.CompressedArchiveSize != compression.Offset(layerDesc.Size) {
+		return fmt.Sprintf("compressed archive size %d doesn't match layer size %d", toc.CompressedArchiveSize, layerDesc.Size)
+	}
+	version := ztoc.Version09
+	if b.config.fileDigests {
+		version = ztoc.Version10
+	}
+	if toc.Version != version {
+		return fmt.Sprintf("version %s doesn't match %s", toc.Version, version)
+	}
+	zinfo, err := toc.Zinfo()
+	if err != nil {
+		return fmt.Sprintf("invalid zinfo: %v", err)
+	}
+	defer zinfo.Close()
+	if int64(zinfo.SpanSize()) != b.config.spanSize {
+		return fmt.Sprintf("span size %d doesn't match %d", zinfo.SpanSize(), b.config.spanSize)
+	}
+	return ""
+}
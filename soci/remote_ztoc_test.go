@@ -0,0 +1,321 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/util/testutil"
+	"github.com/awslabs/soci-snapshotter/ztoc"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content/memory"
+)
+
+// fakeRemoteRepository is an in-memory `RemoteZtocRepository`.
+type fakeRemoteRepository struct {
+	*memory.Store
+	tags []string
+	// fetched contains the digests of the fetched blobs.
+	fetched map[digest.Digest]struct{}
+}
+
+func (r *fakeRemoteRepository) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if r.fetched == nil {
+		r.fetched = make(map[digest.Digest]struct{})
+	}
+	r.fetched[desc.Digest] = struct{}{}
+	return r.Store.Fetch(ctx, desc)
+}
+
+func (r *fakeRemoteRepository) Tags(ctx context.Context, last string, fn func(tags []string) error) error {
+	return fn(r.tags)
+}
+
+func (r *fakeRemoteRepository) Referrers(ctx context.Context, desc ocispec.Descriptor, artifactType string, fn func(referrers []ocispec.Descriptor) error) error {
+	predecessors, err := r.Predecessors(ctx, desc)
+	if err != nil {
+		return err
+	}
+	var referrers []ocispec.Descriptor
+	for _, p := range predecessors {
+		if p.ArtifactType == artifactType {
+			referrers = append(referrers, p)
+		}
+	}
+	return fn(referrers)
+}
+
+// push pushes a JSON object as a manifest which can be resolved by its digest.
+func (r *fakeRemoteRepository) push(t *testing.T, mediaType, artifactType string, obj any) ocispec.Descriptor {
+	var (
+		b   []byte
+		err error
+	)
+	if index, ok := obj.(*Index); ok {
+		b, err = MarshalIndex(index)
+	} else {
+		b, err = json.Marshal(obj)
+	}
+	if err != nil {
+		t.Fatalf("can't marshal manifest: %v", err)
+	}
+	desc := ocispec.Descriptor{
+		MediaType:    mediaType,
+		ArtifactType: artifactType,
+		Digest:       digest.FromBytes(b),
+		Size:         int64(len(b)),
+	}
+	if err := r.Push(context.Background(), desc, bytes.NewReader(b)); err != nil {
+		t.Fatalf("can't push manifest: %v", err)
+	}
+	if err := r.Tag(context.Background(), desc, desc.Digest.String()); err != nil {
+		t.Fatalf("can't tag manifest: %v", err)
+	}
+	return desc
+}
+
+func TestRemoteZtocReuse(t *testing.T) {
+	const spanSize = 1 << 16
+	ctx := context.Background()
+	r := testutil.NewTestRand(t)
+	layer, err := io.ReadAll(testutil.BuildTarGz([]testutil.TarEntry{
+		testutil.File("file", string(r.RandomByteData(200000))),
+	}, gzip.BestSpeed))
+	if err != nil {
+		t.Fatalf("can't build layer: %v", err)
+	}
+	layerDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromBytes(layer),
+		Size:      int64(len(layer)),
+	}
+
+	// remoteRepo creates a repository with an image whose SOCI index contains a
+	// ztoc of the layer built by `buildTool`.
+	remoteRepo := func(t *testing.T, indexVersion IndexVersion, span int64, fileDigests bool, buildTool string) (*fakeRemoteRepository, digest.Digest) {
+		repo := &fakeRemoteRepository{Store: memory.New()}
+		opts := []ztoc.BuildOption{}
+		if fileDigests {
+			opts = append(opts, ztoc.WithFileDigests())
+		}
+		toc, err := ztoc.NewBuilder(buildTool).BuildZtocFromReader(bytes.NewReader(layer), span, opts...)
+		if err != nil {
+			t.Fatalf("can't build ztoc: %v", err)
+		}
+		zr, ztocDesc, err := ztoc.Marshal(toc)
+		if err != nil {
+			t.Fatalf("can't marshal ztoc: %v", err)
+		}
+		ztocDesc.MediaType = SociLayerMediaType
+		if err := repo.Push(ctx, ztocDesc, zr); err != nil {
+			t.Fatalf("can't push ztoc: %v", err)
+		}
+		ztocDesc.Annotations = map[string]string{
+			IndexAnnotationImageLayerMediaType: layerDesc.MediaType,
+			IndexAnnotationImageLayerDigest:    layerDesc.Digest.String(),
+			IndexAnnotationSociSpanSize:        strconv.FormatInt(span, 10),
+		}
+
+		manifest := ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Layers:    []ocispec.Descriptor{layerDesc},
+		}
+		index := NewIndex(indexVersion, []ocispec.Descriptor{ztocDesc}, nil, nil)
+		if indexVersion.version == V1.version {
+			manifestDesc := repo.push(t, ocispec.MediaTypeImageManifest, "", manifest)
+			index.Subject = &manifestDesc
+			repo.push(t, ocispec.MediaTypeImageManifest, SociIndexArtifactTypeV1, index)
+			repo.Tag(ctx, manifestDesc, "latest")
+		} else {
+			indexDesc := repo.push(t, ocispec.MediaTypeImageManifest, SociIndexArtifactTypeV2, index)
+			manifest.Annotations = map[string]string{ImageAnnotationSociIndexDigest: indexDesc.Digest.String()}
+			manifestDesc := repo.push(t, ocispec.MediaTypeImageManifest, "", manifest)
+			imageIndexDesc := repo.push(t, ocispec.MediaTypeImageIndex, "", ocispec.Index{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				MediaType: ocispec.MediaTypeImageIndex,
+				Manifests: []ocispec.Descriptor{manifestDesc, indexDesc},
+			})
+			repo.Tag(ctx, imageIndexDesc, "latest")
+		}
+		repo.tags = []string{"latest"}
+		return repo, ztocDesc.Digest
+	}
+
+	// addBrokenImages tags images which can't be scanned before the other images:
+	// an image index whose manifest doesn't exist, and a manifest whose SOCI index
+	// doesn't exist.
+	addBrokenImages := func(t *testing.T, repo *fakeRemoteRepository) {
+		missingManifestDesc := repo.push(t, ocispec.MediaTypeImageIndex, "", ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: []ocispec.Descriptor{{
+				MediaType: ocispec.MediaTypeImageManifest,
+				Digest:    digest.FromString("missing"),
+				Size:      7,
+			}},
+		})
+		repo.Tag(ctx, missingManifestDesc, "missing-manifest")
+		missingIndexDesc := repo.push(t, ocispec.MediaTypeImageManifest, "", ocispec.Manifest{
+			Versioned:   specs.Versioned{SchemaVersion: 2},
+			MediaType:   ocispec.MediaTypeImageManifest,
+			Layers:      []ocispec.Descriptor{layerDesc},
+			Annotations: map[string]string{ImageAnnotationSociIndexDigest: digest.FromString("missing").String()},
+		})
+		repo.Tag(ctx, missingIndexDesc, "missing-index")
+		repo.tags = append([]string{"missing-manifest", "missing-index"}, repo.tags...)
+	}
+
+	testcases := []struct {
+		name         string
+		indexVersion IndexVersion
+		spanSize     int64
+		fileDigests  bool
+		force        bool
+		// buildTool is the build tool of the remote ztoc. Defaults to the local one.
+		buildTool string
+		// unresolvableTag adds a tag to the repository which can't be resolved.
+		unresolvableTag bool
+		// brokenImages adds images to the repository which can't be scanned.
+		brokenImages bool
+		expectReuse  bool
+	}{
+		{
+			name:         "reuse ztoc from SOCI index manifest v2",
+			indexVersion: V2,
+			spanSize:     spanSize,
+			fileDigests:  true,
+			expectReuse:  true,
+		},
+		{
+			name:         "reuse ztoc from SOCI index manifest v1",
+			indexVersion: V1,
+			spanSize:     spanSize,
+			fileDigests:  true,
+			expectReuse:  true,
+		},
+		{
+			name:            "reuse ztoc when another tag can't be resolved",
+			indexVersion:    V2,
+			spanSize:        spanSize,
+			fileDigests:     true,
+			unresolvableTag: true,
+			expectReuse:     true,
+		},
+		{
+			name:         "reuse ztoc when other images can't be scanned",
+			indexVersion: V2,
+			spanSize:     spanSize,
+			fileDigests:  true,
+			brokenImages: true,
+			expectReuse:  true,
+		},
+		{
+			name:         "don't reuse ztoc built by a different build tool",
+			indexVersion: V2,
+			spanSize:     spanSize,
+			fileDigests:  true,
+			buildTool:    "remote",
+		},
+		{
+			name:         "don't reuse ztoc with a different span size",
+			indexVersion: V2,
+			spanSize:     spanSize * 2,
+			fileDigests:  true,
+		},
+		{
+			name:         "don't reuse ztoc without file digests",
+			indexVersion: V2,
+			spanSize:     spanSize,
+		},
+		{
+			name:         "don't reuse ztoc when recreating ztocs",
+			indexVersion: V2,
+			spanSize:     spanSize,
+			fileDigests:  true,
+			force:        true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, remoteZtocDigest := remoteRepo(t, tc.indexVersion, tc.spanSize, tc.fileDigests, cmp.Or(tc.buildTool, "local"))
+			if tc.unresolvableTag {
+				repo.tags = append([]string{"deleted"}, repo.tags...)
+			}
+			if tc.brokenImages {
+				addBrokenImages(t, repo)
+			}
+			cs, err := local.NewStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("can't create content store: %v", err)
+			}
+			// A reused ztoc is identical to the ztoc the builder would build, so the layer
+			// is only available to the builder if the ztoc must not be reused.
+			if !tc.expectReuse {
+				if err := content.WriteBlob(ctx, cs, "layer", bytes.NewReader(layer), layerDesc); err != nil {
+					t.Fatalf("can't write layer: %v", err)
+				}
+			}
+			artifactsDb, err := newTestableDb()
+			if err != nil {
+				t.Fatalf("can't create a test db: %v", err)
+			}
+			blobStore := NewOrasMemoryStore()
+			builder, err := NewIndexBuilder(cs, blobStore,
+				WithArtifactsDb(artifactsDb),
+				WithSpanSize(spanSize),
+				WithMinLayerSize(0),
+				WithBuildToolIdentifier("local"),
+				WithForceRecreateZtocs(tc.force),
+				WithRemoteZtocSource(NewRemoteZtocSource(repo)))
+			if err != nil {
+				t.Fatalf("can't create index builder: %v", err)
+			}
+
+			ztocDesc, _, err := builder.buildSociLayer(ctx, layerDesc)
+			if err != nil {
+				t.Fatalf("can't build soci layer: %v", err)
+			}
+			_, fetched := repo.fetched[remoteZtocDigest]
+			if reused := fetched && ztocDesc.Digest == remoteZtocDigest; reused != tc.expectReuse {
+				t.Fatalf("unexpected ztoc reuse. expected: %t, actual: %t", tc.expectReuse, reused)
+			}
+			if ok, _ := blobStore.Exists(ctx, ocispec.Descriptor{Digest: ztocDesc.Digest, Size: ztocDesc.Size}); !ok {
+				t.Fatalf("ztoc %s was not stored in the blob store", ztocDesc.Digest)
+			}
+			entry, err := artifactsDb.GetArtifactEntry(ztocDesc.Digest.String())
+			if err != nil {
+				t.Fatalf("can't get artifact entry of the ztoc: %v", err)
+			}
+			if entry.OriginalDigest != layerDesc.Digest.String() {
+				t.Fatalf("unexpected layer digest of the ztoc. expected: %s, actual: %s", layerDesc.Digest, entry.OriginalDigest)
+			}
+		})
+	}
+}
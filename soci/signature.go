@@ -0,0 +1,242 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// SociSignatureArtifactType is the artifact type of a SOCI index signature. A signature
+	// is an OCI manifest whose subject is the signed SOCI index, so it can be found
+	// with the OCI referrers API.
+	SociSignatureArtifactType = "application/vnd.amazon.soci.signature.v1+json"
+	// SignatureAnnotationSignature is the signature annotation of a signature manifest.
+	// The value is the base64 encoded ASN.1 ECDSA signature of the SHA-256 digest of
+	// the SOCI index digest string.
+	SignatureAnnotationSignature = "com.amazon.soci.signature"
+)
+
+var (
+	// ErrNoSignature is returned when a SOCI index has no signature.
+	ErrNoSignature = errors.New("no signature found for SOCI index")
+	// ErrInvalidSignature is returned when a signature of a SOCI index was not
+	// created by a trusted key.
+	ErrInvalidSignature = errors.New("invalid SOCI index signature")
+
+	// signatureConfigDescriptor is the descriptor of the config object of a signature manifest.
+	signatureConfigDescriptor = ocispec.Descriptor{
+		// The Config's media type is set to `SociSignatureArtifactType` so that registries
+		// which don't support the artifactType field can still filter signatures.
+		MediaType: SociSignatureArtifactType,
+		Digest:    emptyJSONObjectDigest,
+		Size:      2,
+	}
+)
+
+// LoadSigningKey reads a PEM encoded ECDSA private key (PKCS #8 or SEC 1) from a file.
+func LoadSigningKey(path string) (*ecdsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not an ECDSA key", path)
+	}
+	return ecKey, nil
+}
+
+// LoadTrustedKeys reads PEM encoded (PKIX) ECDSA public keys from files.
+func LoadTrustedKeys(paths []string) ([]*ecdsa.PublicKey, error) {
+	keys := make([]*ecdsa.PublicKey, 0, len(paths))
+	for _, path := range paths {
+		block, err := readPEM(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+		}
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key %s is not an ECDSA key", path)
+		}
+		keys = append(keys, ecKey)
+	}
+	return keys, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return block, nil
+}
+
+// signatureHash returns the hash which is signed for a SOCI index. The index digest
+// covers the digests of every ztoc and prefetch artifact in the index.
+func signatureHash(indexDigest digest.Digest) []byte {
+	h := sha256.Sum256([]byte(indexDigest.String()))
+	return h[:]
+}
+
+// NewSignatureManifest signs a SOCI index with `key` and returns the signature
+// manifest. The subject of the manifest is the SOCI index.
+func NewSignatureManifest(key *ecdsa.PrivateKey, indexDesc ocispec.Descriptor) (*ocispec.Manifest, error) {
+	sig, err := ecdsa.SignASN1(rand.Reader, key, signatureHash(indexDesc.Digest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign SOCI index %s: %w", indexDesc.Digest, err)
+	}
+	subject := ocispec.Descriptor{
+		MediaType:    indexDesc.MediaType,
+		ArtifactType: indexDesc.ArtifactType,
+		Digest:       indexDesc.Digest,
+		Size:         indexDesc.Size,
+	}
+	return &ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		// The signature is stored in an annotation, so the manifest only has the
+		// empty layer recommended for artifacts without content.
+		ArtifactType: SociSignatureArtifactType,
+		Config:       signatureConfigDescriptor,
+		Layers:       []ocispec.Descriptor{ocispec.DescriptorEmptyJSON},
+		Subject:      &subject,
+		Annotations: map[string]string{
+			SignatureAnnotationSignature: base64.StdEncoding.EncodeToString(sig),
+		},
+	}, nil
+}
+
+// VerifySignatureManifest checks that a signature manifest contains a signature of
+// the SOCI index `indexDigest` created by one of `keys`.
+func VerifySignatureManifest(b []byte, indexDigest digest.Digest, keys []*ecdsa.PublicKey) error {
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return fmt.Errorf("%w: cannot unmarshal signature manifest: %w", ErrInvalidSignature, err)
+	}
+	if manifest.Subject == nil || manifest.Subject.Digest != indexDigest {
+		return fmt.Errorf("%w: signature subject is not %s", ErrInvalidSignature, indexDigest)
+	}
+	sig, err := base64.StdEncoding.DecodeString(manifest.Annotations[SignatureAnnotationSignature])
+	if err != nil || len(sig) == 0 {
+		return fmt.Errorf("%w: missing or malformed signature annotation", ErrInvalidSignature)
+	}
+	hash := signatureHash(indexDigest)
+	for _, key := range keys {
+		if ecdsa.VerifyASN1(key, hash, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: signature of %s doesn't match any trusted key", ErrInvalidSignature, indexDigest)
+}
+
+// WriteIndexSignature signs a SOCI index with `key` and writes the signature manifest
+// to `blobStore`. The signature is recorded in the artifacts db so that it can be
+// pushed with the index.
+func WriteIndexSignature(ctx context.Context, blobStore store.Store, artifactsDb *ArtifactsDb, key *ecdsa.PrivateKey, indexDesc ocispec.Descriptor) (ocispec.Descriptor, error) {
+	manifest, err := NewSignatureManifest(key, indexDesc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("cannot marshal signature manifest: %w", err)
+	}
+	desc := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: SociSignatureArtifactType,
+		Digest:       digest.FromBytes(b),
+		Size:         int64(len(b)),
+	}
+
+	for _, blob := range []ocispec.Descriptor{signatureConfigDescriptor, ocispec.DescriptorEmptyJSON} {
+		err = blobStore.Push(ctx, blob, bytes.NewReader(defaultConfigContent))
+		if err != nil && !store.IsErrAlreadyExists(err) {
+			return ocispec.Descriptor{}, fmt.Errorf("error creating empty signature config: %w", err)
+		}
+	}
+	err = blobStore.Push(ctx, desc, bytes.NewReader(b))
+	if err != nil && !store.IsErrAlreadyExists(err) {
+		return ocispec.Descriptor{}, fmt.Errorf("cannot write signature to local store: %w", err)
+	}
+	// The signature is kept as long as the index it signs.
+	err = store.LabelGCRefContent(ctx, blobStore, indexDesc, "signature", desc.Digest.String())
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("cannot apply garbage collection label to index %s referencing signature: %w", indexDesc.Digest, err)
+	}
+
+	entry := &ArtifactEntry{
+		Digest:         desc.Digest.String(),
+		OriginalDigest: indexDesc.Digest.String(),
+		Type:           ArtifactEntryTypeSignature,
+		Size:           desc.Size,
+		MediaType:      desc.MediaType,
+		ArtifactType:   SociSignatureArtifactType,
+		CreatedAt:      time.Now(),
+	}
+	return desc, artifactsDb.WriteArtifactEntry(entry)
+}
+
+// GetIndexSignatures returns the descriptors of the signatures of a SOCI index
+// recorded in the artifacts db.
+func (db *ArtifactsDb) GetIndexSignatures(indexDigest string) ([]ocispec.Descriptor, error) {
+	var descs []ocispec.Descriptor
+	err := db.Walk(func(ae *ArtifactEntry) error {
+		if ae.Type == ArtifactEntryTypeSignature && ae.OriginalDigest == indexDigest {
+			descs = append(descs, ocispec.Descriptor{
+				MediaType:    ae.MediaType,
+				ArtifactType: ae.ArtifactType,
+				Digest:       digest.Digest(ae.Digest),
+				Size:         ae.Size,
+			})
+		}
+		return nil
+	})
+	return descs, err
+}
@@ -0,0 +1,169 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestIndexSignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDesc := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: SociIndexArtifactTypeV2,
+		Digest:       digest.FromString("index"),
+		Size:         5,
+	}
+	manifest, err := NewSignatureManifest(key, indexDesc)
+	if err != nil {
+		t.Fatalf("failed to sign index: %v", err)
+	}
+	if manifest.ArtifactType != SociSignatureArtifactType {
+		t.Fatalf("unexpected artifact type %s", manifest.ArtifactType)
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := *manifest
+	tampered.Annotations = map[string]string{SignatureAnnotationSignature: "bm90IGEgc2lnbmF0dXJl"}
+	tamperedBytes, err := json.Marshal(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		manifest    []byte
+		indexDigest digest.Digest
+		keys        []*ecdsa.PublicKey
+		valid       bool
+	}{
+		{
+			name:        "signature by trusted key",
+			manifest:    b,
+			indexDigest: indexDesc.Digest,
+			keys:        []*ecdsa.PublicKey{&otherKey.PublicKey, &key.PublicKey},
+			valid:       true,
+		},
+		{
+			name:        "signature by untrusted key",
+			manifest:    b,
+			indexDigest: indexDesc.Digest,
+			keys:        []*ecdsa.PublicKey{&otherKey.PublicKey},
+		},
+		{
+			name:        "signature of another index",
+			manifest:    b,
+			indexDigest: digest.FromString("other index"),
+			keys:        []*ecdsa.PublicKey{&key.PublicKey},
+		},
+		{
+			name:        "tampered signature",
+			manifest:    tamperedBytes,
+			indexDigest: indexDesc.Digest,
+			keys:        []*ecdsa.PublicKey{&key.PublicKey},
+		},
+		{
+			name:        "not a manifest",
+			manifest:    []byte("not json"),
+			indexDigest: indexDesc.Digest,
+			keys:        []*ecdsa.PublicKey{&key.PublicKey},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifySignatureManifest(tc.manifest, tc.indexDigest, tc.keys)
+			if tc.valid && err != nil {
+				t.Fatalf("expected valid signature, got %v", err)
+			}
+			if !tc.valid && !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("expected %v, got %v", ErrInvalidSignature, err)
+			}
+		})
+	}
+}
+
+func TestLoadSigningKeys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writePEM := func(name, blockType string, b []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	sec1, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{writePEM("sec1.pem", "EC PRIVATE KEY", sec1), writePEM("pkcs8.pem", "PRIVATE KEY", pkcs8)} {
+		loaded, err := LoadSigningKey(path)
+		if err != nil {
+			t.Fatalf("failed to load %s: %v", path, err)
+		}
+		if !loaded.Equal(key) {
+			t.Fatalf("loaded key from %s doesn't match", path)
+		}
+	}
+
+	keys, err := LoadTrustedKeys([]string{writePEM("pub.pem", "PUBLIC KEY", pub)})
+	if err != nil {
+		t.Fatalf("failed to load public key: %v", err)
+	}
+	if len(keys) != 1 || !keys[0].Equal(&key.PublicKey) {
+		t.Fatalf("loaded public key doesn't match")
+	}
+
+	if _, err := LoadTrustedKeys([]string{writePEM("garbage.pem", "PUBLIC KEY", []byte("garbage"))}); err == nil {
+		t.Fatalf("expected an error loading an invalid public key")
+	}
+}
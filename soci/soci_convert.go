@@ -77,12 +77,30 @@ func ConvertWithNoGarbageCollectionLabels() ConvertOption {
 // If the image is a single platform image, this function will create an OCI index so that it can bundle the
 // image and SOCI index into a single artifact.
 func (b *IndexBuilder) Convert(ctx context.Context, img images.Image, opts ...ConvertOption) (*ocispec.Descriptor, error) {
-	allPlatforms, err := images.Platforms(ctx, b.contentStore, img.Target)
+	convertCfg, err := b.newConvertConfig(ctx, &img, opts)
 	if err != nil {
 		return nil, err
 	}
+
+	// Create the SOCI Indexes
+	indexes, err := b.buildSociIndexesv2ForPlatforms(ctx, img, convertCfg.platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.addIndexesToImage(ctx, img, indexes, convertCfg.gcRoot)
+}
+
+// newConvertConfig applies `opts` to the default conversion config of `img`.
+// If the target of `img` is a single image manifest, newConvertConfig also sets
+// the platform of the image on the target.
+func (b *IndexBuilder) newConvertConfig(ctx context.Context, img *images.Image, opts []ConvertOption) (convertConfig, error) {
+	allPlatforms, err := images.Platforms(ctx, b.contentStore, img.Target)
+	if err != nil {
+		return convertConfig{}, err
+	}
 	if len(allPlatforms) == 0 {
-		return nil, errors.New("image does not support any platforms")
+		return convertConfig{}, errors.New("image does not support any platforms")
 	}
 	defaultPlatform := platforms.DefaultSpec()
 	if images.IsManifestType(img.Target.MediaType) {
@@ -109,19 +127,40 @@ func (b *IndexBuilder) Convert(ctx context.Context, img images.Image, opts ...Co
 	for _, opt := range opts {
 		err := opt(&convertCfg)
 		if err != nil {
-			return nil, err
+			return convertConfig{}, err
 		}
 	}
 	convertCfg.platforms = ociutil.DedupePlatforms(convertCfg.platforms)
+	return convertCfg, nil
+}
 
-	// Initialize the OCI Index
-	ociIndex, err := b.newOciIndex(ctx, img)
-	if err != nil {
-		return nil, err
+// UpdateConvertedImage replaces SOCI index manifests v2 of an image converted by `Convert`,
+// e.g. after updating their prefetch artifacts with `UpdatePrefetchPaths`. The `ManifestDesc`
+// of each index must be the image manifest which references the index being replaced.
+//
+// Like `Convert`, this creates new image manifests and a new OCI index. It returns the
+// descriptor of the new OCI index, which should be used as the target of the image.
+func (b *IndexBuilder) UpdateConvertedImage(ctx context.Context, img images.Image, indexes []*IndexWithMetadata, opts ...ConvertOption) (*ocispec.Descriptor, error) {
+	if !images.IsIndexType(img.Target.MediaType) {
+		return nil, fmt.Errorf("image %s is not a converted image: %w", img.Name, errdefs.ErrInvalidArgument)
 	}
+	convertCfg := convertConfig{
+		gcRoot: true,
+	}
+	for _, opt := range opts {
+		err := opt(&convertCfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.addIndexesToImage(ctx, img, indexes, convertCfg.gcRoot)
+}
 
-	// Create the SOCI Indexes
-	indexes, err := b.buildSociIndexesv2ForPlatforms(ctx, img, convertCfg.platforms)
+// addIndexesToImage annotates the image manifests of `img` with the digests of SOCI indexes
+// `indexes` and adds the indexes to a new OCI index, which is pushed to the blob store.
+func (b *IndexBuilder) addIndexesToImage(ctx context.Context, img images.Image, indexes []*IndexWithMetadata, gcRoot bool) (*ocispec.Descriptor, error) {
+	// Initialize the OCI Index
+	ociIndex, err := b.newOciIndex(ctx, img)
 	if err != nil {
 		return nil, err
 	}
@@ -158,7 +197,7 @@ func (b *IndexBuilder) Convert(ctx context.Context, img images.Image, opts ...Co
 			return nil, err
 		}
 	}
-	if convertCfg.gcRoot {
+	if gcRoot {
 		err := store.LabelGCRoot(ctx, b.blobStore, ociIndexDesc)
 		if err != nil {
 			return nil, err
@@ -243,6 +282,10 @@ func (b *IndexBuilder) newOciIndex(ctx context.Context, img images.Image) (ocisp
 func (b *IndexBuilder) annotateImages(ctx context.Context, ociIndex *ocispec.Index, sociIndexes []*IndexWithMetadata) error {
 	for i := 0; i < len(ociIndex.Manifests); i++ {
 		manifestDesc := &ociIndex.Manifests[i]
+		// The OCI index of a converted image already contains SOCI indexes, which are not images.
+		if manifestDesc.ArtifactType == SociIndexArtifactTypeV2 {
+			continue
+		}
 
 		var indexWithMetadata *IndexWithMetadata
 		idx := slices.IndexFunc(sociIndexes, func(i *IndexWithMetadata) bool { return i.ManifestDesc.Digest == manifestDesc.Digest })
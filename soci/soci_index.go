@@ -297,6 +297,10 @@ type builderConfig struct {
 	optimizations       []Optimization
 	forceRecreateZtocs  bool
 	prefetchPaths       []string
+	prefetchPriorities  map[string]int
+	fileDigests         bool
+	remoteZtocSource    *RemoteZtocSource
+	created             time.Time
 }
 
 func (b *builderConfig) hasOptimization(o Optimization) bool {
@@ -395,6 +399,51 @@ func WithPrefetchPaths(paths []string) BuilderOption {
 	}
 }
 
+// WithPrefetchPathPriorities sets the priority of the spans of prefetch paths.
+// Lower values are prefetched first. Paths which are not in `priorities` have priority 0.
+func WithPrefetchPathPriorities(priorities map[string]int) BuilderOption {
+	return func(c *builderConfig) error {
+		c.prefetchPriorities = priorities
+		return nil
+	}
+}
+
+// WithFileDigests specifies whether the digest of every regular file is added
+// to the ztocs. File digests are enabled by default.
+func WithFileDigests(enabled bool) BuilderOption {
+	return func(c *builderConfig) error {
+		c.fileDigests = enabled
+		return nil
+	}
+}
+
+// WithRemoteZtocSource specifies a `RemoteZtocSource` which is searched for existing
+// ztocs of layers which don't have a ztoc in the artifacts database. Remote ztocs are
+// only reused if they were built with the same settings as the builder.
+func WithRemoteZtocSource(source *RemoteZtocSource) BuilderOption {
+	return func(c *builderConfig) error {
+		c.remoteZtocSource = source
+		return nil
+	}
+}
+
+// WithCreated specifies the time set as the `org.opencontainers.image.created` annotation
+// of the built indexes, which the `newest` index selection policy of the snapshotter uses.
+// The annotation is not set by default, so that building an index is deterministic.
+func WithCreated(created time.Time) BuilderOption {
+	return func(c *builderConfig) error {
+		c.created = created
+		return nil
+	}
+}
+
+// annotateCreated sets the created annotation in `annotations` if it is configured.
+func (b *builderConfig) annotateCreated(annotations map[string]string) {
+	if !b.created.IsZero() {
+		annotations[ocispec.AnnotationCreated] = b.created.UTC().Format(time.RFC3339)
+	}
+}
+
 // BuildOption is a functional argument that affects a single SOCI Index build.
 type BuildOption func(*buildConfig) error
 
@@ -453,6 +502,7 @@ func NewIndexBuilder(contentStore content.Store, blobStore store.Store, opts ...
 		spanSize:            defaultSpanSize,
 		minLayerSize:        defaultMinLayerSize,
 		buildToolIdentifier: defaultBuildToolIdentifier,
+		fileDigests:         true,
 	}
 
 	for _, opt := range opts {
@@ -615,6 +665,7 @@ func (b *IndexBuilder) build(ctx context.Context, img images.Image, buildCfg bui
 	annotations := map[string]string{
 		IndexAnnotationBuildToolIdentifier: b.config.buildToolIdentifier,
 	}
+	b.config.annotateCreated(annotations)
 
 	refers := &ocispec.Descriptor{
 		MediaType: imgManifestDesc.MediaType,
@@ -668,7 +719,7 @@ func (b *IndexBuilder) buildSociLayer(ctx context.Context, desc ocispec.Descript
 	}
 
 	if !b.ztocBuilder.CheckCompressionAlgorithm(compressionAlgo) {
-		fmt.Printf("ztoc skipped - layer %s (%s) is compressed in an unsupported format. expect: [tar, gzip, unknown] but got %q\n",
+		fmt.Printf("ztoc skipped - layer %s (%s) is compressed in an unsupported format. expect: [tar, gzip, zstd, unknown] but got %q\n",
 			desc.Digest, desc.MediaType, compressionAlgo)
 		return nil, nil, errUnsupportedLayerFormat
 	}
@@ -697,6 +748,15 @@ func (b *IndexBuilder) buildSociLayer(ctx context.Context, desc ocispec.Descript
 		}
 	}
 
+	if toc, data, ztocDesc := b.getRemoteZtocForLayer(ctx, desc, compressionAlgo); toc != nil {
+		if err := b.storeZtoc(ctx, desc, *ztocDesc, bytes.NewReader(data)); err != nil {
+			return nil, nil, err
+		}
+		fmt.Printf("layer %s -> ztoc %s (reused from remote repository)\n", desc.Digest, ztocDesc.Digest)
+		b.addSociLayerAnnotations(&desc, ztocDesc, toc)
+		return ztocDesc, toc, nil
+	}
+
 	ra, err := b.contentStore.ReaderAt(ctx, desc)
 	if err != nil {
 		return nil, nil, err
@@ -704,32 +764,36 @@ func (b *IndexBuilder) buildSociLayer(ctx context.Context, desc ocispec.Descript
 	defer ra.Close()
 	sr := io.NewSectionReader(ra, 0, desc.Size)
 
-	tmpFile, err := os.CreateTemp("", "tmp.*")
-	if err != nil {
-		return nil, nil, err
+	ztocOpts := []ztoc.BuildOption{ztoc.WithCompression(compressionAlgo)}
+	if b.config.fileDigests {
+		ztocOpts = append(ztocOpts, ztoc.WithFileDigests())
 	}
-	defer os.Remove(tmpFile.Name())
-	n, err := io.Copy(tmpFile, sr)
+	toc, err := b.ztocBuilder.BuildZtocFromReader(sr, b.config.spanSize, ztocOpts...)
 	if err != nil {
 		return nil, nil, err
 	}
-	if n != desc.Size {
-		return nil, nil, errors.New("the size of the temp file doesn't match that of the layer")
+	if toc.CompressedArchiveSize != compression.Offset(desc.Size) {
+		return nil, nil, errors.New("the size of the read data doesn't match that of the layer")
 	}
 
-	toc, err := b.ztocBuilder.BuildZtoc(tmpFile.Name(), b.config.spanSize, ztoc.WithCompression(compressionAlgo))
+	ztocReader, ztocDesc, err := ztoc.Marshal(toc)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	ztocReader, ztocDesc, err := ztoc.Marshal(toc)
-	if err != nil {
+	if err := b.storeZtoc(ctx, desc, ztocDesc, ztocReader); err != nil {
 		return nil, nil, err
 	}
 
-	err = b.blobStore.Push(ctx, ztocDesc, ztocReader)
+	fmt.Printf("layer %s -> ztoc %s\n", desc.Digest, ztocDesc.Digest)
+	b.addSociLayerAnnotations(&desc, &ztocDesc, toc)
+	return &ztocDesc, toc, nil
+}
+
+// storeZtoc pushes the ztoc of a layer to the blob store and writes its artifact entry.
+func (b *IndexBuilder) storeZtoc(ctx context.Context, layerDesc ocispec.Descriptor, ztocDesc ocispec.Descriptor, ztocReader io.Reader) error {
+	err := b.blobStore.Push(ctx, ztocDesc, ztocReader)
 	if err != nil && !store.IsErrAlreadyExists(err) {
-		return nil, nil, fmt.Errorf("cannot push ztoc to local store: %w", err)
+		return fmt.Errorf("cannot push ztoc to local store: %w", err)
 	}
 
 	// write the artifact entry for soci layer
@@ -737,21 +801,14 @@ func (b *IndexBuilder) buildSociLayer(ctx context.Context, desc ocispec.Descript
 	entry := &ArtifactEntry{
 		Size:           ztocDesc.Size,
 		Digest:         ztocDesc.Digest.String(),
-		OriginalDigest: desc.Digest.String(),
+		OriginalDigest: layerDesc.Digest.String(),
 		Type:           ArtifactEntryTypeLayer,
-		Location:       desc.Digest.String(),
+		Location:       layerDesc.Digest.String(),
 		MediaType:      SociLayerMediaType,
 		CreatedAt:      time.Now(),
 		SpanSize:       b.config.spanSize,
 	}
-	err = b.config.artifactsDb.WriteArtifactEntry(entry)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	fmt.Printf("layer %s -> ztoc %s\n", desc.Digest, ztocDesc.Digest)
-	b.addSociLayerAnnotations(&desc, &ztocDesc, toc)
-	return &ztocDesc, toc, nil
+	return b.config.artifactsDb.WriteArtifactEntry(entry)
 }
 
 // buildPrefetchLayer builds and stores prefetch artifacts for layers that contain prefetch files
@@ -760,14 +817,43 @@ func (b *IndexBuilder) buildPrefetchLayer(ctx context.Context, builtZtocs []*zto
 		return nil, nil
 	}
 
-	layerZtocMap := make(map[string]*ztocWithLayer, len(builtZtocs))
-	for _, zwl := range builtZtocs {
+	layerPrefetchSpansMap := prefetchSpansForPaths(ctx, builtZtocs, layers, b.config.prefetchPaths, b.config.prefetchPriorities)
+	if len(layerPrefetchSpansMap) == 0 {
+		return nil, nil
+	}
+
+	prefetchDescs := make([]*ocispec.Descriptor, 0, len(layerPrefetchSpansMap))
+	for _, layer := range layers {
+		layerDigest := layer.Digest.String()
+		prefetchSpans, ok := layerPrefetchSpansMap[layerDigest]
+		if !ok || len(prefetchSpans) == 0 {
+			continue
+		}
+
+		prefetchDesc, err := b.storePrefetchLayer(ctx, layerDigest, prefetchSpans)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store prefetch artifact for layer %s: %w", layerDigest, err)
+		} else if prefetchDesc != nil {
+			prefetchDescs = append(prefetchDescs, prefetchDesc)
+		}
+	}
+
+	return prefetchDescs, nil
+}
+
+// prefetchSpansForPaths returns the spans of each layer which contain the files `paths`.
+// A path is resolved in the topmost layer of `layers` which contains it, so files
+// overridden by upper layers are not prefetched.
+func prefetchSpansForPaths(ctx context.Context, ztocs []*ztocWithLayer, layers []ocispec.Descriptor, paths []string, priorities map[string]int) map[string][]PrefetchSpan {
+	layerZtocMap := make(map[string]*ztocWithLayer, len(ztocs))
+	for _, zwl := range ztocs {
 		layerZtocMap[zwl.layerDigest] = zwl
 	}
 
 	layerPrefetchSpansMap := make(map[string][]PrefetchSpan)
-	for _, prefetchPath := range b.config.prefetchPaths {
+	for _, prefetchPath := range paths {
 		found := false
+		priority := priorities[prefetchPath]
 		// Strip leading slash if absolute path is given in prefetchPaths
 		// Copied from cleanEntryPath in metadata/reader.go
 		prefetchPath = strings.TrimPrefix(filepath.Clean(string(os.PathSeparator)+prefetchPath), string(os.PathSeparator))
@@ -803,6 +889,7 @@ func (b *IndexBuilder) buildPrefetchLayer(ctx context.Context, builtZtocs []*zto
 					layerPrefetchSpansMap[layerDigest] = append(layerPrefetchSpansMap[layerDigest], PrefetchSpan{
 						StartSpan: startSpan,
 						EndSpan:   endSpan,
+						Priority:  priority,
 					})
 					found = true
 					break PrefetchPathLoop
@@ -814,27 +901,7 @@ func (b *IndexBuilder) buildPrefetchLayer(ctx context.Context, builtZtocs []*zto
 		}
 	}
 
-	if len(layerPrefetchSpansMap) == 0 {
-		return nil, nil
-	}
-
-	prefetchDescs := make([]*ocispec.Descriptor, 0, len(layerPrefetchSpansMap))
-	for _, layer := range layers {
-		layerDigest := layer.Digest.String()
-		prefetchSpans, ok := layerPrefetchSpansMap[layerDigest]
-		if !ok || len(prefetchSpans) == 0 {
-			continue
-		}
-
-		prefetchDesc, err := b.storePrefetchLayer(ctx, layerDigest, prefetchSpans)
-		if err != nil {
-			return nil, fmt.Errorf("failed to store prefetch artifact for layer %s: %w", layerDigest, err)
-		} else if prefetchDesc != nil {
-			prefetchDescs = append(prefetchDescs, prefetchDesc)
-		}
-	}
-
-	return prefetchDescs, nil
+	return layerPrefetchSpansMap
 }
 
 func (b *IndexBuilder) addSociLayerAnnotations(layerDesc *ocispec.Descriptor, ztocDesc *ocispec.Descriptor, toc *ztoc.Ztoc) {
@@ -847,23 +914,41 @@ func (b *IndexBuilder) addSociLayerAnnotations(layerDesc *ocispec.Descriptor, zt
 	b.maybeAddDisableXattrAnnotation(ztocDesc, toc)
 }
 
+// normalizePrefetchSpans sorts spans by start span and merges overlapping and adjacent
+// spans of the same priority.
 func normalizePrefetchSpans(spans []PrefetchSpan) []PrefetchSpan {
 	if len(spans) == 0 {
 		return nil
 	}
 
-	sort.Slice(spans, func(i, j int) bool {
-		if spans[i].StartSpan == spans[j].StartSpan {
-			return spans[i].EndSpan < spans[j].EndSpan
+	// Spans are claimed in priority order, so a span ID covered by several spans
+	// keeps the highest priority (i.e. the lowest value).
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].Priority != spans[j].Priority {
+			return spans[i].Priority < spans[j].Priority
 		}
 		return spans[i].StartSpan < spans[j].StartSpan
 	})
+	var claimed []PrefetchSpan
+	for _, s := range spans {
+		if s.EndSpan < s.StartSpan {
+			continue
+		}
+		claimed = append(claimed, subtractPrefetchSpans(s, claimed)...)
+	}
+	if len(claimed) == 0 {
+		return nil
+	}
+
+	sort.Slice(claimed, func(i, j int) bool {
+		return claimed[i].StartSpan < claimed[j].StartSpan
+	})
 
-	merged := make([]PrefetchSpan, 0, len(spans))
-	current := spans[0]
-	for i := 1; i < len(spans); i++ {
-		s := spans[i]
-		if s.StartSpan <= current.EndSpan+1 {
+	merged := make([]PrefetchSpan, 0, len(claimed))
+	current := claimed[0]
+	for i := 1; i < len(claimed); i++ {
+		s := claimed[i]
+		if s.StartSpan <= current.EndSpan+1 && s.Priority == current.Priority {
 			if s.EndSpan > current.EndSpan {
 				current.EndSpan = s.EndSpan
 			}
@@ -877,6 +962,28 @@ func normalizePrefetchSpans(spans []PrefetchSpan) []PrefetchSpan {
 	return merged
 }
 
+// subtractPrefetchSpans returns the parts of `span` which are not covered by any of `covered`.
+func subtractPrefetchSpans(span PrefetchSpan, covered []PrefetchSpan) []PrefetchSpan {
+	remaining := []PrefetchSpan{span}
+	for _, c := range covered {
+		var next []PrefetchSpan
+		for _, r := range remaining {
+			if c.EndSpan < r.StartSpan || c.StartSpan > r.EndSpan {
+				next = append(next, r)
+				continue
+			}
+			if r.StartSpan < c.StartSpan {
+				next = append(next, PrefetchSpan{StartSpan: r.StartSpan, EndSpan: c.StartSpan - 1, Priority: r.Priority})
+			}
+			if r.EndSpan > c.EndSpan {
+				next = append(next, PrefetchSpan{StartSpan: c.EndSpan + 1, EndSpan: r.EndSpan, Priority: r.Priority})
+			}
+		}
+		remaining = next
+	}
+	return remaining
+}
+
 func (b *IndexBuilder) storePrefetchLayer(ctx context.Context, layerDigest string, prefetchSpans []PrefetchSpan) (*ocispec.Descriptor, error) {
 	artifact := NewPrefetchArtifact()
 
@@ -1037,26 +1144,8 @@ func (b *IndexBuilder) writeSociIndex(ctx context.Context, indexWithMetadata *In
 
 	log.G(ctx).WithField("digest", dgst.String()).Debugf("soci index has been written")
 
-	if gcRoot {
-		err = store.LabelGCRoot(ctx, b.blobStore, desc)
-		if err != nil {
-			return ocispec.Descriptor{}, fmt.Errorf("cannot apply garbage collection label to index %s: %w", desc.Digest.String(), err)
-		}
-	}
-	err = store.LabelGCRefContent(ctx, b.blobStore, desc, "config", defaultConfigDescriptor.Digest.String())
-	if err != nil {
-		return ocispec.Descriptor{}, fmt.Errorf("cannot apply garbage collection label to index %s referencing default config: %w", desc.Digest.String(), err)
-	}
-
-	var allErr error
-	for i, blob := range indexWithMetadata.Index.Blobs {
-		err = store.LabelGCRefContent(ctx, b.blobStore, desc, "ztoc."+strconv.Itoa(i), blob.Digest.String())
-		if err != nil {
-			errors.Join(allErr, err)
-		}
-	}
-	if allErr != nil {
-		return ocispec.Descriptor{}, fmt.Errorf("cannot apply one or more garbage collection labels to index %s: %w", desc.Digest.String(), allErr)
+	if err := labelSociIndex(ctx, b.blobStore, desc, indexWithMetadata.Index.Blobs, gcRoot); err != nil {
+		return ocispec.Descriptor{}, err
 	}
 
 	refers := indexWithMetadata.Index.Subject
@@ -1081,6 +1170,34 @@ func (b *IndexBuilder) writeSociIndex(ctx context.Context, indexWithMetadata *In
 	return desc, b.config.artifactsDb.WriteArtifactEntry(entry)
 }
 
+// labelSociIndex applies the garbage collection labels of a SOCI index, which keep
+// its config and its ztocs and prefetch artifacts. If `gcRoot` is true, the index is
+// also labeled as a root, otherwise it must be referenced by an image to be kept.
+func labelSociIndex(ctx context.Context, blobStore store.Store, desc ocispec.Descriptor, blobs []ocispec.Descriptor, gcRoot bool) error {
+	if gcRoot {
+		err := store.LabelGCRoot(ctx, blobStore, desc)
+		if err != nil {
+			return fmt.Errorf("cannot apply garbage collection label to index %s: %w", desc.Digest.String(), err)
+		}
+	}
+	err := store.LabelGCRefContent(ctx, blobStore, desc, "config", defaultConfigDescriptor.Digest.String())
+	if err != nil {
+		return fmt.Errorf("cannot apply garbage collection label to index %s referencing default config: %w", desc.Digest.String(), err)
+	}
+
+	var allErr error
+	for i, blob := range blobs {
+		err = store.LabelGCRefContent(ctx, blobStore, desc, "ztoc."+strconv.Itoa(i), blob.Digest.String())
+		if err != nil {
+			allErr = errors.Join(allErr, err)
+		}
+	}
+	if allErr != nil {
+		return fmt.Errorf("cannot apply one or more garbage collection labels to index %s: %w", desc.Digest.String(), allErr)
+	}
+	return nil
+}
+
 func (b *IndexBuilder) maybeAddDisableXattrAnnotation(ztocDesc *ocispec.Descriptor, ztoc *ztoc.Ztoc) {
 	if b.config.hasOptimization(XAttrOptimization) && shouldDisableXattrs(ztoc) {
 		if ztocDesc.Annotations == nil {
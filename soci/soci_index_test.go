@@ -22,9 +22,13 @@ import (
 	"encoding/json"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/awslabs/soci-snapshotter/util/testutil"
 	"github.com/awslabs/soci-snapshotter/ztoc"
 	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/containerd/platforms"
 	"github.com/google/go-cmp/cmp"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -336,6 +340,51 @@ func TestBuildSociIndexWithLimits(t *testing.T) {
 	}
 }
 
+func TestBuildSociIndexCreatedAnnotation(t *testing.T) {
+	ctx := context.Background()
+	r := testutil.NewTestRand(t)
+	cs, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("can't create content store: %v", err)
+	}
+	platform := platforms.DefaultSpec()
+	img := newTestImage(ctx, t, cs, platform, testutil.File("file", string(r.RandomByteData(100000))))
+	build := func(t *testing.T, opts ...BuilderOption) *IndexWithMetadata {
+		artifactsDb, err := newTestableDb()
+		if err != nil {
+			t.Fatalf("can't create a test db: %v", err)
+		}
+		opts = append([]BuilderOption{WithArtifactsDb(artifactsDb), WithSpanSize(1 << 16), WithMinLayerSize(0)}, opts...)
+		builder, err := NewIndexBuilder(cs, NewOrasMemoryStore(), opts...)
+		if err != nil {
+			t.Fatalf("can't create index builder: %v", err)
+		}
+		index, err := builder.Build(ctx, img, WithPlatform(platform))
+		if err != nil {
+			t.Fatalf("can't build SOCI index: %v", err)
+		}
+		return index
+	}
+
+	t.Run("not set by default", func(t *testing.T) {
+		index := build(t)
+		if created, ok := index.Index.Annotations[ocispec.AnnotationCreated]; ok {
+			t.Fatalf("expected no created annotation, got %s", created)
+		}
+		if again := build(t); again.Desc.Digest != index.Desc.Digest {
+			t.Fatalf("expected building the index again to be deterministic, got %s and %s", index.Desc.Digest, again.Desc.Digest)
+		}
+	})
+
+	t.Run("set with WithCreated", func(t *testing.T) {
+		created := time.Date(2025, 1, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+		index := build(t, WithCreated(created))
+		if actual := index.Index.Annotations[ocispec.AnnotationCreated]; actual != "2025-01-01T11:00:00Z" {
+			t.Fatalf("unexpected created annotation %q", actual)
+		}
+	})
+}
+
 func TestDisableXattrs(t *testing.T) {
 	testcases := []struct {
 		name                string
@@ -718,6 +767,40 @@ func TestNormalizePrefetchSpans(t *testing.T) {
 				{StartSpan: 0, EndSpan: 30},
 			},
 		},
+		{
+			name: "keep adjacent spans with different priorities",
+			input: []PrefetchSpan{
+				{StartSpan: 0, EndSpan: 10, Priority: 1},
+				{StartSpan: 11, EndSpan: 20},
+			},
+			expected: []PrefetchSpan{
+				{StartSpan: 0, EndSpan: 10, Priority: 1},
+				{StartSpan: 11, EndSpan: 20},
+			},
+		},
+		{
+			name: "overlapping spans keep the highest priority",
+			input: []PrefetchSpan{
+				{StartSpan: 0, EndSpan: 30, Priority: 5},
+				{StartSpan: 10, EndSpan: 15, Priority: 1},
+				{StartSpan: 12, EndSpan: 20, Priority: 1},
+			},
+			expected: []PrefetchSpan{
+				{StartSpan: 0, EndSpan: 9, Priority: 5},
+				{StartSpan: 10, EndSpan: 20, Priority: 1},
+				{StartSpan: 21, EndSpan: 30, Priority: 5},
+			},
+		},
+		{
+			name: "lower priority span inside a higher priority span is dropped",
+			input: []PrefetchSpan{
+				{StartSpan: 5, EndSpan: 6, Priority: 2},
+				{StartSpan: 0, EndSpan: 10},
+			},
+			expected: []PrefetchSpan{
+				{StartSpan: 0, EndSpan: 10},
+			},
+		},
 	}
 
 	for _, tc := range testcases {
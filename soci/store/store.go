@@ -21,6 +21,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"os"
 	"path/filepath"
 	"sync"
 	"time"
@@ -55,6 +57,46 @@ type Store interface {
 	BatchOpen(ctx context.Context) (context.Context, CleanupFunc, error)
 }
 
+// Walker is implemented by stores which can list the content that they keep on their own.
+type Walker interface {
+	// Walk calls fn for every blob that is not removed by the store while it exists,
+	// e.g. every blob of a SOCI store, or the garbage collection roots of containerd.
+	Walk(ctx context.Context, fn func(ocispec.Descriptor) error) error
+}
+
+// ReaderAtStore is implemented by stores which can read their content at random offsets,
+// e.g. to serve the spans of a layer blob present on the host.
+type ReaderAtStore interface {
+	// ReaderAt returns a reader of the described content.
+	ReaderAt(ctx context.Context, target ocispec.Descriptor) (content.ReaderAt, error)
+}
+
+// UsageTracker is implemented by stores which record when content was last used,
+// so that the least recently used SOCI artifacts can be removed first.
+type UsageTracker interface {
+	// MarkUsed records that the content is used now.
+	MarkUsed(ctx context.Context, dgst digest.Digest) error
+	// LastUsed returns the time the content was last marked as used,
+	// or the time it was written if it was never marked as used.
+	LastUsed(ctx context.Context, dgst digest.Digest) (time.Time, error)
+}
+
+// MarkUsed records that content is used, if the store tracks the usage of its content.
+func MarkUsed(ctx context.Context, store Store, dgst digest.Digest) error {
+	if tracker, ok := store.(UsageTracker); ok {
+		return tracker.MarkUsed(ctx, dgst)
+	}
+	return nil
+}
+
+const (
+	// LabelLastUsed is the label of content in the containerd content store
+	// which records when the content was last used.
+	LabelLastUsed = "soci.amazon.com/last-used"
+
+	labelGCRoot = "containerd.io/gc.root"
+)
+
 type ContentStoreType = config.ContentStoreType
 
 const (
@@ -90,6 +132,12 @@ func IsErrAlreadyExists(err error) bool {
 		errors.Is(err, errdef.ErrAlreadyExists) // ORAS error
 }
 
+// IsErrNotFound is a store-type-agnostic way to check if the content doesn't exist in the store.
+func IsErrNotFound(err error) bool {
+	return errors.Is(err, errdefs.ErrNotFound) || // containerd error
+		errors.Is(err, errdef.ErrNotFound) // ORAS error
+}
+
 // This struct allows SOCI to create a connection to the containerd on-demand
 // instead of on startup, allowing the daemon to start without containerd
 type ContainerdClient struct {
@@ -225,10 +273,17 @@ func NewContentStore(opts ...Option) (Store, error) {
 // SociStore wraps oci.Store and adds or stubs additional functionality of the Store interface.
 type SociStore struct {
 	*oci.Store
+	// root is the directory of the OCI image layout, if the store was created with NewSociStore.
+	root string
 }
 
-// assert that SociStore implements Store
-var _ Store = (*SociStore)(nil)
+// assert that SociStore implements Store, Walker, UsageTracker and ReaderAtStore
+var (
+	_ Store         = (*SociStore)(nil)
+	_ Walker        = (*SociStore)(nil)
+	_ UsageTracker  = (*SociStore)(nil)
+	_ ReaderAtStore = (*SociStore)(nil)
+)
 
 // NewSociStore creates a sociStore.
 func NewSociStore(path string) (*SociStore, error) {
@@ -236,7 +291,13 @@ func NewSociStore(path string) (*SociStore, error) {
 		path = DefaultSociContentStorePath
 	}
 	store, err := oci.New(path)
-	return &SociStore{store}, err
+	if err != nil {
+		return nil, err
+	}
+	// The graph of oci.Store only knows the content pushed by this process, so removing
+	// dangling content on Delete could remove ztocs that are still used by other indexes.
+	store.AutoGC = false
+	return &SociStore{Store: store, root: path}, nil
 }
 
 // Label is a no-op for sociStore until sociStore and ArtifactsDb are better integrated.
@@ -244,9 +305,107 @@ func (s *SociStore) Label(_ context.Context, _ ocispec.Descriptor, _ string, _ s
 	return nil
 }
 
-// Delete is a no-op for sociStore until oci.Store provides this method.
-func (s *SociStore) Delete(_ context.Context, _ digest.Digest) error {
-	return nil
+// Delete removes the content from the store.
+func (s *SociStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	return s.Store.Delete(ctx, ocispec.Descriptor{Digest: dgst})
+}
+
+// Walk calls fn for every blob of the store.
+func (s *SociStore) Walk(ctx context.Context, fn func(ocispec.Descriptor) error) error {
+	if s.root == "" {
+		return fmt.Errorf("cannot walk SOCI store without root directory: %w", errdefs.ErrNotImplemented)
+	}
+	blobsDir := filepath.Join(s.root, ocispec.ImageBlobsDir)
+	return filepath.WalkDir(blobsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return ctx.Err()
+		}
+		dgst := digest.NewDigestFromEncoded(digest.Algorithm(filepath.Base(filepath.Dir(path))), d.Name())
+		if dgst.Validate() != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// The blob was deleted after it was listed.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return fn(ocispec.Descriptor{Digest: dgst, Size: info.Size()})
+	})
+}
+
+// MarkUsed sets the modification time of the blob to now.
+func (s *SociStore) MarkUsed(_ context.Context, dgst digest.Digest) error {
+	path, err := s.blobPath(dgst)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
+
+// LastUsed returns the modification time of the blob, which is set by MarkUsed.
+func (s *SociStore) LastUsed(_ context.Context, dgst digest.Digest) (time.Time, error) {
+	path, err := s.blobPath(dgst)
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return time.Time{}, fmt.Errorf("%s: %w", dgst, errdefs.ErrNotFound)
+		}
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// ReaderAt returns a reader of the described content.
+func (s *SociStore) ReaderAt(_ context.Context, target ocispec.Descriptor) (content.ReaderAt, error) {
+	path, err := s.blobPath(target.Digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", target.Digest, errdefs.ErrNotFound)
+		}
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileReaderAt{File: f, size: info.Size()}, nil
+}
+
+// fileReaderAt reads a blob of the SOCI store.
+type fileReaderAt struct {
+	*os.File
+	size int64
+}
+
+func (f *fileReaderAt) Size() int64 {
+	return f.size
+}
+
+func (s *SociStore) blobPath(dgst digest.Digest) (string, error) {
+	if s.root == "" {
+		return "", fmt.Errorf("SOCI store has no root directory: %w", errdefs.ErrNotImplemented)
+	}
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, ocispec.ImageBlobsDir, dgst.Algorithm().String(), dgst.Encoded()), nil
 }
 
 // BatchOpen is a no-op for sociStore; it does not support batching operations.
@@ -258,8 +417,13 @@ type ContainerdStore struct {
 	ContentStoreConfig
 }
 
-// assert that ContainerdStore implements Store
-var _ Store = (*ContainerdStore)(nil)
+// assert that ContainerdStore implements Store, Walker, UsageTracker and ReaderAtStore
+var (
+	_ Store         = (*ContainerdStore)(nil)
+	_ Walker        = (*ContainerdStore)(nil)
+	_ UsageTracker  = (*ContainerdStore)(nil)
+	_ ReaderAtStore = (*ContainerdStore)(nil)
+)
 
 func NewContainerdStore(storeConfig ContentStoreConfig) (*ContainerdStore, error) {
 	containerdStore := ContainerdStore{
@@ -309,6 +473,15 @@ func (s *ContainerdStore) Fetch(ctx context.Context, target ocispec.Descriptor)
 	return sectionReaderAt{ra, io.NewSectionReader(ra, 0, ra.Size())}, nil
 }
 
+// ReaderAt returns a reader of the described content.
+func (s *ContainerdStore) ReaderAt(ctx context.Context, target ocispec.Descriptor) (content.ReaderAt, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.ContentStore().ReaderAt(ctx, target)
+}
+
 // Push pushes the content, matching the expected descriptor.
 // This should be done within a Batch and followed by Label calls to prevent garbage collection.
 func (s *ContainerdStore) Push(ctx context.Context, expected ocispec.Descriptor, reader io.Reader) error {
@@ -364,7 +537,7 @@ func (s *ContainerdStore) Push(ctx context.Context, expected ocispec.Descriptor,
 
 // LabelGCRoot labels the target resource to prevent garbage collection of itself.
 func LabelGCRoot(ctx context.Context, store Store, target ocispec.Descriptor) error {
-	return store.Label(ctx, target, "containerd.io/gc.root", time.Now().Format(time.RFC3339))
+	return store.Label(ctx, target, labelGCRoot, time.Now().Format(time.RFC3339))
 }
 
 // LabelGCRefContent labels the target resource to prevent garbage collection of another resource identified by digest
@@ -419,6 +592,40 @@ func (s *ContainerdStore) BatchOpen(ctx context.Context) (context.Context, Clean
 	return ctx, leaseDone, nil
 }
 
+// Walk calls fn for the content labeled as a garbage collection root, which includes the
+// SOCI index manifests v1. Other content is removed by the garbage collection of containerd
+// once nothing references it.
+func (s *ContainerdStore) Walk(ctx context.Context, fn func(ocispec.Descriptor) error) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	return client.ContentStore().Walk(ctx, func(info content.Info) error {
+		return fn(ocispec.Descriptor{Digest: info.Digest, Size: info.Size})
+	}, fmt.Sprintf("labels.%q", labelGCRoot))
+}
+
+// MarkUsed records the current time in a label of the content.
+func (s *ContainerdStore) MarkUsed(ctx context.Context, dgst digest.Digest) error {
+	return s.Label(ctx, ocispec.Descriptor{Digest: dgst}, LabelLastUsed, time.Now().Format(time.RFC3339))
+}
+
+// LastUsed returns the time recorded by MarkUsed, or the last update of the content if it was never marked as used.
+func (s *ContainerdStore) LastUsed(ctx context.Context, dgst digest.Digest) (time.Time, error) {
+	client, err := s.client()
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := client.ContentStore().Info(ctx, dgst)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if lastUsed, err := time.Parse(time.RFC3339, info.Labels[LabelLastUsed]); err == nil {
+		return lastUsed, nil
+	}
+	return info.UpdatedAt, nil
+}
+
 func (s *ContainerdStore) client() (*containerd.Client, error) {
 	return s.ctdClient.Client()
 }
@@ -18,8 +18,11 @@ package store
 
 import (
 	"context"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/awslabs/soci-snapshotter/config"
 	"github.com/opencontainers/go-digest"
@@ -205,3 +208,97 @@ func TestStoreLabelGCRefContent(t *testing.T) {
 		t.Fatalf("label references wrong digest, expected \"%s\", got \"%s\"", testDigest.String(), store.Labels[0][2])
 	}
 }
+
+func TestSociStoreWalkDelete(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewSociStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("can't create SOCI store: %v", err)
+	}
+	blobs := map[digest.Digest]ocispec.Descriptor{}
+	for _, data := range []string{"first blob", "second blob"} {
+		desc := ocispec.Descriptor{MediaType: "application/octet-stream", Digest: digest.FromString(data), Size: int64(len(data))}
+		if err := store.Push(ctx, desc, strings.NewReader(data)); err != nil {
+			t.Fatalf("can't push blob: %v", err)
+		}
+		blobs[desc.Digest] = desc
+	}
+
+	walked := map[digest.Digest]int64{}
+	err = store.Walk(ctx, func(desc ocispec.Descriptor) error {
+		walked[desc.Digest] = desc.Size
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("can't walk SOCI store: %v", err)
+	}
+	if len(walked) != len(blobs) {
+		t.Fatalf("expected %d blobs, got %d", len(blobs), len(walked))
+	}
+	for dgst, desc := range blobs {
+		if walked[dgst] != desc.Size {
+			t.Fatalf("expected blob %s of size %d, got %d", dgst, desc.Size, walked[dgst])
+		}
+	}
+
+	before := time.Now().Add(-time.Hour)
+	for dgst := range blobs {
+		path, _ := store.blobPath(dgst)
+		if err := os.Chtimes(path, before, before); err != nil {
+			t.Fatalf("can't set blob times: %v", err)
+		}
+		if err := MarkUsed(ctx, store, dgst); err != nil {
+			t.Fatalf("can't mark blob as used: %v", err)
+		}
+		lastUsed, err := store.LastUsed(ctx, dgst)
+		if err != nil {
+			t.Fatalf("can't get last use of blob: %v", err)
+		}
+		if !lastUsed.After(before) {
+			t.Fatalf("expected blob to be used after %v, got %v", before, lastUsed)
+		}
+
+		if err := store.Delete(ctx, dgst); err != nil {
+			t.Fatalf("can't delete blob: %v", err)
+		}
+		if exists, err := store.Exists(ctx, blobs[dgst]); err != nil || exists {
+			t.Fatalf("expected blob %s to be deleted, exists=%v err=%v", dgst, exists, err)
+		}
+		if _, err := store.LastUsed(ctx, dgst); !IsErrNotFound(err) {
+			t.Fatalf("expected not found error for deleted blob, got %v", err)
+		}
+		if err := store.Delete(ctx, dgst); !IsErrNotFound(err) {
+			t.Fatalf("expected not found error when deleting blob twice, got %v", err)
+		}
+	}
+}
+
+func TestSociStoreReaderAt(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewSociStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("can't create SOCI store: %v", err)
+	}
+	data := "layer blob"
+	desc := ocispec.Descriptor{MediaType: "application/octet-stream", Digest: digest.FromString(data), Size: int64(len(data))}
+	if err := store.Push(ctx, desc, strings.NewReader(data)); err != nil {
+		t.Fatalf("can't push blob: %v", err)
+	}
+
+	ra, err := store.ReaderAt(ctx, desc)
+	if err != nil {
+		t.Fatalf("can't open blob: %v", err)
+	}
+	defer ra.Close()
+	if ra.Size() != desc.Size {
+		t.Fatalf("expected blob of size %d, got %d", desc.Size, ra.Size())
+	}
+	p := make([]byte, 4)
+	if _, err := ra.ReadAt(p, 6); err != nil || string(p) != "blob" {
+		t.Fatalf("unexpected read of blob: %q, %v", p, err)
+	}
+
+	if _, err := store.ReaderAt(ctx, ocispec.Descriptor{Digest: digest.FromString("missing")}); !IsErrNotFound(err) {
+		t.Fatalf("expected not found error for missing blob, got %v", err)
+	}
+}
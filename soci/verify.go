@@ -0,0 +1,242 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/awslabs/soci-snapshotter/soci/store"
+	"github.com/awslabs/soci-snapshotter/ztoc"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// BlobProblem is a blob referenced by a SOCI index which is missing or invalid.
+type BlobProblem struct {
+	Desc    ocispec.Descriptor
+	Problem string
+}
+
+func (p BlobProblem) String() string {
+	return fmt.Sprintf("blob %s (%s): %s", p.Desc.Digest, p.Desc.MediaType, p.Problem)
+}
+
+// ZtocVerifyResult is the result of verifying a ztoc against its layer.
+type ZtocVerifyResult struct {
+	ZtocDigest  digest.Digest
+	LayerDigest digest.Digest
+	Report      *ztoc.VerifyReport
+}
+
+// IndexVerifyReport contains the problems found by `VerifyIndex`.
+type IndexVerifyReport struct {
+	// Ztocs contains the result of every ztoc which was verified against its layer.
+	Ztocs []ZtocVerifyResult
+	// BlobProblems contains ztocs, prefetch artifacts and layers which are
+	// missing or can't be parsed.
+	BlobProblems []BlobProblem
+}
+
+// OK returns true if no problem was found.
+func (r *IndexVerifyReport) OK() bool {
+	if len(r.BlobProblems) > 0 {
+		return false
+	}
+	for _, z := range r.Ztocs {
+		if !z.Report.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Problems returns a human readable description of every problem in the report.
+func (r *IndexVerifyReport) Problems() []string {
+	var problems []string
+	for _, p := range r.BlobProblems {
+		problems = append(problems, p.String())
+	}
+	for _, z := range r.Ztocs {
+		for _, p := range z.Report.Problems() {
+			problems = append(problems, fmt.Sprintf("ztoc %s (layer %s): %s", z.ZtocDigest, z.LayerDigest, p))
+		}
+	}
+	return problems
+}
+
+// VerifyIndex checks that every blob referenced by a SOCI index exists in `blobStore`,
+// and verifies every ztoc against its layer from `cs` with `ztoc.Verify`. Prefetch
+// artifacts are checked to reference spans which exist in the ztoc of their layer.
+func VerifyIndex(ctx context.Context, index *Index, blobStore store.Store, cs content.Store) (*IndexVerifyReport, error) {
+	report := &IndexVerifyReport{}
+	// ztocs contains the successfully fetched ztoc of every layer.
+	ztocs := make(map[string]*ztoc.Ztoc)
+	var prefetchDescs []ocispec.Descriptor
+	for _, desc := range index.Blobs {
+		switch desc.MediaType {
+		case SociLayerMediaType:
+			layerDigest, toc, result, err := verifyZtocBlob(ctx, desc, blobStore, cs, report)
+			if err != nil {
+				return nil, err
+			}
+			if toc != nil {
+				ztocs[layerDigest.String()] = toc
+			}
+			if result != nil {
+				report.Ztocs = append(report.Ztocs, *result)
+			}
+		case SociPrefetchMediaType:
+			// Prefetch artifacts are checked once all ztocs are fetched.
+			prefetchDescs = append(prefetchDescs, desc)
+		default:
+			report.BlobProblems = append(report.BlobProblems, BlobProblem{Desc: desc, Problem: "unexpected media type"})
+		}
+	}
+
+	for _, desc := range prefetchDescs {
+		if err := verifyPrefetchBlob(ctx, desc, blobStore, ztocs, report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// verifyZtocBlob fetches a ztoc and verifies it against its layer. Missing or invalid
+// blobs are added to `report`. It returns the layer digest, and the ztoc and its
+// verification result if they exist.
+func verifyZtocBlob(ctx context.Context, desc ocispec.Descriptor, blobStore store.Store, cs content.Store, report *IndexVerifyReport) (digest.Digest, *ztoc.Ztoc, *ZtocVerifyResult, error) {
+	layerDigest, err := digest.Parse(desc.Annotations[IndexAnnotationImageLayerDigest])
+	if err != nil {
+		report.BlobProblems = append(report.BlobProblems, BlobProblem{Desc: desc, Problem: fmt.Sprintf("invalid layer digest annotation: %v", err)})
+		return "", nil, nil, nil
+	}
+
+	toc, problem, err := fetchZtoc(ctx, desc, blobStore)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if problem != "" {
+		report.BlobProblems = append(report.BlobProblems, BlobProblem{Desc: desc, Problem: problem})
+		return layerDigest, nil, nil, nil
+	}
+
+	layerDesc := ocispec.Descriptor{
+		MediaType: desc.Annotations[IndexAnnotationImageLayerMediaType],
+		Digest:    layerDigest,
+	}
+	ra, err := cs.ReaderAt(ctx, layerDesc)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			report.BlobProblems = append(report.BlobProblems, BlobProblem{Desc: layerDesc, Problem: "layer not found in the content store"})
+			return layerDigest, toc, nil, nil
+		}
+		return "", nil, nil, fmt.Errorf("failed to open layer %s: %w", layerDigest, err)
+	}
+	defer ra.Close()
+
+	ztocReport, err := toc.Verify(io.NewSectionReader(ra, 0, ra.Size()))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to verify ztoc %s: %w", desc.Digest, err)
+	}
+	return layerDigest, toc, &ZtocVerifyResult{
+		ZtocDigest:  desc.Digest,
+		LayerDigest: layerDigest,
+		Report:      ztocReport,
+	}, nil
+}
+
+// fetchZtoc fetches and parses a ztoc. If the ztoc is missing or invalid,
+// a description of the problem is returned instead.
+func fetchZtoc(ctx context.Context, desc ocispec.Descriptor, blobStore store.Store) (*ztoc.Ztoc, string, error) {
+	b, problem, err := fetchBlob(ctx, desc, blobStore)
+	if err != nil || problem != "" {
+		return nil, problem, err
+	}
+	toc, err := ztoc.Unmarshal(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Sprintf("invalid ztoc: %v", err), nil
+	}
+	return toc, "", nil
+}
+
+// verifyPrefetchBlob fetches a prefetch artifact and checks that its spans exist
+// in the ztoc of its layer. Problems are added to `report`.
+func verifyPrefetchBlob(ctx context.Context, desc ocispec.Descriptor, blobStore store.Store, ztocs map[string]*ztoc.Ztoc, report *IndexVerifyReport) error {
+	b, problem, err := fetchBlob(ctx, desc, blobStore)
+	if err != nil {
+		return err
+	}
+	if problem != "" {
+		report.BlobProblems = append(report.BlobProblems, BlobProblem{Desc: desc, Problem: problem})
+		return nil
+	}
+
+	artifact, err := UnmarshalPrefetchArtifact(bytes.NewReader(b))
+	if err != nil {
+		report.BlobProblems = append(report.BlobProblems, BlobProblem{Desc: desc, Problem: fmt.Sprintf("invalid prefetch artifact: %v", err)})
+		return nil
+	}
+	toc, ok := ztocs[desc.Annotations[IndexAnnotationImageLayerDigest]]
+	if !ok {
+		report.BlobProblems = append(report.BlobProblems, BlobProblem{Desc: desc, Problem: "no ztoc for the layer of the prefetch artifact"})
+		return nil
+	}
+	numSpans := len(toc.SpanDigests)
+	for _, span := range artifact.PrefetchSpans {
+		if span.StartSpan > span.EndSpan || int(span.EndSpan) >= numSpans {
+			report.BlobProblems = append(report.BlobProblems, BlobProblem{
+				Desc:    desc,
+				Problem: fmt.Sprintf("invalid prefetch span [%d, %d], the ztoc has %d spans", span.StartSpan, span.EndSpan, numSpans),
+			})
+		}
+	}
+	return nil
+}
+
+// fetchBlob fetches a blob from `blobStore` and checks its digest. If the blob is missing
+// or its content doesn't match the descriptor, a description of the problem is returned
+// instead.
+func fetchBlob(ctx context.Context, desc ocispec.Descriptor, blobStore store.Store) ([]byte, string, error) {
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, fmt.Sprintf("invalid digest: %v", err), nil
+	}
+	exists, err := blobStore.Exists(ctx, desc)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to check if blob %s exists: %w", desc.Digest, err)
+	}
+	if !exists {
+		return nil, "not found in the SOCI store", nil
+	}
+	rc, err := blobStore.Fetch(ctx, desc)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch blob %s: %w", desc.Digest, err)
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read blob %s: %w", desc.Digest, err)
+	}
+	if actual := desc.Digest.Algorithm().FromBytes(b); actual != desc.Digest {
+		return nil, fmt.Sprintf("content digest mismatch: got %s", actual), nil
+	}
+	return b, "", nil
+}
@@ -0,0 +1,156 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package soci
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/util/testutil"
+	"github.com/awslabs/soci-snapshotter/ztoc"
+	"github.com/awslabs/soci-snapshotter/ztoc/compression"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestVerifyIndex(t *testing.T) {
+	ctx := context.Background()
+	r := testutil.NewTestRand(t)
+	layer, err := io.ReadAll(testutil.BuildTarGz([]testutil.TarEntry{
+		testutil.File("file", string(r.RandomByteData(200000))),
+	}, gzip.BestSpeed))
+	if err != nil {
+		t.Fatalf("can't build layer: %v", err)
+	}
+	layerDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromBytes(layer),
+		Size:      int64(len(layer)),
+	}
+
+	toc, err := ztoc.NewBuilder("test").BuildZtocFromReader(bytes.NewReader(layer), 1<<16, ztoc.WithCompression(compression.Gzip))
+	if err != nil {
+		t.Fatalf("can't build ztoc: %v", err)
+	}
+	zr, ztocDesc, err := ztoc.Marshal(toc)
+	if err != nil {
+		t.Fatalf("can't marshal ztoc: %v", err)
+	}
+	ztocBytes, _ := io.ReadAll(zr)
+	ztocDesc.MediaType = SociLayerMediaType
+	ztocDesc.Annotations = map[string]string{
+		IndexAnnotationImageLayerMediaType: layerDesc.MediaType,
+		IndexAnnotationImageLayerDigest:    layerDesc.Digest.String(),
+	}
+
+	prefetch := func(start, end compression.SpanID) ([]byte, ocispec.Descriptor) {
+		artifact := NewPrefetchArtifact()
+		artifact.AddPrefetchSpan(PrefetchSpan{StartSpan: start, EndSpan: end})
+		pr, desc, err := MarshalPrefetchArtifact(artifact)
+		if err != nil {
+			t.Fatalf("can't marshal prefetch artifact: %v", err)
+		}
+		b, _ := io.ReadAll(pr)
+		desc.Annotations = map[string]string{IndexAnnotationImageLayerDigest: layerDesc.Digest.String()}
+		return b, desc
+	}
+	maxSpan := compression.SpanID(len(toc.SpanDigests) - 1)
+	validPrefetch, validPrefetchDesc := prefetch(0, maxSpan)
+	invalidPrefetch, invalidPrefetchDesc := prefetch(0, maxSpan+1)
+
+	testcases := []struct {
+		name             string
+		blobs            []ocispec.Descriptor
+		storedBlobs      map[digest.Digest][]byte
+		storeLayer       bool
+		expectedProblems int
+	}{
+		{
+			name:             "valid index",
+			blobs:            []ocispec.Descriptor{ztocDesc, validPrefetchDesc},
+			storedBlobs:      map[digest.Digest][]byte{ztocDesc.Digest: ztocBytes, validPrefetchDesc.Digest: validPrefetch},
+			storeLayer:       true,
+			expectedProblems: 0,
+		},
+		{
+			name:             "missing ztoc",
+			blobs:            []ocispec.Descriptor{ztocDesc, validPrefetchDesc},
+			storedBlobs:      map[digest.Digest][]byte{validPrefetchDesc.Digest: validPrefetch},
+			storeLayer:       true,
+			expectedProblems: 2, // the ztoc and the prefetch artifact without a ztoc
+		},
+		{
+			name:             "missing prefetch artifact",
+			blobs:            []ocispec.Descriptor{ztocDesc, validPrefetchDesc},
+			storedBlobs:      map[digest.Digest][]byte{ztocDesc.Digest: ztocBytes},
+			storeLayer:       true,
+			expectedProblems: 1,
+		},
+		{
+			name:             "missing layer",
+			blobs:            []ocispec.Descriptor{ztocDesc},
+			storedBlobs:      map[digest.Digest][]byte{ztocDesc.Digest: ztocBytes},
+			expectedProblems: 1,
+		},
+		{
+			name:             "prefetch artifact with spans outside the ztoc",
+			blobs:            []ocispec.Descriptor{ztocDesc, invalidPrefetchDesc},
+			storedBlobs:      map[digest.Digest][]byte{ztocDesc.Digest: ztocBytes, invalidPrefetchDesc.Digest: invalidPrefetch},
+			storeLayer:       true,
+			expectedProblems: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			blobStore := NewOrasMemoryStore()
+			for _, desc := range tc.blobs {
+				if b, ok := tc.storedBlobs[desc.Digest]; ok {
+					if err := blobStore.Push(ctx, desc, bytes.NewReader(b)); err != nil {
+						t.Fatalf("can't push blob: %v", err)
+					}
+				}
+			}
+			cs, err := local.NewStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("can't create content store: %v", err)
+			}
+			if tc.storeLayer {
+				if err := content.WriteBlob(ctx, cs, "layer", bytes.NewReader(layer), layerDesc); err != nil {
+					t.Fatalf("can't write layer: %v", err)
+				}
+			}
+
+			report, err := VerifyIndex(ctx, NewIndex(V2, tc.blobs, nil, nil), blobStore, cs)
+			if err != nil {
+				t.Fatalf("can't verify index: %v", err)
+			}
+			problems := report.Problems()
+			if len(problems) != tc.expectedProblems {
+				t.Fatalf("expected %d problems, got %v", tc.expectedProblems, problems)
+			}
+			if report.OK() != (tc.expectedProblems == 0) {
+				t.Fatalf("unexpected report status: %t", report.OK())
+			}
+		})
+	}
+}
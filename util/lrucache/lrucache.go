@@ -46,6 +46,12 @@ type Cache struct {
 	cache *lru.Cache
 	mu    sync.Mutex
 
+	// sizeOf returns the size of a value, if the cache is bounded by the total
+	// size of its contents. curSize is the total size of the contents in the cache.
+	sizeOf  func(value interface{}) int64
+	maxSize int64
+	curSize int64
+
 	// OnEvicted optionally specifies a callback function to be
 	// executed when an entry is purged from the cache.
 	OnEvicted func(key string, value interface{})
@@ -53,15 +59,31 @@ type Cache struct {
 
 // New creates new cache.
 func New(maxEntries int) *Cache {
-	inner := lru.New(maxEntries)
-	inner.OnEvicted = func(key lru.Key, value interface{}) {
+	return newCache(maxEntries, 0, nil)
+}
+
+// NewWithMaxSize creates new cache which evicts the least recently used contents once the
+// total size of the contents exceeds maxSize. The size of a content is given by sizeOf.
+func NewWithMaxSize(maxSize int64, sizeOf func(value interface{}) int64) *Cache {
+	return newCache(0, maxSize, sizeOf)
+}
+
+func newCache(maxEntries int, maxSize int64, sizeOf func(value interface{}) int64) *Cache {
+	c := &Cache{
+		cache:   lru.New(maxEntries),
+		sizeOf:  sizeOf,
+		maxSize: maxSize,
+	}
+	c.cache.OnEvicted = func(key lru.Key, value interface{}) {
+		rc := value.(*refCounter)
+		if c.sizeOf != nil {
+			c.curSize -= c.sizeOf(rc.v)
+		}
 		// Decrease the ref count incremented in Add().
 		// When nobody refers to this value, this value will be finalized via refCounter.
-		value.(*refCounter).finalize()
-	}
-	return &Cache{
-		cache: inner,
+		rc.finalize()
 	}
+	return c
 }
 
 // Get retrieves the specified object from the cache and increments the reference counter of the
@@ -99,6 +121,12 @@ func (c *Cache) Add(key string, value interface{}) (cachedValue interface{}, don
 	rc.initialize() // Keep this object having at least 1 ref count (will be decreased in OnEviction)
 	rc.inc()        // The client references this object (will be decreased on "done")
 	c.cache.Add(key, rc)
+	if c.sizeOf != nil {
+		c.curSize += c.sizeOf(value)
+		for c.curSize > c.maxSize && c.cache.Len() > 0 {
+			c.cache.RemoveOldest()
+		}
+	}
 	return rc.v, c.decreaseOnceFunc(rc), true
 }
 
@@ -110,6 +138,14 @@ func (c *Cache) Remove(key string) {
 	c.cache.Remove(key)
 }
 
+// Size returns the total size of the cached contents of a cache created with
+// NewWithMaxSize. It returns 0 for other caches.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.curSize
+}
+
 func (c *Cache) decreaseOnceFunc(rc *refCounter) func() {
 	var once sync.Once
 	return func() {
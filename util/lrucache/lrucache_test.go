@@ -166,3 +166,54 @@ func TestEviction(t *testing.T) {
 		return
 	}
 }
+
+func TestMaxSize(t *testing.T) {
+	var evicted []string
+	c := NewWithMaxSize(10, func(value interface{}) int64 {
+		return int64(len(value.(string)))
+	})
+	c.OnEvicted = func(key string, value interface{}) {
+		evicted = append(evicted, key)
+	}
+	_, done1, _ := c.Add("key1", "abcd")
+	_, done2, _ := c.Add("key2", "abcd")
+	done2()
+	if _, done, ok := c.Get("key1"); !ok {
+		t.Fatalf("key1 must be cached")
+	} else {
+		done()
+	}
+	if len(evicted) != 0 {
+		t.Fatalf("no content must be evicted within the max size; evicted %v", evicted)
+	}
+
+	// key2 is the least recently used content.
+	_, done3, _ := c.Add("key3", "abcd")
+	done3()
+	if len(evicted) != 1 || evicted[0] != "key2" {
+		t.Fatalf("key2 must be evicted once the max size is exceeded; evicted %v", evicted)
+	}
+	if _, _, ok := c.Get("key2"); ok {
+		t.Fatalf("key2 must not be cached anymore")
+	}
+
+	// Contents larger than the max size aren't kept.
+	_, done4, _ := c.Add("key4", "abcdefghijk")
+	done4()
+	if _, _, ok := c.Get("key4"); ok {
+		t.Fatalf("key4 must not be cached")
+	}
+	if _, _, ok := c.Get("key1"); ok {
+		t.Fatalf("key1 must be removed from the cache")
+	}
+	// key1 is still referenced.
+	for _, key := range evicted {
+		if key == "key1" {
+			t.Fatalf("key1 must not be evicted until all references are discarded")
+		}
+	}
+	done1()
+	if evicted[len(evicted)-1] != "key1" {
+		t.Fatalf("key1 must be evicted once all references are discarded; evicted %v", evicted)
+	}
+}
@@ -61,6 +61,10 @@ type BuildTarOptions struct {
 	GzipComment  string
 	GzipFilename string
 	GzipExtra    []byte
+
+	// ZstdFrameSize is the maximum uncompressed size of a zstd frame.
+	// If it's 0, the whole tar is compressed into a single frame.
+	ZstdFrameSize int
 }
 
 // BuildTarOption is an option used during building blob.
@@ -91,6 +95,14 @@ func WithGzipExtra(extra []byte) BuildTarOption {
 	}
 }
 
+// WithZstdFrameSize is an option to split a zstd compressed tar into independent
+// frames containing at most `size` bytes of uncompressed data each.
+func WithZstdFrameSize(size int) BuildTarOption {
+	return func(o *BuildTarOptions) {
+		o.ZstdFrameSize = size
+	}
+}
+
 // BuildTar builds a tar given a list of tar entries and returns an io.Reader
 func BuildTar(ents []TarEntry, opts ...BuildTarOption) io.Reader {
 	var bo BuildTarOptions
@@ -161,11 +173,15 @@ func BuildTarZstd(ents []TarEntry, compressionLevel int, opts ...BuildTarOption)
 	}
 	pr, pw := io.Pipe()
 	go func() {
-		zw, err := zstd.NewWriter(pw, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(compressionLevel)))
+		enc, err := zstd.NewWriter(pw, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(compressionLevel)))
 		if err != nil {
 			pw.CloseWithError(err)
 			return
 		}
+		var zw io.WriteCloser = enc
+		if bo.ZstdFrameSize > 0 {
+			zw = &zstdFrameWriter{w: pw, enc: enc, frameSize: bo.ZstdFrameSize}
+		}
 		tw := tar.NewWriter(zw)
 		for _, ent := range ents {
 			if err := ent.AppendTar(tw, bo); err != nil {
@@ -186,6 +202,43 @@ func BuildTarZstd(ents []TarEntry, compressionLevel int, opts ...BuildTarOption)
 	return pr
 }
 
+// zstdFrameWriter compresses every `frameSize` bytes written to it into
+// a separate zstd frame.
+type zstdFrameWriter struct {
+	w         io.Writer
+	enc       *zstd.Encoder
+	frameSize int
+	buf       []byte
+}
+
+func (fw *zstdFrameWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		l := min(fw.frameSize-len(fw.buf), len(p))
+		fw.buf = append(fw.buf, p[:l]...)
+		p = p[l:]
+		if len(fw.buf) == fw.frameSize {
+			if err := fw.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (fw *zstdFrameWriter) flush() error {
+	if len(fw.buf) == 0 {
+		return nil
+	}
+	_, err := fw.w.Write(fw.enc.EncodeAll(fw.buf, nil))
+	fw.buf = fw.buf[:0]
+	return err
+}
+
+func (fw *zstdFrameWriter) Close() error {
+	return fw.flush()
+}
+
 // WriteTarToTempFile writes the contents of a tar archive to a specified path and
 // return the temp filename and the tar data (as []byte).
 //
@@ -223,6 +276,23 @@ func GetFilesAndContentsWithinTarGz(tarGz string) (map[string][]byte, []string,
 	return getFilesAndContentsFromTarReader(tr)
 }
 
+// GetFilesAndContentsWithinTarZstd takes a path to a zstd compressed tar archive and returns a list of its files and their contents
+func GetFilesAndContentsWithinTarZstd(tarZstd string) (map[string][]byte, []string, error) {
+	f, err := os.Open(tarZstd)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	return getFilesAndContentsFromTarReader(tr)
+}
+
 // GetFilesAndContentsWithinTar takes a path to a tar archive and returns a list of its files and their contents
 func GetFilesAndContentsWithinTar(tarFile string) (map[string][]byte, []string, error) {
 	f, err := os.Open(tarFile)
@@ -1,3 +1,5 @@
+//go:build cgo && !purego
+
 /*
    Copyright The Soci Snapshotter Authors.
 
@@ -48,7 +50,6 @@
 #include <stdlib.h>
 #include <string.h>
 
-#define CHUNK (1 << 14)         // file input buffer size
 #define GZIP_TRAILER_SIZE 8    // gzip trailer: 4-byte CRC32 + 4-byte ISIZE
 
 
@@ -200,112 +201,158 @@ static struct gzip_zinfo *add_checkpoint(struct gzip_zinfo *index, uint8_t bits,
     return index;
 }
 
-/* Pretty much the same as from zran.c */
-int generate_zinfo_from_fp(FILE* in, offset_t span, struct gzip_zinfo** idx) {
+int zinfo_stream_new(offset_t span, struct gzip_zinfo_stream **stream) {
     int ret;
-    offset_t totin, totout;        /* our own total counters to avoid 4GB limit */
-    offset_t last;                 /* totout value of last access point */
-    struct gzip_zinfo *index;       /* access points being generated */
-    z_stream strm;
-    unsigned char input[CHUNK], window[WINSIZE];
-    memset(window, 0, WINSIZE);
+    struct gzip_zinfo_stream *s = malloc(sizeof(struct gzip_zinfo_stream));
+    if (s == NULL)
+        return GZIP_ZINFO_CANNOT_ALLOC;
+    memset(s, 0, sizeof(struct gzip_zinfo_stream));
+    s->span = span;
 
     /* initialize inflate */
-    ret = init_flate(&strm, 47); /* automatic zlib or gzip decoding */
-    if (ret != Z_OK)
+    ret = init_flate(&s->strm, 47); /* automatic zlib or gzip decoding */
+    if (ret != Z_OK) {
+        free(s);
         return ret;
+    }
+    *stream = s;
+    return GZIP_ZINFO_OK;
+}
 
-    /* inflate the input, maintain a sliding window, and build an index -- this
-       also validates the integrity of the compressed data using the check
-       information at the end of the gzip or zlib stream */
-    totin = totout = last = 0;
-    index = NULL;               /* will be allocated by first add_checkpoint() */
-    strm.avail_out = 0;
-    do {
-        /* get some compressed data from input file */
-        memset(input, 0, CHUNK);
-        strm.avail_in = fread(input, 1, CHUNK, in);
-        if (ferror(in)) {
-            ret = Z_ERRNO;
-            goto build_index_error;
-        }
-        if (strm.avail_in == 0) {
-            ret = Z_DATA_ERROR;
-            goto build_index_error;
-        }
-        strm.next_in = input;
+/* Makes the first len bytes of stream->input available to inflate.
+   Must only be called once all the previous input was consumed. */
+void zinfo_stream_feed(struct gzip_zinfo_stream *s, unsigned len) {
+    s->strm.avail_in = len;
+    s->strm.next_in = s->input;
+}
 
-        /* process all of that, or until end of stream */
-        do {
-            /* reset sliding window if necessary */
-            if (strm.avail_out == 0) {
-                strm.avail_out = WINSIZE;
-                strm.next_out = window;
-            }
+/* Pretty much the same as the loop of zran.c's build_index, one inflate call
+   at a time. Returns GZIP_ZINFO_STREAM_OK after inflating some data, in which
+   case out_off, out_len and checkpoint are set, GZIP_ZINFO_STREAM_NEED_INPUT
+   or GZIP_ZINFO_STREAM_END if all input was consumed, or a zlib error. */
+int zinfo_stream_next(struct gzip_zinfo_stream *s) {
+    int ret;
+    s->out_len = 0;
+    s->checkpoint = false;
+
+    if (s->member_end) {
+        if (s->strm.avail_in == 0)
+            return GZIP_ZINFO_STREAM_END;
+        /* Handle concatenated gzip streams (e.g., mgzip/pigz).
+           If there's more data, reset inflate for the next member. */
+        ret = inflateReset2(&s->strm, 47);
+        if (ret != Z_OK)
+            return ret;
+        s->member_end = false;
+    }
+    if (s->strm.avail_in == 0)
+        return GZIP_ZINFO_STREAM_NEED_INPUT;
 
-            /* inflate until out of input, output, or at end of block --
-               update the total input and output counters */
-            totin += strm.avail_in;
-            totout += strm.avail_out;
-            ret = inflate(&strm, Z_BLOCK);      /* return at end of block */
-            totin -= strm.avail_in;
-            totout -= strm.avail_out;
-            if (ret == Z_NEED_DICT)
-                ret = Z_DATA_ERROR;
-            if (ret == Z_MEM_ERROR || ret == Z_DATA_ERROR)
-                goto build_index_error;
-            if (ret == Z_STREAM_END) {
-                /* Handle concatenated gzip streams (e.g., mgzip/pigz).
-                   If there's more data, reset inflate for the next member. */
-                if (strm.avail_in > 0 ||
-                    ungetc(getc(in), in) != EOF) {
-                    ret = inflateReset2(&strm, 47);
-                    if (ret != Z_OK)
-                        goto build_index_error;
-                    if (strm.avail_in > 0)
-                        continue;
-                }
-                break;
-            }
+    /* reset sliding window if necessary */
+    if (s->strm.avail_out == 0) {
+        s->strm.avail_out = WINSIZE;
+        s->strm.next_out = s->window;
+    }
+    s->out_off = WINSIZE - s->strm.avail_out;
+
+    /* inflate until out of input, output, or at end of block --
+       update the total input and output counters */
+    s->totin += s->strm.avail_in;
+    s->totout += s->strm.avail_out;
+    ret = inflate(&s->strm, Z_BLOCK);      /* return at end of block */
+    s->totin -= s->strm.avail_in;
+    s->totout -= s->strm.avail_out;
+    s->out_len = WINSIZE - s->strm.avail_out - s->out_off;
+    if (ret == Z_NEED_DICT)
+        ret = Z_DATA_ERROR;
+    if (ret == Z_MEM_ERROR || ret == Z_DATA_ERROR)
+        return ret;
+    if (ret == Z_STREAM_END) {
+        s->member_end = true;
+        return GZIP_ZINFO_STREAM_OK;
+    }
 
-            /* if at end of block, consider adding an index entry (note that if
-               data_type indicates an end-of-block, then all of the
-               uncompressed data from that block has been delivered, and none
-               of the compressed data after that block has been consumed,
-               except for up to seven bits) -- the totout == 0 provides an
-               entry point after the zlib or gzip header, and assures that the
-               index always has at least one access point; we avoid creating an
-               access point after the last block by checking bit 6 of data_type
-             */
-            if ((strm.data_type & 128) && !(strm.data_type & 64) &&
-                (totout == 0 || totout - last > span)) {
-                index = add_checkpoint(index, (uint8_t)(strm.data_type & 7), totin,
-                                 totout, strm.avail_out, window);
-                if (index == NULL) {
-                    ret = Z_MEM_ERROR;
-                    goto build_index_error;
-                }
-                last = totout;
-            }
-        } while (strm.avail_in != 0);
-    } while (ret != Z_STREAM_END);
+    /* if at end of block, consider adding an index entry (note that if
+       data_type indicates an end-of-block, then all of the
+       uncompressed data from that block has been delivered, and none
+       of the compressed data after that block has been consumed,
+       except for up to seven bits) -- the totout == 0 provides an
+       entry point after the zlib or gzip header, and assures that the
+       index always has at least one access point; we avoid creating an
+       access point after the last block by checking bit 6 of data_type
+     */
+    if ((s->strm.data_type & 128) && !(s->strm.data_type & 64) &&
+        (s->totout == 0 || s->totout - s->last > s->span)) {
+        s->index = add_checkpoint(s->index, (uint8_t)(s->strm.data_type & 7), s->totin,
+                                  s->totout, s->strm.avail_out, s->window);
+        if (s->index == NULL)
+            return Z_MEM_ERROR;
+        s->last = s->totout;
+        s->checkpoint = true;
+        s->checkpoint_bits = (uint8_t)(s->strm.data_type & 7);
+    }
+    return GZIP_ZINFO_STREAM_OK;
+}
 
-    /* clean up and return index (release unused entries in list) */
-    (void)inflateEnd(&strm);
+/* Hands out the generated index once the whole stream was consumed.
+   Returns the number of access points or an error if the stream is truncated. */
+int zinfo_stream_finish(struct gzip_zinfo_stream *s, struct gzip_zinfo **idx) {
+    struct gzip_zinfo *index = s->index;
+    if (!s->member_end || s->strm.avail_in != 0 || index == NULL)
+        return Z_DATA_ERROR;
+
+    /* release unused entries in list */
     index->list = realloc(index->list, sizeof(struct gzip_checkpoint) * index->have);
     index->size = index->have;
     index->have = encode_int32(index->have);
     int32_t sz = index->size;
     index->size = encode_int32(index->size);
-    index->span_size = encode_offset(span);
+    index->span_size = encode_offset(s->span);
     index->version = encode_int32(ZINFO_VERSION_CUR);
+    s->index = NULL;
     *idx = index;
     return sz;
+}
 
-    /* return error */
-  build_index_error:
-    (void)inflateEnd(&strm);
-    free_zinfo(index);
+void zinfo_stream_free(struct gzip_zinfo_stream *s) {
+    if (s != NULL) {
+        (void)inflateEnd(&s->strm);
+        free_zinfo(s->index);
+        free(s);
+    }
+}
+
+int generate_zinfo_from_fp(FILE* in, offset_t span, struct gzip_zinfo** idx) {
+    int ret;
+    unsigned n;
+    struct gzip_zinfo_stream *s;
+
+    ret = zinfo_stream_new(span, &s);
+    if (ret != GZIP_ZINFO_OK)
+        return ret;
+
+    /* inflate the input, maintain a sliding window, and build an index -- this
+       also validates the integrity of the compressed data using the check
+       information at the end of the gzip or zlib stream */
+    do {
+        ret = zinfo_stream_next(s);
+        if (ret == GZIP_ZINFO_STREAM_NEED_INPUT || ret == GZIP_ZINFO_STREAM_END) {
+            /* get some compressed data from input file */
+            n = fread(s->input, 1, CHUNK, in);
+            if (ferror(in)) {
+                ret = Z_ERRNO;
+                break;
+            }
+            if (n == 0) {
+                ret = zinfo_stream_finish(s, idx);
+                break;
+            }
+            zinfo_stream_feed(s, n);
+            ret = GZIP_ZINFO_STREAM_OK;
+        }
+    } while (ret == GZIP_ZINFO_STREAM_OK);
+
+    zinfo_stream_free(s);
     return ret;
 }
 
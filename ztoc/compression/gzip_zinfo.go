@@ -1,3 +1,5 @@
+//go:build cgo && !purego
+
 /*
    Copyright The Soci Snapshotter Authors.
 
@@ -25,6 +27,7 @@ import "C"
 
 import (
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"unsafe"
@@ -65,6 +68,56 @@ func newGzipZinfoFromFile(gzipFile string, spanSize int64) (*GzipZinfo, error) {
 	}, nil
 }
 
+// newGzipZinfoFromReader creates a new instance of `GzipZinfo` by inflating the gzip
+// stream read from `sr`. The uncompressed data is written to `w`.
+func newGzipZinfoFromReader(sr *spanReader, spanSize int64, w io.Writer) (*GzipZinfo, error) {
+	var stream *C.struct_gzip_zinfo_stream
+	if ret := C.zinfo_stream_new(C.off_t(spanSize), &stream); ret != C.GZIP_ZINFO_OK {
+		return nil, fmt.Errorf("could not generate gzip zinfo. gzip error: %v", ret)
+	}
+	defer C.zinfo_stream_free(stream)
+
+	input := unsafe.Slice((*byte)(unsafe.Pointer(&stream.input[0])), len(stream.input))
+	window := unsafe.Slice((*byte)(unsafe.Pointer(&stream.window[0])), len(stream.window))
+	for {
+		ret := C.zinfo_stream_next(stream)
+		switch ret {
+		case C.GZIP_ZINFO_STREAM_OK:
+			if stream.out_len > 0 {
+				if _, err := w.Write(window[stream.out_off : stream.out_off+stream.out_len]); err != nil {
+					return nil, err
+				}
+			}
+			if stream.checkpoint {
+				in := Offset(stream.totin)
+				start := in
+				if stream.checkpoint_bits != 0 {
+					start--
+				}
+				sr.startSpan(start, in)
+			}
+		case C.GZIP_ZINFO_STREAM_NEED_INPUT, C.GZIP_ZINFO_STREAM_END:
+			n, err := sr.Read(input)
+			if n > 0 {
+				C.zinfo_stream_feed(stream, C.uint(n))
+				continue
+			}
+			if !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			var cZinfo *C.struct_gzip_zinfo
+			if ret := C.zinfo_stream_finish(stream, &cZinfo); ret < 0 {
+				return nil, fmt.Errorf("could not generate gzip zinfo. gzip error: %v", ret)
+			}
+			return &GzipZinfo{
+				cZinfo: cZinfo,
+			}, nil
+		default:
+			return nil, fmt.Errorf("could not generate gzip zinfo at offset %d. gzip error: %v", stream.totin, ret)
+		}
+	}
+}
+
 // Close calls `C.free` on the pointer to `C.struct_gzip_zinfo`.
 func (i *GzipZinfo) Close() {
 	if i.cZinfo != nil {
@@ -75,6 +75,8 @@ typedef int64_t offset_t;
 */
 #define BLOB_HEADER_SIZE (4 + 8)
 
+#define CHUNK (1 << 14)         // file input buffer size
+
 
 enum {
     GZIP_ZINFO_OK = 0,
@@ -83,6 +85,12 @@ enum {
     GZIP_ZINFO_CANNOT_ALLOC = -82,
 };
 
+enum {
+    GZIP_ZINFO_STREAM_OK = 0,
+    GZIP_ZINFO_STREAM_NEED_INPUT = 1,
+    GZIP_ZINFO_STREAM_END = 2,
+};
+
 struct gzip_checkpoint {
     offset_t out;          /* corresponding offset in uncompressed data */
     offset_t in;           /* offset in input file of first full byte */
@@ -98,6 +106,26 @@ struct gzip_zinfo {
     offset_t span_size;
 };
 
+/* State of a zinfo that is generated incrementally from a stream.
+   The caller copies compressed data to `input` and calls zinfo_stream_feed,
+   then calls zinfo_stream_next until it needs more input. */
+struct gzip_zinfo_stream {
+    z_stream strm;
+    offset_t span;
+    offset_t totin;         /* compressed bytes consumed */
+    offset_t totout;        /* uncompressed bytes produced */
+    offset_t last;          /* totout value of last access point */
+    bool member_end;        /* at the end of a gzip member */
+    struct gzip_zinfo *index;
+    /* results of the last call to zinfo_stream_next */
+    unsigned out_off;       /* offset of the produced uncompressed data in window */
+    unsigned out_len;       /* size of the produced uncompressed data */
+    bool checkpoint;        /* an access point was added at totin */
+    uint8_t checkpoint_bits;
+    unsigned char input[CHUNK];
+    unsigned char window[WINSIZE];
+};
+
 // zinfo - metadata starts.
 // Get index number of gzip zinfo within which the uncompressed offset is present
 int         pt_index_from_ucmp_offset(struct gzip_zinfo *index, offset_t off);
@@ -110,6 +138,11 @@ int         has_bits(struct gzip_zinfo *index, int checkpoint);
 
 // zinfo - generation/extraction starts.
 int generate_zinfo_from_file(const char* filepath, offset_t span, struct gzip_zinfo** index);
+int zinfo_stream_new(offset_t span, struct gzip_zinfo_stream** stream);
+void zinfo_stream_feed(struct gzip_zinfo_stream* stream, unsigned len);
+int zinfo_stream_next(struct gzip_zinfo_stream* stream);
+int zinfo_stream_finish(struct gzip_zinfo_stream* stream, struct gzip_zinfo** index);
+void zinfo_stream_free(struct gzip_zinfo_stream* stream);
 int extract_data_from_file(const char* file, struct gzip_zinfo* index, offset_t offset, void* buf, int len);
 int extract_data_from_buffer(void* d, offset_t datalen, struct gzip_zinfo* index, offset_t offset, void* buffer, offset_t len, int first_checkpoint);
 // zinfo - generation/extraction ends.
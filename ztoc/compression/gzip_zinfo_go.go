@@ -0,0 +1,406 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	gzipZinfoVersionOne = 1
+	gzipZinfoVersionTwo = 2
+
+	// gzipBlobHeaderSize is the size of the serialized zinfo header:
+	// 4 bytes number of checkpoints + 8 bytes span size.
+	gzipBlobHeaderSize = 4 + 8
+	// gzipPackedCheckpointSize is the size of a serialized checkpoint:
+	// 8 bytes compressed offset + 8 bytes uncompressed offset + 1 byte bits + window.
+	gzipPackedCheckpointSize = 8 + 8 + 1 + winSize
+	// gzipV1FirstCheckpointOffset is the compressed offset of the first checkpoint
+	// implied by v1 zinfo, which doesn't serialize it.
+	gzipV1FirstCheckpointOffset = 10
+)
+
+// gzipCheckpoint is an access point in a gzip stream.
+type gzipCheckpoint struct {
+	in     Offset // offset in the compressed stream of the first full byte
+	out    Offset // corresponding offset in the uncompressed stream
+	bits   uint8  // number of bits (1-7) from the byte at in - 1, or 0
+	window []byte // preceding 32KiB of uncompressed data
+}
+
+// GoGzipZinfo is a pure Go implementation of the gzip zinfo. It produces the
+// same checkpoints and serialized bytes as the C implementation in `gzip_zinfo.c`,
+// so zinfo created by one implementation can be used by the other.
+type GoGzipZinfo struct {
+	version     int32
+	spanSize    int64
+	checkpoints []gzipCheckpoint
+}
+
+// newGoGzipZinfo creates a new instance of `GoGzipZinfo` from zinfo bytes.
+func newGoGzipZinfo(zinfoBytes []byte) (*GoGzipZinfo, error) {
+	if len(zinfoBytes) == 0 {
+		return nil, fmt.Errorf("empty checkpoints")
+	}
+	if len(zinfoBytes) < gzipBlobHeaderSize {
+		return nil, fmt.Errorf("cannot convert blob to gzip_zinfo")
+	}
+	numCheckpoints := int64(int32(binary.LittleEndian.Uint32(zinfoBytes[0:4])))
+	spanSize := int64(binary.LittleEndian.Uint64(zinfoBytes[4:12]))
+
+	var version int32
+	claimedSize := gzipPackedCheckpointSize*numCheckpoints + gzipBlobHeaderSize
+	switch int64(len(zinfoBytes)) {
+	case claimedSize:
+		// If we have exactly numCheckpoints checkpoints, then we have a current blob
+		version = gzipZinfoVersionTwo
+	case claimedSize - gzipPackedCheckpointSize:
+		// If we only have numCheckpoints - 1 checkpoints, then we have a v1 blob
+		version = gzipZinfoVersionOne
+	default:
+		return nil, fmt.Errorf("cannot convert blob to gzip_zinfo")
+	}
+
+	zinfo := &GoGzipZinfo{
+		version:     version,
+		spanSize:    spanSize,
+		checkpoints: make([]gzipCheckpoint, numCheckpoints),
+	}
+	first := 0
+	if version == gzipZinfoVersionOne {
+		// v1 didn't serialize the 0th checkpoint because it was assumed
+		// to be right after a gzip header without optional fields.
+		zinfo.checkpoints[0] = gzipCheckpoint{
+			in:     gzipV1FirstCheckpointOffset,
+			window: make([]byte, winSize),
+		}
+		first = 1
+	}
+	cur := zinfoBytes[gzipBlobHeaderSize:]
+	for i := first; i < len(zinfo.checkpoints); i++ {
+		zinfo.checkpoints[i] = gzipCheckpoint{
+			in:     Offset(binary.LittleEndian.Uint64(cur[0:8])),
+			out:    Offset(binary.LittleEndian.Uint64(cur[8:16])),
+			bits:   cur[16],
+			window: cur[17:gzipPackedCheckpointSize:gzipPackedCheckpointSize],
+		}
+		cur = cur[gzipPackedCheckpointSize:]
+	}
+	return zinfo, nil
+}
+
+// newGoGzipZinfoFromFile creates a new instance of `GoGzipZinfo` given gzip file name and span size.
+func newGoGzipZinfoFromFile(gzipFile string, spanSize int64) (*GoGzipZinfo, error) {
+	f, err := os.Open(gzipFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate gzip zinfo: %w", err)
+	}
+	defer f.Close()
+	return newGoGzipZinfoFromReader(bufio.NewReader(f), spanSize, nil, nil)
+}
+
+// newGoGzipZinfoFromReader creates a new instance of `GoGzipZinfo` by inflating
+// the whole gzip stream and adding a checkpoint at the end of a deflate block
+// roughly every `spanSize` uncompressed bytes. If `w` is not nil, the uncompressed
+// data is written to it. If `onSpan` is not nil, it is called with the start offset
+// of every new span in the compressed stream and the end offset of the previous span.
+func newGoGzipZinfoFromReader(r io.ByteReader, spanSize int64, w io.Writer, onSpan func(start, prevEnd Offset)) (*GoGzipZinfo, error) {
+	var sink func([]byte) error
+	if w != nil {
+		sink = func(p []byte) error {
+			_, err := w.Write(p)
+			return err
+		}
+	}
+	f := newInflater(r, sink)
+	zinfo := &GoGzipZinfo{
+		version:  gzipZinfoVersionTwo,
+		spanSize: spanSize,
+	}
+
+	var last Offset
+	// maybeAddCheckpoint is called at the end of the gzip header and at the end of
+	// every deflate block except the last one of a member. The checkpoint after the
+	// first header ensures the zinfo always has at least one checkpoint.
+	maybeAddCheckpoint := func() {
+		out := Offset(f.total)
+		if out == 0 || int64(out-last) > spanSize {
+			cp := gzipCheckpoint{
+				in:     f.br.offset(),
+				out:    out,
+				bits:   f.br.unusedBits(),
+				window: f.lastWindow(),
+			}
+			zinfo.checkpoints = append(zinfo.checkpoints, cp)
+			last = out
+			if onSpan != nil {
+				start := cp.in
+				if cp.bits != 0 {
+					start--
+				}
+				onSpan(start, cp.in)
+			}
+		}
+	}
+
+	for {
+		if err := f.readGzipHeader(); err != nil {
+			return nil, inflateError(err, f.br.offset())
+		}
+		maybeAddCheckpoint()
+		for {
+			final, err := f.inflateBlock()
+			if err != nil {
+				return nil, inflateError(err, f.br.offset())
+			}
+			if final {
+				break
+			}
+			maybeAddCheckpoint()
+		}
+		if err := f.readGzipTrailer(true); err != nil {
+			return nil, inflateError(err, f.br.offset())
+		}
+		// Handle concatenated gzip streams (e.g., mgzip/pigz).
+		if !f.br.more() {
+			break
+		}
+	}
+	if f.br.err != nil && !errors.Is(f.br.err, io.EOF) {
+		return nil, f.br.err
+	}
+	return zinfo, nil
+}
+
+// Close doesn't do anything since there is nothing to close/release.
+func (i *GoGzipZinfo) Close() {}
+
+// Bytes returns the byte slice containing the zinfo.
+func (i *GoGzipZinfo) Bytes() ([]byte, error) {
+	// In v1, we skipped the 0th checkpoint because we assumed it was fixed size.
+	// In v2, we encode the 0th checkpoint because it's not a fixed size if gzip headers are used.
+	// For backwards compatibility we want to reserialize v1 zinfo to exactly the same bytes.
+	first := 0
+	if i.version == gzipZinfoVersionOne {
+		first = 1
+	}
+	numPacked := max(len(i.checkpoints)-first, 0)
+	buf := make([]byte, gzipBlobHeaderSize, gzipBlobHeaderSize+numPacked*gzipPackedCheckpointSize)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(i.checkpoints)))
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(i.spanSize))
+	for _, cp := range i.checkpoints[min(first, len(i.checkpoints)):] {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(cp.in))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(cp.out))
+		buf = append(buf, cp.bits)
+		buf = append(buf, cp.window...)
+	}
+	return buf, nil
+}
+
+// MaxSpanID returns the max span ID.
+func (i *GoGzipZinfo) MaxSpanID() SpanID {
+	return SpanID(len(i.checkpoints) - 1)
+}
+
+// SpanSize returns the span size of the constructed ztoc.
+func (i *GoGzipZinfo) SpanSize() Offset {
+	return Offset(i.spanSize)
+}
+
+// UncompressedOffsetToSpanID returns the ID of the span containing the data pointed by uncompressed offset.
+func (i *GoGzipZinfo) UncompressedOffsetToSpanID(offset Offset) SpanID {
+	idx := sort.Search(len(i.checkpoints), func(j int) bool {
+		return i.checkpoints[j].out > offset
+	})
+	if idx == 0 {
+		return 0
+	}
+	return SpanID(idx - 1)
+}
+
+// ExtractDataFromBuffer inflates the data specified by offset and size from `compressedBuf`,
+// which must start at the beginning of `spanID` in the compressed stream.
+func (i *GoGzipZinfo) ExtractDataFromBuffer(compressedBuf []byte, uncompressedSize, uncompressedOffset Offset, spanID SpanID) ([]byte, error) {
+	if len(compressedBuf) == 0 {
+		return nil, fmt.Errorf("empty compressed buffer")
+	}
+	if uncompressedSize < 0 {
+		return nil, fmt.Errorf("invalid uncompressed size: %d", uncompressedSize)
+	}
+	if uncompressedSize == 0 {
+		return []byte{}, nil
+	}
+	if spanID < 0 || spanID > i.MaxSpanID() {
+		return nil, fmt.Errorf("invalid span id: %d", spanID)
+	}
+	data, err := i.extractData(bytes.NewReader(compressedBuf), spanID, uncompressedSize, uncompressedOffset)
+	if err != nil {
+		return nil, fmt.Errorf("error extracting data: %w", err)
+	}
+	return data, nil
+}
+
+// ExtractDataFromFile inflates the data specified by offset and size directly from a gzip file.
+func (i *GoGzipZinfo) ExtractDataFromFile(fileName string, uncompressedSize, uncompressedOffset Offset) ([]byte, error) {
+	if uncompressedSize < 0 {
+		return nil, fmt.Errorf("invalid uncompressed size: %d", uncompressedSize)
+	}
+	if uncompressedSize == 0 {
+		return []byte{}, nil
+	}
+	if len(i.checkpoints) == 0 {
+		return nil, fmt.Errorf("unable to extract data: no checkpoints")
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to extract data: %w", err)
+	}
+	defer f.Close()
+
+	spanID := i.UncompressedOffsetToSpanID(uncompressedOffset)
+	if _, err := f.Seek(int64(i.StartCompressedOffset(spanID)), io.SeekStart); err != nil {
+		return nil, fmt.Errorf("unable to extract data: %w", err)
+	}
+	data, err := i.extractData(bufio.NewReader(f), spanID, uncompressedSize, uncompressedOffset)
+	if err != nil {
+		return nil, fmt.Errorf("unable to extract data: %w", err)
+	}
+	return data, nil
+}
+
+// extractData inflates `uncompressedSize` bytes at `uncompressedOffset` from `r`,
+// which must be positioned at the start of `spanID` in the compressed stream.
+func (i *GoGzipZinfo) extractData(r io.ByteReader, spanID SpanID, uncompressedSize, uncompressedOffset Offset) ([]byte, error) {
+	cp := i.checkpoints[spanID]
+	skip := int64(uncompressedOffset - cp.out)
+	if skip < 0 {
+		return nil, fmt.Errorf("offset %d is before span %d", uncompressedOffset, spanID)
+	}
+
+	buf := make([]byte, 0, uncompressedSize)
+	f := newInflater(r, func(p []byte) error {
+		if skip >= int64(len(p)) {
+			skip -= int64(len(p))
+			return nil
+		}
+		p = p[skip:]
+		skip = 0
+		buf = append(buf, p[:min(len(p), cap(buf)-len(buf))]...)
+		return nil
+	})
+	f.limit = int64(uncompressedOffset-cp.out) + int64(uncompressedSize)
+	f.setDictionary(cp.window)
+	if cp.bits != 0 {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		f.br.hold = uint64(c >> (8 - cp.bits))
+		f.br.nbits = uint(cp.bits)
+	}
+
+	// The checkpoint is inside of a raw deflate stream. Once the stream ends,
+	// the gzip trailer is skipped and later members are inflated as gzip streams.
+	raw := true
+	for {
+		for {
+			last, err := f.inflateBlock()
+			if errors.Is(err, errInflateLimit) {
+				return buf, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if last {
+				break
+			}
+		}
+		if err := f.readGzipTrailer(!raw); err != nil {
+			return nil, err
+		}
+		raw = false
+		if !f.br.more() {
+			break
+		}
+		if err := f.readGzipHeader(); err != nil {
+			return nil, err
+		}
+	}
+	if Offset(len(buf)) != uncompressedSize {
+		return nil, fmt.Errorf("unexpected end of gzip stream. expect length: %d, actual length: %d", uncompressedSize, len(buf))
+	}
+	return buf, nil
+}
+
+// StartCompressedOffset returns the start offset of the span in the compressed stream.
+func (i *GoGzipZinfo) StartCompressedOffset(spanID SpanID) Offset {
+	start := i.checkpoints[spanID].in
+	if i.hasBits(spanID) {
+		start--
+	}
+	return start
+}
+
+// EndCompressedOffset returns the end offset of the span in the compressed stream. If
+// it's the last span, returns the size of the compressed stream.
+func (i *GoGzipZinfo) EndCompressedOffset(spanID SpanID, fileSize Offset) Offset {
+	if spanID == i.MaxSpanID() {
+		return fileSize
+	}
+	return i.checkpoints[spanID+1].in
+}
+
+// StartUncompressedOffset returns the start offset of the span in the uncompressed stream.
+func (i *GoGzipZinfo) StartUncompressedOffset(spanID SpanID) Offset {
+	return i.checkpoints[spanID].out
+}
+
+// EndUncompressedOffset returns the end offset of the span in the uncompressed stream. If
+// it's the last span, returns the size of the uncompressed stream.
+func (i *GoGzipZinfo) EndUncompressedOffset(spanID SpanID, fileSize Offset) Offset {
+	if spanID == i.MaxSpanID() {
+		return fileSize
+	}
+	return i.checkpoints[spanID+1].out
+}
+
+// VerifyHeader checks if the given zinfo has a proper header
+func (i *GoGzipZinfo) VerifyHeader(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if gz != nil {
+		gz.Close()
+	}
+	return err
+}
+
+// hasBits returns true if any data is contained in the previous span.
+func (i *GoGzipZinfo) hasBits(spanID SpanID) bool {
+	if int(spanID) >= len(i.checkpoints) {
+		return false
+	}
+	return i.checkpoints[spanID].bits != 0
+}
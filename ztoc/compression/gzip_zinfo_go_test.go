@@ -0,0 +1,274 @@
+//go:build cgo && !purego
+
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// genGzipTestData generates `size` bytes of partially compressible data.
+func genGzipTestData(seed int64, size int) []byte {
+	r := rand.New(rand.NewSource(seed))
+	words := []string{"soci", "snapshotter", "lazy", "loading", "ztoc", "span", "checkpoint", "\n"}
+	var b bytes.Buffer
+	for b.Len() < size {
+		if r.Intn(4) == 0 {
+			chunk := make([]byte, r.Intn(512))
+			r.Read(chunk)
+			b.Write(chunk)
+			continue
+		}
+		for n := r.Intn(64); n > 0; n-- {
+			b.WriteString(words[r.Intn(len(words))])
+		}
+	}
+	return b.Bytes()[:size]
+}
+
+type gzipMember struct {
+	data   []byte
+	level  int
+	header gzip.Header
+}
+
+// writeGzipMembers writes each member as a separate gzip stream to a temp file.
+// It returns the file name and the concatenated uncompressed data.
+func writeGzipMembers(t *testing.T, members []gzipMember) (string, []byte) {
+	var compressed bytes.Buffer
+	var uncompressed []byte
+	for _, m := range members {
+		gw, err := gzip.NewWriterLevel(&compressed, m.level)
+		if err != nil {
+			t.Fatalf("failed to create gzip writer: %v", err)
+		}
+		gw.Header = m.header
+		if _, err := gw.Write(m.data); err != nil {
+			t.Fatalf("failed to write gzip data: %v", err)
+		}
+		if err := gw.Close(); err != nil {
+			t.Fatalf("failed to close gzip writer: %v", err)
+		}
+		uncompressed = append(uncompressed, m.data...)
+	}
+	name := filepath.Join(t.TempDir(), "layer.gz")
+	if err := os.WriteFile(name, compressed.Bytes(), 0600); err != nil {
+		t.Fatalf("failed to write gzip file: %v", err)
+	}
+	return name, uncompressed
+}
+
+// TestGoGzipZinfoMatchesC checks that the pure Go gzip zinfo produces the same
+// zinfo and extracted data as the C implementation.
+func TestGoGzipZinfoMatchesC(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name     string
+		members  []gzipMember
+		spanSize int64
+	}{
+		{
+			name:     "default compression",
+			members:  []gzipMember{{data: genGzipTestData(1, 1<<20), level: gzip.DefaultCompression}},
+			spanSize: 64 << 10,
+		},
+		{
+			name:     "best compression with small spans",
+			members:  []gzipMember{{data: genGzipTestData(2, 512<<10), level: gzip.BestCompression}},
+			spanSize: 1 << 10,
+		},
+		{
+			name:     "stored blocks",
+			members:  []gzipMember{{data: genGzipTestData(3, 256<<10), level: gzip.NoCompression}},
+			spanSize: 16 << 10,
+		},
+		{
+			name:     "huffman only",
+			members:  []gzipMember{{data: genGzipTestData(4, 256<<10), level: gzip.HuffmanOnly}},
+			spanSize: 16 << 10,
+		},
+		{
+			name:     "single span",
+			members:  []gzipMember{{data: genGzipTestData(5, 100<<10), level: gzip.DefaultCompression}},
+			spanSize: 4 << 20,
+		},
+		{
+			name: "gzip header with optional fields",
+			members: []gzipMember{{
+				data:  genGzipTestData(6, 256<<10),
+				level: gzip.DefaultCompression,
+				header: gzip.Header{
+					Name:    "layer.tar",
+					Comment: "soci",
+					Extra:   []byte("extra"),
+				},
+			}},
+			spanSize: 32 << 10,
+		},
+		{
+			name: "concatenated gzip members",
+			members: []gzipMember{
+				{data: genGzipTestData(7, 200<<10), level: gzip.DefaultCompression},
+				{data: genGzipTestData(8, 10), level: gzip.BestSpeed},
+				{data: genGzipTestData(9, 300<<10), level: gzip.BestCompression, header: gzip.Header{Name: "second"}},
+			},
+			spanSize: 32 << 10,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name, uncompressed := writeGzipMembers(t, tc.members)
+			compressed, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatalf("failed to read gzip file: %v", err)
+			}
+			fileSize := Offset(len(compressed))
+			uncompressedSize := Offset(len(uncompressed))
+
+			cZinfo, err := newGzipZinfoFromFile(name, tc.spanSize)
+			if err != nil {
+				t.Fatalf("failed to create C zinfo: %v", err)
+			}
+			defer cZinfo.Close()
+			goZinfo, err := newGoGzipZinfoFromFile(name, tc.spanSize)
+			if err != nil {
+				t.Fatalf("failed to create Go zinfo: %v", err)
+			}
+
+			cBytes, err := cZinfo.Bytes()
+			if err != nil {
+				t.Fatalf("failed to serialize C zinfo: %v", err)
+			}
+			goBytes, err := goZinfo.Bytes()
+			if err != nil {
+				t.Fatalf("failed to serialize Go zinfo: %v", err)
+			}
+			if !bytes.Equal(cBytes, goBytes) {
+				t.Fatalf("zinfo bytes differ. C: %d bytes, %d spans; Go: %d bytes, %d spans",
+					len(cBytes), cZinfo.MaxSpanID()+1, len(goBytes), goZinfo.MaxSpanID()+1)
+			}
+
+			// Load the C generated zinfo with the Go implementation and vice versa.
+			goFromC, err := newGoGzipZinfo(cBytes)
+			if err != nil {
+				t.Fatalf("failed to load C zinfo bytes in Go: %v", err)
+			}
+			cFromGo, err := newGzipZinfo(goBytes)
+			if err != nil {
+				t.Fatalf("failed to load Go zinfo bytes in C: %v", err)
+			}
+			defer cFromGo.Close()
+
+			for _, zinfo := range []Zinfo{goZinfo, goFromC} {
+				if zinfo.MaxSpanID() != cFromGo.MaxSpanID() || zinfo.SpanSize() != cFromGo.SpanSize() {
+					t.Fatalf("unexpected span metadata")
+				}
+				for i := SpanID(0); i <= zinfo.MaxSpanID(); i++ {
+					start := zinfo.StartUncompressedOffset(i)
+					end := zinfo.EndUncompressedOffset(i, uncompressedSize)
+					if start != cFromGo.StartUncompressedOffset(i) || end != cFromGo.EndUncompressedOffset(i, uncompressedSize) ||
+						zinfo.StartCompressedOffset(i) != cFromGo.StartCompressedOffset(i) ||
+						zinfo.EndCompressedOffset(i, fileSize) != cFromGo.EndCompressedOffset(i, fileSize) {
+						t.Fatalf("span %d has different offsets", i)
+					}
+					for _, offset := range []Offset{start, (start + end) / 2, end - 1} {
+						if zinfo.UncompressedOffsetToSpanID(offset) != cFromGo.UncompressedOffsetToSpanID(offset) {
+							t.Fatalf("offset %d maps to different spans", offset)
+						}
+					}
+
+					buf := compressed[zinfo.StartCompressedOffset(i):zinfo.EndCompressedOffset(i, fileSize)]
+					goData, err := zinfo.ExtractDataFromBuffer(buf, end-start, start, i)
+					if err != nil {
+						t.Fatalf("failed to extract span %d in Go: %v", i, err)
+					}
+					cData, err := cFromGo.ExtractDataFromBuffer(buf, end-start, start, i)
+					if err != nil {
+						t.Fatalf("failed to extract span %d in C: %v", i, err)
+					}
+					if !bytes.Equal(goData, cData) || !bytes.Equal(goData, uncompressed[start:end]) {
+						t.Fatalf("span %d has unexpected content", i)
+					}
+				}
+
+				r := rand.New(rand.NewSource(int64(len(uncompressed))))
+				for n := 0; n < 10; n++ {
+					offset := Offset(r.Int63n(int64(uncompressedSize)))
+					size := Offset(r.Int63n(int64(uncompressedSize-offset))) + 1
+					data, err := zinfo.ExtractDataFromFile(name, size, offset)
+					if err != nil {
+						t.Fatalf("failed to extract data from file at offset %d: %v", offset, err)
+					}
+					if !bytes.Equal(data, uncompressed[offset:offset+size]) {
+						t.Fatalf("extracted data from file at offset %d has unexpected content", offset)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestGoGzipZinfoV1 checks that v1 zinfo, which doesn't serialize the first checkpoint,
+// is loaded and re-serialized the same way by both implementations.
+func TestGoGzipZinfoV1(t *testing.T) {
+	t.Parallel()
+	name, uncompressed := writeGzipMembers(t, []gzipMember{{data: genGzipTestData(10, 256<<10), level: gzip.DefaultCompression}})
+	zinfo, err := newGoGzipZinfoFromFile(name, 32<<10)
+	if err != nil {
+		t.Fatalf("failed to create Go zinfo: %v", err)
+	}
+	v2, err := zinfo.Bytes()
+	if err != nil {
+		t.Fatalf("failed to serialize Go zinfo: %v", err)
+	}
+	// Go's gzip writer doesn't write optional header fields by default, so the
+	// first checkpoint is the one implied by v1.
+	v1 := append(v2[:gzipBlobHeaderSize:gzipBlobHeaderSize], v2[gzipBlobHeaderSize+gzipPackedCheckpointSize:]...)
+
+	cZinfo, err := newGzipZinfo(v1)
+	if err != nil {
+		t.Fatalf("failed to load v1 zinfo in C: %v", err)
+	}
+	defer cZinfo.Close()
+	goZinfo, err := newGoGzipZinfo(v1)
+	if err != nil {
+		t.Fatalf("failed to load v1 zinfo in Go: %v", err)
+	}
+	for _, z := range []Zinfo{cZinfo, goZinfo} {
+		b, err := z.Bytes()
+		if err != nil {
+			t.Fatalf("failed to serialize v1 zinfo: %v", err)
+		}
+		if !bytes.Equal(b, v1) {
+			t.Fatalf("v1 zinfo was not re-serialized to the same bytes")
+		}
+		data, err := z.ExtractDataFromFile(name, Offset(len(uncompressed)), 0)
+		if err != nil {
+			t.Fatalf("failed to extract data from v1 zinfo: %v", err)
+		}
+		if !bytes.Equal(data, uncompressed) {
+			t.Fatalf("data extracted with v1 zinfo has unexpected content")
+		}
+	}
+}
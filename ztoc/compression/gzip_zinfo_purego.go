@@ -0,0 +1,41 @@
+//go:build !cgo || purego
+
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compression
+
+import "io"
+
+// GzipZinfo is the pure Go gzip zinfo. It is used when building without cgo
+// or with the `purego` build tag.
+type GzipZinfo = GoGzipZinfo
+
+// newGzipZinfo creates a new instance of `GzipZinfo` from zinfo bytes on zTOC.
+func newGzipZinfo(zinfoBytes []byte) (*GzipZinfo, error) {
+	return newGoGzipZinfo(zinfoBytes)
+}
+
+// newGzipZinfoFromFile creates a new instance of `GzipZinfo` given gzip file name and span size.
+func newGzipZinfoFromFile(gzipFile string, spanSize int64) (*GzipZinfo, error) {
+	return newGoGzipZinfoFromFile(gzipFile, spanSize)
+}
+
+// newGzipZinfoFromReader creates a new instance of `GzipZinfo` by inflating the gzip
+// stream read from `sr`. The uncompressed data is written to `w`.
+func newGzipZinfoFromReader(sr *spanReader, spanSize int64, w io.Writer) (*GzipZinfo, error) {
+	return newGoGzipZinfoFromReader(sr, spanSize, w, sr.startSpan)
+}
@@ -0,0 +1,667 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+  This source code is based on
+  https://github.com/madler/zlib/blob/master/contrib/puff/puff.c
+  Copyright (C) 2002-2013 Mark Adler, all rights reserved.
+  It has been substantially modified from the original.
+*/
+
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// `compress/flate` does not expose deflate block boundaries, nor does it allow
+// to start inflating in the middle of a byte. Both are needed to build and use
+// gzip checkpoints, so this file contains a small deflate (RFC 1951) and gzip
+// (RFC 1952) decoder that mirrors the behavior of zlib used by `gzip_zinfo.c`.
+
+const (
+	// winSize is the size of the deflate sliding window.
+	winSize = 32768
+
+	maxCodeBits  = 15  // maximum bits in a code
+	maxLCodes    = 286 // maximum number of literal/length codes
+	maxDCodes    = 30  // maximum number of distance codes
+	maxCodes     = maxLCodes + maxDCodes
+	fixedLCodes  = 288 // number of fixed literal/length codes
+	huffFastBits = 9   // number of bits decoded with a single table lookup
+
+	gzipID1       = 0x1f
+	gzipID2       = 0x8b
+	gzipDeflate   = 8
+	gzipFlagHCRC  = 1 << 1
+	gzipFlagExtra = 1 << 2
+	gzipFlagName  = 1 << 3
+	gzipFlagCmnt  = 1 << 4
+	// gzipFlagReserved are the reserved flag bits that must be zero.
+	gzipFlagReserved = 0xe0
+	gzipTrailerSize  = 8
+)
+
+var (
+	errInflateLimit           = errors.New("inflate output limit reached")
+	errInflateBlockType       = errors.New("invalid block type")
+	errInflateStoredLength    = errors.New("invalid stored block lengths")
+	errInflateTooManySymbols  = errors.New("too many length or distance symbols")
+	errInflateCodeLengths     = errors.New("invalid code lengths set")
+	errInflateRepeat          = errors.New("invalid bit length repeat")
+	errInflateMissingEOB      = errors.New("invalid code -- missing end-of-block")
+	errInflateLitLenSet       = errors.New("invalid literal/lengths set")
+	errInflateDistSet         = errors.New("invalid distances set")
+	errInflateLitLenCode      = errors.New("invalid literal/length code")
+	errInflateDistCode        = errors.New("invalid distance code")
+	errInflateDistTooFar      = errors.New("invalid distance too far back")
+	errInflateCode            = errors.New("invalid huffman code")
+	errGzipHeader             = errors.New("incorrect gzip header check")
+	errGzipMethod             = errors.New("unknown gzip compression method")
+	errGzipFlags              = errors.New("unknown gzip header flags set")
+	errGzipHeaderCRC          = errors.New("gzip header crc mismatch")
+	errGzipDataCRC            = errors.New("incorrect gzip data check")
+	errGzipLength             = errors.New("incorrect gzip length check")
+	errInflateUnexpectedInput = errors.New("unexpected end of compressed data")
+)
+
+var (
+	// base lengths and extra bits for length codes 257..285
+	lengthBase  = [29]uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	// base offsets and extra bits for distance codes 0..29
+	distBase  = [30]uint16{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra = [30]uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+	// permutation of code length code lengths
+	codeLengthOrder = [19]uint8{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+	fixedLenCode, fixedDistCode huffman
+)
+
+func init() {
+	var lengths [fixedLCodes]uint8
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	fixedLenCode.init(lengths[:])
+	for i := 0; i < maxDCodes; i++ {
+		lengths[i] = 5
+	}
+	fixedDistCode.init(lengths[:maxDCodes])
+}
+
+// bitReader reads a deflate stream bit by bit (least significant bit first).
+type bitReader struct {
+	r     io.ByteReader
+	n     int64  // number of bytes read from r
+	hold  uint64 // bit accumulator
+	nbits uint   // number of bits in the accumulator
+	err   error  // sticky read error
+}
+
+// fill tries to get at least `n` bits into the accumulator. It stops without
+// an error at the end of the input. The caller checks `nbits`.
+func (b *bitReader) fill(n uint) {
+	for b.nbits < n && b.err == nil {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			b.err = err
+			return
+		}
+		b.hold |= uint64(c) << b.nbits
+		b.nbits += 8
+		b.n++
+	}
+}
+
+// readErr returns the error to report when the input doesn't have enough bits.
+func (b *bitReader) readErr() error {
+	if b.err == nil || errors.Is(b.err, io.EOF) {
+		return errInflateUnexpectedInput
+	}
+	return b.err
+}
+
+// bits reads `n` (<= 32) bits from the stream.
+func (b *bitReader) bits(n uint) (uint32, error) {
+	b.fill(n)
+	if b.nbits < n {
+		return 0, b.readErr()
+	}
+	v := uint32(b.hold & (1<<n - 1))
+	b.hold >>= n
+	b.nbits -= n
+	return v, nil
+}
+
+// alignToByte discards the remaining bits of the current byte.
+func (b *bitReader) alignToByte() {
+	drop := b.nbits % 8
+	b.hold >>= drop
+	b.nbits -= drop
+}
+
+// readByte reads a full byte. The reader must be byte aligned.
+func (b *bitReader) readByte() (byte, error) {
+	v, err := b.bits(8)
+	return byte(v), err
+}
+
+// more returns true if there is more input. The reader must be byte aligned.
+func (b *bitReader) more() bool {
+	b.fill(8)
+	return b.nbits >= 8
+}
+
+// offset returns the offset of the first byte that is not fully consumed.
+// If `unusedBits` is non-zero, the byte before `offset` has unconsumed bits.
+func (b *bitReader) offset() Offset {
+	return Offset(b.n - int64(b.nbits/8))
+}
+
+// unusedBits returns the number of bits (0-7) not yet consumed from the byte before `offset`.
+func (b *bitReader) unusedBits() uint8 {
+	return uint8(b.nbits % 8)
+}
+
+// huffman is a canonical huffman decoder.
+type huffman struct {
+	count  [maxCodeBits + 1]uint16 // number of symbols of each length
+	symbol [fixedLCodes]uint16     // canonically ordered symbols
+	// fast maps the next `huffFastBits` bits of the stream to `length<<9 | symbol`
+	// for codes of up to `huffFastBits` bits. 0 means the code is longer.
+	fast [1 << huffFastBits]uint16
+}
+
+// init builds the decoder from code lengths. It returns 0 for a complete code,
+// a positive number for an incomplete code and a negative number for an
+// over-subscribed code.
+func (h *huffman) init(lengths []uint8) int {
+	*h = huffman{}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	if int(h.count[0]) == len(lengths) {
+		// no codes: complete, but decoding will fail
+		return 0
+	}
+
+	left := 1
+	for l := 1; l <= maxCodeBits; l++ {
+		left <<= 1
+		left -= int(h.count[l])
+		if left < 0 {
+			return left
+		}
+	}
+
+	var offs [maxCodeBits + 1]uint16
+	for l := 1; l < maxCodeBits; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+	for sym, l := range lengths {
+		if l != 0 {
+			h.symbol[offs[l]] = uint16(sym)
+			offs[l]++
+		}
+	}
+
+	code, idx := 0, 0
+	for l := 1; l <= huffFastBits; l++ {
+		for k := 0; k < int(h.count[l]); k++ {
+			entry := uint16(l)<<9 | h.symbol[idx]
+			for j := reverseBits(code, l); j < 1<<huffFastBits; j += 1 << l {
+				h.fast[j] = entry
+			}
+			idx++
+			code++
+		}
+		code <<= 1
+	}
+	return left
+}
+
+func reverseBits(code, n int) int {
+	r := 0
+	for i := 0; i < n; i++ {
+		r = r<<1 | code&1
+		code >>= 1
+	}
+	return r
+}
+
+// decode decodes a single symbol from the stream.
+func (h *huffman) decode(b *bitReader) (int, error) {
+	b.fill(maxCodeBits)
+	if e := h.fast[b.hold&(1<<huffFastBits-1)]; e != 0 {
+		if l := uint(e >> 9); l <= b.nbits {
+			b.hold >>= l
+			b.nbits -= l
+			return int(e & (1<<9 - 1)), nil
+		}
+	}
+
+	// slow path: decode the code bit by bit
+	code, first, index := 0, 0, 0
+	for l := 1; l <= maxCodeBits; l++ {
+		bit, err := b.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= int(bit)
+		count := int(h.count[l])
+		if code-count < first {
+			return int(h.symbol[index+(code-first)]), nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+	return 0, errInflateCode
+}
+
+// inflater decodes deflate blocks and gzip members. Uncompressed data is kept
+// in a circular window and passed to `sink` as it is produced.
+type inflater struct {
+	br bitReader
+
+	window    [winSize]byte
+	wpos      int   // next write position in window
+	flushed   int   // start of data in window not yet passed to sink
+	available int64 // bytes in window which can be referenced by distances
+
+	// total is the number of uncompressed bytes produced.
+	total int64
+	// limit stops inflating once `total` reaches it. A negative limit means no limit.
+	limit int64
+	sink  func([]byte) error
+
+	crc  uint32 // crc32 of the current gzip member
+	size uint32 // uncompressed size of the current gzip member (mod 2^32)
+
+	lencode, distcode huffman
+}
+
+func newInflater(r io.ByteReader, sink func([]byte) error) *inflater {
+	return &inflater{
+		br:    bitReader{r: r},
+		limit: -1,
+		sink:  sink,
+	}
+}
+
+// setDictionary presets the window, e.g. to resume inflating from a checkpoint.
+func (f *inflater) setDictionary(dict []byte) {
+	copy(f.window[:], dict)
+	f.available = int64(len(dict))
+}
+
+// lastWindow returns the last `winSize` uncompressed bytes, oldest first.
+// If less than `winSize` bytes were produced, the window is zero-padded in front.
+func (f *inflater) lastWindow() []byte {
+	w := make([]byte, 0, winSize)
+	w = append(w, f.window[f.wpos:]...)
+	return append(w, f.window[:f.wpos]...)
+}
+
+func (f *inflater) flush() error {
+	if f.wpos == f.flushed {
+		return nil
+	}
+	p := f.window[f.flushed:f.wpos]
+	f.crc = crc32.Update(f.crc, crc32.IEEETable, p)
+	f.size += uint32(len(p))
+	f.flushed = f.wpos
+	if f.sink != nil {
+		return f.sink(p)
+	}
+	return nil
+}
+
+func (f *inflater) put(c byte) error {
+	f.window[f.wpos] = c
+	f.wpos++
+	f.total++
+	if f.available < winSize {
+		f.available++
+	}
+	if f.wpos == winSize {
+		if err := f.flush(); err != nil {
+			return err
+		}
+		f.wpos = 0
+		f.flushed = 0
+	}
+	return nil
+}
+
+// checkLimit flushes pending data and returns `errInflateLimit` if enough data was produced.
+func (f *inflater) checkLimit() error {
+	if f.limit >= 0 && f.total >= f.limit {
+		if err := f.flush(); err != nil {
+			return err
+		}
+		return errInflateLimit
+	}
+	return nil
+}
+
+// inflateBlock decodes a single deflate block and returns whether it was the last one.
+func (f *inflater) inflateBlock() (bool, error) {
+	hdr, err := f.br.bits(3)
+	if err != nil {
+		return false, err
+	}
+	last := hdr&1 != 0
+	switch hdr >> 1 {
+	case 0:
+		err = f.stored()
+	case 1:
+		err = f.codes(&fixedLenCode, &fixedDistCode)
+	case 2:
+		if err = f.dynamic(); err == nil {
+			err = f.codes(&f.lencode, &f.distcode)
+		}
+	default:
+		err = errInflateBlockType
+	}
+	if err != nil {
+		return false, err
+	}
+	return last, f.flush()
+}
+
+func (f *inflater) stored() error {
+	f.br.alignToByte()
+	l, err := f.br.bits(16)
+	if err != nil {
+		return err
+	}
+	nl, err := f.br.bits(16)
+	if err != nil {
+		return err
+	}
+	if l != ^nl&0xffff {
+		return errInflateStoredLength
+	}
+	for ; l > 0; l-- {
+		c, err := f.br.readByte()
+		if err != nil {
+			return err
+		}
+		if err := f.put(c); err != nil {
+			return err
+		}
+		if err := f.checkLimit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *inflater) dynamic() error {
+	var lengths [maxCodes]uint8
+
+	nlen, err := f.br.bits(5)
+	if err != nil {
+		return err
+	}
+	nlen += 257
+	ndist, err := f.br.bits(5)
+	if err != nil {
+		return err
+	}
+	ndist++
+	ncode, err := f.br.bits(4)
+	if err != nil {
+		return err
+	}
+	ncode += 4
+	if nlen > maxLCodes || ndist > maxDCodes {
+		return errInflateTooManySymbols
+	}
+
+	for i := uint32(0); i < ncode; i++ {
+		l, err := f.br.bits(3)
+		if err != nil {
+			return err
+		}
+		lengths[codeLengthOrder[i]] = uint8(l)
+	}
+	if f.lencode.init(lengths[:19]) != 0 {
+		return errInflateCodeLengths
+	}
+
+	for i := range lengths[:19] {
+		lengths[i] = 0
+	}
+	for index := uint32(0); index < nlen+ndist; {
+		sym, err := f.lencode.decode(&f.br)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[index] = uint8(sym)
+			index++
+			continue
+		}
+		var l uint8
+		var repeat uint32
+		switch sym {
+		case 16:
+			if index == 0 {
+				return errInflateRepeat
+			}
+			l = lengths[index-1]
+			repeat, err = f.br.bits(2)
+			repeat += 3
+		case 17:
+			repeat, err = f.br.bits(3)
+			repeat += 3
+		default:
+			repeat, err = f.br.bits(7)
+			repeat += 11
+		}
+		if err != nil {
+			return err
+		}
+		if index+repeat > nlen+ndist {
+			return errInflateRepeat
+		}
+		for ; repeat > 0; repeat-- {
+			lengths[index] = l
+			index++
+		}
+	}
+
+	if lengths[256] == 0 {
+		return errInflateMissingEOB
+	}
+	// incomplete codes are only allowed for a single length 1 code
+	if left := f.lencode.init(lengths[:nlen]); left < 0 || (left > 0 && int(nlen) != int(f.lencode.count[0]+f.lencode.count[1])) {
+		return errInflateLitLenSet
+	}
+	if left := f.distcode.init(lengths[nlen : nlen+ndist]); left < 0 || (left > 0 && int(ndist) != int(f.distcode.count[0]+f.distcode.count[1])) {
+		return errInflateDistSet
+	}
+	return nil
+}
+
+func (f *inflater) codes(lencode, distcode *huffman) error {
+	for {
+		sym, err := lencode.decode(&f.br)
+		if err != nil {
+			return err
+		}
+		switch {
+		case sym < 256:
+			if err := f.put(byte(sym)); err != nil {
+				return err
+			}
+		case sym == 256:
+			return nil
+		default:
+			sym -= 257
+			if sym >= len(lengthBase) {
+				return errInflateLitLenCode
+			}
+			extra, err := f.br.bits(uint(lengthExtra[sym]))
+			if err != nil {
+				return err
+			}
+			length := int(lengthBase[sym]) + int(extra)
+
+			dsym, err := distcode.decode(&f.br)
+			if err != nil {
+				return err
+			}
+			if dsym >= len(distBase) {
+				return errInflateDistCode
+			}
+			extra, err = f.br.bits(uint(distExtra[dsym]))
+			if err != nil {
+				return err
+			}
+			dist := int(distBase[dsym]) + int(extra)
+			if int64(dist) > f.available {
+				return errInflateDistTooFar
+			}
+			for ; length > 0; length-- {
+				if err := f.put(f.window[(f.wpos-dist+winSize)%winSize]); err != nil {
+					return err
+				}
+			}
+		}
+		if err := f.checkLimit(); err != nil {
+			return err
+		}
+	}
+}
+
+// readGzipHeader reads a gzip member header and resets the deflate state for the member.
+func (f *inflater) readGzipHeader() error {
+	var hdr [10]byte
+	for i := range hdr {
+		c, err := f.br.readByte()
+		if err != nil {
+			return err
+		}
+		hdr[i] = c
+	}
+	if hdr[0] != gzipID1 || hdr[1] != gzipID2 {
+		return errGzipHeader
+	}
+	if hdr[2] != gzipDeflate {
+		return errGzipMethod
+	}
+	flags := hdr[3]
+	if flags&gzipFlagReserved != 0 {
+		return errGzipFlags
+	}
+	headerCRC := crc32.Update(0, crc32.IEEETable, hdr[:])
+
+	readByte := func() (byte, error) {
+		c, err := f.br.readByte()
+		if err == nil {
+			headerCRC = crc32.Update(headerCRC, crc32.IEEETable, []byte{c})
+		}
+		return c, err
+	}
+	if flags&gzipFlagExtra != 0 {
+		lo, err := readByte()
+		if err != nil {
+			return err
+		}
+		hi, err := readByte()
+		if err != nil {
+			return err
+		}
+		for n := int(lo) | int(hi)<<8; n > 0; n-- {
+			if _, err := readByte(); err != nil {
+				return err
+			}
+		}
+	}
+	for _, flag := range []byte{gzipFlagName, gzipFlagCmnt} {
+		if flags&flag == 0 {
+			continue
+		}
+		for {
+			c, err := readByte()
+			if err != nil {
+				return err
+			}
+			if c == 0 {
+				break
+			}
+		}
+	}
+	if flags&gzipFlagHCRC != 0 {
+		v, err := f.br.bits(16)
+		if err != nil {
+			return err
+		}
+		if v != headerCRC&0xffff {
+			return errGzipHeaderCRC
+		}
+	}
+
+	f.available = 0
+	f.crc = 0
+	f.size = 0
+	return nil
+}
+
+// readGzipTrailer reads a gzip member trailer. If `check` is set, it verifies
+// the crc and size of the member's uncompressed data.
+func (f *inflater) readGzipTrailer(check bool) error {
+	f.br.alignToByte()
+	crc, err := f.br.bits(32)
+	if err != nil {
+		return err
+	}
+	size, err := f.br.bits(32)
+	if err != nil {
+		return err
+	}
+	if !check {
+		return nil
+	}
+	if crc != f.crc {
+		return errGzipDataCRC
+	}
+	if size != f.size {
+		return errGzipLength
+	}
+	return nil
+}
+
+func inflateError(err error, offset Offset) error {
+	return fmt.Errorf("inflate error at compressed offset %d: %w", offset, err)
+}
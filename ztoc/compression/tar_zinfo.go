@@ -17,6 +17,7 @@
 package compression
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -73,6 +74,28 @@ func newTarZinfoFromFile(tarFile string, spanSize int64) (*TarZinfo, error) {
 	}, nil
 }
 
+// newTarZinfoFromReader creates a new instance of `TarZinfo` by copying a tar
+// stream to `w` in chunks of `spanSize` bytes, one chunk per span.
+func newTarZinfoFromReader(sr *spanReader, spanSize int64, w io.Writer) (*TarZinfo, error) {
+	if spanSize <= 0 {
+		return nil, fmt.Errorf("invalid span size: %d", spanSize)
+	}
+	var size int64
+	for sr.more() {
+		sr.startSpan(Offset(size), Offset(size))
+		n, err := io.CopyN(w, sr, spanSize)
+		size += n
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	}
+	return &TarZinfo{
+		version:  zinfoVersion,
+		spanSize: spanSize,
+		size:     size,
+	}, nil
+}
+
 // Close doesn't do anything since there is nothing to close/release.
 func (i *TarZinfo) Close() {}
 
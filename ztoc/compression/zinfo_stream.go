@@ -0,0 +1,215 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// spanReaderBufferSize is the size of the buffer of `spanReader`.
+	spanReaderBufferSize = 64 << 10
+	// spanReaderKeepSize is the number of most recently consumed bytes that `spanReader`
+	// keeps buffered when it's refilled, since a new span can start slightly before
+	// the current offset (e.g. gzip spans start in the middle of a byte).
+	spanReaderKeepSize = 16
+)
+
+// StreamInfo contains information about a compressed stream that is collected
+// while creating a zinfo with `NewZinfoFromReader`.
+type StreamInfo struct {
+	// CompressedSize is the size of the compressed stream.
+	CompressedSize Offset
+	// SpanDigests contains the digest of the compressed data of each span.
+	SpanDigests []digest.Digest
+}
+
+// NewZinfoFromReader creates a zinfo struct given a compressed stream and a span size.
+// Unlike `NewZinfoFromFile`, the compressed stream is read exactly once: the uncompressed
+// data is written to `w` (e.g. to build a TOC at the same time) and the digest of every
+// span is computed while the zinfo is created.
+func NewZinfoFromReader(compressionAlgo string, r io.Reader, spanSize int64, w io.Writer) (Zinfo, StreamInfo, error) {
+	if w == nil {
+		w = io.Discard
+	}
+	sr := newSpanReader(r)
+
+	var (
+		zinfo Zinfo
+		err   error
+	)
+	switch compressionAlgo {
+	case Gzip:
+		zinfo, err = newGzipZinfoFromReader(sr, spanSize, w)
+	case Zstd:
+		zinfo, err = newZstdZinfoFromReader(sr, spanSize, w)
+	case Uncompressed, Unknown:
+		zinfo, err = newTarZinfoFromReader(sr, spanSize, w)
+	default:
+		return nil, StreamInfo{}, fmt.Errorf("unexpected compression algorithm: %s", compressionAlgo)
+	}
+	if err != nil {
+		return nil, StreamInfo{}, err
+	}
+
+	info, err := sr.finish()
+	if err != nil {
+		zinfo.Close()
+		return nil, StreamInfo{}, err
+	}
+	return zinfo, info, nil
+}
+
+// spanReader reads a compressed stream for the streaming zinfo builders. It tracks
+// the offset of the consumed data and computes the digest of every span, which is
+// started with `startSpan`. Data before the first span (e.g. a gzip header) isn't
+// included in any digest. The stream is read in chunks of `spanReaderBufferSize`
+// bytes which are added to the digests as a whole.
+type spanReader struct {
+	r io.Reader
+	// buf contains the data of the stream starting at offset `base`.
+	// The data before `pos` has been consumed.
+	buf  []byte
+	pos  int
+	base int64
+	// hashed is the offset up to which the data was added to the digest of the
+	// current span (or skipped before the first span).
+	hashed int64
+	// digester is the digester of the current span. nil before the first span.
+	digester digest.Digester
+	digests  []digest.Digest
+	err      error // sticky read error
+}
+
+func newSpanReader(r io.Reader) *spanReader {
+	return &spanReader{
+		r:   r,
+		buf: make([]byte, 0, spanReaderBufferSize),
+	}
+}
+
+// ReadByte implements `io.ByteReader`.
+func (s *spanReader) ReadByte() (byte, error) {
+	if s.pos == len(s.buf) {
+		if err := s.fill(); err != nil {
+			return 0, err
+		}
+	}
+	c := s.buf[s.pos]
+	s.pos++
+	return c, nil
+}
+
+// Read implements `io.Reader`.
+func (s *spanReader) Read(p []byte) (int, error) {
+	if s.pos == len(s.buf) {
+		if err := s.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf[s.pos:])
+	s.pos += n
+	return n, nil
+}
+
+// offset returns the number of bytes consumed from the stream.
+func (s *spanReader) offset() int64 {
+	return s.base + int64(s.pos)
+}
+
+// more returns true if there is more data in the stream.
+func (s *spanReader) more() bool {
+	return s.pos < len(s.buf) || s.fill() == nil
+}
+
+// fill reads more data once the buffered data is consumed. The buffered data is
+// added to the digest of the current span and dropped first, except the most
+// recently consumed bytes which may also belong to the next span.
+func (s *spanReader) fill() error {
+	if s.err != nil {
+		return s.err
+	}
+	s.hash(s.base + int64(len(s.buf)) - spanReaderKeepSize)
+	drop := int(s.hashed - s.base)
+	s.buf = s.buf[:copy(s.buf[:cap(s.buf)], s.buf[drop:])]
+	s.base += int64(drop)
+	s.pos -= drop
+	for {
+		n, err := s.r.Read(s.buf[len(s.buf):cap(s.buf)])
+		s.buf = s.buf[:len(s.buf)+n]
+		if err != nil {
+			s.err = err
+		}
+		if n > 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// hash adds the buffered data before offset `end` to the digest of the current span.
+func (s *spanReader) hash(end int64) {
+	if end <= s.hashed {
+		return
+	}
+	if s.digester != nil {
+		s.digester.Hash().Write(s.buf[s.hashed-s.base : end-s.base])
+	}
+	s.hashed = end
+}
+
+// startSpan ends the current span at compressed offset `prevEnd` and starts
+// a new span at compressed offset `start`. `start` can be before `prevEnd` if the
+// spans share a byte.
+func (s *spanReader) startSpan(start, prevEnd Offset) {
+	if s.digester != nil {
+		s.hash(int64(prevEnd))
+		s.digests = append(s.digests, s.digester.Digest())
+	}
+	s.hashed = int64(start)
+	s.digester = digest.Canonical.Digester()
+}
+
+// finish reads the rest of the stream and ends the last span at the end of the stream.
+func (s *spanReader) finish() (StreamInfo, error) {
+	for {
+		s.pos = len(s.buf)
+		err := s.fill()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return StreamInfo{}, fmt.Errorf("failed to read compressed stream: %w", err)
+		}
+	}
+	size := s.offset()
+	if s.digester != nil {
+		s.hash(size)
+		s.digests = append(s.digests, s.digester.Digest())
+		s.digester = nil
+	}
+	return StreamInfo{
+		CompressedSize: Offset(size),
+		SpanDigests:    s.digests,
+	}, nil
+}
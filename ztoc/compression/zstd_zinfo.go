@@ -0,0 +1,547 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	zinfo_flatbuffers "github.com/awslabs/soci-snapshotter/ztoc/compression/fbs/zinfo"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// zstdFrameMagic is the magic number at the beginning of every zstd frame.
+	zstdFrameMagic uint32 = 0xFD2FB528
+	// zstdSkippableFrameMagic is the magic number of a skippable frame.
+	// The lower 4 bits can be any value.
+	zstdSkippableFrameMagic     uint32 = 0x184D2A50
+	zstdSkippableFrameMagicMask uint32 = 0xFFFFFFF0
+
+	zstdMagicSize       = 4
+	zstdBlockHeaderSize = 3
+	zstdChecksumSize    = 4
+)
+
+var (
+	errZstdInvalidMagic     = errors.New("invalid zstd frame magic number")
+	errZstdReservedBlock    = errors.New("invalid zstd block type")
+	errZstdOffsetsMismatch  = errors.New("zstd zinfo has a different number of compressed and uncompressed offsets")
+	errZstdEmptyCheckpoints = errors.New("empty checkpoints")
+)
+
+// ZstdZinfo implements the `Zinfo` interface for zstd compressed files.
+//
+// Unlike gzip, the state of a zstd decoder cannot be cheaply captured in the
+// middle of a frame, so checkpoints are placed on frame boundaries. Each span
+// consists of one or more complete frames, and a new span is started at the
+// first frame boundary after at least `spanSize` bytes of uncompressed data.
+// Layers compressed as a single frame therefore have a single span. Layers
+// compressed with multiple frames (e.g. by the zstd seekable format or by
+// compressors that limit the frame size) can be lazily loaded per span.
+type ZstdZinfo struct {
+	version  int32
+	spanSize int64
+	// compressedOffsets[i] is the offset of span i in the compressed stream
+	compressedOffsets []Offset
+	// uncompressedOffsets[i] is the offset of span i in the uncompressed stream
+	uncompressedOffsets []Offset
+}
+
+// zstdFrame describes the location of a single frame in the compressed stream.
+type zstdFrame struct {
+	compressedOffset Offset
+	compressedSize   Offset
+	skippable        bool
+}
+
+// newZstdZinfo creates a new instance of `ZstdZinfo` from serialized bytes.
+func newZstdZinfo(zinfoBytes []byte) (zinfo *ZstdZinfo, err error) {
+	if len(zinfoBytes) == 0 {
+		return nil, errZstdEmptyCheckpoints
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			zinfo = nil
+			err = fmt.Errorf("cannot unmarshal zstd zinfo: %v", r)
+		}
+	}()
+
+	zinfoFlatbuf := zinfo_flatbuffers.GetRootAsZstdZinfo(zinfoBytes, 0)
+	if zinfoFlatbuf.CompressedOffsetsLength() != zinfoFlatbuf.UncompressedOffsetsLength() {
+		return nil, errZstdOffsetsMismatch
+	}
+	if zinfoFlatbuf.CompressedOffsetsLength() == 0 {
+		return nil, errZstdEmptyCheckpoints
+	}
+	zinfo = &ZstdZinfo{
+		version:             zinfoFlatbuf.Version(),
+		spanSize:            zinfoFlatbuf.SpanSize(),
+		compressedOffsets:   make([]Offset, zinfoFlatbuf.CompressedOffsetsLength()),
+		uncompressedOffsets: make([]Offset, zinfoFlatbuf.UncompressedOffsetsLength()),
+	}
+	for i := range zinfo.compressedOffsets {
+		zinfo.compressedOffsets[i] = Offset(zinfoFlatbuf.CompressedOffsets(i))
+		zinfo.uncompressedOffsets[i] = Offset(zinfoFlatbuf.UncompressedOffsets(i))
+	}
+	return zinfo, nil
+}
+
+// newZstdZinfoFromFile creates a new instance of `ZstdZinfo` given zstd file name and span size.
+func newZstdZinfoFromFile(zstdFile string, spanSize int64) (*ZstdZinfo, error) {
+	if spanSize <= 0 {
+		return nil, fmt.Errorf("invalid span size: %d", spanSize)
+	}
+	f, err := os.Open(zstdFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not open file for reading: %w", err)
+	}
+	defer f.Close()
+
+	frames, err := scanZstdFrames(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	zinfo := &ZstdZinfo{
+		version:             zinfoVersion,
+		spanSize:            spanSize,
+		compressedOffsets:   []Offset{0},
+		uncompressedOffsets: []Offset{0},
+	}
+	var uncompressedOffset Offset
+	for _, frame := range frames {
+		if frame.skippable {
+			continue
+		}
+		// Start a new span at this frame if the current span is large enough.
+		lastSpanStart := zinfo.uncompressedOffsets[len(zinfo.uncompressedOffsets)-1]
+		if uncompressedOffset-lastSpanStart >= Offset(spanSize) {
+			zinfo.compressedOffsets = append(zinfo.compressedOffsets, frame.compressedOffset)
+			zinfo.uncompressedOffsets = append(zinfo.uncompressedOffsets, uncompressedOffset)
+		}
+
+		if err := dec.Reset(io.NewSectionReader(f, int64(frame.compressedOffset), int64(frame.compressedSize))); err != nil {
+			return nil, err
+		}
+		n, err := io.Copy(io.Discard, dec)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress zstd frame at offset %d: %w", frame.compressedOffset, err)
+		}
+		uncompressedOffset += Offset(n)
+	}
+	return zinfo, nil
+}
+
+// newZstdZinfoFromReader creates a new instance of `ZstdZinfo` by decompressing
+// a zstd stream frame by frame. The uncompressed data is written to `w`.
+func newZstdZinfoFromReader(sr *spanReader, spanSize int64, w io.Writer) (*ZstdZinfo, error) {
+	if spanSize <= 0 {
+		return nil, fmt.Errorf("invalid span size: %d", spanSize)
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	zinfo := &ZstdZinfo{
+		version:             zinfoVersion,
+		spanSize:            spanSize,
+		compressedOffsets:   []Offset{0},
+		uncompressedOffsets: []Offset{0},
+	}
+	sr.startSpan(0, 0)
+	var (
+		uncompressedOffset Offset
+		buf                [zstdMagicSize]byte
+	)
+	for {
+		offset := Offset(sr.offset())
+		if _, err := io.ReadFull(sr, buf[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return zinfo, nil
+			}
+			return nil, fmt.Errorf("could not read zstd frame header at offset %d: %w", offset, err)
+		}
+		magic := binary.LittleEndian.Uint32(buf[:])
+		switch {
+		case magic&zstdSkippableFrameMagicMask == zstdSkippableFrameMagic:
+			if _, err := io.ReadFull(sr, buf[:]); err != nil {
+				return nil, fmt.Errorf("could not read zstd skippable frame size at offset %d: %w", offset, err)
+			}
+			if err := skipN(sr, int64(binary.LittleEndian.Uint32(buf[:]))); err != nil {
+				return nil, err
+			}
+		case magic == zstdFrameMagic:
+			// Start a new span at this frame if the current span is large enough.
+			lastSpanStart := zinfo.uncompressedOffsets[len(zinfo.uncompressedOffsets)-1]
+			if uncompressedOffset-lastSpanStart >= Offset(spanSize) {
+				zinfo.compressedOffsets = append(zinfo.compressedOffsets, offset)
+				zinfo.uncompressedOffsets = append(zinfo.uncompressedOffsets, uncompressedOffset)
+				sr.startSpan(offset, offset)
+			}
+			n, err := decompressZstdFrame(dec, sr, w)
+			if err != nil {
+				return nil, fmt.Errorf("could not decompress zstd frame at offset %d: %w", offset, err)
+			}
+			uncompressedOffset += Offset(n)
+		default:
+			return nil, fmt.Errorf("%w at offset %d: %#x", errZstdInvalidMagic, offset, magic)
+		}
+	}
+}
+
+// decompressZstdFrame decompresses a single zstd frame from `r`, whose magic
+// number was already read, into `w` and returns the size of the uncompressed data.
+// `r` is read exactly up to the end of the frame.
+func decompressZstdFrame(dec *zstd.Decoder, r io.Reader, w io.Writer) (int64, error) {
+	// The decoder reads until the end of its input, so it's given a pipe which only
+	// contains the frame. The frame is delimited by walking its block headers.
+	pr, pw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		var magic [zstdMagicSize]byte
+		binary.LittleEndian.PutUint32(magic[:], zstdFrameMagic)
+		_, err := pw.Write(magic[:])
+		if err == nil {
+			_, err = skipZstdFrame(io.TeeReader(r, pw))
+		}
+		pw.CloseWithError(err)
+		errc <- err
+	}()
+
+	var n int64
+	err := dec.Reset(pr)
+	if err == nil {
+		n, err = io.Copy(w, dec)
+	}
+	if err == nil {
+		// make sure the whole frame is consumed, e.g. if the decoder stops before the checksum
+		_, err = io.Copy(io.Discard, pr)
+	}
+	if err != nil {
+		pr.CloseWithError(err)
+	}
+	if frameErr := <-errc; frameErr != nil && err == nil {
+		err = frameErr
+	}
+	return n, err
+}
+
+// scanZstdFrames walks the frame and block headers of a zstd stream and returns
+// the location of every frame without decompressing any data.
+func scanZstdFrames(r io.Reader) ([]zstdFrame, error) {
+	var (
+		frames []zstdFrame
+		offset Offset
+		buf    [8]byte
+	)
+	for {
+		if _, err := io.ReadFull(r, buf[:zstdMagicSize]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return frames, nil
+			}
+			return nil, fmt.Errorf("could not read zstd frame header at offset %d: %w", offset, err)
+		}
+		frame := zstdFrame{compressedOffset: offset}
+		magic := binary.LittleEndian.Uint32(buf[:zstdMagicSize])
+
+		var size int64
+		switch {
+		case magic&zstdSkippableFrameMagicMask == zstdSkippableFrameMagic:
+			if _, err := io.ReadFull(r, buf[:4]); err != nil {
+				return nil, fmt.Errorf("could not read zstd skippable frame size at offset %d: %w", offset, err)
+			}
+			frameSize := int64(binary.LittleEndian.Uint32(buf[:4]))
+			if err := skipN(r, frameSize); err != nil {
+				return nil, err
+			}
+			frame.skippable = true
+			size = zstdMagicSize + 4 + frameSize
+		case magic == zstdFrameMagic:
+			n, err := skipZstdFrame(r)
+			if err != nil {
+				return nil, fmt.Errorf("could not read zstd frame at offset %d: %w", offset, err)
+			}
+			size = zstdMagicSize + n
+		default:
+			return nil, fmt.Errorf("%w at offset %d: %#x", errZstdInvalidMagic, offset, magic)
+		}
+		frame.compressedSize = Offset(size)
+		frames = append(frames, frame)
+		offset += Offset(size)
+	}
+}
+
+// skipZstdFrame skips over a zstd frame (after the magic number) and returns the number of bytes skipped.
+func skipZstdFrame(r io.Reader) (int64, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return 0, err
+	}
+	descriptor := buf[0]
+	singleSegment := descriptor&(1<<5) != 0
+	hasChecksum := descriptor&(1<<2) != 0
+
+	// Frame_Header_Descriptor + optional Window_Descriptor + Dictionary_ID + Frame_Content_Size
+	headerSize := int64(0)
+	if !singleSegment {
+		headerSize++
+	}
+	headerSize += [4]int64{0, 1, 2, 4}[descriptor&0x3]
+	switch descriptor >> 6 {
+	case 0:
+		if singleSegment {
+			headerSize++
+		}
+	case 1:
+		headerSize += 2
+	case 2:
+		headerSize += 4
+	case 3:
+		headerSize += 8
+	}
+	if err := skipN(r, headerSize); err != nil {
+		return 0, err
+	}
+	n := 1 + headerSize
+
+	for {
+		if _, err := io.ReadFull(r, buf[:zstdBlockHeaderSize]); err != nil {
+			return 0, err
+		}
+		header := uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16
+		last := header&1 != 0
+		blockType := (header >> 1) & 0x3
+		blockSize := int64(header >> 3)
+		switch blockType {
+		case 1:
+			// RLE blocks store a single byte regardless of the block size.
+			blockSize = 1
+		case 3:
+			return 0, errZstdReservedBlock
+		}
+		if err := skipN(r, blockSize); err != nil {
+			return 0, err
+		}
+		n += zstdBlockHeaderSize + blockSize
+		if last {
+			break
+		}
+	}
+	if hasChecksum {
+		if err := skipN(r, zstdChecksumSize); err != nil {
+			return 0, err
+		}
+		n += zstdChecksumSize
+	}
+	return n, nil
+}
+
+func skipN(r io.Reader, n int64) error {
+	skipped, err := io.CopyN(io.Discard, r, n)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if skipped != n {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// Close doesn't do anything since there is nothing to close/release.
+func (i *ZstdZinfo) Close() {}
+
+// Bytes returns the byte slice containing the `ZstdZinfo` flatbuffer.
+func (i *ZstdZinfo) Bytes() (fb []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fb = nil
+			err = fmt.Errorf("failed to generate zstd zinfo flatbuf bytes: %v", r)
+		}
+	}()
+
+	builder := flatbuffers.NewBuilder(0)
+	zinfo_flatbuffers.ZstdZinfoStartCompressedOffsetsVector(builder, len(i.compressedOffsets))
+	for j := len(i.compressedOffsets) - 1; j >= 0; j-- {
+		builder.PrependInt64(int64(i.compressedOffsets[j]))
+	}
+	compressedOffsets := builder.EndVector(len(i.compressedOffsets))
+
+	zinfo_flatbuffers.ZstdZinfoStartUncompressedOffsetsVector(builder, len(i.uncompressedOffsets))
+	for j := len(i.uncompressedOffsets) - 1; j >= 0; j-- {
+		builder.PrependInt64(int64(i.uncompressedOffsets[j]))
+	}
+	uncompressedOffsets := builder.EndVector(len(i.uncompressedOffsets))
+
+	zinfo_flatbuffers.ZstdZinfoStart(builder)
+	zinfo_flatbuffers.ZstdZinfoAddVersion(builder, i.version)
+	zinfo_flatbuffers.ZstdZinfoAddSpanSize(builder, i.spanSize)
+	zinfo_flatbuffers.ZstdZinfoAddCompressedOffsets(builder, compressedOffsets)
+	zinfo_flatbuffers.ZstdZinfoAddUncompressedOffsets(builder, uncompressedOffsets)
+	zstdZinfoFlatbuf := zinfo_flatbuffers.ZstdZinfoEnd(builder)
+	builder.Finish(zstdZinfoFlatbuf)
+	return builder.FinishedBytes(), nil
+}
+
+// MaxSpanID returns the max span ID.
+func (i *ZstdZinfo) MaxSpanID() SpanID {
+	return SpanID(len(i.compressedOffsets) - 1)
+}
+
+// SpanSize returns the span size of the constructed zinfo.
+func (i *ZstdZinfo) SpanSize() Offset {
+	return Offset(i.spanSize)
+}
+
+// UncompressedOffsetToSpanID returns the ID of the span containing the data pointed by uncompressed offset.
+func (i *ZstdZinfo) UncompressedOffsetToSpanID(offset Offset) SpanID {
+	idx := sort.Search(len(i.uncompressedOffsets), func(j int) bool {
+		return i.uncompressedOffsets[j] > offset
+	})
+	if idx == 0 {
+		return 0
+	}
+	return SpanID(idx - 1)
+}
+
+// ExtractDataFromBuffer decompresses `compressedBuf`, which must start at the
+// beginning of `spanID`, and returns the data specified by offset and size.
+func (i *ZstdZinfo) ExtractDataFromBuffer(compressedBuf []byte, uncompressedSize, uncompressedOffset Offset, spanID SpanID) ([]byte, error) {
+	if len(compressedBuf) == 0 {
+		return nil, fmt.Errorf("empty compressed buffer")
+	}
+	if uncompressedSize < 0 {
+		return nil, fmt.Errorf("invalid uncompressed size: %d", uncompressedSize)
+	}
+	if uncompressedSize == 0 {
+		return []byte{}, nil
+	}
+	if spanID < 0 || spanID > i.MaxSpanID() {
+		return nil, fmt.Errorf("invalid span id: %d", spanID)
+	}
+	return i.extractData(bytes.NewReader(compressedBuf), uncompressedSize, uncompressedOffset-i.StartUncompressedOffset(spanID))
+}
+
+// ExtractDataFromFile decompresses the data specified by offset and size directly
+// from a zstd file, starting at the span containing `uncompressedOffset`.
+func (i *ZstdZinfo) ExtractDataFromFile(fileName string, uncompressedSize, uncompressedOffset Offset) ([]byte, error) {
+	if uncompressedSize < 0 {
+		return nil, fmt.Errorf("invalid uncompressed size: %d", uncompressedSize)
+	}
+	if uncompressedSize == 0 {
+		return []byte{}, nil
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	spanID := i.UncompressedOffsetToSpanID(uncompressedOffset)
+	start := i.StartCompressedOffset(spanID)
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	sr := io.NewSectionReader(f, int64(start), fi.Size()-int64(start))
+	return i.extractData(sr, uncompressedSize, uncompressedOffset-i.StartUncompressedOffset(spanID))
+}
+
+// extractData decompresses `r`, which must start on a frame boundary, and returns
+// `uncompressedSize` bytes starting at `offsetInStream` in the decompressed data.
+func (i *ZstdZinfo) extractData(r io.Reader, uncompressedSize, offsetInStream Offset) ([]byte, error) {
+	if offsetInStream < 0 {
+		return nil, fmt.Errorf("invalid uncompressed offset: %d", offsetInStream)
+	}
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	if err := skipN(dec, int64(offsetInStream)); err != nil {
+		return nil, fmt.Errorf("failed to extract data: %w", err)
+	}
+	bytes := make([]byte, uncompressedSize)
+	if n, err := io.ReadFull(dec, bytes); err != nil {
+		return nil, fmt.Errorf("failed to extract data. expect length: %d, actual length: %d: %w", uncompressedSize, n, err)
+	}
+	return bytes, nil
+}
+
+// StartCompressedOffset returns the start offset of the span in the compressed stream.
+func (i *ZstdZinfo) StartCompressedOffset(spanID SpanID) Offset {
+	return i.compressedOffsets[spanID]
+}
+
+// EndCompressedOffset returns the end offset of the span in the compressed stream. If
+// it's the last span, returns the size of the compressed stream.
+func (i *ZstdZinfo) EndCompressedOffset(spanID SpanID, fileSize Offset) Offset {
+	if spanID == i.MaxSpanID() {
+		return fileSize
+	}
+	return i.compressedOffsets[spanID+1]
+}
+
+// StartUncompressedOffset returns the start offset of the span in the uncompressed stream.
+func (i *ZstdZinfo) StartUncompressedOffset(spanID SpanID) Offset {
+	return i.uncompressedOffsets[spanID]
+}
+
+// EndUncompressedOffset returns the end offset of the span in the uncompressed stream. If
+// it's the last span, returns the size of the uncompressed stream.
+func (i *ZstdZinfo) EndUncompressedOffset(spanID SpanID, fileSize Offset) Offset {
+	if spanID == i.MaxSpanID() {
+		return fileSize
+	}
+	return i.uncompressedOffsets[spanID+1]
+}
+
+// VerifyHeader checks if the given reader starts with a zstd frame or a skippable frame.
+//
+// zstd has no stream header outside of its frames, so the reader is expected to
+// contain the beginning of the first span.
+func (i *ZstdZinfo) VerifyHeader(r io.Reader) error {
+	var buf [zstdMagicSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return fmt.Errorf("could not read zstd magic number: %w", err)
+	}
+	magic := binary.LittleEndian.Uint32(buf[:])
+	if magic != zstdFrameMagic && magic&zstdSkippableFrameMagicMask != zstdSkippableFrameMagic {
+		return fmt.Errorf("%w: %#x", errZstdInvalidMagic, magic)
+	}
+	return nil
+}
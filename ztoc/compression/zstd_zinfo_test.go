@@ -0,0 +1,201 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// writeZstdFrames compresses each chunk into its own zstd frame and writes
+// them to a temp file. It returns the file name and the uncompressed data.
+func writeZstdFrames(t *testing.T, chunks [][]byte, skippable bool) (string, []byte) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("failed to create zstd encoder: %v", err)
+	}
+	defer enc.Close()
+
+	var compressed, uncompressed []byte
+	for _, chunk := range chunks {
+		if skippable {
+			var hdr [8]byte
+			binary.LittleEndian.PutUint32(hdr[:4], zstdSkippableFrameMagic|0x5)
+			binary.LittleEndian.PutUint32(hdr[4:], 3)
+			compressed = append(compressed, hdr[:]...)
+			compressed = append(compressed, "foo"...)
+		}
+		compressed = enc.EncodeAll(chunk, compressed)
+		uncompressed = append(uncompressed, chunk...)
+	}
+
+	name := filepath.Join(t.TempDir(), "layer.zst")
+	if err := os.WriteFile(name, compressed, 0600); err != nil {
+		t.Fatalf("failed to write zstd file: %v", err)
+	}
+	return name, uncompressed
+}
+
+func TestNewZstdZinfo(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name        string
+		zinfoBytes  []byte
+		expectError bool
+	}{
+		{
+			name:        "nil zinfoBytes should return error",
+			zinfoBytes:  nil,
+			expectError: true,
+		},
+		{
+			name:        "empty zinfoBytes should return error",
+			zinfoBytes:  []byte{},
+			expectError: true,
+		},
+		{
+			name:        "garbage zinfoBytes should return error",
+			zinfoBytes:  []byte{0xFF, 00},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newZstdZinfo(tc.zinfoBytes)
+			if tc.expectError != (err != nil) {
+				t.Fatalf("expect error: %t, actual error: %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func TestZstdZinfoSpans(t *testing.T) {
+	t.Parallel()
+	chunk := func(b byte, size int) []byte {
+		return bytes.Repeat([]byte{b}, size)
+	}
+	testCases := []struct {
+		name                        string
+		chunks                      [][]byte
+		skippable                   bool
+		spanSize                    int64
+		expectedUncompressedOffsets []Offset
+	}{
+		{
+			name:                        "single frame has a single span",
+			chunks:                      [][]byte{chunk('a', 100000)},
+			spanSize:                    1000,
+			expectedUncompressedOffsets: []Offset{0},
+		},
+		{
+			name:                        "one span per frame",
+			chunks:                      [][]byte{chunk('a', 1000), chunk('b', 1000), chunk('c', 10)},
+			spanSize:                    1000,
+			expectedUncompressedOffsets: []Offset{0, 1000, 2000},
+		},
+		{
+			name:                        "small frames are merged into a span",
+			chunks:                      [][]byte{chunk('a', 400), chunk('b', 400), chunk('c', 400), chunk('d', 400)},
+			spanSize:                    1000,
+			expectedUncompressedOffsets: []Offset{0, 1200},
+		},
+		{
+			name:                        "skippable frames are included in spans",
+			chunks:                      [][]byte{chunk('a', 1000), chunk('b', 1000)},
+			skippable:                   true,
+			spanSize:                    1000,
+			expectedUncompressedOffsets: []Offset{0, 1000},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name, uncompressed := writeZstdFrames(t, tc.chunks, tc.skippable)
+			zinfo, err := newZstdZinfoFromFile(name, tc.spanSize)
+			if err != nil {
+				t.Fatalf("failed to create zinfo: %v", err)
+			}
+			if int(zinfo.MaxSpanID())+1 != len(tc.expectedUncompressedOffsets) {
+				t.Fatalf("unexpected number of spans. expected: %d, actual: %d", len(tc.expectedUncompressedOffsets), zinfo.MaxSpanID()+1)
+			}
+			for i, expected := range tc.expectedUncompressedOffsets {
+				if actual := zinfo.StartUncompressedOffset(SpanID(i)); actual != expected {
+					t.Fatalf("unexpected start offset of span %d. expected: %d, actual: %d", i, expected, actual)
+				}
+			}
+
+			b, err := zinfo.Bytes()
+			if err != nil {
+				t.Fatalf("failed to serialize zinfo: %v", err)
+			}
+			zinfo, err = newZstdZinfo(b)
+			if err != nil {
+				t.Fatalf("failed to deserialize zinfo: %v", err)
+			}
+
+			compressed, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatalf("failed to read zstd file: %v", err)
+			}
+			fileSize := Offset(len(compressed))
+			uncompressedSize := Offset(len(uncompressed))
+			for i := SpanID(0); i <= zinfo.MaxSpanID(); i++ {
+				start := zinfo.StartUncompressedOffset(i)
+				end := zinfo.EndUncompressedOffset(i, uncompressedSize)
+				if zinfo.UncompressedOffsetToSpanID(start) != i {
+					t.Fatalf("offset %d should be in span %d", start, i)
+				}
+				buf := compressed[zinfo.StartCompressedOffset(i):zinfo.EndCompressedOffset(i, fileSize)]
+				if err := zinfo.VerifyHeader(bytes.NewReader(buf)); err != nil {
+					t.Fatalf("span %d should start with a valid frame: %v", i, err)
+				}
+				data, err := zinfo.ExtractDataFromBuffer(buf, end-start, start, i)
+				if err != nil {
+					t.Fatalf("failed to extract span %d: %v", i, err)
+				}
+				if !bytes.Equal(data, uncompressed[start:end]) {
+					t.Fatalf("span %d has unexpected content", i)
+				}
+			}
+
+			data, err := zinfo.ExtractDataFromFile(name, uncompressedSize-1, 1)
+			if err != nil {
+				t.Fatalf("failed to extract data from file: %v", err)
+			}
+			if !bytes.Equal(data, uncompressed[1:]) {
+				t.Fatalf("extracted data from file has unexpected content")
+			}
+		})
+	}
+}
+
+func TestZstdZinfoFromInvalidFile(t *testing.T) {
+	t.Parallel()
+	name := filepath.Join(t.TempDir(), "layer.zst")
+	if err := os.WriteFile(name, []byte("not a zstd file"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := newZstdZinfoFromFile(name, 1000); err == nil {
+		t.Fatalf("expected an error building zinfo from an invalid file")
+	}
+}
@@ -24,10 +24,11 @@ import (
 	"testing"
 
 	"github.com/awslabs/soci-snapshotter/util/testutil"
+	"github.com/awslabs/soci-snapshotter/ztoc/compression"
 )
 
 // BuildZtocReader creates the tar gz file for tar entries. It returns ztoc and io.SectionReader of the file.
-func BuildZtocReader(_ *testing.T, ents []testutil.TarEntry, compressionLevel int, spanSize int64, opts ...testutil.BuildTarOption) (*Ztoc, *io.SectionReader, error) {
+func BuildZtocReader(_ testing.TB, ents []testutil.TarEntry, compressionLevel int, spanSize int64, opts ...testutil.BuildTarOption) (*Ztoc, *io.SectionReader, error) {
 	tarReader := testutil.BuildTarGz(ents, compressionLevel, opts...)
 
 	tarFileName, tarData, err := testutil.WriteTarToTempFile("tmp.*", tarReader)
@@ -43,3 +44,22 @@ func BuildZtocReader(_ *testing.T, ents []testutil.TarEntry, compressionLevel in
 	}
 	return ztoc, sr, nil
 }
+
+// BuildZtocReaderZstd creates the tar zstd file for tar entries. It returns ztoc and io.SectionReader of the file.
+// Use `testutil.WithZstdFrameSize` to split the layer into multiple spans.
+func BuildZtocReaderZstd(_ testing.TB, ents []testutil.TarEntry, compressionLevel int, spanSize int64, opts ...testutil.BuildTarOption) (*Ztoc, *io.SectionReader, error) {
+	tarReader := testutil.BuildTarZstd(ents, compressionLevel, opts...)
+
+	tarFileName, tarData, err := testutil.WriteTarToTempFile("tmp.*", tarReader)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(tarFileName)
+
+	sr := io.NewSectionReader(bytes.NewReader(tarData), 0, int64(len(tarData)))
+	ztoc, err := NewBuilder("test").BuildZtoc(tarFileName, spanSize, WithCompression(compression.Zstd))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build sample ztoc: %w", err)
+	}
+	return ztoc, sr, nil
+}
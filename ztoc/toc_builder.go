@@ -27,6 +27,7 @@ import (
 	"github.com/awslabs/soci-snapshotter/util/ioutils"
 	"github.com/awslabs/soci-snapshotter/ztoc/compression"
 	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
 )
 
 // TarProvider creates a tar reader from a compressed file reader (e.g., a gzip file reader),
@@ -80,11 +81,18 @@ func (tb TocBuilder) CheckCompressionAlgorithm(algorithm string) bool {
 // TocFromFile creates a `TOC` given a layer blob filename and the compression
 // algorithm used by the layer.
 func (tb TocBuilder) TocFromFile(algorithm, filename string) (TOC, compression.Offset, error) {
+	return tb.tocFromFile(algorithm, filename, false)
+}
+
+// tocFromFile creates a `TOC` given a layer blob filename and the compression
+// algorithm used by the layer. If `fileDigests` is set, the digest of every regular
+// file is added to the `TOC`.
+func (tb TocBuilder) tocFromFile(algorithm, filename string, fileDigests bool) (TOC, compression.Offset, error) {
 	if !tb.CheckCompressionAlgorithm(algorithm) {
 		return TOC{}, 0, fmt.Errorf("unsupported compression algorithm: %s", algorithm)
 	}
 
-	fm, uncompressedArchiveSize, err := tb.getFileMetadata(algorithm, filename)
+	fm, uncompressedArchiveSize, err := tb.getFileMetadata(algorithm, filename, fileDigests)
 	if err != nil {
 		return TOC{}, 0, err
 	}
@@ -94,7 +102,7 @@ func (tb TocBuilder) TocFromFile(algorithm, filename string) (TOC, compression.O
 
 // getFileMetadata creates `FileMetadata` for each file within the compressed file
 // and calculate the uncompressed size of the passed file.
-func (tb TocBuilder) getFileMetadata(algorithm, filename string) ([]FileMetadata, compression.Offset, error) {
+func (tb TocBuilder) getFileMetadata(algorithm, filename string, fileDigests bool) ([]FileMetadata, compression.Offset, error) {
 	// read compress file and create compress tar reader.
 	compressFile, err := os.Open(filename)
 	if err != nil {
@@ -107,7 +115,7 @@ func (tb TocBuilder) getFileMetadata(algorithm, filename string) ([]FileMetadata
 		return nil, 0, err
 	}
 
-	md, uncompressFileSize, err := metadataFromTarReader(compressTarReader)
+	md, uncompressFileSize, err := metadataFromTarReader(compressTarReader, fileDigests)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -115,8 +123,9 @@ func (tb TocBuilder) getFileMetadata(algorithm, filename string) ([]FileMetadata
 }
 
 // metadataFromTarReader reads every file from tar reader `sr` and creates
-// `FileMetadata` for each file.
-func metadataFromTarReader(r io.Reader) ([]FileMetadata, compression.Offset, error) {
+// `FileMetadata` for each file. If `fileDigests` is set, the content of every
+// regular file is digested as it's read.
+func metadataFromTarReader(r io.Reader, fileDigests bool) ([]FileMetadata, compression.Offset, error) {
 	pt := ioutils.NewPositionTrackerReader(r)
 	tarRdr := tar.NewReader(pt)
 	var md []FileMetadata
@@ -153,6 +162,13 @@ func metadataFromTarReader(r io.Reader) ([]FileMetadata, compression.Offset, err
 			Devminor:           hdr.Devminor,
 			PAXHeaders:         hdr.PAXRecords,
 		}
+		if fileDigests && hdr.Typeflag == tar.TypeReg {
+			digester := digest.Canonical.Digester()
+			if _, err := io.Copy(digester.Hash(), tarRdr); err != nil {
+				return nil, 0, fmt.Errorf("error while reading file %s: %w", hdr.Name, err)
+			}
+			metadataEntry.Digest = digester.Digest()
+		}
 		md = append(md, metadataEntry)
 		// The next file's tar header can be found immediately after the current file + padding
 		tarHeaderOffset = AlignToTarBlock(metadataEntry.UncompressedOffset + metadataEntry.UncompressedSize)
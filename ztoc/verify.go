@@ -0,0 +1,271 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package ztoc
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/awslabs/soci-snapshotter/ztoc/compression"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+)
+
+// Mismatch is a value in a ztoc that doesn't match the layer.
+type Mismatch struct {
+	// Field is the name of the mismatched value.
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s mismatch: expected %s, got %s", m.Field, m.Expected, m.Actual)
+}
+
+// SpanDigestMismatch is a span whose compressed data doesn't match its digest in the ztoc.
+type SpanDigestMismatch struct {
+	SpanID   compression.SpanID
+	Expected digest.Digest
+	Actual   digest.Digest
+}
+
+func (m SpanDigestMismatch) String() string {
+	return fmt.Sprintf("span %d: digest mismatch: expected %s, got %s", m.SpanID, m.Expected, m.Actual)
+}
+
+// TOCEntryMismatch is a TOC entry that doesn't match the corresponding tar header
+// (or file content) in the layer.
+type TOCEntryMismatch struct {
+	// Index is the position of the entry in the TOC.
+	Index int
+	// Name is the name of the entry in the TOC, or the name of the tar header
+	// if the TOC has fewer entries than the layer.
+	Name string
+	Mismatch
+}
+
+func (m TOCEntryMismatch) String() string {
+	return fmt.Sprintf("toc entry %d (%s): %s", m.Index, m.Name, m.Mismatch)
+}
+
+// VerifyReport contains the problems found by `Ztoc.Verify`.
+type VerifyReport struct {
+	// ArchiveMismatches contains mismatches of values describing the whole layer
+	// (e.g. its size).
+	ArchiveMismatches    []Mismatch
+	SpanDigestMismatches []SpanDigestMismatch
+	TOCEntryMismatches   []TOCEntryMismatch
+}
+
+// OK returns true if no problem was found.
+func (r *VerifyReport) OK() bool {
+	return len(r.ArchiveMismatches) == 0 && len(r.SpanDigestMismatches) == 0 && len(r.TOCEntryMismatches) == 0
+}
+
+// Problems returns a human readable description of every problem in the report.
+func (r *VerifyReport) Problems() []string {
+	var problems []string
+	for _, m := range r.ArchiveMismatches {
+		problems = append(problems, m.String())
+	}
+	for _, m := range r.SpanDigestMismatches {
+		problems = append(problems, m.String())
+	}
+	for _, m := range r.TOCEntryMismatches {
+		problems = append(problems, m.String())
+	}
+	return problems
+}
+
+// Verify checks the ztoc against the layer blob `r`. It checks the digest of every
+// span and compares every TOC entry (and file digest, if present) with the tar headers
+// in the layer. Problems with the ztoc are returned in the report; an error is only
+// returned if the verification couldn't be done (e.g. the layer can't be read).
+func (zt Ztoc) Verify(r *io.SectionReader) (*VerifyReport, error) {
+	report := &VerifyReport{}
+	if size := compression.Offset(r.Size()); size != zt.CompressedArchiveSize {
+		report.ArchiveMismatches = append(report.ArchiveMismatches, Mismatch{
+			Field:    "compressed archive size",
+			Expected: fmt.Sprint(zt.CompressedArchiveSize),
+			Actual:   fmt.Sprint(size),
+		})
+	}
+	if err := zt.verifySpans(r, report); err != nil {
+		return nil, err
+	}
+	if err := zt.verifyTOC(io.NewSectionReader(r, 0, r.Size()), report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// verifySpans checks the digest of the compressed data of every span.
+func (zt Ztoc) verifySpans(r io.ReaderAt, report *VerifyReport) error {
+	zinfo, err := zt.Zinfo()
+	if err != nil {
+		return fmt.Errorf("failed to parse zinfo: %w", err)
+	}
+	defer zinfo.Close()
+
+	numSpans := int(zinfo.MaxSpanID()) + 1
+	if numSpans != len(zt.SpanDigests) {
+		report.ArchiveMismatches = append(report.ArchiveMismatches, Mismatch{
+			Field:    "number of span digests",
+			Expected: fmt.Sprint(numSpans),
+			Actual:   fmt.Sprint(len(zt.SpanDigests)),
+		})
+		numSpans = min(numSpans, len(zt.SpanDigests))
+	}
+
+	for i := compression.SpanID(0); int(i) < numSpans; i++ {
+		start := zinfo.StartCompressedOffset(i)
+		end := zinfo.EndCompressedOffset(i, zt.CompressedArchiveSize)
+		expected := zt.SpanDigests[i]
+		// A malformed digest can't be computed, e.g. in a corrupted ztoc.
+		if err := expected.Validate(); err != nil {
+			report.ArchiveMismatches = append(report.ArchiveMismatches, Mismatch{
+				Field:    fmt.Sprintf("span %d digest", i),
+				Expected: "a valid digest",
+				Actual:   fmt.Sprintf("invalid digest %q: %v", expected, err),
+			})
+			continue
+		}
+		digester := expected.Algorithm().Digester()
+		// A layer shorter than the ztoc expects results in a digest mismatch,
+		// so the number of copied bytes doesn't need to be checked.
+		if _, err := io.Copy(digester.Hash(), io.NewSectionReader(r, int64(start), int64(end-start))); err != nil {
+			return fmt.Errorf("failed to read span %d: %w", i, err)
+		}
+		if actual := digester.Digest(); actual != expected {
+			report.SpanDigestMismatches = append(report.SpanDigestMismatches, SpanDigestMismatch{
+				SpanID:   i,
+				Expected: expected,
+				Actual:   actual,
+			})
+		}
+	}
+	return nil
+}
+
+// verifyTOC decompresses the layer and compares the TOC with the tar headers in it.
+func (zt Ztoc) verifyTOC(r io.Reader, report *VerifyReport) error {
+	fileDigests := false
+	for _, m := range zt.FileMetadata {
+		if m.Digest != "" {
+			fileDigests = true
+			break
+		}
+	}
+	actual, uncompressedArchiveSize, err := readFileMetadata(zt.CompressionAlgorithm, r, fileDigests)
+	if err != nil {
+		// The TOC can't be compared with a layer which isn't a valid tar archive.
+		report.ArchiveMismatches = append(report.ArchiveMismatches, Mismatch{
+			Field:    "layer",
+			Expected: fmt.Sprintf("a valid %s tar archive", zt.CompressionAlgorithm),
+			Actual:   err.Error(),
+		})
+		return nil
+	}
+	if uncompressedArchiveSize != zt.UncompressedArchiveSize {
+		report.ArchiveMismatches = append(report.ArchiveMismatches, Mismatch{
+			Field:    "uncompressed archive size",
+			Expected: fmt.Sprint(zt.UncompressedArchiveSize),
+			Actual:   fmt.Sprint(uncompressedArchiveSize),
+		})
+	}
+
+	for i := 0; i < max(len(zt.FileMetadata), len(actual)); i++ {
+		switch {
+		case i >= len(actual):
+			report.TOCEntryMismatches = append(report.TOCEntryMismatches, TOCEntryMismatch{
+				Index:    i,
+				Name:     zt.FileMetadata[i].Name,
+				Mismatch: Mismatch{Field: "entry", Expected: "a tar header", Actual: "end of archive"},
+			})
+		case i >= len(zt.FileMetadata):
+			report.TOCEntryMismatches = append(report.TOCEntryMismatches, TOCEntryMismatch{
+				Index:    i,
+				Name:     actual[i].Name,
+				Mismatch: Mismatch{Field: "entry", Expected: "end of TOC", Actual: "a tar header"},
+			})
+		default:
+			for _, m := range compareFileMetadata(zt.FileMetadata[i], actual[i]) {
+				report.TOCEntryMismatches = append(report.TOCEntryMismatches, TOCEntryMismatch{
+					Index:    i,
+					Name:     zt.FileMetadata[i].Name,
+					Mismatch: m,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// compareFileMetadata compares the fields of a TOC entry that locate
+// its tar header and content in the layer.
+func compareFileMetadata(expected, actual FileMetadata) []Mismatch {
+	var mismatches []Mismatch
+	add := func(field string, expected, actual any) {
+		if expected != actual {
+			mismatches = append(mismatches, Mismatch{
+				Field:    field,
+				Expected: fmt.Sprint(expected),
+				Actual:   fmt.Sprint(actual),
+			})
+		}
+	}
+	add("name", expected.Name, actual.Name)
+	add("type", expected.Type, actual.Type)
+	add("tar header offset", expected.TarHeaderOffset, actual.TarHeaderOffset)
+	add("offset", expected.UncompressedOffset, actual.UncompressedOffset)
+	add("size", expected.UncompressedSize, actual.UncompressedSize)
+	add("linkname", expected.Linkname, actual.Linkname)
+	if expected.Digest != "" {
+		add("digest", expected.Digest, actual.Digest)
+	}
+	return mismatches
+}
+
+// readFileMetadata decompresses a layer and creates `FileMetadata` for each file in it.
+func readFileMetadata(algorithm string, r io.Reader, fileDigests bool) ([]FileMetadata, compression.Offset, error) {
+	tr, err := decompressedReader(algorithm, r)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tr.Close()
+	return metadataFromTarReader(tr, fileDigests)
+}
+
+// decompressedReader returns a reader of the tar archive in a layer compressed with `algorithm`.
+func decompressedReader(algorithm string, r io.Reader) (io.ReadCloser, error) {
+	switch algorithm {
+	case compression.Gzip:
+		return gzip.NewReader(r)
+	case compression.Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case compression.Uncompressed, compression.Unknown:
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm: %s", algorithm)
+	}
+}
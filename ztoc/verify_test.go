@@ -0,0 +1,169 @@
+/*
+   Copyright The Soci Snapshotter Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package ztoc
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/awslabs/soci-snapshotter/util/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestVerify(t *testing.T) {
+	for _, tc := range testZtocs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := testutil.NewTestRand(t)
+			tarEntries := []testutil.TarEntry{
+				testutil.Dir("dir/"),
+				testutil.File("dir/file", string(r.RandomByteDataRange(100000, 200000))),
+				testutil.File("smallfile", string(r.RandomByteDataRange(1, 100))),
+				testutil.Symlink("link", "dir/file"),
+			}
+			tarFilePath, _, _ := tc.tarGenerator(t, "verify", tarEntries)
+			defer os.Remove(tarFilePath)
+			layer, err := os.ReadFile(tarFilePath)
+			if err != nil {
+				t.Fatalf("can't read layer: %v", err)
+			}
+
+			testcases := []struct {
+				name          string
+				modifyLayer   func([]byte) []byte
+				modifyZtoc    func(*Ztoc)
+				archive       int
+				spanDigests   int
+				tocMismatches []string
+				// corrupted is set if the layer content is corrupted, in which case
+				// decompressing the layer may fail or produce a different TOC.
+				corrupted bool
+			}{
+				{
+					name: "valid ztoc",
+				},
+				{
+					name: "corrupted layer",
+					modifyLayer: func(b []byte) []byte {
+						b = bytes.Clone(b)
+						b[len(b)/2] ^= 0xff
+						return b
+					},
+					spanDigests: 1,
+					corrupted:   true,
+				},
+				{
+					name: "wrong entry offsets",
+					modifyZtoc: func(zt *Ztoc) {
+						zt.FileMetadata[1].UncompressedOffset += 512
+						zt.FileMetadata[1].TarHeaderOffset += 512
+					},
+					tocMismatches: []string{"tar header offset", "offset"},
+				},
+				{
+					name: "wrong file digest",
+					modifyZtoc: func(zt *Ztoc) {
+						zt.FileMetadata[2].Digest = digest.FromString("foo")
+					},
+					tocMismatches: []string{"digest"},
+				},
+				{
+					name: "missing entry",
+					modifyZtoc: func(zt *Ztoc) {
+						zt.FileMetadata = zt.FileMetadata[:len(zt.FileMetadata)-1]
+					},
+					tocMismatches: []string{"entry"},
+				},
+				{
+					name: "wrong span digest",
+					modifyZtoc: func(zt *Ztoc) {
+						zt.SpanDigests = append([]digest.Digest{digest.FromString("foo")}, zt.SpanDigests[1:]...)
+					},
+					spanDigests: 1,
+				},
+				{
+					name: "invalid span digest",
+					modifyZtoc: func(zt *Ztoc) {
+						zt.SpanDigests = append([]digest.Digest{"sha256:invalid"}, zt.SpanDigests[1:]...)
+					},
+					archive: 1,
+				},
+				{
+					name: "empty span digest",
+					modifyZtoc: func(zt *Ztoc) {
+						zt.SpanDigests = append([]digest.Digest{""}, zt.SpanDigests[1:]...)
+					},
+					archive: 1,
+				},
+				{
+					name: "wrong archive sizes",
+					modifyZtoc: func(zt *Ztoc) {
+						zt.UncompressedArchiveSize++
+						zt.CompressedArchiveSize++
+					},
+					// The last span ends past the end of the layer, but its content is the same.
+					archive: 2,
+				},
+			}
+			for _, c := range testcases {
+				t.Run(c.name, func(t *testing.T) {
+					zt, err := NewBuilder("test").BuildZtoc(tarFilePath, 1<<16, WithCompression(tc.compressionAlgo), WithFileDigests())
+					if err != nil {
+						t.Fatalf("can't build ztoc: %v", err)
+					}
+					if c.modifyZtoc != nil {
+						c.modifyZtoc(zt)
+					}
+					l := layer
+					if c.modifyLayer != nil {
+						l = c.modifyLayer(l)
+					}
+
+					report, err := zt.Verify(io.NewSectionReader(bytes.NewReader(l), 0, int64(len(l))))
+					if err != nil {
+						t.Fatalf("can't verify ztoc: %v", err)
+					}
+					if c.corrupted {
+						if len(report.SpanDigestMismatches) == 0 || report.OK() {
+							t.Fatalf("expected span digest mismatches, problems: %v", report.Problems())
+						}
+						return
+					}
+					if len(report.ArchiveMismatches) != c.archive {
+						t.Fatalf("unexpected archive mismatches: %v", report.ArchiveMismatches)
+					}
+					if len(report.SpanDigestMismatches) != c.spanDigests {
+						t.Fatalf("unexpected span digest mismatches: %v", report.SpanDigestMismatches)
+					}
+					if len(report.TOCEntryMismatches) != len(c.tocMismatches) {
+						t.Fatalf("unexpected toc entry mismatches: %v", report.TOCEntryMismatches)
+					}
+					for i, field := range c.tocMismatches {
+						if report.TOCEntryMismatches[i].Field != field {
+							t.Fatalf("unexpected toc entry mismatch. expected field: %s, actual: %v", field, report.TOCEntryMismatches[i])
+						}
+					}
+					expectOK := c.archive == 0 && c.spanDigests == 0 && len(c.tocMismatches) == 0
+					if report.OK() != expectOK {
+						t.Fatalf("unexpected report status. expected ok: %t, problems: %v", expectOK, report.Problems())
+					}
+				})
+			}
+		})
+	}
+}
@@ -32,6 +32,16 @@ type ZinfoBuilder interface {
 	ZinfoFromFile(filename string, spanSize int64) (zinfo CompressionInfo, fs compression.Offset, err error)
 }
 
+// ZinfoStreamBuilder is implemented by a `ZinfoBuilder` that can also build zinfo
+// in a single pass over a compressed stream, which lets `ztoc.Builder` build
+// the zinfo and the toc without storing the stream in a file.
+type ZinfoStreamBuilder interface {
+	ZinfoBuilder
+	// ZinfoFromReader builds zinfo given a compressed tar stream and span size, and calculate the size of the stream.
+	// The uncompressed tar stream is written to `w`.
+	ZinfoFromReader(r io.Reader, spanSize int64, w io.Writer) (zinfo CompressionInfo, fs compression.Offset, err error)
+}
+
 type gzipZinfoBuilder struct{}
 
 // ZinfoFromFile creates zinfo for a gzip file. The underlying zinfo object (i.e. `GzipZinfo`)
@@ -66,6 +76,50 @@ func (gzb gzipZinfoBuilder) ZinfoFromFile(filename string, spanSize int64) (zinf
 	}, fs, nil
 }
 
+// ZinfoFromReader creates zinfo for a gzip stream.
+func (gzb gzipZinfoBuilder) ZinfoFromReader(r io.Reader, spanSize int64, w io.Writer) (CompressionInfo, compression.Offset, error) {
+	return zinfoFromReader(compression.Gzip, r, spanSize, w)
+}
+
+type zstdZinfoBuilder struct{}
+
+// ZinfoFromFile creates zinfo for a zstd file. The underlying zinfo object (i.e. `ZstdZinfo`)
+// is stored in `CompressionInfo.Checkpoints` as byte slice.
+func (zzb zstdZinfoBuilder) ZinfoFromFile(filename string, spanSize int64) (zinfo CompressionInfo, fs compression.Offset, err error) {
+	index, err := compression.NewZinfoFromFile(compression.Zstd, filename, spanSize)
+	if err != nil {
+		return
+	}
+	defer index.Close()
+
+	fs, err = getFileSize(filename)
+	if err != nil {
+		return
+	}
+
+	digests, err := getPerSpanDigests(filename, int64(fs), index)
+	if err != nil {
+		return
+	}
+
+	checkpoints, err := index.Bytes()
+	if err != nil {
+		return
+	}
+
+	return CompressionInfo{
+		MaxSpanID:            index.MaxSpanID(),
+		SpanDigests:          digests,
+		Checkpoints:          checkpoints,
+		CompressionAlgorithm: compression.Zstd,
+	}, fs, nil
+}
+
+// ZinfoFromReader creates zinfo for a zstd stream.
+func (zzb zstdZinfoBuilder) ZinfoFromReader(r io.Reader, spanSize int64, w io.Writer) (CompressionInfo, compression.Offset, error) {
+	return zinfoFromReader(compression.Zstd, r, spanSize, w)
+}
+
 type tarZinfoBuilder struct{}
 
 func (tzb tarZinfoBuilder) ZinfoFromFile(filename string, spanSize int64) (zinfo CompressionInfo, fs compression.Offset, err error) {
@@ -98,6 +152,30 @@ func (tzb tarZinfoBuilder) ZinfoFromFile(filename string, spanSize int64) (zinfo
 	}, fs, nil
 }
 
+// ZinfoFromReader creates zinfo for a tar stream.
+func (tzb tarZinfoBuilder) ZinfoFromReader(r io.Reader, spanSize int64, w io.Writer) (CompressionInfo, compression.Offset, error) {
+	return zinfoFromReader(compression.Uncompressed, r, spanSize, w)
+}
+
+// zinfoFromReader creates zinfo and the per span digests in a single pass over a compressed stream.
+func zinfoFromReader(algorithm string, r io.Reader, spanSize int64, w io.Writer) (CompressionInfo, compression.Offset, error) {
+	index, info, err := compression.NewZinfoFromReader(algorithm, r, spanSize, w)
+	if err != nil {
+		return CompressionInfo{}, 0, err
+	}
+	defer index.Close()
+	checkpoints, err := index.Bytes()
+	if err != nil {
+		return CompressionInfo{}, 0, err
+	}
+	return CompressionInfo{
+		MaxSpanID:            index.MaxSpanID(),
+		SpanDigests:          info.SpanDigests,
+		Checkpoints:          checkpoints,
+		CompressionAlgorithm: algorithm,
+	}, info.CompressedSize, nil
+}
+
 func getPerSpanDigests(filename string, fileSize int64, index compression.Zinfo) ([]digest.Digest, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -18,6 +18,7 @@ package ztoc
 
 import (
 	"archive/tar"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -35,8 +36,14 @@ type Version string
 // Ztoc versions available.
 const (
 	Version09 Version = "0.9"
+	// Version10 adds the digest of regular files to the TOC.
+	Version10 Version = "1.0"
 )
 
+// ErrFileDigestMismatch is returned when the content of a file doesn't match
+// its digest in the TOC.
+var ErrFileDigestMismatch = errors.New("file digest mismatch")
+
 // Ztoc is a table of contents for compressed data which consists 2 parts:
 //
 // (1). toc (`TOC`): a table of contents containing file metadata and its
@@ -87,6 +94,8 @@ type FileMetadata struct {
 	Devminor int64     // Minor device number (valid for TypeChar or TypeBlock)
 
 	PAXHeaders map[string]string
+
+	Digest digest.Digest // Digest of the file content (valid for TypeReg, empty before ztoc version 1.0)
 }
 
 // FileMode gets file mode for the file metadata
@@ -123,7 +132,8 @@ func (src FileMetadata) Equal(o FileMetadata) bool {
 		src.Gname != o.Gname ||
 		src.ModTime != o.ModTime ||
 		src.Devmajor != o.Devmajor ||
-		src.Devminor != o.Devminor {
+		src.Devminor != o.Devminor ||
+		src.Digest != o.Digest {
 		return false
 	}
 	if len(src.PAXHeaders) != len(o.PAXHeaders) {
@@ -145,6 +155,7 @@ func (src FileMetadata) Xattrs() map[string]string {
 type MetadataEntry struct {
 	UncompressedSize   compression.Offset
 	UncompressedOffset compression.Offset
+	Digest             digest.Digest
 }
 
 // GetMetadataEntry gets MetadataEntry given a filename.
@@ -157,20 +168,36 @@ func (toc TOC) GetMetadataEntry(filename string) (MetadataEntry, error) {
 			return MetadataEntry{
 				UncompressedSize:   v.UncompressedSize,
 				UncompressedOffset: v.UncompressedOffset,
+				Digest:             v.Digest,
 			}, nil
 		}
 	}
 	return MetadataEntry{}, fmt.Errorf("file %s does not exist in metadata", filename)
 }
 
+// Verify checks that `data` matches the digest of the entry. Entries without
+// a digest (e.g. from ztocs before version 1.0) are not verified.
+func (entry MetadataEntry) Verify(data []byte) error {
+	if entry.Digest == "" {
+		return nil
+	}
+	if actual := entry.Digest.Algorithm().FromBytes(data); actual != entry.Digest {
+		return fmt.Errorf("%w: expected %s, got %s", ErrFileDigestMismatch, entry.Digest, actual)
+	}
+	return nil
+}
+
 // ExtractFile extracts a file from compressed data (as a reader) and returns the
-// byte data.
+// byte data. If the TOC contains the digest of the file, the data is verified.
 func (zt Ztoc) ExtractFile(r *io.SectionReader, filename string) ([]byte, error) {
 	entry, err := zt.GetMetadataEntry(filename)
 	if err != nil {
 		return nil, err
 	}
 	if entry.UncompressedSize == 0 {
+		if err := entry.Verify(nil); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
 		return []byte{}, nil
 	}
 
@@ -222,11 +249,15 @@ func (zt Ztoc) ExtractFile(r *io.SectionReader, filename string) ([]byte, error)
 	if err != nil {
 		return nil, err
 	}
+	if err := entry.Verify(bytes); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
 
 	return bytes, nil
 }
 
 // ExtractFromTarGz extracts data given a gzip tar file (`gz`) and its `ztoc`.
+// If the TOC contains the digest of the file, the data is verified.
 func (zt Ztoc) ExtractFromTarGz(gz string, filename string) (string, error) {
 	entry, err := zt.GetMetadataEntry(filename)
 	if err != nil {
@@ -234,6 +265,9 @@ func (zt Ztoc) ExtractFromTarGz(gz string, filename string) (string, error) {
 	}
 
 	if entry.UncompressedSize == 0 {
+		if err := entry.Verify(nil); err != nil {
+			return "", fmt.Errorf("%s: %w", filename, err)
+		}
 		return "", nil
 	}
 
@@ -248,6 +282,9 @@ func (zt Ztoc) ExtractFromTarGz(gz string, filename string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if err := entry.Verify(bytes); err != nil {
+		return "", fmt.Errorf("%s: %w", filename, err)
+	}
 
 	return string(bytes), nil
 }
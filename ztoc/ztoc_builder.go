@@ -18,6 +18,8 @@ package ztoc
 
 import (
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/awslabs/soci-snapshotter/ztoc/compression"
 )
@@ -34,7 +36,7 @@ type Builder struct {
 }
 
 // NewBuilder creates a `Builder` used to build ztocs. By default it supports gzip,
-// user can register new compression algorithms by calling `RegisterCompressionAlgorithm`.
+// zstd and uncompressed tar, user can register new compression algorithms by calling `RegisterCompressionAlgorithm`.
 func NewBuilder(buildToolIdentifier string) *Builder {
 	builder := Builder{
 		tocBuilder:          NewTocBuilder(),
@@ -42,6 +44,7 @@ func NewBuilder(buildToolIdentifier string) *Builder {
 		buildToolIdentifier: buildToolIdentifier,
 	}
 	builder.RegisterCompressionAlgorithm(compression.Gzip, TarProviderGzip, gzipZinfoBuilder{})
+	builder.RegisterCompressionAlgorithm(compression.Zstd, TarProviderZstd, zstdZinfoBuilder{})
 	builder.RegisterCompressionAlgorithm(compression.Uncompressed, TarProviderTar, tarZinfoBuilder{})
 	builder.RegisterCompressionAlgorithm(compression.Unknown, TarProviderTar, tarZinfoBuilder{})
 
@@ -50,7 +53,8 @@ func NewBuilder(buildToolIdentifier string) *Builder {
 
 // buildConfig contains configuration used when `ztoc.Builder` builds a `Ztoc`.
 type buildConfig struct {
-	algorithm string
+	algorithm   string
+	fileDigests bool
 }
 
 // BuildOption specifies a change to `buildConfig` when building a ztoc.
@@ -64,6 +68,15 @@ func WithCompression(algorithm string) BuildOption {
 	}
 }
 
+// WithFileDigests adds the digest of every regular file to the TOC. Ztocs
+// with file digests use `Version10`.
+func WithFileDigests() BuildOption {
+	return func(opt *buildConfig) error {
+		opt.fileDigests = true
+		return nil
+	}
+}
+
 // defaultBuildConfig creates a `buildConfig` with default values.
 func defaultBuildConfig() buildConfig {
 	return buildConfig{
@@ -77,37 +90,113 @@ func (b *Builder) BuildZtoc(filename string, span int64, options ...BuildOption)
 	if filename == "" {
 		return nil, fmt.Errorf("need to provide a compressed filename")
 	}
+	opt, err := b.buildConfig(options...)
+	if err != nil {
+		return nil, err
+	}
+	compressionInfo, fs, err := b.zinfoBuilders[opt.algorithm].ZinfoFromFile(filename, span)
+	if err != nil {
+		return nil, err
+	}
 
-	opt := defaultBuildConfig()
-	for _, f := range options {
-		err := f(&opt)
-		if err != nil {
-			return nil, err
-		}
+	toc, uncompressedArchiveSize, err := b.tocBuilder.tocFromFile(opt.algorithm, filename, opt.fileDigests)
+	if err != nil {
+		return nil, err
 	}
 
-	if !b.CheckCompressionAlgorithm(opt.algorithm) {
-		return nil, fmt.Errorf("unsupported compression algorithm, supported: gzip, got: %s", opt.algorithm)
+	return b.newZtoc(opt, toc, uncompressedArchiveSize, compressionInfo, fs), nil
+}
+
+// BuildZtocFromReader builds a `Ztoc` given a reader of a layer blob. By default it assumes
+// the layer is compressed using `gzip`, unless specified via `WithCompression`.
+//
+// If the `ZinfoBuilder` of the compression algorithm is a `ZinfoStreamBuilder`, the layer
+// is decompressed once to build both the zinfo and the toc, and is never stored locally.
+// Otherwise the layer is copied to a temporary file and built with `BuildZtoc`.
+func (b *Builder) BuildZtocFromReader(r io.Reader, span int64, options ...BuildOption) (*Ztoc, error) {
+	opt, err := b.buildConfig(options...)
+	if err != nil {
+		return nil, err
+	}
+	zinfoBuilder, ok := b.zinfoBuilders[opt.algorithm].(ZinfoStreamBuilder)
+	if !ok {
+		return b.buildZtocFromTempFile(r, span, options...)
 	}
 
-	compressionInfo, fs, err := b.zinfoBuilders[opt.algorithm].ZinfoFromFile(filename, span)
+	type tocResult struct {
+		toc                     TOC
+		uncompressedArchiveSize compression.Offset
+		err                     error
+	}
+	pr, pw := io.Pipe()
+	tocc := make(chan tocResult, 1)
+	go func() {
+		md, uncompressedArchiveSize, err := metadataFromTarReader(pr, opt.fileDigests)
+		if err == nil {
+			// The tar reader stops at the end-of-archive marker; drain the padding
+			// after it so the zinfo builder can read the rest of the stream.
+			_, err = io.Copy(io.Discard, pr)
+		}
+		pr.CloseWithError(err)
+		tocc <- tocResult{toc: TOC{FileMetadata: md}, uncompressedArchiveSize: uncompressedArchiveSize, err: err}
+	}()
+
+	compressionInfo, fs, err := zinfoBuilder.ZinfoFromReader(r, span, pw)
+	pw.CloseWithError(err)
+	res := <-tocc
 	if err != nil {
 		return nil, err
 	}
+	if res.err != nil {
+		return nil, res.err
+	}
 
-	toc, uncompressedArchiveSize, err := b.tocBuilder.TocFromFile(opt.algorithm, filename)
+	return b.newZtoc(opt, res.toc, res.uncompressedArchiveSize, compressionInfo, fs), nil
+}
+
+// buildZtocFromTempFile copies a layer blob to a temporary file and builds a `Ztoc` from it.
+func (b *Builder) buildZtocFromTempFile(r io.Reader, span int64, options ...BuildOption) (*Ztoc, error) {
+	tmpFile, err := os.CreateTemp("", "tmp.*")
 	if err != nil {
 		return nil, err
 	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	if _, err := io.Copy(tmpFile, r); err != nil {
+		return nil, err
+	}
+	return b.BuildZtoc(tmpFile.Name(), span, options...)
+}
 
+// buildConfig applies build options and checks the compression algorithm is supported.
+func (b *Builder) buildConfig(options ...BuildOption) (buildConfig, error) {
+	opt := defaultBuildConfig()
+	for _, f := range options {
+		err := f(&opt)
+		if err != nil {
+			return buildConfig{}, err
+		}
+	}
+
+	if !b.CheckCompressionAlgorithm(opt.algorithm) {
+		return buildConfig{}, fmt.Errorf("unsupported compression algorithm, supported: gzip, zstd, uncompressed, got: %s", opt.algorithm)
+	}
+	return opt, nil
+}
+
+func (b *Builder) newZtoc(opt buildConfig, toc TOC, uncompressedArchiveSize compression.Offset, compressionInfo CompressionInfo, fs compression.Offset) *Ztoc {
+	version := Version09
+	if opt.fileDigests {
+		version = Version10
+	}
 	return &Ztoc{
-		Version:                 Version09,
+		Version:                 version,
 		TOC:                     toc,
 		CompressedArchiveSize:   fs,
 		UncompressedArchiveSize: uncompressedArchiveSize,
 		BuildToolIdentifier:     b.buildToolIdentifier,
 		CompressionInfo:         compressionInfo,
-	}, nil
+	}
 }
 
 // RegisterCompressionAlgorithm supports a new compression algorithm in `ztoc.Builder`.
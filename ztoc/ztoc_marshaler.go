@@ -94,8 +94,8 @@ func flatbufToZtoc(flatbuffer []byte) (z *Ztoc, err error) {
 	ztoc.MaxSpanID = compression.SpanID(compressionInfo.MaxSpanId())
 	ztoc.SpanDigests = make([]digest.Digest, compressionInfo.SpanDigestsLength())
 	for i := 0; i < compressionInfo.SpanDigestsLength(); i++ {
-		dgst, _ := digest.Parse(string(compressionInfo.SpanDigests(i)))
-		ztoc.SpanDigests[i] = dgst
+		// Malformed digests are kept as is, so that verification reports them.
+		ztoc.SpanDigests[i] = digest.Digest(compressionInfo.SpanDigests(i))
 	}
 	// Since compressionInfo.CheckpointsBytes() returns a slice,
 	// we need to give it its own array so the GC can free compressionInfo.
@@ -137,6 +137,13 @@ func flatbufferToTOC(fbtoc *ztoc_flatbuffers.TOC) (TOC, error) {
 			value := string(xattrEntry.Value())
 			me.PAXHeaders[key] = value
 		}
+		if d := metadataEntry.Digest(); len(d) > 0 {
+			dgst, err := digest.Parse(string(d))
+			if err != nil {
+				return toc, fmt.Errorf("%w: invalid digest for %s: %v", ErrInvalidTOCEntry, me.Name, err)
+			}
+			me.Digest = dgst
+		}
 
 		toc.FileMetadata[i] = me
 	}
@@ -246,6 +253,12 @@ func prepareMetadataOffset(builder *flatbuffers.Builder, me FileMetadata) flatbu
 	modTime := builder.CreateString(string(modTimeBinary))
 
 	xattrs := prepareXattrsOffset(me, builder)
+	// the digest is optional, so don't serialize it if it's empty to keep
+	// ztocs without file digests identical to older versions.
+	var dgst flatbuffers.UOffsetT
+	if me.Digest != "" {
+		dgst = builder.CreateString(me.Digest.String())
+	}
 
 	ztoc_flatbuffers.FileMetadataStart(builder)
 	ztoc_flatbuffers.FileMetadataAddName(builder, name)
@@ -263,6 +276,9 @@ func prepareMetadataOffset(builder *flatbuffers.Builder, me FileMetadata) flatbu
 	ztoc_flatbuffers.FileMetadataAddDevminor(builder, me.Devminor)
 
 	ztoc_flatbuffers.FileMetadataAddXattrs(builder, xattrs)
+	if me.Digest != "" {
+		ztoc_flatbuffers.FileMetadataAddDigest(builder, dgst)
+	}
 
 	off := ztoc_flatbuffers.FileMetadataEnd(builder)
 	return off
@@ -19,6 +19,7 @@ package ztoc
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -48,6 +49,22 @@ func buildTarGZ(t testing.TB, tarName string, tarEntries []testutil.TarEntry) (s
 	return tarGzFilePath, m, fileNames
 }
 
+// buildTarZstd creates a temp tar zstd file with the given `tarEntries`. The tar
+// is split into multiple zstd frames so the ztoc has more than one span.
+func buildTarZstd(t testing.TB, tarName string, tarEntries []testutil.TarEntry) (string, map[string][]byte, []string) {
+	tarReader := testutil.BuildTarZstd(tarEntries, 1, testutil.WithZstdFrameSize(testZstdFrameSize))
+	tarZstdFilePath, _, err := testutil.WriteTarToTempFile(tarName+".tar.zst", tarReader)
+	if err != nil {
+		t.Fatalf("cannot prepare the .tar.zst file for testing")
+	}
+	m, fileNames, err := testutil.GetFilesAndContentsWithinTarZstd(tarZstdFilePath)
+	if err != nil {
+		os.Remove(tarZstdFilePath)
+		t.Fatalf("failed to get tar zstd files and their contents: %v", err)
+	}
+	return tarZstdFilePath, m, fileNames
+}
+
 func buildTar(t testing.TB, tarName string, tarEntries []testutil.TarEntry) (string, map[string][]byte, []string) {
 	tarReader := testutil.BuildTar(tarEntries)
 	tarFilePath, _, err := testutil.WriteTarToTempFile(tarName+".tar", tarReader)
@@ -78,6 +95,11 @@ var testZtocs = []struct {
 		compressionAlgo: compression.Gzip,
 		tarGenerator:    buildTarGZ,
 	},
+	{
+		name:            "zstd",
+		compressionAlgo: compression.Zstd,
+		tarGenerator:    buildTarZstd,
+	},
 	{
 		name:            "uncompressed",
 		compressionAlgo: compression.Uncompressed,
@@ -85,6 +107,9 @@ var testZtocs = []struct {
 	},
 }
 
+// testZstdFrameSize is the uncompressed size of each frame in zstd test layers.
+const testZstdFrameSize = 32 << 10
+
 func TestDecompress(t *testing.T) {
 	for _, tc := range testZtocs {
 		testDecompress(t, tc.compressionAlgo, tc.tarGenerator)
@@ -452,6 +477,192 @@ func testZtocGenerationConsistency(t *testing.T, compressionAlgo string, generat
 
 }
 
+func TestBuildZtocFromReader(t *testing.T) {
+	for _, tc := range testZtocs {
+		testBuildZtocFromReader(t, tc.compressionAlgo, tc.tarGenerator)
+	}
+}
+
+// testBuildZtocFromReader checks that building a ztoc in a single pass over a
+// stream produces the same ztoc as building it from a file.
+func testBuildZtocFromReader(t *testing.T, compressionAlgo string, generator tarGenerator) {
+	r := testutil.NewTestRand(t)
+	tarEntries := []testutil.TarEntry{
+		testutil.Dir("dir/"),
+		testutil.File("dir/smallfile", string(r.RandomByteDataRange(1, 100))),
+		testutil.File("mediumfile", string(r.RandomByteDataRange(10000, 128000))),
+		testutil.Symlink("link", "mediumfile"),
+		testutil.File("largefile", string(r.RandomByteDataRange(350000, 500000))),
+	}
+	tarFilePath, _, _ := generator(t, "reader", tarEntries)
+	defer os.Remove(tarFilePath)
+
+	ztocBuilder := NewBuilder("test")
+	for _, spanSize := range []int64{64, 10000, 65535, 1 << 20} {
+		t.Run(fmt.Sprintf("%s-span_size=%d", compressionAlgo, spanSize), func(t *testing.T) {
+			expected, err := ztocBuilder.BuildZtoc(tarFilePath, spanSize, WithCompression(compressionAlgo))
+			if err != nil {
+				t.Fatalf("can't build ztoc from file: %v", err)
+			}
+			f, err := os.Open(tarFilePath)
+			if err != nil {
+				t.Fatalf("can't open tar file: %v", err)
+			}
+			defer f.Close()
+			actual, err := ztocBuilder.BuildZtocFromReader(f, spanSize, WithCompression(compressionAlgo))
+			if err != nil {
+				t.Fatalf("can't build ztoc from reader: %v", err)
+			}
+			if !bytes.Equal(expected.Checkpoints, actual.Checkpoints) {
+				diffIdx := getPositionOfFirstDiffInByteSlice(expected.Checkpoints, actual.Checkpoints)
+				t.Fatalf("checkpoints differ starting from position %d", diffIdx)
+			}
+			if !reflect.DeepEqual(expected, actual) {
+				t.Fatalf("ztoc built from reader differs from ztoc built from file.\nexpected: %+v\nactual: %+v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestBuildZtocFromReaderFailures(t *testing.T) {
+	r := testutil.NewTestRand(t)
+	tarEntries := []testutil.TarEntry{
+		testutil.File("file", string(r.RandomByteData(100000))),
+	}
+	testcases := []struct {
+		name            string
+		compressionAlgo string
+		data            func() []byte
+	}{
+		{
+			name:            "truncated gzip layer",
+			compressionAlgo: compression.Gzip,
+			data: func() []byte {
+				b, _ := io.ReadAll(testutil.BuildTarGz(tarEntries, gzip.DefaultCompression))
+				return b[:len(b)/2]
+			},
+		},
+		{
+			name:            "truncated zstd layer",
+			compressionAlgo: compression.Zstd,
+			data: func() []byte {
+				b, _ := io.ReadAll(testutil.BuildTarZstd(tarEntries, 1, testutil.WithZstdFrameSize(testZstdFrameSize)))
+				return b[:len(b)/2]
+			},
+		},
+		{
+			name:            "gzip layer with invalid tar",
+			compressionAlgo: compression.Gzip,
+			data: func() []byte {
+				var buf bytes.Buffer
+				gw := gzip.NewWriter(&buf)
+				gw.Write(r.RandomByteData(100000))
+				gw.Close()
+				return buf.Bytes()
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewBuilder("test").BuildZtocFromReader(bytes.NewReader(tc.data()), 1<<16, WithCompression(tc.compressionAlgo))
+			if err == nil {
+				t.Fatalf("expected an error building ztoc")
+			}
+		})
+	}
+}
+
+func TestFileDigests(t *testing.T) {
+	for _, tc := range testZtocs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := testutil.NewTestRand(t)
+			tarEntries := []testutil.TarEntry{
+				testutil.Dir("dir/"),
+				testutil.File("dir/file", string(r.RandomByteDataRange(10000, 128000))),
+				testutil.File("empty", ""),
+				testutil.Symlink("link", "dir/file"),
+			}
+			tarFilePath, contents, _ := tc.tarGenerator(t, "digests", tarEntries)
+			defer os.Remove(tarFilePath)
+
+			toc, err := NewBuilder("test").BuildZtoc(tarFilePath, 1<<16, WithCompression(tc.compressionAlgo), WithFileDigests())
+			if err != nil {
+				t.Fatalf("can't build ztoc: %v", err)
+			}
+			if toc.Version != Version10 {
+				t.Fatalf("unexpected ztoc version. expected: %s, actual: %s", Version10, toc.Version)
+			}
+			for _, m := range toc.FileMetadata {
+				if m.Type != "reg" {
+					if m.Digest != "" {
+						t.Fatalf("%s: unexpected digest for non-regular file: %s", m.Name, m.Digest)
+					}
+					continue
+				}
+				if expected := digest.FromBytes(contents[m.Name]); m.Digest != expected {
+					t.Fatalf("%s: unexpected digest. expected: %s, actual: %s", m.Name, expected, m.Digest)
+				}
+			}
+
+			zr, _, err := Marshal(toc)
+			if err != nil {
+				t.Fatalf("can't marshal ztoc: %v", err)
+			}
+			unmarshaled, err := Unmarshal(zr)
+			if err != nil {
+				t.Fatalf("can't unmarshal ztoc: %v", err)
+			}
+			for i, m := range unmarshaled.FileMetadata {
+				if m.Digest != toc.FileMetadata[i].Digest {
+					t.Fatalf("%s: unexpected digest after unmarshaling. expected: %s, actual: %s", m.Name, toc.FileMetadata[i].Digest, m.Digest)
+				}
+			}
+
+			f, err := os.Open(tarFilePath)
+			if err != nil {
+				t.Fatalf("can't open tar file: %v", err)
+			}
+			defer f.Close()
+			fi, err := f.Stat()
+			if err != nil {
+				t.Fatalf("can't stat tar file: %v", err)
+			}
+			sr := io.NewSectionReader(f, 0, fi.Size())
+			if _, err := toc.ExtractFile(sr, "dir/file"); err != nil {
+				t.Fatalf("can't extract file: %v", err)
+			}
+			for i, m := range toc.FileMetadata {
+				if m.Name == "dir/file" {
+					toc.FileMetadata[i].Digest = digest.FromString("foo")
+				}
+			}
+			if _, err := toc.ExtractFile(sr, "dir/file"); !errors.Is(err, ErrFileDigestMismatch) {
+				t.Fatalf("expected %v, actual: %v", ErrFileDigestMismatch, err)
+			}
+		})
+	}
+}
+
+func TestBuildZtocWithoutFileDigests(t *testing.T) {
+	r := testutil.NewTestRand(t)
+	tarEntries := []testutil.TarEntry{
+		testutil.File("file", string(r.RandomByteData(1000))),
+	}
+	tarFilePath, _, _ := buildTarGZ(t, "nodigests", tarEntries)
+	defer os.Remove(tarFilePath)
+
+	toc, err := NewBuilder("test").BuildZtoc(tarFilePath, 1<<16)
+	if err != nil {
+		t.Fatalf("can't build ztoc: %v", err)
+	}
+	if toc.Version != Version09 {
+		t.Fatalf("unexpected ztoc version. expected: %s, actual: %s", Version09, toc.Version)
+	}
+	if d := toc.FileMetadata[0].Digest; d != "" {
+		t.Fatalf("unexpected digest: %s", d)
+	}
+}
+
 func TestZtocGeneration(t *testing.T) {
 	for _, tc := range testZtocs {
 		testZtocGeneration(t, tc.compressionAlgo, tc.tarGenerator)
@@ -648,6 +859,67 @@ func benchmarkZtocGeneration(b *testing.B, algo string, generator tarGenerator)
 	}
 }
 
+// BenchmarkBuildZtocFromReader compares building a ztoc in a single pass over a
+// layer stream with copying the stream to a temporary file and building the ztoc
+// from the file.
+func BenchmarkBuildZtocFromReader(b *testing.B) {
+	for _, tc := range testZtocs {
+		b.Run(tc.name, func(b *testing.B) {
+			benchmarkBuildZtocFromReader(b, tc.compressionAlgo, tc.tarGenerator)
+		})
+	}
+}
+
+func benchmarkBuildZtocFromReader(b *testing.B, algo string, generator tarGenerator) {
+	// Use compressible file contents, since decompression dominates for real layers.
+	r := testutil.NewTestRand(b)
+	entries := make([]testutil.TarEntry, 10)
+	for i := range entries {
+		data := r.RandomByteData(10_000_000)
+		for j := range data {
+			data[j] = 'a' + data[j]%16
+		}
+		entries[i] = testutil.File(fmt.Sprintf("file%d", i), string(data))
+	}
+	tarfile, _, _ := generator(b, algo, entries)
+	defer os.Remove(tarfile)
+	const spanSize = 1 << 22
+	builder := NewBuilder("benchmark")
+
+	b.Run("temp file", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			f, err := os.Open(tarfile)
+			if err != nil {
+				b.Fatal(err)
+			}
+			tmp, err := os.CreateTemp(b.TempDir(), "layer")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.Copy(tmp, f); err != nil {
+				b.Fatal(err)
+			}
+			f.Close()
+			tmp.Close()
+			if _, err := builder.BuildZtoc(tmp.Name(), spanSize, WithCompression(algo)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("stream", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			f, err := os.Open(tarfile)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := builder.BuildZtocFromReader(f, spanSize, WithCompression(algo)); err != nil {
+				b.Fatal(err)
+			}
+			f.Close()
+		}
+	})
+}
+
 func TestZtocSerialization(t *testing.T) {
 	for _, tc := range testZtocs {
 		testZtocSerialization(t, tc.compressionAlgo, tc.tarGenerator)